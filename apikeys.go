@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 客户端 API Key 相关 ====================
+//
+// 除环境变量 DEFAULT_KEY 外，可以在 /admin/api/keys 创建多个客户端 Key，
+// 每个 Key 带有独立的策略（例如响应缓存策略），可以单独吊销。
+// 数据库只保存 Key 的 SHA-256 哈希，完整 Key 仅在创建时返回一次。
+
+// 内置的 DEFAULT_KEY 对应的 Key ID
+const DEFAULT_API_KEY_ID = "default"
+
+// 响应缓存策略
+const (
+	CACHE_POLICY_OFF           = "off"           // 不使用缓存
+	CACHE_POLICY_DETERMINISTIC = "deterministic" // 仅缓存 temperature 显式为 0 的请求
+	CACHE_POLICY_ALWAYS        = "always"        // 缓存所有可缓存的请求
+)
+
+// 客户端 API Key
+type APIKey struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Prefix      string `json:"prefix"`
+	CachePolicy string `json:"cache_policy"` // 空表示使用 CACHE_DEFAULT_POLICY
+	CreatedAt   int64  `json:"created_at"`
+	LastUsedAt  *int64 `json:"last_used_at"`
+	RevokedAt   *int64 `json:"revoked_at"`
+}
+
+var (
+	apiKeyDB      *sql.DB
+	apiKeyDBMutex sync.Mutex
+)
+
+// 初始化 API Key 数据库（共用 register 数据库）
+func initAPIKeyDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	apiKeyDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开 API Key 数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	apiKeyDB.SetMaxOpenConns(10)
+	apiKeyDB.SetMaxIdleConns(2)
+	apiKeyDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT,
+		key_hash TEXT UNIQUE NOT NULL,
+		key_prefix TEXT,
+		cache_policy TEXT DEFAULT '',
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER,
+		revoked_at INTEGER
+	);
+	`
+	_, err = apiKeyDB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("创建 API Key 表失败: %v", err)
+	}
+
+	return nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func isValidCachePolicy(policy string) bool {
+	switch policy {
+	case "", CACHE_POLICY_OFF, CACHE_POLICY_DETERMINISTIC, CACHE_POLICY_ALWAYS:
+		return true
+	}
+	return false
+}
+
+// 获取 Key 实际生效的缓存策略
+func (k *APIKey) effectiveCachePolicy() string {
+	if k.CachePolicy != "" {
+		return k.CachePolicy
+	}
+	return CACHE_DEFAULT_POLICY
+}
+
+// 校验客户端提供的 Key，返回对应的 API Key 记录
+func authenticateAPIKey(key string) (*APIKey, bool) {
+	if key == "" {
+		return nil, false
+	}
+	if key == DEFAULT_KEY {
+		return &APIKey{ID: DEFAULT_API_KEY_ID, Name: "DEFAULT_KEY"}, true
+	}
+	if apiKeyDB == nil {
+		return nil, false
+	}
+
+	apiKeyDBMutex.Lock()
+	defer apiKeyDBMutex.Unlock()
+
+	var k APIKey
+	var name, prefix, policy sql.NullString
+	var lastUsedAt sql.NullInt64
+	err := apiKeyDB.QueryRow(`
+		SELECT id, name, key_prefix, cache_policy, created_at, last_used_at FROM api_keys
+		WHERE key_hash = ? AND revoked_at IS NULL
+	`, hashAPIKey(key)).Scan(&k.ID, &name, &prefix, &policy, &k.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, false
+	}
+	k.Name = name.String
+	k.Prefix = prefix.String
+	k.CachePolicy = policy.String
+
+	// 最近使用时间按分钟粒度更新，避免每个请求都写库
+	now := time.Now().Unix()
+	if !lastUsedAt.Valid || now-lastUsedAt.Int64 >= 60 {
+		apiKeyDB.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, now, k.ID)
+		lastUsedAt.Int64 = now
+	}
+	k.LastUsedAt = &lastUsedAt.Int64
+
+	return &k, true
+}
+
+// 从请求头中解析并校验 API Key
+func apiKeyFromRequest(r *http.Request) (*APIKey, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, false
+	}
+	return authenticateAPIKey(strings.TrimPrefix(authHeader, "Bearer "))
+}
+
+// 创建 API Key，返回记录和完整 Key（只返回这一次）
+func createAPIKey(name, cachePolicy string) (*APIKey, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	key := "sk-" + hex.EncodeToString(buf)
+
+	id, err := generatePrefixedID("key_")
+	if err != nil {
+		return nil, "", err
+	}
+
+	k := &APIKey{
+		ID:          id,
+		Name:        name,
+		Prefix:      key[:TOKEN_DISPLAY_LENGTH],
+		CachePolicy: cachePolicy,
+		CreatedAt:   time.Now().Unix(),
+	}
+
+	apiKeyDBMutex.Lock()
+	defer apiKeyDBMutex.Unlock()
+
+	_, err = apiKeyDB.Exec(`
+		INSERT INTO api_keys (id, name, key_hash, key_prefix, cache_policy, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, k.ID, k.Name, hashAPIKey(key), k.Prefix, k.CachePolicy, k.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, key, nil
+}
+
+// 列出所有 API Key（含已吊销）
+func listAPIKeys() ([]APIKey, error) {
+	apiKeyDBMutex.Lock()
+	defer apiKeyDBMutex.Unlock()
+
+	rows, err := apiKeyDB.Query(`
+		SELECT id, name, key_prefix, cache_policy, created_at, last_used_at, revoked_at
+		FROM api_keys ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var name, prefix, policy sql.NullString
+		var lastUsedAt, revokedAt sql.NullInt64
+		if err := rows.Scan(&k.ID, &name, &prefix, &policy, &k.CreatedAt, &lastUsedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		k.Name = name.String
+		k.Prefix = prefix.String
+		k.CachePolicy = policy.String
+		if lastUsedAt.Valid {
+			k.LastUsedAt = &lastUsedAt.Int64
+		}
+		if revokedAt.Valid {
+			k.RevokedAt = &revokedAt.Int64
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// 更新 API Key 的缓存策略
+func updateAPIKeyCachePolicy(id, cachePolicy string) error {
+	apiKeyDBMutex.Lock()
+	defer apiKeyDBMutex.Unlock()
+
+	result, err := apiKeyDB.Exec(`UPDATE api_keys SET cache_policy = ? WHERE id = ?`, cachePolicy, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 吊销 API Key
+func revokeAPIKey(id string) error {
+	apiKeyDBMutex.Lock()
+	defer apiKeyDBMutex.Unlock()
+
+	result, err := apiKeyDB.Exec(`UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 处理 API Key 管理：
+//
+//	GET    /admin/api/keys        列表
+//	POST   /admin/api/keys        创建 {"name", "cache_policy"}
+//	PUT    /admin/api/keys/{id}   修改 {"cache_policy"}
+//	DELETE /admin/api/keys/{id}   吊销
+func handleAdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+	if apiKeyDB == nil {
+		writeError(http.StatusServiceUnavailable, "API Key 存储未初始化")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/keys"), "/")
+
+	var body struct {
+		Name        string `json:"name"`
+		CachePolicy string `json:"cache_policy"`
+	}
+	if r.Method == "POST" || r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(http.StatusBadRequest, "无效的请求数据")
+			return
+		}
+		if !isValidCachePolicy(body.CachePolicy) {
+			writeError(http.StatusBadRequest, "cache_policy 必须为 off / deterministic / always")
+			return
+		}
+	}
+
+	switch {
+	case id == "" && r.Method == "GET":
+		keys, err := listAPIKeys()
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":        true,
+			"keys":           keys,
+			"default_policy": CACHE_DEFAULT_POLICY,
+		})
+
+	case id == "" && r.Method == "POST":
+		k, key, err := createAPIKey(body.Name, body.CachePolicy)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		logMain.Info("创建 API Key", "key_id", k.ID, "name", k.Name)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"key":     k,
+			"api_key": key,
+		})
+
+	case id != "" && r.Method == "PUT":
+		if err := updateAPIKeyCachePolicy(id, body.CachePolicy); err == sql.ErrNoRows {
+			writeError(http.StatusNotFound, "API Key 不存在")
+			return
+		} else if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	case id != "" && r.Method == "DELETE":
+		if err := revokeAPIKey(id); err == sql.ErrNoRows {
+			writeError(http.StatusNotFound, "API Key 不存在或已吊销")
+			return
+		} else if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		logMain.Info("吊销 API Key", "key_id", id)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		writeError(http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ==================== 后台（异步）补全相关 ====================
+//
+// 请求体带 "background": true 时，/v1/chat/completions 立即返回任务对象，
+// 由服务端完成上游调用并保存结果。客户端可以用创建任务的 API Key 轮询 /v1/jobs/{id}，
+// 也可以在请求中提供 callback_url，任务完成或失败时收到带 HMAC 签名的 POST。
+//
+// 回调签名：
+//   X-Webhook-Timestamp: <unix 秒>
+//   X-Webhook-Signature: sha256=<hex(HMAC-SHA256(secret, timestamp + "." + body))>
+// secret 为 BACKGROUND_CALLBACK_SECRET，未配置时不接受 callback_url。
+// 回调地址只能指向公网：创建任务时解析域名，连接时再次检查实际地址，
+// 拒绝回环、私有、链路本地和未指定地址（防止 SSRF 和 DNS 重绑定）。
+
+// 后台任务相关常量
+const (
+	MAX_BACKGROUND_RUNNING    = 16               // 同时执行的后台任务上限
+	BACKGROUND_JOB_RETENTION  = 24 * time.Hour   // 已结束任务的保留时间
+	CALLBACK_TIMEOUT          = 10 * time.Second // 单次回调超时
+	CALLBACK_MAX_ATTEMPTS     = 3                // 回调最多尝试次数
+	CALLBACK_RETRY_BASE_DELAY = 2 * time.Second  // 回调重试基础间隔（指数退避）
+)
+
+// 任务错误
+type JobError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// 后台任务对象
+type BackgroundJob struct {
+	ID          string          `json:"id"`
+	Object      string          `json:"object"`
+	Status      string          `json:"status"` // queued / in_progress / completed / failed
+	Model       string          `json:"model"`
+	CallbackURL string          `json:"callback_url,omitempty"`
+	CreatedAt   int64           `json:"created_at"`
+	StartedAt   *int64          `json:"started_at"`
+	CompletedAt *int64          `json:"completed_at"`
+	Result      *OpenAIResponse `json:"result"`
+	Error       *JobError       `json:"error"`
+	APIKeyID    string          `json:"-"` // 创建任务的 API Key
+}
+
+var (
+	jobDB      *sql.DB
+	jobDBMutex sync.Mutex
+	jobSlots   = make(chan struct{}, MAX_BACKGROUND_RUNNING)
+)
+
+// 初始化后台任务数据库（共用 register 数据库）
+func initJobDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	jobDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开后台任务数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	jobDB.SetMaxOpenConns(10)
+	jobDB.SetMaxIdleConns(2)
+	jobDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS background_jobs (
+		id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		model TEXT,
+		request TEXT NOT NULL,
+		callback_url TEXT,
+		callback_status TEXT,
+		callback_attempts INTEGER DEFAULT 0,
+		result TEXT,
+		error TEXT,
+		created_at INTEGER NOT NULL,
+		started_at INTEGER,
+		completed_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_background_jobs_status ON background_jobs(status);
+	`
+	_, err = jobDB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("创建后台任务表失败: %v", err)
+	}
+
+	return nil
+}
+
+// 重新执行上次运行中断的任务
+func resumeBackgroundJobs() {
+	pending := requeueBackgroundJobs()
+	if len(pending) == 0 {
+		return
+	}
+
+	logBackground.Info("恢复中断的后台任务", "count", len(pending))
+	for _, job := range pending {
+		// 自定义 token 不落库，恢复的任务使用统一的 token 获取逻辑
+		go runBackgroundJob(job.id, job.req, "")
+	}
+}
+
+type interruptedJob struct {
+	id  string
+	req OpenAIRequest
+}
+
+// 将中断的任务重新置为排队状态
+func requeueBackgroundJobs() []interruptedJob {
+	jobDBMutex.Lock()
+	defer jobDBMutex.Unlock()
+
+	rows, err := jobDB.Query(`SELECT id, request FROM background_jobs WHERE status IN ('queued', 'in_progress')`)
+	if err != nil {
+		logBackground.Warn("查询中断的后台任务失败", "error", err)
+		return nil
+	}
+
+	var pending []interruptedJob
+	for rows.Next() {
+		var id, body string
+		if err := rows.Scan(&id, &body); err != nil {
+			continue
+		}
+		var req OpenAIRequest
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			continue
+		}
+		pending = append(pending, interruptedJob{id: id, req: req})
+	}
+	rows.Close()
+
+	requeued := pending[:0]
+	for _, job := range pending {
+		_, err := jobDB.Exec(`UPDATE background_jobs SET status = 'queued', started_at = NULL WHERE id = ?`, job.id)
+		if err != nil {
+			logBackground.Warn("恢复后台任务失败", "job_id", job.id, "error", err)
+			continue
+		}
+		requeued = append(requeued, job)
+	}
+	return requeued
+}
+
+// 清理过期的已结束任务
+func cleanupBackgroundJobs() {
+	if jobDB == nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-BACKGROUND_JOB_RETENTION).Unix()
+	result, err := jobDB.Exec(`DELETE FROM background_jobs WHERE status IN ('completed', 'failed') AND completed_at < ?`, cutoff)
+	if err != nil {
+		logBackground.Warn("清理后台任务失败", "error", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logBackground.Debug("清理过期的后台任务", "count", n)
+	}
+}
+
+var errCallbackAddressNotAllowed = errors.New("callback_url must resolve to a public address")
+
+// 回调不允许访问的地址：回环、私有、链路本地、未指定和组播地址
+func isDisallowedCallbackIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// 校验回调地址：解析域名，所有地址都必须是公网地址
+func validateCallbackURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid callback_url")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback_url must use http or https")
+	}
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", u.Hostname())
+	if err != nil || len(ips) == 0 {
+		return fmt.Errorf("callback_url host cannot be resolved")
+	}
+	for _, ip := range ips {
+		if isDisallowedCallbackIP(ip) {
+			return errCallbackAddressNotAllowed
+		}
+	}
+	return nil
+}
+
+// 回调使用的 HTTP 客户端：连接时检查实际地址（域名可能在校验后被解析到内网），不使用代理
+var callbackClient = &http.Client{
+	Timeout: CALLBACK_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: CALLBACK_TIMEOUT,
+			Control: func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || isDisallowedCallbackIP(ip) {
+					return errCallbackAddressNotAllowed
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: CALLBACK_TIMEOUT,
+	},
+}
+
+// 创建 API Key 的后台任务
+func createBackgroundJob(apiKeyID string, req OpenAIRequest) (*BackgroundJob, error) {
+	id, err := generatePrefixedID("job_")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	jobDBMutex.Lock()
+	defer jobDBMutex.Unlock()
+
+	_, err = jobDB.Exec(`
+		INSERT INTO background_jobs (id, api_key_id, status, model, request, callback_url, created_at)
+		VALUES (?, ?, 'queued', ?, ?, ?, ?)
+	`, id, apiKeyID, req.Model, string(body), req.CallbackURL, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return getBackgroundJobLocked(id)
+}
+
+// 获取 API Key 的后台任务，不存在或不属于该 Key 时返回 sql.ErrNoRows
+func getBackgroundJob(apiKeyID, id string) (*BackgroundJob, error) {
+	jobDBMutex.Lock()
+	defer jobDBMutex.Unlock()
+	job, err := getBackgroundJobLocked(id)
+	if err == nil && job.APIKeyID != apiKeyID {
+		return nil, sql.ErrNoRows
+	}
+	return job, err
+}
+
+func getBackgroundJobLocked(id string) (*BackgroundJob, error) {
+	var job BackgroundJob
+	var model, callbackURL, result, jobErr sql.NullString
+	var startedAt, completedAt sql.NullInt64
+
+	err := jobDB.QueryRow(`
+		SELECT id, api_key_id, status, model, callback_url, result, error, created_at, started_at, completed_at
+		FROM background_jobs WHERE id = ?
+	`, id).Scan(&job.ID, &job.APIKeyID, &job.Status, &model, &callbackURL, &result, &jobErr, &job.CreatedAt, &startedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Object = "chat.completion.job"
+	job.Model = model.String
+	job.CallbackURL = callbackURL.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Int64
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Int64
+	}
+	if result.Valid && result.String != "" {
+		var response OpenAIResponse
+		if json.Unmarshal([]byte(result.String), &response) == nil {
+			job.Result = &response
+		}
+	}
+	if jobErr.Valid && jobErr.String != "" {
+		var e JobError
+		if json.Unmarshal([]byte(jobErr.String), &e) == nil {
+			job.Error = &e
+		}
+	}
+
+	return &job, nil
+}
+
+// 执行后台任务：调用上游、保存结果并发送回调
+func runBackgroundJob(id string, req OpenAIRequest, authToken string) {
+	// 服务关闭中不再开始新任务，任务保持排队状态，下次启动时执行
+	if !beginServerTask() {
+		return
+	}
+	defer endServerTask()
+	select {
+	case jobSlots <- struct{}{}:
+	case <-serverStopping:
+		return
+	}
+	defer func() { <-jobSlots }()
+
+	jobDBMutex.Lock()
+	jobDB.Exec(`UPDATE background_jobs SET status = 'in_progress', started_at = ? WHERE id = ?`, time.Now().Unix(), id)
+	jobDBMutex.Unlock()
+
+	logBackground.Debug("开始执行后台任务", "job_id", id)
+	response, err := runChatCompletion(req, "BACKGROUND", authToken)
+	if err != nil && serverContext.Err() != nil {
+		// 服务关闭时被中断，保持执行中状态，下次启动时重新执行
+		logBackground.Info("后台任务被服务关闭中断，将在重启后重新执行", "job_id", id)
+		return
+	}
+
+	status := "completed"
+	var result, jobErr string
+	if err != nil {
+		status = "failed"
+		e, _ := json.Marshal(JobError{Code: "upstream_error", Message: err.Error()})
+		jobErr = string(e)
+	} else {
+		r, _ := json.Marshal(response)
+		result = string(r)
+	}
+
+	jobDBMutex.Lock()
+	_, dbErr := jobDB.Exec(`UPDATE background_jobs SET status = ?, result = ?, error = ?, completed_at = ? WHERE id = ?`,
+		status, result, jobErr, time.Now().Unix(), id)
+	job, getErr := getBackgroundJobLocked(id)
+	jobDBMutex.Unlock()
+
+	if dbErr != nil || getErr != nil {
+		logBackground.Error("保存后台任务结果失败", "job_id", id)
+		return
+	}
+	logBackground.Debug("后台任务结束", "job_id", id, "status", status)
+
+	if job.CallbackURL != "" {
+		sendJobCallback(job)
+	}
+}
+
+// 计算回调签名
+func signCallback(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 发送任务回调，失败时按指数退避重试
+func sendJobCallback(job *BackgroundJob) {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return
+	}
+
+	callbackStatus := "failed"
+	attempts := 0
+	defer func() {
+		jobDBMutex.Lock()
+		jobDB.Exec(`UPDATE background_jobs SET callback_status = ?, callback_attempts = ? WHERE id = ?`, callbackStatus, attempts, job.ID)
+		jobDBMutex.Unlock()
+	}()
+
+	// 重启后恢复的任务可能带有回调地址而密钥已被移除，不发送未签名的回调
+	secret := BACKGROUND_CALLBACK_SECRET
+	if secret == "" {
+		logBackground.Warn("未配置 BACKGROUND_CALLBACK_SECRET，跳过后台任务回调", "job_id", job.ID)
+		return
+	}
+
+	for attempts < CALLBACK_MAX_ATTEMPTS {
+		if attempts > 0 {
+			time.Sleep(CALLBACK_RETRY_BASE_DELAY << (attempts - 1))
+		}
+		attempts++
+
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		httpReq, err := http.NewRequest("POST", job.CallbackURL, bytes.NewReader(body))
+		if err != nil {
+			break
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		httpReq.Header.Set("User-Agent", "ZtoApi-Webhook/1.0")
+		httpReq.Header.Set("X-Webhook-ID", job.ID)
+		httpReq.Header.Set("X-Webhook-Timestamp", timestamp)
+		httpReq.Header.Set("X-Webhook-Signature", signCallback(secret, timestamp, body))
+
+		resp, err := callbackClient.Do(httpReq)
+		if err != nil {
+			logBackground.Warn("后台任务回调失败", "job_id", job.ID, "attempt", attempts, "max_attempts", CALLBACK_MAX_ATTEMPTS, "error", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			callbackStatus = "delivered"
+			break
+		}
+		logBackground.Warn("后台任务回调返回错误状态", "job_id", job.ID, "status", resp.StatusCode, "attempt", attempts, "max_attempts", CALLBACK_MAX_ATTEMPTS)
+	}
+
+	if callbackStatus != "delivered" {
+		logBackground.Warn("后台任务回调失败", "job_id", job.ID, "callback_url", job.CallbackURL)
+	}
+}
+
+// 处理 background: true 的补全请求，立即返回任务对象
+func handleBackgroundCompletion(w http.ResponseWriter, r *http.Request, req OpenAIRequest, apiKeyID string) int {
+	if jobDB == nil {
+		httpError(w, "Background completions are disabled", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	if req.Stream {
+		httpError(w, "Background mode does not support stream", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	if req.ConversationID != "" {
+		httpError(w, "Background mode does not support conversation_id", http.StatusBadRequest)
+		return http.StatusBadRequest
+	}
+	if req.CallbackURL != "" {
+		if BACKGROUND_CALLBACK_SECRET == "" {
+			httpError(w, "callback_url requires BACKGROUND_CALLBACK_SECRET to be configured", http.StatusBadRequest)
+			return http.StatusBadRequest
+		}
+		if err := validateCallbackURL(r.Context(), req.CallbackURL); err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return http.StatusBadRequest
+		}
+	}
+
+	job, err := createBackgroundJob(apiKeyID, req)
+	if err != nil {
+		logBackground.WarnContext(r.Context(), "创建后台任务失败", "error", err)
+		httpError(w, "Failed to create background job", http.StatusInternalServerError)
+		return http.StatusInternalServerError
+	}
+
+	// 自定义 token 只保存在内存中，随任务一次性使用
+	customToken := r.Header.Get("X-ZAI-Token")
+	go runBackgroundJob(job.ID, req, customToken)
+
+	logBackground.Info("创建后台任务", "job_id", job.ID, "model", req.Model)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+	return http.StatusAccepted
+}
+
+// 查询后台任务：GET /v1/jobs/{id}
+func handleJobByID(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	job, err := getBackgroundJob(clientKey.ID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, "Failed to get job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// 使用临时目录中的后台任务数据库，测试结束后恢复全局状态
+func useJobDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "jobs.db")
+
+	oldDB := jobDB
+	if err := initJobDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		jobDB.Close()
+		jobDB = oldDB
+	})
+}
+
+func TestBackgroundJobOwnership(t *testing.T) {
+	useJobDB(t)
+	job, err := createBackgroundJob("key-1", OpenAIRequest{Model: "GLM-4.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		apiKeyID string
+		wantErr  error
+	}{
+		{name: "owner", apiKeyID: "key-1"},
+		{name: "other key", apiKeyID: "key-2", wantErr: sql.ErrNoRows},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getBackgroundJob(tt.apiKeyID, job.ID)
+			if err != tt.wantErr {
+				t.Fatalf("getBackgroundJob(): got %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.ID != job.ID {
+				t.Errorf("getBackgroundJob(): got %q, want %q", got.ID, job.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,1108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 批处理（Batch API）相关 ====================
+//
+// 兼容 OpenAI Batch API：
+//   - /v1/files 上传 JSONL 输入文件，下载输出/错误文件
+//   - /v1/batches 创建、查询、取消批处理任务
+// 输入文件的每一行拆分为 batch_requests 表中的一条记录，由后台 worker 池按
+// BATCH_CONCURRENCY 并发执行；任务状态与每行结果都保存在数据库中，重启后继续执行。
+// 文件和批处理属于创建它们的 API Key（输出/错误文件属于所在批处理的 Key），其他 Key 视为不存在。
+
+// 批处理相关常量
+const (
+	MAX_BATCH_FILE_SIZE      = 200 << 20       // 上传文件最大 200MB
+	MAX_BATCH_REQUESTS       = 50000           // 单个批处理最多请求数
+	BATCH_COMPLETION_WINDOW  = 24 * time.Hour  // 仅支持 24h 完成窗口
+	BATCH_CHECK_INTERVAL     = 5 * time.Second // 后台检查间隔
+	BATCH_DEFAULT_ENDPOINT   = "/v1/chat/completions"
+	BATCH_FILE_PURPOSE_INPUT = "batch"
+)
+
+// 文件对象
+type BatchFile struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int    `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// 批处理请求计数
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// 批处理错误
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// 批处理对象
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrorList    `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        *int64             `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+type BatchErrorList struct {
+	Object string       `json:"object"`
+	Data   []BatchError `json:"data"`
+}
+
+// 输入文件中的一行
+type batchInputLine struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// 输出/错误文件中的一行
+type batchOutputLine struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *batchOutputResponse `json:"response"`
+	Error    *BatchError          `json:"error"`
+}
+
+type batchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       *OpenAIResponse `json:"body"`
+}
+
+// 待执行的批处理请求
+type batchRequestItem struct {
+	ID       int64
+	BatchID  string
+	CustomID string
+	Body     string
+}
+
+var (
+	batchDB      *sql.DB
+	batchDBMutex sync.Mutex
+	batchWakeup  = make(chan struct{}, 1)
+)
+
+// 初始化批处理数据库（共用 register 数据库）
+func initBatchDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	batchDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开批处理数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	batchDB.SetMaxOpenConns(10)
+	batchDB.SetMaxIdleConns(2)
+	batchDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS files (
+		id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		filename TEXT,
+		purpose TEXT NOT NULL,
+		bytes INTEGER DEFAULT 0,
+		content BLOB,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_files_key_created ON files(api_key_id, created_at DESC);
+	CREATE TABLE IF NOT EXISTS batches (
+		id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		endpoint TEXT NOT NULL,
+		input_file_id TEXT NOT NULL,
+		completion_window TEXT NOT NULL,
+		status TEXT NOT NULL,
+		output_file_id TEXT,
+		error_file_id TEXT,
+		errors TEXT,
+		metadata TEXT,
+		total INTEGER DEFAULT 0,
+		completed INTEGER DEFAULT 0,
+		failed INTEGER DEFAULT 0,
+		created_at INTEGER NOT NULL,
+		in_progress_at INTEGER,
+		expires_at INTEGER,
+		finalizing_at INTEGER,
+		completed_at INTEGER,
+		failed_at INTEGER,
+		expired_at INTEGER,
+		cancelling_at INTEGER,
+		cancelled_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_batches_status ON batches(status);
+	CREATE INDEX IF NOT EXISTS idx_batches_key_created ON batches(api_key_id, created_at DESC);
+	CREATE TABLE IF NOT EXISTS batch_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		batch_id TEXT NOT NULL,
+		line_index INTEGER NOT NULL,
+		custom_id TEXT NOT NULL,
+		body TEXT NOT NULL,
+		status TEXT DEFAULT 'pending',
+		result TEXT,
+		updated_at INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_batch_requests_batch ON batch_requests(batch_id, status);
+	`
+	_, err = batchDB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("创建批处理表失败: %v", err)
+	}
+
+	// 上次运行中断的请求重新排队
+	result, err := batchDB.Exec(`UPDATE batch_requests SET status = 'pending' WHERE status = 'running'`)
+	if err != nil {
+		return fmt.Errorf("恢复批处理请求失败: %v", err)
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		logBatch.Info("恢复中断的批处理请求", "count", count)
+	}
+
+	return nil
+}
+
+// 启动批处理 worker 池
+func startBatchWorkers(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	for i := 0; i < concurrency; i++ {
+		go batchWorker()
+	}
+
+	// 定时检查过期、取消和已完成的批处理
+	go checkBatches()
+	startPeriodicTask(BATCH_CHECK_INTERVAL, checkBatches)
+}
+
+// 唤醒空闲的 worker
+func wakeBatchWorkers() {
+	select {
+	case batchWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// 生成带前缀的随机ID
+func generatePrefixedID(prefix string) (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(bytes), nil
+}
+
+// worker：不断领取待执行的请求
+func batchWorker() {
+	for {
+		// 服务关闭中不再领取新的请求
+		if !beginServerTask() {
+			return
+		}
+		item, err := claimBatchRequest()
+		if err != nil {
+			logBatch.Warn("领取批处理请求失败", "error", err)
+		}
+		if item != nil {
+			// 可能还有更多待执行请求，继续唤醒其他 worker
+			wakeBatchWorkers()
+			processBatchRequest(item)
+			checkBatches()
+		}
+		endServerTask()
+
+		if item == nil {
+			select {
+			case <-batchWakeup:
+			case <-time.After(BATCH_CHECK_INTERVAL):
+			case <-serverStopping:
+				return
+			}
+		}
+	}
+}
+
+// 领取一个待执行请求（只从 in_progress 状态的批处理中领取）
+func claimBatchRequest() (*batchRequestItem, error) {
+	if batchDB == nil {
+		return nil, nil
+	}
+
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	item := &batchRequestItem{}
+	err := batchDB.QueryRow(`
+		SELECT r.id, r.batch_id, r.custom_id, r.body
+		FROM batch_requests r JOIN batches b ON b.id = r.batch_id
+		WHERE r.status = 'pending' AND b.status = 'in_progress'
+		ORDER BY b.created_at ASC, r.line_index ASC
+		LIMIT 1
+	`).Scan(&item.ID, &item.BatchID, &item.CustomID, &item.Body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = batchDB.Exec(`UPDATE batch_requests SET status = 'running', updated_at = ? WHERE id = ?`,
+		time.Now().Unix(), item.ID)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// 执行一个批处理请求并保存结果
+func processBatchRequest(item *batchRequestItem) {
+	requestID, _ := generatePrefixedID("batch_req_")
+	line := batchOutputLine{ID: requestID, CustomID: item.CustomID}
+
+	status := "completed"
+	var req OpenAIRequest
+	if err := json.Unmarshal([]byte(item.Body), &req); err != nil {
+		status = "failed"
+		line.Error = &BatchError{Code: "invalid_request", Message: "Invalid JSON body: " + err.Error()}
+	} else {
+		response, err := runChatCompletion(req, "BATCH", "")
+		if err != nil && serverContext.Err() != nil {
+			// 服务关闭时被中断，保持执行中状态，下次启动时重新执行
+			logBatch.Info("批处理请求被服务关闭中断，将在重启后重新执行", "batch_id", item.BatchID, "custom_id", item.CustomID)
+			return
+		}
+		if err != nil {
+			status = "failed"
+			line.Error = &BatchError{Code: "upstream_error", Message: err.Error()}
+		} else {
+			line.Response = &batchOutputResponse{
+				StatusCode: http.StatusOK,
+				RequestID:  requestID,
+				Body:       response,
+			}
+		}
+	}
+
+	result, _ := json.Marshal(line)
+
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	_, err := batchDB.Exec(`UPDATE batch_requests SET status = ?, result = ?, updated_at = ? WHERE id = ?`,
+		status, string(result), time.Now().Unix(), item.ID)
+	if err != nil {
+		logBatch.Warn("保存批处理结果失败", "error", err)
+		return
+	}
+
+	column := "completed"
+	if status == "failed" {
+		column = "failed"
+	}
+	batchDB.Exec(fmt.Sprintf(`UPDATE batches SET %s = %s + 1 WHERE id = ?`, column, column), item.BatchID)
+	logBatch.Debug("批处理请求完成", "batch_id", item.BatchID, "custom_id", item.CustomID, "status", status)
+}
+
+// 检查批处理：处理过期、完成和取消
+func checkBatches() {
+	if batchDB == nil {
+		return
+	}
+
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	now := time.Now().Unix()
+
+	// 超出完成窗口的请求标记为过期
+	_, err := batchDB.Exec(`
+		UPDATE batch_requests SET status = 'expired', updated_at = ?
+		WHERE status = 'pending' AND batch_id IN (
+			SELECT id FROM batches WHERE status = 'in_progress' AND expires_at < ?
+		)
+	`, now, now)
+	if err != nil {
+		logBatch.Warn("标记过期批处理请求失败", "error", err)
+	}
+
+	// 找出没有待执行/执行中请求的批处理，以及上次收尾失败仍处于 finalizing 的批处理
+	rows, err := batchDB.Query(`
+		SELECT b.id, b.status, b.expires_at, b.cancelling_at FROM batches b
+		WHERE b.status IN ('in_progress', 'cancelling', 'finalizing')
+		AND NOT EXISTS (
+			SELECT 1 FROM batch_requests r WHERE r.batch_id = b.id AND r.status = 'running'
+		)
+		AND (b.status IN ('cancelling', 'finalizing') OR NOT EXISTS (
+			SELECT 1 FROM batch_requests r WHERE r.batch_id = b.id AND r.status = 'pending'
+		))
+	`)
+	if err != nil {
+		logBatch.Warn("检查批处理失败", "error", err)
+		return
+	}
+
+	type readyBatch struct {
+		id        string
+		status    string
+		expiresAt int64
+		cancelled bool
+	}
+	var ready []readyBatch
+	for rows.Next() {
+		var b readyBatch
+		var expiresAt, cancellingAt sql.NullInt64
+		if err := rows.Scan(&b.id, &b.status, &expiresAt, &cancellingAt); err != nil {
+			continue
+		}
+		b.expiresAt = expiresAt.Int64
+		b.cancelled = cancellingAt.Valid
+		ready = append(ready, b)
+	}
+	rows.Close()
+
+	for _, b := range ready {
+		finalStatus := "completed"
+		if b.cancelled {
+			finalStatus = "cancelled"
+		} else if b.expiresAt > 0 && b.expiresAt < now {
+			var expired int
+			batchDB.QueryRow(`SELECT COUNT(*) FROM batch_requests WHERE batch_id = ? AND status = 'expired'`, b.id).Scan(&expired)
+			if expired > 0 {
+				finalStatus = "expired"
+			}
+		}
+		if err := finalizeBatch(b.id, finalStatus); err != nil {
+			logBatch.Error("批处理收尾失败", "batch_id", b.id, "error", err)
+		}
+	}
+}
+
+// 生成输出/错误文件并结束批处理（调用方持有 batchDBMutex）
+// 文件和最终状态在同一事务中写入，失败时批处理停留在 finalizing，由下次检查重新收尾
+func finalizeBatch(batchID, finalStatus string) error {
+	_, err := batchDB.Exec(`UPDATE batches SET status = 'finalizing', finalizing_at = COALESCE(finalizing_at, ?) WHERE id = ?`,
+		time.Now().Unix(), batchID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := batchDB.Query(`
+		SELECT custom_id, status, COALESCE(result, '') FROM batch_requests
+		WHERE batch_id = ? ORDER BY line_index ASC
+	`, batchID)
+	if err != nil {
+		return err
+	}
+
+	var output, errorsOut bytes.Buffer
+	for rows.Next() {
+		var customID, status, result string
+		if err := rows.Scan(&customID, &status, &result); err != nil {
+			continue
+		}
+		switch status {
+		case "completed":
+			output.WriteString(result)
+			output.WriteByte('\n')
+		case "failed":
+			errorsOut.WriteString(result)
+			errorsOut.WriteByte('\n')
+		case "expired":
+			requestID, _ := generatePrefixedID("batch_req_")
+			line, _ := json.Marshal(batchOutputLine{
+				ID:       requestID,
+				CustomID: customID,
+				Error:    &BatchError{Code: "batch_expired", Message: "This request could not be executed before the completion window expired."},
+			})
+			errorsOut.Write(line)
+			errorsOut.WriteByte('\n')
+		}
+	}
+	rows.Close()
+
+	var apiKeyID string
+	if err := batchDB.QueryRow(`SELECT api_key_id FROM batches WHERE id = ?`, batchID).Scan(&apiKeyID); err != nil {
+		return err
+	}
+
+	tx, err := batchDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var outputFileID, errorFileID interface{}
+	if output.Len() > 0 {
+		id, err := insertBatchFile(tx, apiKeyID, batchID+"_output.jsonl", "batch_output", output.Bytes())
+		if err != nil {
+			return err
+		}
+		outputFileID = id
+	}
+	if errorsOut.Len() > 0 {
+		id, err := insertBatchFile(tx, apiKeyID, batchID+"_error.jsonl", "batch_output", errorsOut.Bytes())
+		if err != nil {
+			return err
+		}
+		errorFileID = id
+	}
+
+	timeColumn := map[string]string{
+		"completed": "completed_at",
+		"cancelled": "cancelled_at",
+		"expired":   "expired_at",
+	}[finalStatus]
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE batches SET status = ?, output_file_id = ?, error_file_id = ?, %s = ?
+		WHERE id = ?
+	`, timeColumn), finalStatus, outputFileID, errorFileID, time.Now().Unix(), batchID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	logBatch.Info("批处理已结束", "batch_id", batchID, "status", finalStatus)
+	return nil
+}
+
+// 保存 API Key 的文件（调用方持有 batchDBMutex），db 可以是 batchDB 或收尾事务
+func insertBatchFile(db interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, apiKeyID, filename, purpose string, content []byte) (string, error) {
+	id, err := generatePrefixedID("file-")
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+		INSERT INTO files (id, api_key_id, filename, purpose, bytes, content, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, apiKeyID, filename, purpose, len(content), content, time.Now().Unix())
+	return id, err
+}
+
+// 获取 API Key 的文件元信息，不存在或不属于该 Key 时返回 sql.ErrNoRows
+func getBatchFile(apiKeyID, id string) (*BatchFile, error) {
+	file := &BatchFile{Object: "file"}
+	err := batchDB.QueryRow(`
+		SELECT id, COALESCE(filename, ''), purpose, bytes, created_at FROM files WHERE id = ? AND api_key_id = ?
+	`, id, apiKeyID).Scan(&file.ID, &file.Filename, &file.Purpose, &file.Bytes, &file.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// 获取 API Key 的文件内容
+func getBatchFileContent(apiKeyID, id string) ([]byte, error) {
+	var content []byte
+	err := batchDB.QueryRow(`SELECT content FROM files WHERE id = ? AND api_key_id = ?`, id, apiKeyID).Scan(&content)
+	return content, err
+}
+
+// 校验输入文件并创建批处理
+func createBatch(apiKeyID, inputFileID, endpoint, completionWindow string, metadata map[string]string) (*Batch, error) {
+	content, err := getBatchFileContent(apiKeyID, inputFileID)
+	if err != nil {
+		return nil, err
+	}
+
+	batchID, err := generatePrefixedID("batch_")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+
+	// 逐行校验
+	var lines []batchInputLine
+	var validationErrors []BatchError
+	seen := make(map[string]bool)
+	reader := bufio.NewReader(bytes.NewReader(content))
+	lineNo := 0
+	for {
+		raw, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(raw)) > 0 {
+			lineNo++
+			var line batchInputLine
+			if err := json.Unmarshal(raw, &line); err != nil {
+				validationErrors = append(validationErrors, BatchError{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON.", Line: lineNo})
+			} else if line.CustomID == "" {
+				validationErrors = append(validationErrors, BatchError{Code: "missing_required_parameter", Message: "Missing custom_id.", Param: "custom_id", Line: lineNo})
+			} else if seen[line.CustomID] {
+				validationErrors = append(validationErrors, BatchError{Code: "duplicate_custom_id", Message: "The custom_id for this request is a duplicate of another request.", Param: "custom_id", Line: lineNo})
+			} else if strings.ToUpper(line.Method) != "POST" {
+				validationErrors = append(validationErrors, BatchError{Code: "invalid_method", Message: "Only POST is supported.", Param: "method", Line: lineNo})
+			} else if line.URL != endpoint {
+				validationErrors = append(validationErrors, BatchError{Code: "invalid_url", Message: "The URL provided for this request does not match the batch endpoint.", Param: "url", Line: lineNo})
+			} else if len(line.Body) == 0 {
+				validationErrors = append(validationErrors, BatchError{Code: "missing_required_parameter", Message: "Missing body.", Param: "body", Line: lineNo})
+			} else {
+				seen[line.CustomID] = true
+				lines = append(lines, line)
+			}
+		}
+		if readErr != nil {
+			break
+		}
+	}
+	if lineNo == 0 {
+		validationErrors = append(validationErrors, BatchError{Code: "empty_file", Message: "The input file is empty."})
+	}
+	if lineNo > MAX_BATCH_REQUESTS {
+		validationErrors = append(validationErrors, BatchError{Code: "too_many_requests", Message: fmt.Sprintf("The input file contains more than %d requests.", MAX_BATCH_REQUESTS)})
+	}
+
+	metadataJSON, _ := json.Marshal(metadata)
+
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	tx, err := batchDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if len(validationErrors) > 0 {
+		errorsJSON, _ := json.Marshal(BatchErrorList{Object: "list", Data: validationErrors})
+		_, err = tx.Exec(`
+			INSERT INTO batches (id, api_key_id, endpoint, input_file_id, completion_window, status, errors, metadata, total, created_at, failed_at)
+			VALUES (?, ?, ?, ?, ?, 'failed', ?, ?, 0, ?, ?)
+		`, batchID, apiKeyID, endpoint, inputFileID, completionWindow, string(errorsJSON), string(metadataJSON), now, now)
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO batches (id, api_key_id, endpoint, input_file_id, completion_window, status, metadata, total, created_at, in_progress_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, 'in_progress', ?, ?, ?, ?, ?)
+		`, batchID, apiKeyID, endpoint, inputFileID, completionWindow, string(metadataJSON), len(lines), now, now,
+			now+int64(BATCH_COMPLETION_WINDOW.Seconds()))
+		for i, line := range lines {
+			if err != nil {
+				break
+			}
+			_, err = tx.Exec(`
+				INSERT INTO batch_requests (batch_id, line_index, custom_id, body, status, updated_at)
+				VALUES (?, ?, ?, ?, 'pending', ?)
+			`, batchID, i, line.CustomID, string(line.Body), now)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return getBatchLocked(apiKeyID, batchID)
+}
+
+// 获取 API Key 的批处理
+func getBatch(apiKeyID, id string) (*Batch, error) {
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+	return getBatchLocked(apiKeyID, id)
+}
+
+const batchColumns = `id, endpoint, input_file_id, completion_window, status, output_file_id, error_file_id,
+	errors, metadata, total, completed, failed, created_at, in_progress_at, expires_at, finalizing_at,
+	completed_at, failed_at, expired_at, cancelling_at, cancelled_at`
+
+// 扫描一行批处理记录
+func scanBatch(scanner interface{ Scan(...interface{}) error }) (*Batch, error) {
+	batch := &Batch{Object: "batch"}
+	var outputFileID, errorFileID, errorsJSON, metadataJSON sql.NullString
+	var inProgressAt, expiresAt, finalizingAt, completedAt, failedAt, expiredAt, cancellingAt, cancelledAt sql.NullInt64
+	err := scanner.Scan(&batch.ID, &batch.Endpoint, &batch.InputFileID, &batch.CompletionWindow, &batch.Status,
+		&outputFileID, &errorFileID, &errorsJSON, &metadataJSON,
+		&batch.RequestCounts.Total, &batch.RequestCounts.Completed, &batch.RequestCounts.Failed,
+		&batch.CreatedAt, &inProgressAt, &expiresAt, &finalizingAt,
+		&completedAt, &failedAt, &expiredAt, &cancellingAt, &cancelledAt)
+	if err != nil {
+		return nil, err
+	}
+
+	nullString := func(v sql.NullString) *string {
+		if !v.Valid {
+			return nil
+		}
+		return &v.String
+	}
+	nullInt := func(v sql.NullInt64) *int64 {
+		if !v.Valid {
+			return nil
+		}
+		return &v.Int64
+	}
+
+	batch.OutputFileID = nullString(outputFileID)
+	batch.ErrorFileID = nullString(errorFileID)
+	batch.InProgressAt = nullInt(inProgressAt)
+	batch.ExpiresAt = nullInt(expiresAt)
+	batch.FinalizingAt = nullInt(finalizingAt)
+	batch.CompletedAt = nullInt(completedAt)
+	batch.FailedAt = nullInt(failedAt)
+	batch.ExpiredAt = nullInt(expiredAt)
+	batch.CancellingAt = nullInt(cancellingAt)
+	batch.CancelledAt = nullInt(cancelledAt)
+	if errorsJSON.Valid && errorsJSON.String != "" {
+		batch.Errors = &BatchErrorList{}
+		json.Unmarshal([]byte(errorsJSON.String), batch.Errors)
+	}
+	if metadataJSON.Valid && metadataJSON.String != "" && metadataJSON.String != "null" {
+		json.Unmarshal([]byte(metadataJSON.String), &batch.Metadata)
+	}
+	return batch, nil
+}
+
+// 获取 API Key 的批处理，不存在或不属于该 Key 时返回 sql.ErrNoRows（调用方持有 batchDBMutex）
+func getBatchLocked(apiKeyID, id string) (*Batch, error) {
+	return scanBatch(batchDB.QueryRow(`SELECT `+batchColumns+` FROM batches WHERE id = ? AND api_key_id = ?`, id, apiKeyID))
+}
+
+// 获取 API Key 的批处理列表（按创建时间倒序，after 为分页游标）
+func listBatches(apiKeyID string, limit int, after string) ([]Batch, bool, error) {
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	query := `SELECT ` + batchColumns + ` FROM batches WHERE api_key_id = ?`
+	args := []interface{}{apiKeyID}
+	if after != "" {
+		query += ` AND (created_at < (SELECT created_at FROM batches WHERE id = ?)
+			OR (created_at = (SELECT created_at FROM batches WHERE id = ?) AND id < ?))`
+		args = append(args, after, after, after)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := batchDB.Query(query, args...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	batches := []Batch{}
+	for rows.Next() {
+		batch, err := scanBatch(rows)
+		if err != nil {
+			continue
+		}
+		batches = append(batches, *batch)
+	}
+
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	return batches, hasMore, nil
+}
+
+// 取消批处理：未执行的请求不再领取，执行中的请求完成后收尾
+func cancelBatch(apiKeyID, id string) (*Batch, error) {
+	batchDBMutex.Lock()
+	defer batchDBMutex.Unlock()
+
+	batch, err := getBatchLocked(apiKeyID, id)
+	if err != nil {
+		return nil, err
+	}
+	if batch.Status != "validating" && batch.Status != "in_progress" {
+		return batch, fmt.Errorf("无法取消状态为 %s 的批处理", batch.Status)
+	}
+
+	_, err = batchDB.Exec(`UPDATE batches SET status = 'cancelling', cancelling_at = ? WHERE id = ?`,
+		time.Now().Unix(), id)
+	if err != nil {
+		return nil, err
+	}
+	return getBatchLocked(apiKeyID, id)
+}
+
+// ==================== HTTP 处理函数 ====================
+
+// 处理 /v1/files（上传、列表）
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		purpose := r.URL.Query().Get("purpose")
+		query := `SELECT id, COALESCE(filename, ''), purpose, bytes, created_at FROM files WHERE api_key_id = ?`
+		args := []interface{}{clientKey.ID}
+		if purpose != "" {
+			query += ` AND purpose = ?`
+			args = append(args, purpose)
+		}
+		query += ` ORDER BY created_at DESC LIMIT 1000`
+
+		rows, err := batchDB.Query(query, args...)
+		if err != nil {
+			httpError(w, "Failed to list files", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		files := []BatchFile{}
+		for rows.Next() {
+			file := BatchFile{Object: "file"}
+			if err := rows.Scan(&file.ID, &file.Filename, &file.Purpose, &file.Bytes, &file.CreatedAt); err != nil {
+				continue
+			}
+			files = append(files, file)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"data":   files,
+		})
+
+	case "POST":
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_BATCH_FILE_SIZE+1<<20)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			httpError(w, "Invalid multipart form", http.StatusBadRequest)
+			return
+		}
+
+		purpose := r.FormValue("purpose")
+		if purpose != BATCH_FILE_PURPOSE_INPUT {
+			httpError(w, "Only purpose=batch is supported", http.StatusBadRequest)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			httpError(w, "No file uploaded", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(io.LimitReader(file, MAX_BATCH_FILE_SIZE+1))
+		if err != nil {
+			httpError(w, "Failed to read file", http.StatusBadRequest)
+			return
+		}
+		if len(content) > MAX_BATCH_FILE_SIZE {
+			httpError(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		batchDBMutex.Lock()
+		id, err := insertBatchFile(batchDB, clientKey.ID, header.Filename, purpose, content)
+		batchDBMutex.Unlock()
+		if err != nil {
+			logBatch.WarnContext(r.Context(), "保存文件失败", "error", err)
+			httpError(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+
+		fileInfo, err := getBatchFile(clientKey.ID, id)
+		if err != nil {
+			httpError(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
+		logBatch.DebugContext(r.Context(), "上传文件", "file_id", id, "bytes", len(content))
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileInfo)
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 处理 /v1/files/{id} 和 /v1/files/{id}/content
+func handleFileByID(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/files/"), "/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != "content") {
+		http.NotFound(w, r)
+		return
+	}
+
+	fileInfo, err := getBatchFile(clientKey.ID, id)
+	if err == sql.ErrNoRows {
+		httpError(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, "Failed to get file", http.StatusInternalServerError)
+		return
+	}
+
+	// 下载文件内容
+	if len(parts) == 2 {
+		if r.Method != "GET" {
+			httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		content, err := getBatchFileContent(clientKey.ID, id)
+		if err != nil {
+			httpError(w, "Failed to get file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/jsonl")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileInfo.Filename))
+		w.Write(content)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fileInfo)
+
+	case "DELETE":
+		batchDBMutex.Lock()
+		_, err := batchDB.Exec(`DELETE FROM files WHERE id = ? AND api_key_id = ?`, id, clientKey.ID)
+		batchDBMutex.Unlock()
+		if err != nil {
+			httpError(w, "Failed to delete file", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      id,
+			"object":  "file",
+			"deleted": true,
+		})
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 处理 /v1/batches（创建、列表）
+func handleBatches(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		limit := 20
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+				limit = l
+			}
+		}
+
+		batches, hasMore, err := listBatches(clientKey.ID, limit, r.URL.Query().Get("after"))
+		if err != nil {
+			logBatch.WarnContext(r.Context(), "获取批处理列表失败", "error", err)
+			httpError(w, "Failed to list batches", http.StatusInternalServerError)
+			return
+		}
+
+		response := map[string]interface{}{
+			"object":   "list",
+			"data":     batches,
+			"has_more": hasMore,
+			"first_id": nil,
+			"last_id":  nil,
+		}
+		if len(batches) > 0 {
+			response["first_id"] = batches[0].ID
+			response["last_id"] = batches[len(batches)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+	case "POST":
+		var req struct {
+			InputFileID      string            `json:"input_file_id"`
+			Endpoint         string            `json:"endpoint"`
+			CompletionWindow string            `json:"completion_window"`
+			Metadata         map[string]string `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if req.InputFileID == "" {
+			httpError(w, "Missing input_file_id", http.StatusBadRequest)
+			return
+		}
+		if req.Endpoint == "" {
+			req.Endpoint = BATCH_DEFAULT_ENDPOINT
+		}
+		if req.Endpoint != BATCH_DEFAULT_ENDPOINT {
+			httpError(w, "Only /v1/chat/completions is supported", http.StatusBadRequest)
+			return
+		}
+		if req.CompletionWindow == "" {
+			req.CompletionWindow = "24h"
+		}
+		if req.CompletionWindow != "24h" {
+			httpError(w, "Only completion_window=24h is supported", http.StatusBadRequest)
+			return
+		}
+
+		fileInfo, err := getBatchFile(clientKey.ID, req.InputFileID)
+		if err == sql.ErrNoRows {
+			httpError(w, "Input file not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httpError(w, "Failed to get input file", http.StatusInternalServerError)
+			return
+		}
+		if fileInfo.Purpose != BATCH_FILE_PURPOSE_INPUT {
+			httpError(w, "Input file must have purpose=batch", http.StatusBadRequest)
+			return
+		}
+
+		batch, err := createBatch(clientKey.ID, req.InputFileID, req.Endpoint, req.CompletionWindow, req.Metadata)
+		if err != nil {
+			logBatch.WarnContext(r.Context(), "创建批处理失败", "error", err)
+			httpError(w, "Failed to create batch", http.StatusInternalServerError)
+			return
+		}
+		logBatch.Info("创建批处理", "batch_id", batch.ID, "requests", batch.RequestCounts.Total, "status", batch.Status)
+		wakeBatchWorkers()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(batch)
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 处理 /v1/batches/{id} 和 /v1/batches/{id}/cancel
+func handleBatchByID(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/batches/"), "/"), "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 || (len(parts) == 2 && parts[1] != "cancel") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var batch *Batch
+	var err error
+	if len(parts) == 2 {
+		if r.Method != "POST" {
+			httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		batch, err = cancelBatch(clientKey.ID, id)
+		if err != nil && err != sql.ErrNoRows && batch != nil {
+			httpError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err == nil {
+			logBatch.Info("取消批处理", "batch_id", id)
+		}
+	} else {
+		if r.Method != "GET" {
+			httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		batch, err = getBatch(clientKey.ID, id)
+	}
+
+	if err == sql.ErrNoRows {
+		httpError(w, "Batch not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		httpError(w, "Failed to get batch", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(batch)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 使用临时目录中的批处理数据库，测试结束后恢复全局状态
+func useBatchDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "batch.db")
+
+	oldDB := batchDB
+	if err := initBatchDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		batchDB.Close()
+		batchDB = oldDB
+	})
+}
+
+// 上传输入文件并创建批处理
+func createTestBatch(t *testing.T, customIDs ...string) *Batch {
+	t.Helper()
+	var input strings.Builder
+	for _, id := range customIDs {
+		input.WriteString(`{"custom_id":"` + id + `","method":"POST","url":"/v1/chat/completions","body":{"messages":[]}}` + "\n")
+	}
+	fileID, err := insertBatchFile(batchDB, "key-1", "input.jsonl", "batch", []byte(input.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch, err := createBatch("key-1", fileID, "/v1/chat/completions", "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.Status != "in_progress" {
+		t.Fatalf("createBatch() status: got %q, want in_progress", batch.Status)
+	}
+	return batch
+}
+
+// 模拟 worker 执行完一个请求
+func setBatchRequestResult(t *testing.T, batchID, customID, status string) {
+	t.Helper()
+	_, err := batchDB.Exec(`UPDATE batch_requests SET status = ?, result = ? WHERE batch_id = ? AND custom_id = ?`,
+		status, `{"custom_id":"`+customID+`"}`, batchID, customID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 读取批处理的输出文件内容，文件不存在时返回空字符串
+func batchFileContent(t *testing.T, id *string) string {
+	t.Helper()
+	if id == nil {
+		return ""
+	}
+	content, err := getBatchFileContent("key-1", *id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestBatchLifecycle(t *testing.T) {
+	tests := []struct {
+		name       string
+		prepare    func(t *testing.T, batch *Batch)
+		wantStatus string
+		wantOutput int // 输出文件行数
+		wantErrors int // 错误文件行数
+	}{
+		{
+			name: "completed",
+			prepare: func(t *testing.T, batch *Batch) {
+				setBatchRequestResult(t, batch.ID, "a", "completed")
+				setBatchRequestResult(t, batch.ID, "b", "completed")
+			},
+			wantStatus: "completed",
+			wantOutput: 2,
+		},
+		{
+			name: "partially failed",
+			prepare: func(t *testing.T, batch *Batch) {
+				setBatchRequestResult(t, batch.ID, "a", "completed")
+				setBatchRequestResult(t, batch.ID, "b", "failed")
+			},
+			wantStatus: "completed",
+			wantOutput: 1,
+			wantErrors: 1,
+		},
+		{
+			name: "cancelled with pending requests",
+			prepare: func(t *testing.T, batch *Batch) {
+				setBatchRequestResult(t, batch.ID, "a", "completed")
+				if _, err := cancelBatch("key-1", batch.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantStatus: "cancelled",
+			wantOutput: 1,
+		},
+		{
+			name: "expired",
+			prepare: func(t *testing.T, batch *Batch) {
+				setBatchRequestResult(t, batch.ID, "a", "completed")
+				batchDB.Exec(`UPDATE batches SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Minute).Unix(), batch.ID)
+			},
+			wantStatus: "expired",
+			wantOutput: 1,
+			wantErrors: 1,
+		},
+		{
+			name: "resume finalizing",
+			prepare: func(t *testing.T, batch *Batch) {
+				setBatchRequestResult(t, batch.ID, "a", "completed")
+				setBatchRequestResult(t, batch.ID, "b", "completed")
+				batchDB.Exec(`UPDATE batches SET status = 'finalizing', finalizing_at = ? WHERE id = ?`, time.Now().Unix(), batch.ID)
+			},
+			wantStatus: "completed",
+			wantOutput: 2,
+		},
+		{
+			name: "resume cancelled finalizing",
+			prepare: func(t *testing.T, batch *Batch) {
+				if _, err := cancelBatch("key-1", batch.ID); err != nil {
+					t.Fatal(err)
+				}
+				batchDB.Exec(`UPDATE batches SET status = 'finalizing', finalizing_at = ? WHERE id = ?`, time.Now().Unix(), batch.ID)
+			},
+			wantStatus: "cancelled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useBatchDB(t)
+			batch := createTestBatch(t, "a", "b")
+			tt.prepare(t, batch)
+
+			checkBatches()
+
+			got, err := getBatch("key-1", batch.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Fatalf("status: got %q, want %q", got.Status, tt.wantStatus)
+			}
+			if got.FinalizingAt == nil {
+				t.Error("finalizing_at not set")
+			}
+			if n := strings.Count(batchFileContent(t, got.OutputFileID), "\n"); n != tt.wantOutput {
+				t.Errorf("output lines: got %d, want %d", n, tt.wantOutput)
+			}
+			errorsOut := batchFileContent(t, got.ErrorFileID)
+			if n := strings.Count(errorsOut, "\n"); n != tt.wantErrors {
+				t.Errorf("error lines: got %d, want %d", n, tt.wantErrors)
+			}
+			if tt.wantStatus == "expired" && !strings.Contains(errorsOut, "batch_expired") {
+				t.Errorf("error file missing batch_expired: %s", errorsOut)
+			}
+		})
+	}
+}
+
+func TestCheckBatchesWaitsForRunningRequests(t *testing.T) {
+	useBatchDB(t)
+	batch := createTestBatch(t, "a", "b")
+	setBatchRequestResult(t, batch.ID, "a", "completed")
+	setBatchRequestResult(t, batch.ID, "b", "running")
+
+	checkBatches()
+
+	got, err := getBatch("key-1", batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "in_progress" || got.OutputFileID != nil {
+		t.Errorf("got status %q with output %v, want in_progress without output", got.Status, got.OutputFileID)
+	}
+}
+
+func TestFinalizeBatchRollsBackFiles(t *testing.T) {
+	useBatchDB(t)
+	batch := createTestBatch(t, "a", "b")
+	setBatchRequestResult(t, batch.ID, "a", "completed")
+	setBatchRequestResult(t, batch.ID, "b", "failed")
+
+	// 错误文件写入失败时，已写入的输出文件应随事务回滚，批处理停留在 finalizing
+	batchDB.Exec(`CREATE TRIGGER reject_error_file BEFORE INSERT ON files
+		WHEN NEW.filename LIKE '%_error.jsonl' BEGIN SELECT RAISE(ABORT, 'disk full'); END`)
+	if err := finalizeBatch(batch.ID, "completed"); err == nil {
+		t.Fatal("finalizeBatch() succeeded, want error")
+	}
+
+	var files int
+	batchDB.QueryRow(`SELECT COUNT(*) FROM files WHERE filename LIKE ?`, batch.ID+"%").Scan(&files)
+	if files != 0 {
+		t.Errorf("orphan output files: got %d, want 0", files)
+	}
+	got, _ := getBatch("key-1", batch.ID)
+	if got.Status != "finalizing" {
+		t.Fatalf("status: got %q, want finalizing", got.Status)
+	}
+
+	// 故障恢复后下次检查完成收尾
+	batchDB.Exec(`DROP TRIGGER reject_error_file`)
+	checkBatches()
+	got, _ = getBatch("key-1", batch.ID)
+	if got.Status != "completed" || got.OutputFileID == nil || got.ErrorFileID == nil {
+		t.Errorf("got status %q, output %v, errors %v", got.Status, got.OutputFileID, got.ErrorFileID)
+	}
+}
+
+func TestBatchOwnership(t *testing.T) {
+	useBatchDB(t)
+	batch := createTestBatch(t, "a")
+
+	if _, err := getBatch("key-2", batch.ID); err != sql.ErrNoRows {
+		t.Errorf("getBatch(other key): got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := getBatchFile("key-2", batch.InputFileID); err != sql.ErrNoRows {
+		t.Errorf("getBatchFile(other key): got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := getBatchFileContent("key-2", batch.InputFileID); err != sql.ErrNoRows {
+		t.Errorf("getBatchFileContent(other key): got %v, want sql.ErrNoRows", err)
+	}
+	if _, err := cancelBatch("key-2", batch.ID); err != sql.ErrNoRows {
+		t.Errorf("cancelBatch(other key): got %v, want sql.ErrNoRows", err)
+	}
+	// 其他 Key 不能用别人的文件创建批处理
+	if _, err := createBatch("key-2", batch.InputFileID, "/v1/chat/completions", "24h", nil); err != sql.ErrNoRows {
+		t.Errorf("createBatch(other key's file): got %v, want sql.ErrNoRows", err)
+	}
+	if batches, _, err := listBatches("key-2", 10, ""); err != nil || len(batches) != 0 {
+		t.Errorf("listBatches(other key): got (%d, %v), want none", len(batches), err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 响应缓存相关 ====================
+//
+// 可选的精确匹配响应缓存（CACHE_ENABLED=true 开启）：
+//   - 缓存键为规范化请求（模型、消息、参数、思考开关）的 SHA-256
+//   - 存储后端为内存 LRU（memory）或 SQLite（sqlite），受 CACHE_TTL、
+//     CACHE_MAX_ENTRIES、CACHE_MAX_BYTES 限制
+//   - 是否使用缓存由客户端 API Key 的缓存策略决定
+//   - 命中时返回 X-Cache: HIT，流式请求按 SSE chunk 重放
+// 会话模式和后台模式的请求不参与缓存。
+
+// 流式重放时每个 chunk 的字符数
+const CACHE_REPLAY_CHUNK_RUNES = 64
+
+// 缓存的响应
+type cachedResponse struct {
+	Content   string
+	CreatedAt time.Time
+}
+
+// 缓存存储后端
+type responseCacheStore interface {
+	Get(key string) (*cachedResponse, bool)
+	Set(key string, entry *cachedResponse)
+}
+
+var responseCache responseCacheStore
+
+// 初始化响应缓存
+func initResponseCache() error {
+	switch CACHE_BACKEND {
+	case "memory":
+		responseCache = newMemoryResponseCache(CACHE_MAX_ENTRIES, CACHE_MAX_BYTES, CACHE_TTL)
+	case "sqlite":
+		store, err := newSQLiteResponseCache(CACHE_MAX_ENTRIES, CACHE_MAX_BYTES, CACHE_TTL)
+		if err != nil {
+			return err
+		}
+		responseCache = store
+	default:
+		return fmt.Errorf("未知的缓存后端: %s", CACHE_BACKEND)
+	}
+	return nil
+}
+
+// ---------- 缓存键 ----------
+
+// 参与缓存键计算的规范化请求
+type cacheKeyRequest struct {
+	Model       string    `json:"model"`
+	Messages    []Message `json:"messages"`
+	Temperature float64   `json:"temperature"`
+	MaxTokens   int       `json:"max_tokens"`
+	Thinking    bool      `json:"thinking"`
+}
+
+// 计算请求的缓存键
+func buildCacheKey(req OpenAIRequest, enableThinking bool) string {
+	normalized := cacheKeyRequest{
+		Model:       strings.ToLower(strings.TrimSpace(req.Model)),
+		Messages:    make([]Message, len(req.Messages)),
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+		Thinking:    enableThinking,
+	}
+	for i, msg := range req.Messages {
+		normalized.Messages[i] = Message{
+			Role:    strings.ToLower(strings.TrimSpace(msg.Role)),
+			Content: msg.Content,
+		}
+	}
+
+	data, _ := json.Marshal(normalized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 根据 API Key 策略判断请求是否可以使用缓存
+func isCacheable(key *APIKey, req OpenAIRequest, body []byte) bool {
+	if responseCache == nil || key == nil {
+		return false
+	}
+	if req.ConversationID != "" || req.Background {
+		return false
+	}
+
+	switch key.effectiveCachePolicy() {
+	case CACHE_POLICY_ALWAYS:
+		return true
+	case CACHE_POLICY_DETERMINISTIC:
+		// temperature 必须显式设置为 0
+		return req.Temperature == 0 && bytes.Contains(body, []byte(`"temperature"`))
+	}
+	return false
+}
+
+// ---------- 内存 LRU ----------
+
+type memoryCacheEntry struct {
+	key   string
+	value *cachedResponse
+	size  int
+}
+
+type memoryResponseCache struct {
+	mu         sync.Mutex
+	ll         *list.List
+	items      map[string]*list.Element
+	bytes      int
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+}
+
+func newMemoryResponseCache(maxEntries, maxBytes int, ttl time.Duration) *memoryResponseCache {
+	return &memoryResponseCache{
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+	}
+}
+
+func (c *memoryResponseCache) Get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	if time.Since(entry.value.CreatedAt) > c.ttl {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *memoryResponseCache) Set(key string, value *cachedResponse) {
+	size := len(key) + len(value.Content)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.items[key] = c.ll.PushFront(&memoryCacheEntry{key: key, value: value, size: size})
+	c.bytes += size
+
+	for (c.maxEntries > 0 && c.ll.Len() > c.maxEntries) || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *memoryResponseCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*memoryCacheEntry)
+	c.ll.Remove(elem)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+// ---------- SQLite ----------
+
+type sqliteResponseCache struct {
+	db         *sql.DB
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+}
+
+// 打开 SQLite 缓存（共用 register 数据库）
+func newSQLiteResponseCache(maxEntries, maxBytes int, ttl time.Duration) (*sqliteResponseCache, error) {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开缓存数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(2)
+	db.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS response_cache (
+		key TEXT PRIMARY KEY,
+		content TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_hit_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_response_cache_last_hit ON response_cache(last_hit_at);
+	`
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return nil, fmt.Errorf("创建缓存表失败: %v", err)
+	}
+
+	return &sqliteResponseCache{db: db, maxEntries: maxEntries, maxBytes: maxBytes, ttl: ttl}, nil
+}
+
+func (c *sqliteResponseCache) Get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var content string
+	var createdAt int64
+	err := c.db.QueryRow(`SELECT content, created_at FROM response_cache WHERE key = ? AND created_at >= ?`,
+		key, time.Now().Add(-c.ttl).Unix()).Scan(&content, &createdAt)
+	if err != nil {
+		return nil, false
+	}
+	c.db.Exec(`UPDATE response_cache SET last_hit_at = ? WHERE key = ?`, time.Now().Unix(), key)
+	return &cachedResponse{Content: content, CreatedAt: time.Unix(createdAt, 0)}, true
+}
+
+func (c *sqliteResponseCache) Set(key string, value *cachedResponse) {
+	size := len(key) + len(value.Content)
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now().Unix()
+	_, err := c.db.Exec(`
+		INSERT OR REPLACE INTO response_cache (key, content, size, created_at, last_hit_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, key, value.Content, size, value.CreatedAt.Unix(), now)
+	if err != nil {
+		logCache.Warn("写入响应缓存失败", "error", err)
+		return
+	}
+
+	// 淘汰过期条目，再按最近命中时间淘汰超出数量/大小限制的条目
+	c.db.Exec(`DELETE FROM response_cache WHERE created_at < ?`, time.Now().Add(-c.ttl).Unix())
+	if c.maxEntries > 0 {
+		c.db.Exec(`
+			DELETE FROM response_cache WHERE key IN (
+				SELECT key FROM response_cache ORDER BY last_hit_at DESC, created_at DESC LIMIT -1 OFFSET ?
+			)
+		`, c.maxEntries)
+	}
+	if c.maxBytes > 0 {
+		c.db.Exec(`
+			DELETE FROM response_cache WHERE key IN (
+				SELECT key FROM (
+					SELECT key, SUM(size) OVER (ORDER BY last_hit_at DESC, created_at DESC) AS total
+					FROM response_cache
+				) WHERE total > ?
+			)
+		`, c.maxBytes)
+	}
+}
+
+// Close 关闭缓存数据库（服务关闭时调用）
+func (c *sqliteResponseCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db.Close()
+}
+
+// ---------- 命中重放 ----------
+
+// 将缓存内容写回客户端：流式按 SSE chunk 重放，非流式返回完整响应
+func writeCachedResponse(w http.ResponseWriter, id string, stream bool, content string) {
+	w.Header().Set("X-Cache", "HIT")
+
+	if !stream {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newChatCompletionResponse(id, content))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher, _ := w.(http.Flusher)
+
+	newChunk := func(delta Delta, finishReason string) OpenAIResponse {
+		return OpenAIResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: time.Now().Unix(),
+			Model:   MODEL_NAME,
+			Choices: []Choice{{Index: 0, Delta: delta, FinishReason: finishReason}},
+		}
+	}
+
+	writeSSEChunk(w, newChunk(Delta{Role: "assistant"}, ""))
+	runes := []rune(content)
+	for start := 0; start < len(runes); start += CACHE_REPLAY_CHUNK_RUNES {
+		end := start + CACHE_REPLAY_CHUNK_RUNES
+		if end > len(runes) {
+			end = len(runes)
+		}
+		writeSSEChunk(w, newChunk(Delta{Content: string(runes[start:end])}, ""))
+	}
+	writeSSEChunk(w, newChunk(Delta{}, "stop"))
+	fmt.Fprintf(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
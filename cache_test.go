@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryResponseCacheLRU(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		maxBytes   int
+		sets       []string // 依次写入的键（内容为 "value"）
+		gets       []string // 写入后依次读取，用于更新最近使用顺序
+		extra      string   // 最后写入的键，触发淘汰
+		want       []string // 仍在缓存中的键
+		evicted    []string
+	}{
+		{
+			name:       "evicts least recently set",
+			maxEntries: 2,
+			sets:       []string{"a", "b"},
+			extra:      "c",
+			want:       []string{"b", "c"},
+			evicted:    []string{"a"},
+		},
+		{
+			name:       "get refreshes recency",
+			maxEntries: 2,
+			sets:       []string{"a", "b"},
+			gets:       []string{"a"},
+			extra:      "c",
+			want:       []string{"a", "c"},
+			evicted:    []string{"b"},
+		},
+		{
+			name:     "evicts by total bytes",
+			maxBytes: 12, // 每个条目 1 + 5 字节
+			sets:     []string{"a", "b"},
+			extra:    "c",
+			want:     []string{"b", "c"},
+			evicted:  []string{"a"},
+		},
+		{
+			name:       "overwrite does not grow",
+			maxEntries: 2,
+			sets:       []string{"a", "b", "a"},
+			extra:      "a",
+			want:       []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newMemoryResponseCache(tt.maxEntries, tt.maxBytes, time.Hour)
+			for _, key := range tt.sets {
+				c.Set(key, &cachedResponse{Content: "value", CreatedAt: time.Now()})
+			}
+			for _, key := range tt.gets {
+				c.Get(key)
+			}
+			c.Set(tt.extra, &cachedResponse{Content: "value", CreatedAt: time.Now()})
+
+			for _, key := range tt.want {
+				if _, ok := c.Get(key); !ok {
+					t.Errorf("Get(%q): miss, want hit", key)
+				}
+			}
+			for _, key := range tt.evicted {
+				if _, ok := c.Get(key); ok {
+					t.Errorf("Get(%q): hit, want evicted", key)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryResponseCacheRejectsOversizedEntry(t *testing.T) {
+	c := newMemoryResponseCache(0, 8, time.Hour)
+	c.Set("a", &cachedResponse{Content: "too long value", CreatedAt: time.Now()})
+	if _, ok := c.Get("a"); ok {
+		t.Error("oversized entry was cached")
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "cache.db")
+	sqliteCache, err := newSQLiteResponseCache(10, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqliteCache.Close() })
+
+	stores := map[string]responseCacheStore{
+		"memory": newMemoryResponseCache(10, 0, time.Hour),
+		"sqlite": sqliteCache,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			store.Set("fresh", &cachedResponse{Content: "fresh", CreatedAt: time.Now()})
+			store.Set("stale", &cachedResponse{Content: "stale", CreatedAt: time.Now().Add(-2 * time.Hour)})
+
+			if got, ok := store.Get("fresh"); !ok || got.Content != "fresh" {
+				t.Errorf("Get(fresh): got (%v, %v), want hit", got, ok)
+			}
+			if _, ok := store.Get("stale"); ok {
+				t.Error("Get(stale): hit, want expired")
+			}
+		})
+	}
+}
+
+func TestSQLiteResponseCacheEvictsByEntries(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "cache.db")
+	c, err := newSQLiteResponseCache(2, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, &cachedResponse{Content: key, CreatedAt: time.Now()})
+	}
+	var count int
+	c.db.QueryRow(`SELECT COUNT(*) FROM response_cache`).Scan(&count)
+	if count != 2 {
+		t.Errorf("entries: got %d, want 2", count)
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	oldCache, oldPolicy := responseCache, CACHE_DEFAULT_POLICY
+	t.Cleanup(func() { responseCache, CACHE_DEFAULT_POLICY = oldCache, oldPolicy })
+	responseCache = newMemoryResponseCache(10, 0, time.Hour)
+	CACHE_DEFAULT_POLICY = CACHE_POLICY_DETERMINISTIC
+
+	tests := []struct {
+		name   string
+		policy string
+		body   string
+		want   bool
+	}{
+		{name: "deterministic explicit zero", policy: CACHE_POLICY_DETERMINISTIC, body: `{"temperature":0}`, want: true},
+		{name: "deterministic omitted temperature", policy: CACHE_POLICY_DETERMINISTIC, body: `{}`, want: false},
+		{name: "deterministic non-zero", policy: CACHE_POLICY_DETERMINISTIC, body: `{"temperature":0.7}`, want: false},
+		{name: "default policy", policy: "", body: `{"temperature":0}`, want: true},
+		{name: "always", policy: CACHE_POLICY_ALWAYS, body: `{"temperature":0.7}`, want: true},
+		{name: "off", policy: CACHE_POLICY_OFF, body: `{"temperature":0}`, want: false},
+		{name: "conversation", policy: CACHE_POLICY_ALWAYS, body: `{"conversation_id":"conv_1"}`, want: false},
+		{name: "background", policy: CACHE_POLICY_ALWAYS, body: `{"background":true}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OpenAIRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatal(err)
+			}
+			key := &APIKey{ID: "key-1", CachePolicy: tt.policy}
+			if got := isCacheable(key, req, []byte(tt.body)); got != tt.want {
+				t.Errorf("isCacheable(): got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if isCacheable(nil, OpenAIRequest{}, []byte(`{"temperature":0}`)) {
+		t.Error("isCacheable(nil key): got true, want false")
+	}
+}
+
+func TestBuildCacheKey(t *testing.T) {
+	base := OpenAIRequest{Model: "GLM-4.5", Messages: []Message{{Role: "user", Content: "hi"}}}
+	tests := []struct {
+		name     string
+		req      OpenAIRequest
+		thinking bool
+		same     bool
+	}{
+		{name: "normalized model and role", req: OpenAIRequest{Model: " glm-4.5 ", Messages: []Message{{Role: "USER", Content: "hi"}}}, same: true},
+		{name: "stream ignored", req: OpenAIRequest{Model: "GLM-4.5", Stream: true, Messages: []Message{{Role: "user", Content: "hi"}}}, same: true},
+		{name: "different content", req: OpenAIRequest{Model: "GLM-4.5", Messages: []Message{{Role: "user", Content: "hello"}}}, same: false},
+		{name: "different max_tokens", req: OpenAIRequest{Model: "GLM-4.5", MaxTokens: 10, Messages: []Message{{Role: "user", Content: "hi"}}}, same: false},
+		{name: "thinking enabled", req: base, thinking: true, same: false},
+	}
+
+	want := buildCacheKey(base, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildCacheKey(tt.req, tt.thinking); (got == want) != tt.same {
+				t.Errorf("buildCacheKey(): same=%v, want %v", got == want, tt.same)
+			}
+		})
+	}
+}
+
+func TestWriteCachedResponseStream(t *testing.T) {
+	w := httptest.NewRecorder()
+	content := strings.Repeat("好", CACHE_REPLAY_CHUNK_RUNES+1)
+	writeCachedResponse(w, "chatcmpl-1", true, content)
+
+	body := w.Body.String()
+	if w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache: got %q, want HIT", w.Header().Get("X-Cache"))
+	}
+	// role chunk + 2 个内容 chunk + 结束 chunk + [DONE]
+	if n := strings.Count(body, "data: "); n != 5 {
+		t.Errorf("SSE events: got %d, want 5\n%s", n, body)
+	}
+	if !strings.HasSuffix(body, "data: [DONE]\n\n") {
+		t.Errorf("stream does not end with [DONE]: %q", body[len(body)-20:])
+	}
+}
@@ -0,0 +1,672 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hulisang/ZtoApi/register"
+)
+
+// ==================== 运维命令 ====================
+//
+// 不带参数或使用 serve 时启动服务，其他子命令用于在 cron / CI 中执行维护操作：
+//   - 结果以 JSON 输出到 stdout，成功时 success 为 true；日志输出到 stderr，默认只输出警告及以上级别
+//   - 退出码：0 成功，1 执行失败，2 参数错误
+//   - 与服务使用相同的 .env、配置文件和环境变量，可以在服务运行时执行
+
+// cliResult 命令的 JSON 输出
+type cliResult map[string]interface{}
+
+// cliCommand 一个子命令
+type cliCommand struct {
+	name string // 例如 "accounts list"
+	args string // 参数说明
+	desc string
+	raw  bool // 自行加载配置（config validate）
+	run  func(args []string) (cliResult, error)
+}
+
+// 参数错误，退出码为 2
+type cliUsageError struct{ msg string }
+
+func (e *cliUsageError) Error() string { return e.msg }
+
+func cliUsagef(format string, args ...interface{}) error {
+	return &cliUsageError{msg: fmt.Sprintf(format, args...)}
+}
+
+var cliCommands = []cliCommand{
+	{name: "accounts list", args: "[--filter has-apikey|no-apikey|inactive|today|week] [--search S] [--limit N] [--show-secrets]", desc: "列出注册账号（默认隐藏密码和 token）", run: cliAccountsList},
+	{name: "accounts import", args: "<文件|->", desc: "导入账号，每行 email----password----token----apikey", run: cliAccountsImport},
+	{name: "accounts export", args: "--output <文件>", desc: "按导入格式导出全部账号", run: cliAccountsExport},
+	{name: "accounts check", args: "[--filter F] [email...]", desc: "检测账号存活并更新状态", run: cliAccountsCheck},
+	{name: "accounts delete-inactive", desc: "删除失效账号", run: cliAccountsDeleteInactive},
+	{name: "keys list", desc: "列出客户端 API Key", run: cliKeysList},
+	{name: "keys create", args: "--name <名称> [--cache-policy off|deterministic|always]", desc: "创建客户端 API Key（完整 Key 只输出这一次）", run: cliKeysCreate},
+	{name: "keys revoke", args: "<id>", desc: "吊销客户端 API Key", run: cliKeysRevoke},
+	{name: "stats report", args: "[--days N] [--tz 时区]", desc: "输出累计统计、每日统计和按模型的每日统计", run: cliStatsReport},
+	{name: "db migrate", desc: "创建或升级所有数据表", run: cliDBMigrate},
+	{name: "db backup", args: "[--output <文件>]", desc: "在线备份数据库（默认写入数据库目录下的 backups/）", run: cliDBBackup},
+	{name: "db vacuum", desc: "整理数据库并回收空间", run: cliDBVacuum},
+	{name: "config validate", args: "[--file <配置文件>]", desc: "校验 .env、配置文件和环境变量", raw: true, run: cliConfigValidate},
+}
+
+// 执行子命令，返回退出码
+func runCLI(args []string) int {
+	switch args[0] {
+	case "serve":
+		if len(args) > 1 {
+			fmt.Fprintf(os.Stderr, "serve 不接受参数: %s\n", strings.Join(args[1:], " "))
+			return 2
+		}
+		serve()
+		return 0
+	case "help", "-h", "--help":
+		printCLIUsage(os.Stdout)
+		return 0
+	}
+
+	var cmd *cliCommand
+	if len(args) > 1 {
+		name := args[0] + " " + args[1]
+		for i := range cliCommands {
+			if cliCommands[i].name == name {
+				cmd = &cliCommands[i]
+				break
+			}
+		}
+	}
+	if cmd == nil {
+		writeCLIResult(nil, cliUsagef("未知的命令: %s", strings.Join(args, " ")))
+		printCLIUsage(os.Stderr)
+		return 2
+	}
+
+	if !cmd.raw {
+		if errs := setupCLI(); len(errs) > 0 {
+			writeCLIResult(cliResult{"errors": errorStrings(errs)}, fmt.Errorf("配置无效（%d 个错误）", len(errs)))
+			return 1
+		}
+	}
+	defer cleanupCLI()
+
+	result, err := cmd.run(args[2:])
+	writeCLIResult(result, err)
+	var usage *cliUsageError
+	switch {
+	case errors.As(err, &usage):
+		return 2
+	case err != nil:
+		return 1
+	}
+	return 0
+}
+
+func printCLIUsage(w io.Writer) {
+	fmt.Fprintf(w, "用法: %s [命令]\n\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(w, "  %-26s %s\n", "serve", "启动 API 服务（默认）")
+	for _, cmd := range cliCommands {
+		fmt.Fprintf(w, "  %-26s %s\n", cmd.name, cmd.desc)
+		if cmd.args != "" {
+			fmt.Fprintf(w, "  %-26s   %s\n", "", cmd.args)
+		}
+	}
+	fmt.Fprintf(w, "\n除 serve 外，命令结果以 JSON 输出到 stdout。\n")
+}
+
+// 输出 JSON 结果
+func writeCLIResult(result cliResult, err error) {
+	if result == nil {
+		result = cliResult{}
+	}
+	result["success"] = err == nil
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(result)
+}
+
+func errorStrings(errs []error) []string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return messages
+}
+
+// 加载配置并初始化日志
+func setupCLI() []error {
+	if errs := loadConfigSources(); len(errs) > 0 {
+		return errs
+	}
+	applyConfig()
+	// stdout 只输出 JSON，日志默认只输出警告及以上级别
+	if getEnv("LOG_LEVEL", "") == "" {
+		os.Setenv("LOG_LEVEL", "warn")
+	}
+	initLogging()
+	return nil
+}
+
+// 写完请求日志队列并关闭数据库
+func cleanupCLI() {
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_CANCEL_WAIT)
+	defer cancel()
+	stopJournalWriter(ctx)
+	closeDatabases()
+	register.CloseDB()
+}
+
+// 解析子命令参数；positional 为允许的位置参数个数（-1 表示不限）
+func parseCLIFlags(fs *flag.FlagSet, args []string, positional int) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return cliUsagef("%s: %v", fs.Name(), err)
+	}
+	if positional >= 0 && fs.NArg() > positional {
+		return cliUsagef("%s: 多余的参数 %s", fs.Name(), strings.Join(fs.Args()[positional:], " "))
+	}
+	return nil
+}
+
+func cliDBPath() string {
+	return getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+}
+
+// ---------- accounts ----------
+
+var accountFilters = []string{"", "has-apikey", "no-apikey", "inactive", "today", "week"}
+
+func initCLIRegister() error {
+	if err := register.InitRegisterSystem(cliDBPath()); err != nil {
+		return fmt.Errorf("注册系统初始化失败: %v", err)
+	}
+	return nil
+}
+
+// 按筛选条件读取全部账号
+func cliAccounts(filter, search string) ([]register.Account, int64, error) {
+	if !containsString(accountFilters, filter) {
+		return nil, 0, cliUsagef("无效的 --filter: %s", filter)
+	}
+	if err := initCLIRegister(); err != nil {
+		return nil, 0, err
+	}
+	return register.GetAccounts(1, 1000000, filter, search)
+}
+
+func cliAccountsList(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("accounts list", flag.ContinueOnError)
+	filter := fs.String("filter", "", "")
+	search := fs.String("search", "", "")
+	limit := fs.Int("limit", 0, "")
+	showSecrets := fs.Bool("show-secrets", false, "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if *limit < 0 {
+		return nil, cliUsagef("--limit 不能为负数")
+	}
+
+	accounts, total, err := cliAccounts(*filter, *search)
+	if err != nil {
+		return nil, err
+	}
+	if *limit > 0 && len(accounts) > *limit {
+		accounts = accounts[:*limit]
+	}
+	if !*showSecrets {
+		for i := range accounts {
+			accounts[i].Password = maskSecret(accounts[i].Password)
+			accounts[i].Token = tokenPrefix(accounts[i].Token)
+			accounts[i].APIKEY = tokenPrefix(accounts[i].APIKEY)
+		}
+	}
+	return cliResult{"total": total, "count": len(accounts), "accounts": accounts}, nil
+}
+
+func cliAccountsImport(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("accounts import", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 1); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, cliUsagef("accounts import: 需要指定文件（- 表示标准输入）")
+	}
+
+	var content []byte
+	var err error
+	if path := fs.Arg(0); path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取文件失败: %v", err)
+	}
+
+	if err := initCLIRegister(); err != nil {
+		return nil, err
+	}
+	imported, failed := register.ImportAccounts(string(content))
+	return cliResult{"imported": imported, "failed": failed}, nil
+}
+
+func cliAccountsExport(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("accounts export", flag.ContinueOnError)
+	output := fs.String("output", "", "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if *output == "" {
+		return nil, cliUsagef("accounts export: 需要指定 --output")
+	}
+
+	accounts, _, err := cliAccounts("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	// 导出文件包含密码和 token，只允许当前用户读写
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("创建文件失败: %v", err)
+	}
+	if err := register.WriteAccounts(file, accounts); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("写入文件失败: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("写入文件失败: %v", err)
+	}
+	return cliResult{"file": *output, "count": len(accounts)}, nil
+}
+
+func cliAccountsCheck(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("accounts check", flag.ContinueOnError)
+	filter := fs.String("filter", "", "")
+	if err := parseCLIFlags(fs, args, -1); err != nil {
+		return nil, err
+	}
+
+	emails := fs.Args()
+	if len(emails) > 0 && *filter != "" {
+		return nil, cliUsagef("accounts check: --filter 不能与邮箱同时使用")
+	}
+	if len(emails) == 0 {
+		accounts, _, err := cliAccounts(*filter, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, acc := range accounts {
+			emails = append(emails, acc.Email)
+		}
+	} else if err := initCLIRegister(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	active, inactive := 0, 0
+	if len(emails) > 0 {
+		// 检测进度通过 register 的日志输出（debug 级别）
+		logChan := make(chan string, 100)
+		go func() {
+			for range logChan {
+			}
+		}()
+		active, inactive = register.BatchCheckAccounts(emails, logChan)
+		close(logChan)
+	}
+	return cliResult{
+		"checked":     len(emails),
+		"active":      active,
+		"inactive":    inactive,
+		"duration_ms": time.Since(start).Milliseconds(),
+	}, nil
+}
+
+func cliAccountsDeleteInactive(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("accounts delete-inactive", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if err := initCLIRegister(); err != nil {
+		return nil, err
+	}
+	count, err := register.DeleteInactiveAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("删除失效账号失败: %v", err)
+	}
+	return cliResult{"deleted": count}, nil
+}
+
+// ---------- keys ----------
+
+func cliKeysList(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("keys list", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if err := initAPIKeyDB(); err != nil {
+		return nil, err
+	}
+	keys, err := listAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	return cliResult{"keys": keys}, nil
+}
+
+func cliKeysCreate(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+	name := fs.String("name", "", "")
+	cachePolicy := fs.String("cache-policy", "", "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(*name) == "" {
+		return nil, cliUsagef("keys create: 需要指定 --name")
+	}
+	if !isValidCachePolicy(*cachePolicy) {
+		return nil, cliUsagef("无效的 --cache-policy: %s", *cachePolicy)
+	}
+
+	if err := initAPIKeyDB(); err != nil {
+		return nil, err
+	}
+	k, key, err := createAPIKey(strings.TrimSpace(*name), *cachePolicy)
+	if err != nil {
+		return nil, fmt.Errorf("创建 API Key 失败: %v", err)
+	}
+	return cliResult{"key": k, "api_key": key}, nil
+}
+
+func cliKeysRevoke(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("keys revoke", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 1); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, cliUsagef("keys revoke: 需要指定 Key ID")
+	}
+
+	if err := initAPIKeyDB(); err != nil {
+		return nil, err
+	}
+	id := fs.Arg(0)
+	if err := revokeAPIKey(id); err == sql.ErrNoRows {
+		return cliResult{"id": id}, fmt.Errorf("API Key 不存在或已吊销")
+	} else if err != nil {
+		return nil, fmt.Errorf("吊销 API Key 失败: %v", err)
+	}
+	return cliResult{"id": id}, nil
+}
+
+// ---------- stats ----------
+
+func cliStatsReport(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("stats report", flag.ContinueOnError)
+	days := fs.Int("days", 7, "")
+	tz := fs.String("tz", "", "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if *days < 1 || *days > 90 {
+		return nil, cliUsagef("--days 必须在 1-90 之间")
+	}
+	loc := statsLocation
+	if *tz != "" {
+		var err error
+		if loc, err = parseTimezone(*tz); err != nil {
+			return nil, cliUsagef("无效的 --tz: %s", *tz)
+		}
+	}
+
+	if err := initStatsDB(); err != nil {
+		return nil, err
+	}
+	if err := loadCumulativeStats(); err != nil {
+		return nil, err
+	}
+	// 与每小时的定时任务相同，先从小时数据汇总每日统计，报表包含当天的数据
+	saveDailyStats()
+
+	var cumulative map[string]interface{}
+	json.Unmarshal(getStatsData(), &cumulative)
+	// 启动时间是本进程的，没有意义
+	delete(cumulative, "startTime")
+	if stats.StatsSince.IsZero() {
+		cumulative["statsSince"] = nil
+	}
+
+	var daily []DailyStats
+	var err error
+	if isStatsLocation(loc) {
+		daily, err = getDailyStats(*days)
+	} else {
+		daily, err = getDailyStatsIn(*days, loc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取每日统计失败: %v", err)
+	}
+	sort.Slice(daily, func(i, j int) bool { return daily[i].Date < daily[j].Date })
+
+	models, err := getDailyDimStats(*days, loc, statsDimQuery{groupBy: []string{"model"}})
+	if err != nil {
+		return nil, fmt.Errorf("读取模型统计失败: %v", err)
+	}
+
+	totals := map[string]int{"requests": 0, "success": 0, "failed": 0, "tokens": 0}
+	for _, d := range daily {
+		totals["requests"] += d.Requests
+		totals["success"] += d.Success
+		totals["failed"] += d.Failed
+		totals["tokens"] += d.Tokens
+	}
+
+	if daily == nil {
+		daily = []DailyStats{}
+	}
+	return cliResult{
+		"timezone":   loc.String(),
+		"days":       *days,
+		"cumulative": cumulative,
+		"totals":     totals,
+		"daily":      daily,
+		"models":     models,
+	}, nil
+}
+
+// ---------- db ----------
+
+// 数据库文件大小（含 WAL 文件）
+func dbFileSize(path string) int64 {
+	var size int64
+	for _, p := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(p); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func openCLIDB() (*sql.DB, error) {
+	path := cliDBPath()
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("数据库不存在: %s", path)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %v", err)
+	}
+	return db, nil
+}
+
+func cliDBMigrate(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("db migrate", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	dbPath := cliDBPath()
+	// 与各子系统启动时相同：建表并执行各自的迁移，不受功能开关影响
+	steps := []struct {
+		name string
+		init func() error
+	}{
+		{"register", func() error { return register.InitRegisterSystem(dbPath) }},
+		{"admin", initAdminDB},
+		{"stats", initStatsDB},
+		{"api_keys", initAPIKeyDB},
+		{"conversations", initConversationDB},
+		{"batch", initBatchDB},
+		{"jobs", initJobDB},
+		{"idempotency", initIdempotencyDB},
+		{"journal", initJournalDB},
+		{"settings", initSettingsDB},
+		{"response_cache", func() error {
+			store, err := newSQLiteResponseCache(CACHE_MAX_ENTRIES, CACHE_MAX_BYTES, CACHE_TTL)
+			if err != nil {
+				return err
+			}
+			return store.Close()
+		}},
+	}
+
+	var failed []string
+	results := make([]map[string]interface{}, 0, len(steps))
+	for _, step := range steps {
+		item := map[string]interface{}{"name": step.name, "ok": true}
+		if err := step.init(); err != nil {
+			item["ok"] = false
+			item["error"] = err.Error()
+			failed = append(failed, step.name)
+		}
+		results = append(results, item)
+	}
+
+	result := cliResult{"database": dbPath, "steps": results}
+	if statsDB != nil {
+		var tables []string
+		rows, err := statsDB.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+		if err == nil {
+			for rows.Next() {
+				var name string
+				if rows.Scan(&name) == nil {
+					tables = append(tables, name)
+				}
+			}
+			rows.Close()
+		}
+		result["tables"] = tables
+	}
+	if len(failed) > 0 {
+		return result, fmt.Errorf("迁移失败: %s", strings.Join(failed, ", "))
+	}
+	return result, nil
+}
+
+func cliDBBackup(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("db backup", flag.ContinueOnError)
+	output := fs.String("output", "", "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	dbPath := cliDBPath()
+	if *output == "" {
+		ext := filepath.Ext(dbPath)
+		name := strings.TrimSuffix(filepath.Base(dbPath), ext) + "-" + time.Now().Format("20060102-150405") + ext
+		*output = filepath.Join(filepath.Dir(dbPath), "backups", name)
+	}
+	if _, err := os.Stat(*output); err == nil {
+		return nil, fmt.Errorf("文件已存在: %s", *output)
+	}
+	if err := os.MkdirAll(filepath.Dir(*output), 0755); err != nil {
+		return nil, fmt.Errorf("创建备份目录失败: %v", err)
+	}
+
+	db, err := openCLIDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// VACUUM INTO 写出一致的快照，服务运行时也可以执行
+	start := time.Now()
+	if _, err := db.Exec(`VACUUM INTO ?`, *output); err != nil {
+		return nil, fmt.Errorf("备份失败: %v", err)
+	}
+	return cliResult{
+		"database":    dbPath,
+		"file":        *output,
+		"bytes":       dbFileSize(*output),
+		"duration_ms": time.Since(start).Milliseconds(),
+	}, nil
+}
+
+func cliDBVacuum(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("db vacuum", flag.ContinueOnError)
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+
+	db, err := openCLIDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	dbPath := cliDBPath()
+	before := dbFileSize(dbPath)
+	start := time.Now()
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		return nil, fmt.Errorf("整理数据库失败: %v", err)
+	}
+	return cliResult{
+		"database":     dbPath,
+		"bytes_before": before,
+		"bytes_after":  dbFileSize(dbPath),
+		"duration_ms":  time.Since(start).Milliseconds(),
+	}, nil
+}
+
+// ---------- config ----------
+
+func cliConfigValidate(args []string) (cliResult, error) {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	file := fs.String("file", "", "")
+	if err := parseCLIFlags(fs, args, 0); err != nil {
+		return nil, err
+	}
+	if *file != "" {
+		if _, err := os.Stat(*file); err != nil {
+			return nil, fmt.Errorf("配置文件不存在: %s", *file)
+		}
+		os.Setenv("CONFIG_FILE", *file)
+	}
+
+	errs := loadConfigSources()
+	result := cliResult{
+		"valid":   len(errs) == 0,
+		"file":    configFilePath,
+		"errors":  errorStrings(errs),
+		"sources": configSourcesSnapshot(),
+	}
+	if len(errs) > 0 {
+		return result, fmt.Errorf("配置无效（%d 个错误）", len(errs))
+	}
+	return result, nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hulisang/ZtoApi/logging"
+	"github.com/hulisang/ZtoApi/toml"
+)
+
+// ==================== 配置文件 ====================
+//
+// 配置来源优先级（从高到低）：
+//  1. 环境变量（包括 .env.local / .env 中的变量）
+//  2. KEY_FILE 环境变量指向的文件内容，例如 ZAI_TOKEN_FILE=/run/secrets/zai_token
+//  3. 配置文件 CONFIG_FILE（默认 ./config.toml，不存在时跳过），任意键都可以写成 key_file 从文件读取
+//  4. 默认值
+//
+// 启动时按 configSettings 校验所有配置项的类型和取值，有错误时全部列出后退出。
+// 收到 SIGHUP 或配置文件变化时重新加载：标记为 Reload 的配置项立即生效，
+// 其余配置项的修改会被忽略并提示需要重启。
+
+const CONFIG_WATCH_INTERVAL = 2 * time.Second // 配置文件变化检查间隔
+
+type settingKind int
+
+const (
+	kindString settingKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindDuration
+)
+
+// 配置项定义
+type configSetting struct {
+	Key    string // 配置文件中的键（表名.键名）
+	Env    string // 对应的环境变量
+	Kind   settingKind
+	Secret bool               // 错误信息和诊断中隐藏取值
+	Reload bool               // 重新加载时立即生效
+	Check  func(string) error // 类型检查通过后的取值检查
+}
+
+// 所有配置项
+var configSettings = []configSetting{
+	{Key: "server.port", Env: "PORT", Kind: kindString, Check: checkPort},
+	{Key: "server.api_key", Env: "DEFAULT_KEY", Kind: kindString, Secret: true},
+	{Key: "server.model_name", Env: "MODEL_NAME", Kind: kindString},
+	{Key: "server.default_stream", Env: "DEFAULT_STREAM", Kind: kindBool, Reload: true},
+	{Key: "server.enable_thinking", Env: "ENABLE_THINKING", Kind: kindBool, Reload: true},
+	{Key: "server.think_tags_mode", Env: "THINK_TAGS_MODE", Kind: kindString, Reload: true, Check: checkOneOf("strip", "think", "raw")},
+	{Key: "server.max_live_requests", Env: "MAX_LIVE_REQUESTS", Kind: kindInt, Reload: true, Check: checkMinInt(1)},
+	{Key: "server.shutdown_grace_period", Env: "SHUTDOWN_GRACE_PERIOD", Kind: kindDuration, Check: checkMinDuration(0)},
+
+	{Key: "upstream.url", Env: "UPSTREAM_URL", Kind: kindString, Check: checkHTTPURL},
+	{Key: "upstream.token", Env: "ZAI_TOKEN", Kind: kindString, Secret: true},
+	{Key: "upstream.timeout", Env: "UPSTREAM_TIMEOUT", Kind: kindDuration, Reload: true, Check: checkMinDuration(time.Second)},
+	{Key: "upstream.anonymous_token", Env: "ANON_TOKEN_ENABLED", Kind: kindBool, Reload: true},
+	{Key: "upstream.signing_secret", Env: "ZAI_SIGNING_SECRET", Kind: kindString, Secret: true, Reload: true},
+
+	{Key: "log.debug", Env: "DEBUG_MODE", Kind: kindBool, Reload: true},
+	{Key: "log.level", Env: "LOG_LEVEL", Kind: kindString, Reload: true, Check: checkOneOf("debug", "info", "warn", "error")},
+	{Key: "log.format", Env: "LOG_FORMAT", Kind: kindString, Reload: true, Check: checkOneOf("text", "json")},
+	{Key: "log.module_levels", Env: "LOG_MODULE_LEVELS", Kind: kindString, Reload: true, Check: checkModuleLevels},
+	{Key: "log.redact_content", Env: "LOG_REDACT_CONTENT", Kind: kindBool, Reload: true},
+
+	{Key: "dashboard.enabled", Env: "DASHBOARD_ENABLED", Kind: kindBool},
+	{Key: "dashboard.alert_error_rate", Env: "DASHBOARD_ALERT_ERROR_RATE", Kind: kindFloat, Check: checkFloatRange(0, 1)},
+	{Key: "dashboard.alert_slow_ms", Env: "DASHBOARD_ALERT_SLOW_MS", Kind: kindInt, Check: checkMinInt(0)},
+
+	{Key: "admin.enabled", Env: "ADMIN_ENABLED", Kind: kindBool},
+	{Key: "admin.username", Env: "ADMIN_USERNAME", Kind: kindString},
+	{Key: "admin.password", Env: "ADMIN_PASSWORD", Kind: kindString, Secret: true},
+
+	{Key: "register.enabled", Env: "REGISTER_ENABLED", Kind: kindBool},
+	{Key: "register.db_path", Env: "REGISTER_DB_PATH", Kind: kindString},
+	{Key: "register.username", Env: "ZAI_USERNAME", Kind: kindString},
+	{Key: "register.password", Env: "ZAI_PASSWORD", Kind: kindString, Secret: true},
+
+	{Key: "conversations.enabled", Env: "CONVERSATIONS_ENABLED", Kind: kindBool},
+
+	{Key: "batch.enabled", Env: "BATCH_ENABLED", Kind: kindBool},
+	{Key: "batch.concurrency", Env: "BATCH_CONCURRENCY", Kind: kindInt, Check: checkMinInt(1)},
+
+	{Key: "background.enabled", Env: "BACKGROUND_ENABLED", Kind: kindBool},
+	{Key: "background.callback_secret", Env: "BACKGROUND_CALLBACK_SECRET", Kind: kindString, Secret: true},
+
+	{Key: "idempotency.ttl", Env: "IDEMPOTENCY_TTL", Kind: kindDuration, Check: checkMinDuration(time.Second)},
+
+	{Key: "cache.enabled", Env: "CACHE_ENABLED", Kind: kindBool},
+	{Key: "cache.backend", Env: "CACHE_BACKEND", Kind: kindString, Check: checkOneOf("memory", "sqlite")},
+	{Key: "cache.ttl", Env: "CACHE_TTL", Kind: kindDuration, Check: checkMinDuration(time.Second)},
+	{Key: "cache.max_entries", Env: "CACHE_MAX_ENTRIES", Kind: kindInt, Check: checkMinInt(0)},
+	{Key: "cache.max_bytes", Env: "CACHE_MAX_BYTES", Kind: kindInt, Check: checkMinInt(0)},
+	{Key: "cache.default_policy", Env: "CACHE_DEFAULT_POLICY", Kind: kindString, Check: checkCachePolicy},
+
+	{Key: "metrics.enabled", Env: "METRICS_ENABLED", Kind: kindBool},
+	{Key: "metrics.token", Env: "METRICS_TOKEN", Kind: kindString, Secret: true},
+
+	{Key: "tracing.enabled", Env: "TRACING_ENABLED", Kind: kindBool},
+	{Key: "tracing.endpoint", Env: "OTEL_EXPORTER_OTLP_ENDPOINT", Kind: kindString, Check: checkHTTPURL},
+	{Key: "tracing.traces_endpoint", Env: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", Kind: kindString, Check: checkHTTPURL},
+	{Key: "tracing.headers", Env: "OTEL_EXPORTER_OTLP_HEADERS", Kind: kindString, Secret: true},
+	{Key: "tracing.service_name", Env: "OTEL_SERVICE_NAME", Kind: kindString},
+	{Key: "tracing.sample_ratio", Env: "TRACING_SAMPLE_RATIO", Kind: kindFloat, Check: checkFloatRange(0, 1)},
+
+	{Key: "stats.hourly_retention_days", Env: "STATS_HOURLY_RETENTION_DAYS", Kind: kindInt, Check: checkMinInt(0)},
+	{Key: "stats.daily_retention_days", Env: "STATS_DAILY_RETENTION_DAYS", Kind: kindInt, Check: checkMinInt(0)},
+	{Key: "stats.timezone", Env: "STATS_TIMEZONE", Kind: kindString, Check: checkTimezone},
+
+	{Key: "journal.enabled", Env: "JOURNAL_ENABLED", Kind: kindBool},
+	{Key: "journal.sample_rate", Env: "JOURNAL_SAMPLE_RATE", Kind: kindFloat, Check: checkFloatRange(0, 1)},
+	{Key: "journal.redact", Env: "JOURNAL_REDACT", Kind: kindString, Check: checkOneOf(JOURNAL_REDACT_SECRETS, JOURNAL_REDACT_CONTENT, JOURNAL_REDACT_NONE)},
+	{Key: "journal.max_field_bytes", Env: "JOURNAL_MAX_FIELD_BYTES", Kind: kindInt, Check: checkMinInt(1)},
+	{Key: "journal.retention_days", Env: "JOURNAL_RETENTION_DAYS", Kind: kindInt, Check: checkMinInt(0)},
+
+	{Key: "health.readyz_upstream_failures", Env: "READYZ_UPSTREAM_FAILURES", Kind: kindInt, Check: checkMinInt(0)},
+}
+
+// 可热重载的配置值，并发读写安全
+type reloadable[T any] struct {
+	v atomic.Pointer[T]
+}
+
+func (r *reloadable[T]) Load() T {
+	if p := r.v.Load(); p != nil {
+		return *p
+	}
+	var zero T
+	return zero
+}
+
+func (r *reloadable[T]) Store(v T) {
+	r.v.Store(&v)
+}
+
+var (
+	configFilePath string // 使用中的配置文件，未使用时为空
+	configMu       sync.Mutex
+	// 由环境变量或 KEY_FILE 设置的配置项，配置文件不会覆盖
+	configExternal = map[string]bool{}
+	// 各配置项的来源，用于错误信息和诊断
+	configSources = map[string]string{}
+)
+
+// ---------- 取值检查 ----------
+
+func checkOneOf(values ...string) func(string) error {
+	return func(v string) error {
+		for _, allowed := range values {
+			if strings.EqualFold(v, allowed) {
+				return nil
+			}
+		}
+		return fmt.Errorf("可选值为 %s", strings.Join(values, " / "))
+	}
+}
+
+func checkMinInt(min int) func(string) error {
+	return func(v string) error {
+		if n, _ := strconv.Atoi(v); n < min {
+			return fmt.Errorf("不能小于 %d", min)
+		}
+		return nil
+	}
+}
+
+func checkFloatRange(min, max float64) func(string) error {
+	return func(v string) error {
+		if f, _ := strconv.ParseFloat(v, 64); f < min || f > max {
+			return fmt.Errorf("应在 %g 到 %g 之间", min, max)
+		}
+		return nil
+	}
+}
+
+func checkMinDuration(min time.Duration) func(string) error {
+	return func(v string) error {
+		if d, _ := time.ParseDuration(v); d < min {
+			return fmt.Errorf("不能小于 %v", min)
+		}
+		return nil
+	}
+}
+
+func checkPort(v string) error {
+	if n, err := strconv.Atoi(strings.TrimPrefix(v, ":")); err != nil || n < 1 || n > 65535 {
+		return errors.New("应为 1-65535 的端口号")
+	}
+	return nil
+}
+
+func checkHTTPURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("应为 http:// 或 https:// 开头的地址")
+	}
+	return nil
+}
+
+func checkModuleLevels(v string) error {
+	_, err := logging.ParseModuleLevels(v)
+	return err
+}
+
+func checkCachePolicy(v string) error {
+	if !isValidCachePolicy(v) {
+		return errors.New("不是有效的缓存策略")
+	}
+	return nil
+}
+
+func checkTimezone(v string) error {
+	_, err := parseTimezone(v)
+	return err
+}
+
+// 检查一个配置项的取值
+func (s configSetting) validate(v string) error {
+	var err error
+	switch s.Kind {
+	case kindInt:
+		if _, err = strconv.Atoi(v); err != nil {
+			return errors.New("应为整数")
+		}
+	case kindFloat:
+		if _, err = strconv.ParseFloat(v, 64); err != nil {
+			return errors.New("应为数字")
+		}
+	case kindBool:
+		if _, err = strconv.ParseBool(v); err != nil {
+			return errors.New("应为 true 或 false")
+		}
+	case kindDuration:
+		if _, err = time.ParseDuration(v); err != nil {
+			return errors.New("应为时长，例如 30s、5m、1h")
+		}
+	}
+	if s.Check != nil {
+		return s.Check(v)
+	}
+	return nil
+}
+
+// ---------- 加载 ----------
+
+// 配置文件中的一个值
+type configFileValue struct {
+	value  string
+	source string
+}
+
+// 加载配置（.env 文件之后调用）：记录环境变量、读取 KEY_FILE 和配置文件并校验，返回所有错误
+func loadConfig() []error {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	var errs []error
+	for _, s := range configSettings {
+		if os.Getenv(s.Env) != "" {
+			configExternal[s.Env] = true
+			configSources[s.Env] = "环境变量"
+			continue
+		}
+		if path := os.Getenv(s.Env + "_FILE"); path != "" {
+			value, err := readSecretFile(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s_FILE: %v", s.Env, err))
+				continue
+			}
+			os.Setenv(s.Env, value)
+			configExternal[s.Env] = true
+			configSources[s.Env] = s.Env + "_FILE"
+		}
+	}
+
+	configFilePath = getEnv("CONFIG_FILE", "")
+	if configFilePath == "" {
+		if _, err := os.Stat("config.toml"); err == nil {
+			configFilePath = "config.toml"
+		}
+	}
+
+	errs = append(errs, applyConfigFile()...)
+	return append(errs, validateConfig()...)
+}
+
+// 读取配置文件并写入未由环境变量设置的配置项
+func applyConfigFile() []error {
+	if configFilePath == "" {
+		return nil
+	}
+	values, errs := readConfigFile(configFilePath)
+	if len(errs) > 0 {
+		return errs
+	}
+	for _, s := range configSettings {
+		if configExternal[s.Env] {
+			continue
+		}
+		if v, ok := values[s.Env]; ok {
+			os.Setenv(s.Env, v.value)
+			configSources[s.Env] = v.source
+		} else if _, ok := configSources[s.Env]; ok {
+			// 已从配置文件中删除
+			os.Unsetenv(s.Env)
+			delete(configSources, s.Env)
+		}
+	}
+	return nil
+}
+
+// 解析配置文件，返回以环境变量名为键的取值
+func readConfigFile(path string) (map[string]configFileValue, []error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, []error{fmt.Errorf("读取配置文件失败: %v", err)}
+	}
+	doc, err := toml.Parse(data)
+	if err != nil {
+		var syntaxErr *toml.Error
+		if errors.As(err, &syntaxErr) {
+			return nil, []error{fmt.Errorf("%s:%d: %s", path, syntaxErr.Line, syntaxErr.Msg)}
+		}
+		return nil, []error{fmt.Errorf("%s: %v", path, err)}
+	}
+
+	byKey := make(map[string]configSetting, len(configSettings))
+	for _, s := range configSettings {
+		byKey[s.Key] = s
+	}
+
+	// 按行号排序，错误信息与文件顺序一致
+	keys := make([]string, 0, len(doc))
+	for key := range doc {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return doc[keys[i]].Line < doc[keys[j]].Line })
+
+	values := map[string]configFileValue{}
+	var errs []error
+	for _, key := range keys {
+		v := doc[key]
+		where := fmt.Sprintf("%s:%d %s", path, v.Line, key)
+
+		s, ok := byKey[key]
+		fromFile := false
+		if !ok {
+			// key_file 从文件读取 key 的值
+			if s, ok = byKey[strings.TrimSuffix(key, "_file")]; !ok {
+				errs = append(errs, fmt.Errorf("%s: 未知的配置项", where))
+				continue
+			}
+			fromFile = true
+			if _, dup := doc[s.Key]; dup {
+				errs = append(errs, fmt.Errorf("%s: 不能同时设置 %s 和 %s", where, s.Key, key))
+				continue
+			}
+		}
+
+		var value string
+		if fromFile {
+			secretPath, isString := v.Data.(string)
+			if !isString {
+				errs = append(errs, fmt.Errorf("%s: 应为文件路径字符串", where))
+				continue
+			}
+			if value, err = readSecretFile(secretPath); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", where, err))
+				continue
+			}
+		} else if value, err = configValueString(v.Data, s.Kind); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", where, err))
+			continue
+		}
+		if value != "" {
+			if err := s.validate(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s = %s 无效: %v", where, displayValue(s, value), err))
+				continue
+			}
+		}
+		values[s.Env] = configFileValue{value: value, source: where}
+	}
+	return values, errs
+}
+
+// 将配置文件中的值转为字符串，类型不符时返回错误
+func configValueString(data interface{}, kind settingKind) (string, error) {
+	switch v := data.(type) {
+	case string:
+		if kind == kindString || kind == kindDuration {
+			return v, nil
+		}
+	case bool:
+		if kind == kindBool {
+			return strconv.FormatBool(v), nil
+		}
+	case int64:
+		// 端口等字符串配置也可以写成整数
+		if kind == kindInt || kind == kindFloat || kind == kindString {
+			return strconv.FormatInt(v, 10), nil
+		}
+	case float64:
+		if kind == kindFloat {
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+	}
+	switch kind {
+	case kindInt:
+		return "", errors.New("应为整数")
+	case kindFloat:
+		return "", errors.New("应为数字")
+	case kindBool:
+		return "", errors.New("应为 true 或 false")
+	case kindDuration:
+		return "", errors.New(`应为时长字符串，例如 "30s"`)
+	}
+	return "", errors.New("应为字符串")
+}
+
+// 读取密钥文件，去掉末尾换行
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取文件失败: %v", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// 校验当前生效的所有配置项
+func validateConfig() []error {
+	var errs []error
+	for _, s := range configSettings {
+		v := os.Getenv(s.Env)
+		if v == "" {
+			continue
+		}
+		if err := s.validate(v); err != nil {
+			errs = append(errs, fmt.Errorf("%s = %s 无效: %v（来源: %s）", s.Env, displayValue(s, v), err, configSources[s.Env]))
+		}
+	}
+	return errs
+}
+
+// 错误信息中显示的取值，密钥类配置隐藏
+func displayValue(s configSetting, v string) string {
+	if s.Secret {
+		return maskSecret(v)
+	}
+	return strconv.Quote(v)
+}
+
+// 读取可热重载的配置项（启动和重新加载时调用，取值已校验）
+func applyRuntimeConfig() {
+	// DEBUG_MODE / DEFAULT_STREAM / ENABLE_THINKING / ANON_TOKEN_ENABLED 可被运行时设置覆盖
+	applyRuntimeSettings()
+	THINK_TAGS_MODE.Store(strings.ToLower(getEnv("THINK_TAGS_MODE", "strip")))
+	timeout, _ := time.ParseDuration(getEnv("UPSTREAM_TIMEOUT", "60s"))
+	UPSTREAM_TIMEOUT.Store(timeout)
+	maxLive, _ := strconv.Atoi(getEnv("MAX_LIVE_REQUESTS", "100"))
+	MAX_LIVE_REQUESTS.Store(maxLive)
+}
+
+// ---------- 重新加载 ----------
+
+// 重新加载配置文件，出错时保持当前配置
+func reloadConfig(reason string) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	before := make(map[string]string, len(configSettings))
+	for _, s := range configSettings {
+		before[s.Env] = os.Getenv(s.Env)
+	}
+	sourcesBefore := make(map[string]string, len(configSources))
+	for k, v := range configSources {
+		sourcesBefore[k] = v
+	}
+	restore := func(env string) {
+		if value := before[env]; value != "" {
+			os.Setenv(env, value)
+		} else {
+			os.Unsetenv(env)
+		}
+		if source, ok := sourcesBefore[env]; ok {
+			configSources[env] = source
+		} else {
+			delete(configSources, env)
+		}
+	}
+
+	errs := applyConfigFile()
+	if len(errs) == 0 {
+		errs = validateConfig()
+	}
+	if len(errs) > 0 {
+		for _, s := range configSettings {
+			restore(s.Env)
+		}
+		logConfig.Error("重新加载配置失败，继续使用当前配置", "reason", reason, "errors", errs)
+		return
+	}
+
+	var applied, pending []string
+	for _, s := range configSettings {
+		if os.Getenv(s.Env) == before[s.Env] {
+			continue
+		}
+		if s.Reload {
+			applied = append(applied, s.Env)
+			continue
+		}
+		// 运行中的配置不变，保持进程环境一致
+		pending = append(pending, s.Env)
+		restore(s.Env)
+	}
+	if len(applied) == 0 && len(pending) == 0 {
+		logConfig.Debug("配置无变化", "reason", reason)
+		return
+	}
+
+	if len(applied) > 0 {
+		applyRuntimeConfig()
+		initLogging()
+		logConfig.Info("配置已重新加载", "reason", reason, "applied", strings.Join(applied, ","))
+		var overridden []string
+		for _, env := range applied {
+			if runtimeSettingOverridden(env) {
+				overridden = append(overridden, env)
+			}
+		}
+		if len(overridden) > 0 {
+			logConfig.Warn("以下配置项已被 Admin 面板中的运行时设置覆盖，修改暂不生效", "settings", strings.Join(overridden, ","))
+		}
+	}
+	if len(pending) > 0 {
+		logConfig.Warn("以下配置项的修改需要重启后生效", "settings", strings.Join(pending, ","))
+	}
+}
+
+// 收到 SIGHUP 或配置文件变化时重新加载
+func startConfigWatcher() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				reloadConfig("SIGHUP")
+			case <-serverStopping:
+				return
+			}
+		}
+	}()
+
+	if configFilePath == "" {
+		return
+	}
+	version := configFileVersion()
+	startPeriodicTask(CONFIG_WATCH_INTERVAL, func() {
+		if v := configFileVersion(); v != version {
+			version = v
+			reloadConfig("配置文件变化")
+		}
+	})
+}
+
+// 配置文件的修改时间和大小，文件不存在时为空
+func configFileVersion() string {
+	info, err := os.Stat(configFilePath)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// 各配置项的来源（诊断用）
+func configSourcesSnapshot() map[string]string {
+	configMu.Lock()
+	defer configMu.Unlock()
+	sources := make(map[string]string, len(configSources))
+	for k, v := range configSources {
+		sources[k] = v
+	}
+	return sources
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 使用临时配置文件，并在测试结束后恢复配置相关的全局状态和环境变量
+func useConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	writeTestFile(t, path, content)
+
+	oldPath, oldExternal, oldSources := configFilePath, configExternal, configSources
+	t.Cleanup(func() {
+		configFilePath, configExternal, configSources = oldPath, oldExternal, oldSources
+	})
+	configFilePath = path
+	configExternal = map[string]bool{}
+	configSources = map[string]string{}
+	for _, s := range configSettings {
+		t.Setenv(s.Env, "")
+	}
+	return path
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadConfigFileSecretFile(t *testing.T) {
+	dir := t.TempDir()
+	token := filepath.Join(dir, "token")
+	writeTestFile(t, token, "zai-token\r\n")
+	path := useConfigFile(t, "[server]\napi_key = \"sk-test\"\n[upstream]\ntoken_file = '"+token+"'\n")
+
+	values, errs := readConfigFile(path)
+	if len(errs) > 0 {
+		t.Fatalf("readConfigFile() errors: %v", errs)
+	}
+	if got := values["ZAI_TOKEN"].value; got != "zai-token" {
+		t.Errorf("ZAI_TOKEN: got %q, want %q", got, "zai-token")
+	}
+	if got := values["ZAI_TOKEN"].source; !strings.HasSuffix(got, ":4 upstream.token_file") {
+		t.Errorf("ZAI_TOKEN source: got %q", got)
+	}
+	if got := values["DEFAULT_KEY"].value; got != "sk-test" {
+		t.Errorf("DEFAULT_KEY: got %q, want %q", got, "sk-test")
+	}
+}
+
+func TestReadConfigFileSecretFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	badPort := filepath.Join(dir, "port")
+	writeTestFile(t, badPort, "not-a-port\n")
+	password := filepath.Join(dir, "password")
+	writeTestFile(t, password, "secret\n")
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "value and file both set",
+			content: "[admin]\npassword = \"x\"\npassword_file = '" + password + "'\n",
+			want:    "不能同时设置 admin.password 和 admin.password_file",
+		},
+		{
+			name:    "path is not a string",
+			content: "[upstream]\ntoken_file = 1\n",
+			want:    "应为文件路径字符串",
+		},
+		{
+			name:    "missing file",
+			content: "[upstream]\ntoken_file = '" + missing + "'\n",
+			want:    "读取文件失败",
+		},
+		{
+			name:    "unknown key",
+			content: "[upstream]\nunknown_file = '" + password + "'\n",
+			want:    "upstream.unknown_file: 未知的配置项",
+		},
+		{
+			name:    "file content is validated",
+			content: "[server]\nport_file = '" + badPort + "'\n",
+			want:    `server.port_file = "not-a-port" 无效`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := useConfigFile(t, tt.content)
+			_, errs := readConfigFile(path)
+			if len(errs) != 1 {
+				t.Fatalf("got %d errors %v, want 1", len(errs), errs)
+			}
+			if !strings.Contains(errs[0].Error(), tt.want) {
+				t.Errorf("got %q, want it to contain %q", errs[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadConfigRollback(t *testing.T) {
+	const initial = "[server]\nport = \"8081\"\nthink_tags_mode = \"think\"\n"
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"syntax error", "[server\nport = \"8082\"\n"},
+		{"invalid value", "[server]\nport = \"8082\"\nthink_tags_mode = \"bogus\"\n"},
+		{"removed key with invalid value", "[server]\nthink_tags_mode = \"raw\"\nmax_live_requests = 0\n"},
+		{"unreadable secret file", "[server]\nthink_tags_mode = \"raw\"\n[upstream]\ntoken_file = '/nonexistent/token'\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := useConfigFile(t, initial)
+			if errs := applyConfigFile(); len(errs) > 0 {
+				t.Fatalf("applyConfigFile() errors: %v", errs)
+			}
+			sources := configSourcesSnapshot()
+
+			writeTestFile(t, path, tt.content)
+			reloadConfig("test")
+
+			if got := os.Getenv("PORT"); got != "8081" {
+				t.Errorf("PORT: got %q, want %q", got, "8081")
+			}
+			if got := os.Getenv("THINK_TAGS_MODE"); got != "think" {
+				t.Errorf("THINK_TAGS_MODE: got %q, want %q", got, "think")
+			}
+			if got := os.Getenv("MAX_LIVE_REQUESTS"); got != "" {
+				t.Errorf("MAX_LIVE_REQUESTS: got %q, want empty", got)
+			}
+			if got := configSourcesSnapshot(); !reflect.DeepEqual(got, sources) {
+				t.Errorf("sources: got %v, want %v", got, sources)
+			}
+		})
+	}
+}
+
+func TestReloadConfigRestartOnlySettings(t *testing.T) {
+	path := useConfigFile(t, "[server]\nport = \"8081\"\nthink_tags_mode = \"think\"\n")
+	if errs := applyConfigFile(); len(errs) > 0 {
+		t.Fatalf("applyConfigFile() errors: %v", errs)
+	}
+	oldMode := THINK_TAGS_MODE.Load()
+	t.Cleanup(func() { THINK_TAGS_MODE.Store(oldMode) })
+
+	writeTestFile(t, path, "[server]\nport = \"8082\"\nthink_tags_mode = \"raw\"\n")
+	reloadConfig("test")
+
+	// PORT 需要重启，保持原值；THINK_TAGS_MODE 立即生效
+	if got := os.Getenv("PORT"); got != "8081" {
+		t.Errorf("PORT: got %q, want %q", got, "8081")
+	}
+	if got := THINK_TAGS_MODE.Load(); got != "raw" {
+		t.Errorf("THINK_TAGS_MODE: got %q, want %q", got, "raw")
+	}
+}
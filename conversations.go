@@ -0,0 +1,561 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 会话（Conversation）相关 ====================
+//
+// 会话把消息历史保存在 SQLite 中，并固定上游 chat_id 和 token（粘性路由），
+// 客户端后续只需在 /v1/chat/completions 中携带 conversation_id 和新消息。
+// 会话属于创建它的 API Key，其他 Key 查询、使用或删除时视为不存在。
+
+// 会话结构
+type Conversation struct {
+	ID           string    `json:"id"`
+	Object       string    `json:"object"`
+	Model        string    `json:"model"`
+	Title        string    `json:"title,omitempty"`
+	ParentID     string    `json:"parent_id,omitempty"`
+	MessageCount int       `json:"message_count"`
+	CreatedAt    int64     `json:"created_at"`
+	UpdatedAt    int64     `json:"updated_at"`
+	Messages     []Message `json:"messages,omitempty"`
+	APIKeyID     string    `json:"-"` // 所属的 API Key
+	ChatID       string    `json:"-"` // 上游 chat_id
+	AuthToken    string    `json:"-"` // 绑定的上游 token
+}
+
+var (
+	conversationDB      *sql.DB
+	conversationDBMutex sync.RWMutex
+
+	conversationLocks      = make(map[string]*conversationLock) // 会话ID -> 会话锁
+	conversationLocksMutex sync.Mutex
+)
+
+// 会话锁，refs 为持有或等待该锁的请求数，归零时从 conversationLocks 移除
+type conversationLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// 初始化会话数据库（共用 register 数据库）
+func initConversationDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	conversationDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开会话数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	conversationDB.SetMaxOpenConns(10)
+	conversationDB.SetMaxIdleConns(2)
+	conversationDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS conversations (
+		id TEXT PRIMARY KEY,
+		api_key_id TEXT NOT NULL,
+		model TEXT,
+		title TEXT,
+		parent_id TEXT,
+		chat_id TEXT NOT NULL,
+		auth_token TEXT,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_conversations_key_updated ON conversations(api_key_id, updated_at DESC);
+	CREATE TABLE IF NOT EXISTS conversation_messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		conversation_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_conversation_messages_conv ON conversation_messages(conversation_id, id);
+	`
+	_, err = conversationDB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("创建会话表失败: %v", err)
+	}
+
+	return nil
+}
+
+// 生成会话ID
+func generateConversationID() (string, error) {
+	bytes := make([]byte, 12)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return "conv_" + hex.EncodeToString(bytes), nil
+}
+
+// 生成上游 chat_id
+func newUpstreamChatID() string {
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), time.Now().Unix())
+}
+
+// 获取会话锁，同一会话的请求串行执行
+func lockConversation(id string) func() {
+	conversationLocksMutex.Lock()
+	lock, ok := conversationLocks[id]
+	if !ok {
+		lock = &conversationLock{}
+		conversationLocks[id] = lock
+	}
+	lock.refs++
+	conversationLocksMutex.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		conversationLocksMutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(conversationLocks, id)
+		}
+		conversationLocksMutex.Unlock()
+	}
+}
+
+// 创建会话
+func createConversation(apiKeyID, model, title, parentID, authToken string, messages []Message) (*Conversation, error) {
+	if conversationDB == nil {
+		return nil, fmt.Errorf("会话数据库未初始化")
+	}
+
+	id, err := generateConversationID()
+	if err != nil {
+		return nil, err
+	}
+	if model == "" {
+		model = MODEL_NAME
+	}
+	now := time.Now().Unix()
+
+	conversationDBMutex.Lock()
+	defer conversationDBMutex.Unlock()
+
+	tx, err := conversationDB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO conversations (id, api_key_id, model, title, parent_id, chat_id, auth_token, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, id, apiKeyID, model, title, parentID, newUpstreamChatID(), authToken, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range messages {
+		_, err = tx.Exec(`
+			INSERT INTO conversation_messages (conversation_id, role, content, created_at)
+			VALUES (?, ?, ?, ?)
+		`, id, msg.Role, msg.Content, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &Conversation{
+		ID:           id,
+		Object:       "conversation",
+		APIKeyID:     apiKeyID,
+		Model:        model,
+		Title:        title,
+		ParentID:     parentID,
+		MessageCount: len(messages),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		Messages:     messages,
+	}, nil
+}
+
+// 获取 API Key 的会话（包含消息历史），不存在或不属于该 Key 时返回 sql.ErrNoRows
+func getConversation(apiKeyID, id string) (*Conversation, error) {
+	if conversationDB == nil {
+		return nil, fmt.Errorf("会话数据库未初始化")
+	}
+
+	conversationDBMutex.RLock()
+	defer conversationDBMutex.RUnlock()
+
+	conv := &Conversation{Object: "conversation", APIKeyID: apiKeyID}
+	var title, parentID, authToken sql.NullString
+	err := conversationDB.QueryRow(`
+		SELECT id, COALESCE(model, ''), title, parent_id, chat_id, auth_token, created_at, updated_at
+		FROM conversations WHERE id = ? AND api_key_id = ?
+	`, id, apiKeyID).Scan(&conv.ID, &conv.Model, &title, &parentID, &conv.ChatID, &authToken, &conv.CreatedAt, &conv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	conv.Title = title.String
+	conv.ParentID = parentID.String
+	conv.AuthToken = authToken.String
+
+	rows, err := conversationDB.Query(`
+		SELECT role, content FROM conversation_messages
+		WHERE conversation_id = ? ORDER BY id ASC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conv.Messages = []Message{}
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.Role, &msg.Content); err != nil {
+			continue
+		}
+		conv.Messages = append(conv.Messages, msg)
+	}
+	conv.MessageCount = len(conv.Messages)
+
+	return conv, nil
+}
+
+// 获取 API Key 的会话列表（不包含消息）
+func listConversations(apiKeyID string, limit, offset int) ([]Conversation, int, error) {
+	if conversationDB == nil {
+		return []Conversation{}, 0, nil
+	}
+
+	conversationDBMutex.RLock()
+	defer conversationDBMutex.RUnlock()
+
+	var total int
+	if err := conversationDB.QueryRow("SELECT COUNT(*) FROM conversations WHERE api_key_id = ?", apiKeyID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := conversationDB.Query(`
+		SELECT c.id, COALESCE(c.model, ''), COALESCE(c.title, ''), COALESCE(c.parent_id, ''),
+		       c.created_at, c.updated_at,
+		       (SELECT COUNT(*) FROM conversation_messages m WHERE m.conversation_id = c.id)
+		FROM conversations c
+		WHERE c.api_key_id = ?
+		ORDER BY c.updated_at DESC
+		LIMIT ? OFFSET ?
+	`, apiKeyID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		conv := Conversation{Object: "conversation"}
+		err := rows.Scan(&conv.ID, &conv.Model, &conv.Title, &conv.ParentID,
+			&conv.CreatedAt, &conv.UpdatedAt, &conv.MessageCount)
+		if err != nil {
+			continue
+		}
+		conversations = append(conversations, conv)
+	}
+
+	return conversations, total, nil
+}
+
+// 追加会话消息
+func appendConversationMessages(id string, messages []Message) error {
+	if conversationDB == nil {
+		return fmt.Errorf("会话数据库未初始化")
+	}
+
+	now := time.Now().Unix()
+
+	conversationDBMutex.Lock()
+	defer conversationDBMutex.Unlock()
+
+	tx, err := conversationDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, msg := range messages {
+		_, err = tx.Exec(`
+			INSERT INTO conversation_messages (conversation_id, role, content, created_at)
+			VALUES (?, ?, ?, ?)
+		`, id, msg.Role, msg.Content, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.Exec("UPDATE conversations SET updated_at = ? WHERE id = ?", now, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// 绑定会话使用的上游 token
+func updateConversationToken(id, authToken string) error {
+	if conversationDB == nil {
+		return fmt.Errorf("会话数据库未初始化")
+	}
+
+	conversationDBMutex.Lock()
+	defer conversationDBMutex.Unlock()
+
+	_, err := conversationDB.Exec("UPDATE conversations SET auth_token = ? WHERE id = ?", authToken, id)
+	return err
+}
+
+// 删除 API Key 的会话及其消息
+func deleteConversation(apiKeyID, id string) (bool, error) {
+	if conversationDB == nil {
+		return false, fmt.Errorf("会话数据库未初始化")
+	}
+
+	conversationDBMutex.Lock()
+	defer conversationDBMutex.Unlock()
+
+	tx, err := conversationDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM conversations WHERE id = ? AND api_key_id = ?", id, apiKeyID)
+	if err != nil {
+		return false, err
+	}
+	count, _ := result.RowsAffected()
+	if count == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec("DELETE FROM conversation_messages WHERE conversation_id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 分叉会话：复制前 messageCount 条消息到新会话，新会话使用新的上游 chat_id
+func forkConversation(apiKeyID, id string, messageCount int) (*Conversation, error) {
+	parent, err := getConversation(apiKeyID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := parent.Messages
+	if messageCount >= 0 && messageCount < len(messages) {
+		messages = messages[:messageCount]
+	}
+
+	return createConversation(apiKeyID, parent.Model, parent.Title, parent.ID, parent.AuthToken, messages)
+}
+
+// ==================== HTTP 处理函数 ====================
+
+// 处理 /v1/conversations（列表、创建）
+func handleConversations(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		limit := 20
+		offset := 0
+		if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+			if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 100 {
+				limit = l
+			}
+		}
+		if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+			if o, err := strconv.Atoi(offsetStr); err == nil && o >= 0 {
+				offset = o
+			}
+		}
+
+		conversations, total, err := listConversations(clientKey.ID, limit, offset)
+		if err != nil {
+			logConversations.WarnContext(r.Context(), "获取会话列表失败", "error", err)
+			httpError(w, "Failed to list conversations", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"object": "list",
+			"data":   conversations,
+			"total":  total,
+			"limit":  limit,
+			"offset": offset,
+		})
+
+	case "POST":
+		var req struct {
+			Model    string    `json:"model"`
+			Title    string    `json:"title"`
+			Messages []Message `json:"messages"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+
+		conv, err := createConversation(clientKey.ID, req.Model, req.Title, "", "", req.Messages)
+		if err != nil {
+			logConversations.WarnContext(r.Context(), "创建会话失败", "error", err)
+			httpError(w, "Failed to create conversation", http.StatusInternalServerError)
+			return
+		}
+		logConversations.DebugContext(r.Context(), "创建会话", "conversation_id", conv.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conv)
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 处理 /v1/conversations/{id} 和 /v1/conversations/{id}/fork
+func handleConversationByID(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	clientKey, ok := apiKeyFromRequest(r)
+	if !ok {
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/conversations/"), "/")
+	parts := strings.Split(rest, "/")
+	id := parts[0]
+	if id == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 分叉会话
+	if len(parts) == 2 {
+		if parts[1] != "fork" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != "POST" {
+			httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// message_count: 保留的消息数，缺省复制全部
+		req := struct {
+			MessageCount *int `json:"message_count"`
+		}{}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httpError(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+		}
+		messageCount := -1
+		if req.MessageCount != nil {
+			messageCount = *req.MessageCount
+		}
+
+		conv, err := forkConversation(clientKey.ID, id, messageCount)
+		if err == sql.ErrNoRows {
+			httpError(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logConversations.WarnContext(r.Context(), "分叉会话失败", "error", err)
+			httpError(w, "Failed to fork conversation", http.StatusInternalServerError)
+			return
+		}
+		logConversations.DebugContext(r.Context(), "分叉会话", "from", id, "conversation_id", conv.ID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conv)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		conv, err := getConversation(clientKey.ID, id)
+		if err == sql.ErrNoRows {
+			httpError(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			logConversations.WarnContext(r.Context(), "获取会话失败", "error", err)
+			httpError(w, "Failed to get conversation", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(conv)
+
+	case "DELETE":
+		deleted, err := deleteConversation(clientKey.ID, id)
+		if err != nil {
+			logConversations.WarnContext(r.Context(), "删除会话失败", "error", err)
+			httpError(w, "Failed to delete conversation", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			httpError(w, "Conversation not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      id,
+			"object":  "conversation.deleted",
+			"deleted": true,
+		})
+
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// 使用临时目录中的会话数据库，测试结束后恢复全局状态
+func useConversationDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "conversations.db")
+
+	oldDB := conversationDB
+	if err := initConversationDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conversationDB.Close()
+		conversationDB = oldDB
+	})
+}
+
+func TestConversationOwnership(t *testing.T) {
+	useConversationDB(t)
+	conv, err := createConversation("key-1", "", "title", "", "", []Message{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		apiKeyID string
+		found    bool
+	}{
+		{name: "owner", apiKeyID: "key-1", found: true},
+		{name: "other key", apiKeyID: "key-2", found: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := getConversation(tt.apiKeyID, conv.ID)
+			if tt.found && err != nil {
+				t.Errorf("getConversation(): %v", err)
+			}
+			if !tt.found && err != sql.ErrNoRows {
+				t.Errorf("getConversation(): got %v, want sql.ErrNoRows", err)
+			}
+
+			list, total, err := listConversations(tt.apiKeyID, 10, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := map[bool]int{true: 1, false: 0}[tt.found]; total != want || len(list) != want {
+				t.Errorf("listConversations(): got %d/%d, want %d", len(list), total, want)
+			}
+
+			if _, err := forkConversation(tt.apiKeyID, conv.ID, -1); (err == nil) != tt.found {
+				t.Errorf("forkConversation(): got %v, want found=%v", err, tt.found)
+			}
+		})
+	}
+
+	if deleted, err := deleteConversation("key-2", conv.ID); err != nil || deleted {
+		t.Errorf("deleteConversation(other key): got (%v, %v), want (false, nil)", deleted, err)
+	}
+	if deleted, err := deleteConversation("key-1", conv.ID); err != nil || !deleted {
+		t.Fatalf("deleteConversation(owner): got (%v, %v), want (true, nil)", deleted, err)
+	}
+	var messages int
+	conversationDB.QueryRow(`SELECT COUNT(*) FROM conversation_messages WHERE conversation_id = ?`, conv.ID).Scan(&messages)
+	if messages != 0 {
+		t.Errorf("messages left after delete: %d", messages)
+	}
+}
+
+func TestLockConversationEvictsIdleLocks(t *testing.T) {
+	unlock := lockConversation("conv-1")
+	released := make(chan struct{})
+	go func() {
+		lockConversation("conv-1")()
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		t.Fatal("second lock acquired while the first was held")
+	default:
+	}
+	unlock()
+	<-released
+
+	conversationLocksMutex.Lock()
+	defer conversationLocksMutex.Unlock()
+	if _, ok := conversationLocks["conv-1"]; ok {
+		t.Error("idle conversation lock was not evicted")
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== Dashboard 实时推送 ====================
+//
+// GET /dashboard/stream 以 SSE 推送 Dashboard 事件：
+//   - snapshot: 连接时的完整状态（统计 + 最近请求）；resync: 客户端落后太多时重新下发完整状态
+//   - request: 新的 LiveRequest
+//   - stats: 单个请求带来的统计增量
+//   - alert: 错误率过高、请求过慢、统计写入失败等告警
+//
+// 事件先写入固定大小的环形缓冲，再通知各客户端自行读取，发布方永远不会被慢客户端阻塞。
+// 客户端落后超过缓冲大小时收到 resync；断线重连时通过 Last-Event-ID 补发错过的事件。
+
+const (
+	DASHBOARD_EVENT_SNAPSHOT = "snapshot"
+	DASHBOARD_EVENT_RESYNC   = "resync"
+	DASHBOARD_EVENT_REQUEST  = "request"
+	DASHBOARD_EVENT_STATS    = "stats"
+	DASHBOARD_EVENT_ALERT    = "alert"
+
+	DASHBOARD_STREAM_HISTORY     = 1000             // 环形缓冲保留的事件数
+	DASHBOARD_STREAM_HEARTBEAT   = 15 * time.Second // 心跳间隔
+	DASHBOARD_STREAM_WRITE_LIMIT = 10 * time.Second // 单次写入超时，超时的客户端直接断开
+	DASHBOARD_SNAPSHOT_REQUESTS  = 20               // 快照中包含的最近请求数
+
+	DASHBOARD_ALERT_WINDOW   = time.Minute // 错误率统计窗口
+	DASHBOARD_ALERT_MIN      = 5           // 窗口内至少有这么多请求才计算错误率
+	DASHBOARD_ALERT_COOLDOWN = time.Minute // 同类告警的最小间隔
+)
+
+type dashboardEvent struct {
+	id   uint64
+	kind string
+	data []byte
+}
+
+// 事件 ID 为 "<进程标识>-<序号>"，进程重启后旧的 Last-Event-ID 不会被误认
+var dashboardStreamEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+var dashboardHub = struct {
+	mu      sync.Mutex
+	events  []dashboardEvent
+	lastID  uint64
+	clients map[chan struct{}]struct{}
+}{
+	clients: make(map[chan struct{}]struct{}),
+}
+
+// 发布事件（不会阻塞）
+func publishDashboardEvent(kind string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	dashboardHub.mu.Lock()
+	defer dashboardHub.mu.Unlock()
+	dashboardHub.lastID++
+	dashboardHub.events = append(dashboardHub.events, dashboardEvent{id: dashboardHub.lastID, kind: kind, data: data})
+	if len(dashboardHub.events) > DASHBOARD_STREAM_HISTORY {
+		dashboardHub.events = dashboardHub.events[len(dashboardHub.events)-DASHBOARD_STREAM_HISTORY:]
+	}
+	for notify := range dashboardHub.clients {
+		select {
+		case notify <- struct{}{}:
+		default: // 已有未处理的通知
+		}
+	}
+}
+
+// 当前最新的事件序号
+func latestDashboardEventID() uint64 {
+	dashboardHub.mu.Lock()
+	defer dashboardHub.mu.Unlock()
+	return dashboardHub.lastID
+}
+
+// 序号 after 之后的事件；after 已不在缓冲中（或不是本进程的序号）时 ok 为 false
+func dashboardEventsAfter(after uint64) (events []dashboardEvent, ok bool) {
+	dashboardHub.mu.Lock()
+	defer dashboardHub.mu.Unlock()
+
+	if after > dashboardHub.lastID {
+		return nil, false
+	}
+	if after == dashboardHub.lastID {
+		return nil, true
+	}
+	if len(dashboardHub.events) == 0 || after+1 < dashboardHub.events[0].id {
+		return nil, false
+	}
+	start := int(after + 1 - dashboardHub.events[0].id)
+	return append([]dashboardEvent(nil), dashboardHub.events[start:]...), true
+}
+
+func formatDashboardEventID(id uint64) string {
+	return dashboardStreamEpoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// 解析 Last-Event-ID，不是本进程的 ID 时 ok 为 false
+func parseDashboardEventID(s string) (uint64, bool) {
+	epoch, seq, found := strings.Cut(s, "-")
+	if !found || epoch != dashboardStreamEpoch {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(seq, 10, 64)
+	return id, err == nil
+}
+
+// 完整状态快照及其对应的事件序号
+// 在持有 statsMutex / requestsMutex 时读取序号，发布方也在这两把锁内发布，保证快照与后续事件不重不漏
+func dashboardSnapshot() (uint64, []byte) {
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	requestsMutex.Lock()
+	defer requestsMutex.Unlock()
+
+	recent := make([]LiveRequest, 0, DASHBOARD_SNAPSHOT_REQUESTS)
+	for i := len(liveRequests) - 1; i >= 0 && len(recent) < DASHBOARD_SNAPSHOT_REQUESTS; i-- {
+		recent = append(recent, liveRequests[i])
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"stats":         json.RawMessage(statsDataLocked()),
+		"requests":      recent,
+		"totalRequests": len(liveRequests),
+	})
+	return latestDashboardEventID(), data
+}
+
+// 单个请求带来的统计增量（附带更新后的累计统计，客户端无需自行计算平均值等），调用方需持有 statsMutex
+func publishStatsDelta(path string, status int, duration time.Duration, model string, isStreaming bool, tokens int) {
+	publishDashboardEvent(DASHBOARD_EVENT_STATS, map[string]interface{}{
+		"delta": map[string]interface{}{
+			"path":       path,
+			"status":     status,
+			"success":    status >= 200 && status < 300,
+			"durationMs": duration.Milliseconds(),
+			"model":      model,
+			"stream":     isStreaming,
+			"tokens":     tokens,
+		},
+		"stats": json.RawMessage(statsDataLocked()),
+	})
+}
+
+// 告警事件
+type DashboardAlert struct {
+	Kind      string    `json:"kind"`
+	Level     string    `json:"level"` // warning / error
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+var dashboardAlerts = struct {
+	mu       sync.Mutex
+	outcomes []struct {
+		at     time.Time
+		failed bool
+	}
+	lastSent map[string]time.Time
+}{
+	lastSent: make(map[string]time.Time),
+}
+
+// 发布告警，同类告警在冷却时间内只发一次
+func publishDashboardAlert(kind, level, message string) {
+	now := time.Now()
+	dashboardAlerts.mu.Lock()
+	if last, ok := dashboardAlerts.lastSent[kind]; ok && now.Sub(last) < DASHBOARD_ALERT_COOLDOWN {
+		dashboardAlerts.mu.Unlock()
+		return
+	}
+	dashboardAlerts.lastSent[kind] = now
+	dashboardAlerts.mu.Unlock()
+
+	publishDashboardEvent(DASHBOARD_EVENT_ALERT, DashboardAlert{Kind: kind, Level: level, Message: message, Timestamp: now})
+}
+
+// 根据单个 API 请求的结果检查错误率和慢请求
+func checkDashboardAlerts(path string, status int, duration time.Duration, model string) {
+	if !strings.HasPrefix(path, "/v1/") {
+		return
+	}
+
+	if DASHBOARD_ALERT_SLOW_MS > 0 && duration.Milliseconds() >= int64(DASHBOARD_ALERT_SLOW_MS) {
+		publishDashboardAlert("slow_request", "warning",
+			fmt.Sprintf("%s 请求耗时 %dms（模型: %s）", path, duration.Milliseconds(), model))
+	}
+
+	if DASHBOARD_ALERT_ERROR_RATE <= 0 {
+		return
+	}
+	now := time.Now()
+	dashboardAlerts.mu.Lock()
+	dashboardAlerts.outcomes = append(dashboardAlerts.outcomes, struct {
+		at     time.Time
+		failed bool
+	}{now, status >= 500})
+	cutoff := now.Add(-DASHBOARD_ALERT_WINDOW)
+	i := 0
+	for i < len(dashboardAlerts.outcomes) && dashboardAlerts.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	dashboardAlerts.outcomes = dashboardAlerts.outcomes[i:]
+	total, failed := len(dashboardAlerts.outcomes), 0
+	for _, o := range dashboardAlerts.outcomes {
+		if o.failed {
+			failed++
+		}
+	}
+	dashboardAlerts.mu.Unlock()
+
+	if total >= DASHBOARD_ALERT_MIN && float64(failed)/float64(total) >= DASHBOARD_ALERT_ERROR_RATE {
+		publishDashboardAlert("error_rate", "error",
+			fmt.Sprintf("最近 %d 秒内 %d/%d 个请求失败（5xx）", int(DASHBOARD_ALERT_WINDOW.Seconds()), failed, total))
+	}
+}
+
+// Dashboard 实时推送处理器
+func handleDashboardStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	notify := make(chan struct{}, 1)
+	dashboardHub.mu.Lock()
+	dashboardHub.clients[notify] = struct{}{}
+	dashboardHub.mu.Unlock()
+	defer func() {
+		dashboardHub.mu.Lock()
+		delete(dashboardHub.clients, notify)
+		dashboardHub.mu.Unlock()
+	}()
+
+	rc := http.NewResponseController(w)
+	write := func(id uint64, kind string, data []byte) error {
+		rc.SetWriteDeadline(time.Now().Add(DASHBOARD_STREAM_WRITE_LIMIT))
+		_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", formatDashboardEventID(id), kind, data)
+		return err
+	}
+	sendSnapshot := func(kind string) (uint64, error) {
+		id, data := dashboardSnapshot()
+		return id, write(id, kind, data)
+	}
+
+	// 优先按 Last-Event-ID 补发；无法补发时下发完整快照
+	var lastID uint64
+	var err error
+	resumeFrom := r.Header.Get("Last-Event-ID")
+	if resumeFrom == "" {
+		resumeFrom = r.URL.Query().Get("lastEventId")
+	}
+	if resumeFrom == "" {
+		fmt.Fprintf(w, "retry: 3000\n\n")
+		lastID, err = sendSnapshot(DASHBOARD_EVENT_SNAPSHOT)
+	} else if id, ok := parseDashboardEventID(resumeFrom); !ok {
+		lastID, err = sendSnapshot(DASHBOARD_EVENT_RESYNC)
+	} else if events, ok := dashboardEventsAfter(id); !ok {
+		lastID, err = sendSnapshot(DASHBOARD_EVENT_RESYNC)
+	} else {
+		lastID = id
+		for _, e := range events {
+			if err = write(e.id, e.kind, e.data); err != nil {
+				return
+			}
+			lastID = e.id
+		}
+	}
+	if err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(DASHBOARD_STREAM_HEARTBEAT)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-serverStopping:
+			return
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(DASHBOARD_STREAM_WRITE_LIMIT))
+			if _, err := fmt.Fprintf(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-notify:
+			events, ok := dashboardEventsAfter(lastID)
+			if !ok {
+				// 落后超过缓冲大小，重新下发完整状态
+				if lastID, err = sendSnapshot(DASHBOARD_EVENT_RESYNC); err != nil {
+					return
+				}
+				break
+			}
+			for _, e := range events {
+				if err := write(e.id, e.kind, e.data); err != nil {
+					return
+				}
+				lastID = e.id
+			}
+		}
+		flusher.Flush()
+	}
+}
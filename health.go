@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/hulisang/ZtoApi/logging"
+	"github.com/hulisang/ZtoApi/register"
+)
+
+// ==================== 健康检查与诊断 ====================
+//
+//   - GET /healthz: 存活检查，进程能处理请求即返回 200，不访问数据库和上游
+//   - GET /readyz: 就绪检查，数据库连接、token 来源、上游熔断状态任一异常时返回 503
+//   - GET /admin/api/diagnostics: 配置（密钥已脱敏）、版本与构建信息、运行时、数据库大小、最近的上游错误
+//
+// 健康检查不经过统计，不会计入首页访问量。
+//
+// 上游熔断状态只用于就绪检查，不会拒绝请求：连续 READYZ_UPSTREAM_FAILURES 次上游失败后进入 open，
+// 实例从负载均衡中摘除；HEALTH_UPSTREAM_COOLDOWN 后进入 half_open，重新接收流量，下一次成功后恢复 closed。
+
+const (
+	HEALTH_CHECK_TIMEOUT     = 2 * time.Second  // 单项检查的超时时间
+	HEALTH_UPSTREAM_COOLDOWN = 30 * time.Second // 熔断后恢复接收流量前的等待时间
+
+	UPSTREAM_STATE_CLOSED    = "closed"
+	UPSTREAM_STATE_OPEN      = "open"
+	UPSTREAM_STATE_HALF_OPEN = "half_open"
+)
+
+// 构建信息，发布时通过 -ldflags "-X main.buildVersion=... -X main.buildCommit=... -X main.buildTime=..." 注入；
+// 未注入时使用 Go 工具链记录的 VCS 信息
+var (
+	buildVersion = "dev"
+	buildCommit  = ""
+	buildTime    = ""
+)
+
+// UpstreamHealth 上游调用的健康状况
+type UpstreamHealth struct {
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastErrorAt         *time.Time `json:"lastErrorAt,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+}
+
+var upstreamHealth = struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	lastError           string
+	lastErrorAt         time.Time
+	lastSuccessAt       time.Time
+}{}
+
+// 记录一次成功的上游调用
+func recordUpstreamSuccess() {
+	upstreamHealth.mu.Lock()
+	upstreamHealth.consecutiveFailures = 0
+	upstreamHealth.lastSuccessAt = time.Now()
+	upstreamHealth.mu.Unlock()
+}
+
+// 记录一次失败的上游调用
+func recordUpstreamFailure(message string) {
+	upstreamHealth.mu.Lock()
+	upstreamHealth.consecutiveFailures++
+	upstreamHealth.lastError = message
+	upstreamHealth.lastErrorAt = time.Now()
+	upstreamHealth.mu.Unlock()
+}
+
+// 根据 callUpstreamWithHeaders 的结果更新上游状态；客户端断开或管理员取消不算上游失败
+func observeUpstreamResult(ctx context.Context, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		if errors.Is(err, context.Canceled) || ctx.Err() != nil {
+			return
+		}
+		// 上游 URL 的查询参数中带有 token，只保留错误本身
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		recordUpstreamFailure(logging.Redact(err.Error()))
+	case resp.StatusCode != http.StatusOK:
+		recordUpstreamFailure(fmt.Sprintf("上游返回错误状态: %d", resp.StatusCode))
+	default:
+		recordUpstreamSuccess()
+	}
+}
+
+// 当前的上游健康状况
+func getUpstreamHealth() UpstreamHealth {
+	upstreamHealth.mu.Lock()
+	defer upstreamHealth.mu.Unlock()
+
+	h := UpstreamHealth{
+		State:               UPSTREAM_STATE_CLOSED,
+		ConsecutiveFailures: upstreamHealth.consecutiveFailures,
+		LastError:           upstreamHealth.lastError,
+	}
+	if !upstreamHealth.lastErrorAt.IsZero() {
+		t := upstreamHealth.lastErrorAt
+		h.LastErrorAt = &t
+	}
+	if !upstreamHealth.lastSuccessAt.IsZero() {
+		t := upstreamHealth.lastSuccessAt
+		h.LastSuccessAt = &t
+	}
+	if READYZ_UPSTREAM_FAILURES > 0 && upstreamHealth.consecutiveFailures >= READYZ_UPSTREAM_FAILURES {
+		h.State = UPSTREAM_STATE_OPEN
+		if time.Since(upstreamHealth.lastErrorAt) >= HEALTH_UPSTREAM_COOLDOWN {
+			h.State = UPSTREAM_STATE_HALF_OPEN
+		}
+	}
+	return h
+}
+
+// HealthCheck 单项检查结果
+type HealthCheck struct {
+	Status  string      `json:"status"` // ok / error / disabled
+	Message string      `json:"message,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// 各子系统的数据库连接（未启用的为 nil）
+func healthDatabases() map[string]*sql.DB {
+	return map[string]*sql.DB{
+		"stats":         statsDB,
+		"admin":         adminDB,
+		"api_keys":      apiKeyDB,
+		"conversations": conversationDB,
+		"batch":         batchDB,
+		"jobs":          jobDB,
+		"idempotency":   idempotencyDB,
+		"journal":       journalDB,
+		"settings":      settingsDB,
+	}
+}
+
+// 检查数据库连接
+func checkDatabases(ctx context.Context) HealthCheck {
+	failed := map[string]string{}
+	checked := 0
+	for name, db := range healthDatabases() {
+		if db == nil {
+			continue
+		}
+		checked++
+		if err := db.PingContext(ctx); err != nil {
+			failed[name] = err.Error()
+		}
+	}
+	if checked == 0 {
+		return HealthCheck{Status: "error", Message: "没有可用的数据库连接"}
+	}
+	if len(failed) > 0 {
+		return HealthCheck{Status: "error", Message: "数据库连接失败", Details: failed}
+	}
+	return HealthCheck{Status: "ok", Details: map[string]int{"connections": checked}}
+}
+
+// 检查 token 来源：与 getAuthToken 的优先级一致，不实际请求匿名 token
+func checkTokenPool(ctx context.Context) HealthCheck {
+	if ZAI_TOKEN != "" {
+		return HealthCheck{Status: "ok", Details: map[string]string{"source": "env"}}
+	}
+	if REGISTER_ENABLED {
+		count, err := register.CountAvailableTokens(ctx)
+		if err == nil && count > 0 {
+			return HealthCheck{Status: "ok", Details: map[string]interface{}{"source": "pool", "available": count}}
+		}
+		if !ANON_TOKEN_ENABLED.Load() {
+			if err != nil {
+				return HealthCheck{Status: "error", Message: "查询 token 池失败: " + err.Error()}
+			}
+			return HealthCheck{Status: "error", Message: "token 池中没有可用的 token"}
+		}
+	}
+	if ANON_TOKEN_ENABLED.Load() {
+		return HealthCheck{Status: "ok", Details: map[string]string{"source": "anonymous"}}
+	}
+	return HealthCheck{Status: "error", Message: "没有可用的 token 来源"}
+}
+
+// 检查上游熔断状态
+func checkUpstream() HealthCheck {
+	if READYZ_UPSTREAM_FAILURES <= 0 {
+		return HealthCheck{Status: "disabled"}
+	}
+	// 就绪检查不需要登录，不返回错误详情（见 /admin/api/diagnostics）
+	h := getUpstreamHealth()
+	details := map[string]interface{}{"state": h.State, "consecutiveFailures": h.ConsecutiveFailures}
+	if h.State == UPSTREAM_STATE_OPEN {
+		return HealthCheck{Status: "error", Message: fmt.Sprintf("上游连续失败 %d 次", h.ConsecutiveFailures), Details: details}
+	}
+	return HealthCheck{Status: "ok", Details: details}
+}
+
+// 执行全部就绪检查
+func readinessChecks(ctx context.Context) (map[string]HealthCheck, bool) {
+	ctx, cancel := context.WithTimeout(ctx, HEALTH_CHECK_TIMEOUT)
+	defer cancel()
+
+	checks := map[string]HealthCheck{
+		"database":   checkDatabases(ctx),
+		"token_pool": checkTokenPool(ctx),
+		"upstream":   checkUpstream(),
+	}
+	ready := true
+	for _, c := range checks {
+		if c.Status == "error" {
+			ready = false
+		}
+	}
+	return checks, ready
+}
+
+// 存活检查处理器
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"uptime": int64(time.Since(processStartTime).Seconds()),
+	})
+}
+
+// 就绪检查处理器，只返回是否就绪，各项检查的详情（token 池数量等）见管理员诊断接口
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	_, ready := readinessChecks(r.Context())
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !ready {
+		status = "unavailable"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+	})
+}
+
+// 版本和构建信息
+func buildInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"version":   buildVersion,
+		"commit":    buildCommit,
+		"buildTime": buildTime,
+		"goVersion": runtime.Version(),
+		"feVersion": X_FE_VERSION,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if buildCommit == "" {
+					info["commit"] = s.Value
+				}
+			case "vcs.time":
+				if buildTime == "" {
+					info["buildTime"] = s.Value
+				}
+			case "vcs.modified":
+				info["modified"] = s.Value == "true"
+			case "-tags":
+				info["tags"] = s.Value
+			}
+		}
+	}
+	return info
+}
+
+// 数据库文件大小（含 WAL 文件）
+func databaseFileInfo() map[string]interface{} {
+	path := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+	info := map[string]interface{}{"path": path}
+	var total int64
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if fi, err := os.Stat(path + suffix); err == nil {
+			total += fi.Size()
+			if suffix == "" {
+				info["size"] = fi.Size()
+			}
+		}
+	}
+	info["totalSize"] = total
+	return info
+}
+
+// 密钥类配置只显示是否已设置
+func maskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "******"
+}
+
+// 当前生效的配置（密钥已脱敏）
+func diagnosticsConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"UPSTREAM_URL":                UPSTREAM_URL,
+		"MODEL_NAME":                  MODEL_NAME,
+		"PORT":                        PORT,
+		"DEFAULT_KEY":                 maskSecret(DEFAULT_KEY),
+		"ZAI_TOKEN":                   maskSecret(ZAI_TOKEN),
+		"ZAI_SIGNING_SECRET":          maskSecret(getEnv("ZAI_SIGNING_SECRET", "")),
+		"DEBUG_MODE":                  DEBUG_MODE.Load(),
+		"DEFAULT_STREAM":              DEFAULT_STREAM.Load(),
+		"ENABLE_THINKING":             ENABLE_THINKING.Load(),
+		"ANON_TOKEN_ENABLED":          ANON_TOKEN_ENABLED.Load(),
+		"THINK_TAGS_MODE":             THINK_TAGS_MODE.Load(),
+		"UPSTREAM_TIMEOUT":            UPSTREAM_TIMEOUT.Load().String(),
+		"MAX_LIVE_REQUESTS":           MAX_LIVE_REQUESTS.Load(),
+		"DASHBOARD_ENABLED":           DASHBOARD_ENABLED,
+		"DASHBOARD_ALERT_ERROR_RATE":  DASHBOARD_ALERT_ERROR_RATE,
+		"DASHBOARD_ALERT_SLOW_MS":     DASHBOARD_ALERT_SLOW_MS,
+		"REGISTER_ENABLED":            REGISTER_ENABLED,
+		"REGISTER_DB_PATH":            getEnv("REGISTER_DB_PATH", "./data/zai2api.db"),
+		"ADMIN_ENABLED":               ADMIN_ENABLED,
+		"ADMIN_USERNAME":              ADMIN_USERNAME,
+		"ADMIN_PASSWORD":              maskSecret(ADMIN_PASSWORD),
+		"CONVERSATIONS_ENABLED":       CONVERSATIONS_ENABLED,
+		"BATCH_ENABLED":               BATCH_ENABLED,
+		"BATCH_CONCURRENCY":           BATCH_CONCURRENCY,
+		"BACKGROUND_ENABLED":          BACKGROUND_ENABLED,
+		"BACKGROUND_CALLBACK_SECRET":  maskSecret(BACKGROUND_CALLBACK_SECRET),
+		"IDEMPOTENCY_TTL":             IDEMPOTENCY_TTL.String(),
+		"CACHE_ENABLED":               CACHE_ENABLED,
+		"CACHE_BACKEND":               CACHE_BACKEND,
+		"CACHE_TTL":                   CACHE_TTL.String(),
+		"CACHE_MAX_ENTRIES":           CACHE_MAX_ENTRIES,
+		"CACHE_MAX_BYTES":             CACHE_MAX_BYTES,
+		"CACHE_DEFAULT_POLICY":        CACHE_DEFAULT_POLICY,
+		"METRICS_ENABLED":             METRICS_ENABLED,
+		"METRICS_TOKEN":               maskSecret(METRICS_TOKEN),
+		"TRACING_ENABLED":             TRACING_ENABLED,
+		"STATS_HOURLY_RETENTION_DAYS": STATS_HOURLY_RETENTION_DAYS,
+		"STATS_DAILY_RETENTION_DAYS":  STATS_DAILY_RETENTION_DAYS,
+		"STATS_TIMEZONE":              STATS_TIMEZONE,
+		"JOURNAL_ENABLED":             JOURNAL_ENABLED,
+		"JOURNAL_SAMPLE_RATE":         JOURNAL_SAMPLE_RATE,
+		"JOURNAL_REDACT":              JOURNAL_REDACT,
+		"JOURNAL_MAX_FIELD_BYTES":     JOURNAL_MAX_FIELD_BYTES,
+		"JOURNAL_RETENTION_DAYS":      JOURNAL_RETENTION_DAYS,
+		"READYZ_UPSTREAM_FAILURES":    READYZ_UPSTREAM_FAILURES,
+		"LOG_LEVEL":                   getEnv("LOG_LEVEL", ""),
+		"LOG_FORMAT":                  getEnv("LOG_FORMAT", "text"),
+	}
+}
+
+// Admin 诊断信息处理器
+func handleAdminAPIDiagnostics(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+	if r.Method != "GET" {
+		writeError(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	checks, ready := readinessChecks(r.Context())
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"build":   buildInfo(),
+		"runtime": map[string]interface{}{
+			"startTime":  processStartTime,
+			"uptime":     int64(time.Since(processStartTime).Seconds()),
+			"goroutines": runtime.NumGoroutine(),
+			"numCPU":     runtime.NumCPU(),
+			"os":         runtime.GOOS,
+			"arch":       runtime.GOARCH,
+			"heapAlloc":  mem.HeapAlloc,
+			"sys":        mem.Sys,
+			"numGC":      mem.NumGC,
+			"inflight":   len(listInflightRequests()),
+		},
+		"database":  databaseFileInfo(),
+		"upstream":  getUpstreamHealth(),
+		"readiness": map[string]interface{}{"ready": ready, "checks": checks},
+		"config":    diagnosticsConfig(),
+		"configSources": map[string]interface{}{
+			"file":     configFilePath,
+			"settings": configSourcesSnapshot(),
+			"runtime":  runtimeOverridesSnapshot(),
+		},
+	})
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ==================== 幂等键（Idempotency-Key）相关 ====================
+//
+// 非流式 /v1/chat/completions 请求携带 Idempotency-Key 头时（键按 API Key 隔离，不同 Key 可以使用相同的键）：
+//   - 首次请求正常执行，成功（2xx）的响应连同请求体哈希保存 IDEMPOTENCY_TTL
+//   - 相同键、相同请求体的重放直接返回保存的响应（Idempotent-Replayed: true）
+//   - 相同键、相同请求体的并发请求等待进行中的请求结束后复用其结果
+//   - 相同键、不同请求体返回 409（与进行中的请求不同时立即返回，不等待）
+// 失败或未完成（上游流提前结束等）的响应不保存，客户端可以用同一个键重试。
+
+// 幂等键最大长度
+const MAX_IDEMPOTENCY_KEY_LENGTH = 255
+
+var (
+	errIdempotencyKeyMismatch = errors.New("idempotency key reused with a different request body")
+
+	idempotencyDB      *sql.DB
+	idempotencyDBMutex sync.Mutex
+
+	// 进行中的请求：(API Key, 幂等键) -> 进行中的请求
+	idempotencyInflight      = make(map[idempotencyScope]*idempotentInflight)
+	idempotencyInflightMutex sync.Mutex
+)
+
+// 幂等键的作用域：同一个 API Key 下的键
+type idempotencyScope struct {
+	apiKeyID string
+	key      string
+}
+
+// 进行中的请求
+type idempotentInflight struct {
+	requestHash string
+	finished    chan struct{} // 请求结束时关闭
+}
+
+// 保存的响应
+type idempotentResponse struct {
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// 初始化幂等键数据库（共用 register 数据库）
+func initIdempotencyDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	idempotencyDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开幂等键数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	idempotencyDB.SetMaxOpenConns(10)
+	idempotencyDB.SetMaxIdleConns(2)
+	idempotencyDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		api_key_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		request_hash TEXT NOT NULL,
+		status_code INTEGER NOT NULL,
+		content_type TEXT,
+		response BLOB,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		PRIMARY KEY (api_key_id, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+	`
+	_, err = idempotencyDB.Exec(createTableSQL)
+	if err != nil {
+		return fmt.Errorf("创建幂等键表失败: %v", err)
+	}
+
+	return nil
+}
+
+// 清理过期的幂等键
+func cleanupIdempotencyKeys() {
+	if idempotencyDB == nil {
+		return
+	}
+
+	idempotencyDBMutex.Lock()
+	defer idempotencyDBMutex.Unlock()
+
+	result, err := idempotencyDB.Exec(`DELETE FROM idempotency_keys WHERE expires_at < ?`, time.Now().Unix())
+	if err != nil {
+		logIdempotency.Warn("清理幂等键失败", "error", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logIdempotency.Debug("清理过期的幂等键", "count", n)
+	}
+}
+
+// 计算请求体哈希
+func hashRequestBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// 查询未过期的已保存响应
+func getIdempotentResponse(scope idempotencyScope) (*idempotentResponse, error) {
+	idempotencyDBMutex.Lock()
+	defer idempotencyDBMutex.Unlock()
+
+	var resp idempotentResponse
+	var contentType sql.NullString
+	err := idempotencyDB.QueryRow(`
+		SELECT request_hash, status_code, content_type, response FROM idempotency_keys
+		WHERE api_key_id = ? AND key = ? AND expires_at >= ?
+	`, scope.apiKeyID, scope.key, time.Now().Unix()).Scan(&resp.RequestHash, &resp.StatusCode, &contentType, &resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.ContentType = contentType.String
+	return &resp, nil
+}
+
+// 保存响应
+func saveIdempotentResponse(scope idempotencyScope, resp *idempotentResponse) error {
+	idempotencyDBMutex.Lock()
+	defer idempotencyDBMutex.Unlock()
+
+	now := time.Now()
+	_, err := idempotencyDB.Exec(`
+		INSERT OR REPLACE INTO idempotency_keys (api_key_id, key, request_hash, status_code, content_type, response, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, scope.apiKeyID, scope.key, resp.RequestHash, resp.StatusCode, resp.ContentType, resp.Body, now.Unix(), now.Add(IDEMPOTENCY_TTL).Unix())
+	return err
+}
+
+// beginIdempotentRequest 开始一个带幂等键的请求
+// 返回已保存的响应（重放），或者返回 release 函数：调用方执行请求后用它保存结果并唤醒等待者。
+// 同一个 API Key 的同一个键有请求进行中时阻塞等待，直到其结束或 done 关闭。
+func beginIdempotentRequest(done <-chan struct{}, apiKeyID, key, requestHash string) (*idempotentResponse, func(*idempotentResponse), error) {
+	scope := idempotencyScope{apiKeyID: apiKeyID, key: key}
+	for {
+		stored, err := getIdempotentResponse(scope)
+		if err == nil {
+			if stored.RequestHash != requestHash {
+				return nil, nil, errIdempotencyKeyMismatch
+			}
+			return stored, nil, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+
+		idempotencyInflightMutex.Lock()
+		inflight, busy := idempotencyInflight[scope]
+		if !busy {
+			finished := make(chan struct{})
+			idempotencyInflight[scope] = &idempotentInflight{requestHash: requestHash, finished: finished}
+			idempotencyInflightMutex.Unlock()
+
+			release := func(resp *idempotentResponse) {
+				if resp != nil {
+					if err := saveIdempotentResponse(scope, resp); err != nil {
+						logIdempotency.Warn("保存幂等响应失败", "error", err)
+					}
+				}
+				idempotencyInflightMutex.Lock()
+				delete(idempotencyInflight, scope)
+				idempotencyInflightMutex.Unlock()
+				close(finished)
+			}
+			return nil, release, nil
+		}
+		idempotencyInflightMutex.Unlock()
+		if inflight.requestHash != requestHash {
+			return nil, nil, errIdempotencyKeyMismatch
+		}
+
+		logIdempotency.Debug("幂等键的请求进行中，等待结果", "idempotency_key", key)
+		select {
+		case <-inflight.finished:
+			// 进行中的请求结束，重新检查（失败时由本请求接手执行）
+		case <-done:
+			return nil, nil, errors.New("request cancelled while waiting")
+		}
+	}
+}
+
+// 记录响应内容的 ResponseWriter，同时写给客户端
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       []byte
+	completed  bool // 请求已完整处理（收到上游完成信号、缓存命中或后台任务已创建）
+}
+
+// 标记请求已完整处理；未标记的响应不保存（方法对 nil 安全）
+func (rec *idempotencyRecorder) complete() {
+	if rec != nil {
+		rec.completed = true
+	}
+}
+
+func (rec *idempotencyRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body = append(rec.body, b...)
+	return rec.ResponseWriter.Write(b)
+}
+
+// 已完成的成功响应转换为待保存的记录，否则返回 nil
+func (rec *idempotencyRecorder) result(requestHash string) *idempotentResponse {
+	if !rec.completed || rec.statusCode < 200 || rec.statusCode >= 300 {
+		return nil
+	}
+	return &idempotentResponse{
+		RequestHash: requestHash,
+		StatusCode:  rec.statusCode,
+		ContentType: rec.Header().Get("Content-Type"),
+		Body:        rec.body,
+	}
+}
+
+// 写出保存的响应
+func writeIdempotentResponse(w http.ResponseWriter, resp *idempotentResponse) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
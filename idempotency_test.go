@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 使用临时目录中的幂等键数据库，测试结束后恢复全局状态
+func useIdempotencyDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "idempotency.db")
+
+	oldDB, oldTTL := idempotencyDB, IDEMPOTENCY_TTL
+	if err := initIdempotencyDB(); err != nil {
+		t.Fatal(err)
+	}
+	IDEMPOTENCY_TTL = time.Hour
+	t.Cleanup(func() {
+		idempotencyDB.Close()
+		idempotencyDB, IDEMPOTENCY_TTL = oldDB, oldTTL
+	})
+}
+
+func TestBeginIdempotentRequest(t *testing.T) {
+	saved := &idempotentResponse{RequestHash: "hash-a", StatusCode: http.StatusOK, ContentType: "application/json", Body: []byte(`{"ok":true}`)}
+
+	tests := []struct {
+		name       string
+		first      *idempotentResponse // 首次请求结束时保存的结果，nil 表示失败不保存
+		apiKeyID   string
+		hash       string
+		wantReplay bool
+		wantErr    error
+	}{
+		{name: "replay", first: saved, apiKeyID: "key-1", hash: "hash-a", wantReplay: true},
+		{name: "different body", first: saved, apiKeyID: "key-1", hash: "hash-b", wantErr: errIdempotencyKeyMismatch},
+		{name: "other api key", first: saved, apiKeyID: "key-2", hash: "hash-b"},
+		{name: "retry after failure", first: nil, apiKeyID: "key-1", hash: "hash-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useIdempotencyDB(t)
+
+			stored, release, err := beginIdempotentRequest(nil, "key-1", "idem-1", "hash-a")
+			if err != nil || stored != nil || release == nil {
+				t.Fatalf("first request: got (%v, %v, %v), want release", stored, release != nil, err)
+			}
+			release(tt.first)
+
+			stored, release, err = beginIdempotentRequest(nil, tt.apiKeyID, "idem-1", tt.hash)
+			if err != tt.wantErr {
+				t.Fatalf("err: got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if tt.wantReplay {
+				if stored == nil || string(stored.Body) != string(saved.Body) || stored.StatusCode != saved.StatusCode {
+					t.Fatalf("replay: got %+v, want %+v", stored, saved)
+				}
+				return
+			}
+			if stored != nil || release == nil {
+				t.Fatalf("got (%v, %v), want a new execution", stored, release != nil)
+			}
+			release(nil)
+		})
+	}
+}
+
+func TestBeginIdempotentRequestConcurrent(t *testing.T) {
+	useIdempotencyDB(t)
+
+	_, release, err := beginIdempotentRequest(nil, "key-1", "idem-1", "hash-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 不同请求体：不等待进行中的请求，立即冲突
+	mismatch := make(chan error, 1)
+	go func() {
+		_, _, err := beginIdempotentRequest(nil, "key-1", "idem-1", "hash-b")
+		mismatch <- err
+	}()
+	select {
+	case err := <-mismatch:
+		if err != errIdempotencyKeyMismatch {
+			t.Fatalf("different body: got %v, want %v", err, errIdempotencyKeyMismatch)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("different body waited for the in-flight request")
+	}
+
+	// 相同请求体：等待进行中的请求结束后重放其结果
+	type result struct {
+		stored *idempotentResponse
+		err    error
+	}
+	replay := make(chan result, 1)
+	go func() {
+		stored, _, err := beginIdempotentRequest(nil, "key-1", "idem-1", "hash-a")
+		replay <- result{stored, err}
+	}()
+	select {
+	case r := <-replay:
+		t.Fatalf("same body returned before the in-flight request finished: %+v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release(&idempotentResponse{RequestHash: "hash-a", StatusCode: http.StatusOK, Body: []byte("done")})
+	r := <-replay
+	if r.err != nil || r.stored == nil || string(r.stored.Body) != "done" {
+		t.Fatalf("same body: got (%+v, %v), want replay", r.stored, r.err)
+	}
+}
+
+func TestBeginIdempotentRequestCancelledWhileWaiting(t *testing.T) {
+	useIdempotencyDB(t)
+
+	_, release, err := beginIdempotentRequest(nil, "key-1", "idem-1", "hash-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release(nil)
+
+	done := make(chan struct{})
+	close(done)
+	if _, _, err := beginIdempotentRequest(done, "key-1", "idem-1", "hash-a"); err == nil {
+		t.Fatal("got nil error, want cancellation")
+	}
+}
+
+func TestIdempotencyRecorderResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		complete bool
+		want     bool
+	}{
+		{name: "completed success", status: http.StatusOK, complete: true, want: true},
+		{name: "incomplete success", status: http.StatusOK, complete: false, want: false},
+		{name: "completed error", status: http.StatusBadGateway, complete: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &idempotencyRecorder{ResponseWriter: httptest.NewRecorder()}
+			rec.Header().Set("Content-Type", "application/json")
+			rec.WriteHeader(tt.status)
+			rec.Write([]byte("body"))
+			if tt.complete {
+				rec.complete()
+			}
+
+			got := rec.result("hash-a")
+			if (got != nil) != tt.want {
+				t.Fatalf("result(): got %+v, want saved=%v", got, tt.want)
+			}
+			if got != nil && (got.StatusCode != tt.status || string(got.Body) != "body" || got.ContentType != "application/json" || got.RequestHash != "hash-a") {
+				t.Errorf("result(): got %+v", got)
+			}
+		})
+	}
+}
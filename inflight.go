@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 进行中的请求 ====================
+//
+// liveRequests 只记录已完成的请求；进行中的对话补全请求（API、批处理、后台任务）
+// 登记在 inflightRequests 中，记录开始时间、模型、客户端 Key、上游 token（脱敏）、
+// 已发送的内容字节数以及当前阶段，供 Dashboard 展示。
+// 管理员取消请求时取消请求的 context：上游连接随之断开，客户端收到错误后结束。
+// 登记表以服务端生成的 ID 为键（客户端传入的 X-Request-ID 可能重复），请求 ID 单独展示。
+
+// 被管理员取消的请求的状态码（与 nginx 的 499 一致）
+const STATUS_REQUEST_CANCELLED = 499
+
+var errRequestCancelled = errors.New("request cancelled by administrator")
+
+// 请求阶段：等待上游响应，之后为上游事件的 phase（thinking / answer ...）
+const INFLIGHT_PHASE_CONNECTING = "connecting"
+
+// InflightRequest 进行中请求的快照
+type InflightRequest struct {
+	ID            string    `json:"id"`
+	RequestID     string    `json:"requestId,omitempty"`
+	Source        string    `json:"source"` // API / BATCH / BACKGROUND
+	Path          string    `json:"path"`
+	Model         string    `json:"model"`
+	Stream        bool      `json:"stream"`
+	APIKeyID      string    `json:"apiKeyId,omitempty"`
+	APIKeyName    string    `json:"apiKeyName,omitempty"`
+	Token         string    `json:"token,omitempty"` // 脱敏后的上游 token
+	ClientIP      string    `json:"clientIp,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	StartTime     time.Time `json:"startTime"`
+	Phase         string    `json:"phase"`
+	BytesStreamed int64     `json:"bytesStreamed"`
+	Duration      int64     `json:"duration"` // 毫秒
+	Cancelled     bool      `json:"cancelled,omitempty"`
+}
+
+type inflightRequest struct {
+	mu     sync.Mutex
+	info   InflightRequest
+	cancel context.CancelCauseFunc
+}
+
+type inflightKey struct{}
+
+var (
+	inflightRequests      = make(map[string]*inflightRequest)
+	inflightRequestsMutex sync.Mutex
+)
+
+// 登记进行中的请求，返回可被取消的 context；请求结束时必须调用 done
+func trackInflightRequest(ctx context.Context, source, path, clientIP, userAgent string) (context.Context, *inflightRequest) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	id := newRequestID()
+	t := &inflightRequest{
+		info: InflightRequest{
+			ID:        id,
+			RequestID: requestIDFromContext(ctx),
+			Source:    source,
+			Path:      path,
+			ClientIP:  clientIP,
+			UserAgent: userAgent,
+			StartTime: time.Now(),
+			Phase:     INFLIGHT_PHASE_CONNECTING,
+		},
+		cancel: cancel,
+	}
+
+	inflightRequestsMutex.Lock()
+	inflightRequests[id] = t
+	inflightRequestsMutex.Unlock()
+	return context.WithValue(ctx, inflightKey{}, t), t
+}
+
+// 取出 context 中的进行中请求，没有时返回 nil（方法对 nil 安全）
+func inflightFromContext(ctx context.Context) *inflightRequest {
+	t, _ := ctx.Value(inflightKey{}).(*inflightRequest)
+	return t
+}
+
+// 请求结束，移出登记表
+func (t *inflightRequest) done() {
+	if t == nil {
+		return
+	}
+	inflightRequestsMutex.Lock()
+	if inflightRequests[t.info.ID] == t {
+		delete(inflightRequests, t.info.ID)
+	}
+	inflightRequestsMutex.Unlock()
+	t.cancel(nil)
+}
+
+func (t *inflightRequest) setRequest(model string, stream bool, key *APIKey) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.Model = model
+	t.info.Stream = stream
+	if key != nil {
+		t.info.APIKeyID = key.ID
+		t.info.APIKeyName = key.Name
+	}
+}
+
+func (t *inflightRequest) setToken(token string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.info.Token = tokenPrefix(token)
+	t.mu.Unlock()
+}
+
+func (t *inflightRequest) setPhase(phase string) {
+	if t == nil || phase == "" {
+		return
+	}
+	t.mu.Lock()
+	t.info.Phase = phase
+	t.mu.Unlock()
+}
+
+func (t *inflightRequest) addBytes(n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.info.BytesStreamed += int64(n)
+	t.mu.Unlock()
+}
+
+func (t *inflightRequest) snapshot() InflightRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info := t.info
+	info.Duration = time.Since(info.StartTime).Milliseconds()
+	return info
+}
+
+// 列出进行中的请求，按开始时间排序
+func listInflightRequests() []InflightRequest {
+	inflightRequestsMutex.Lock()
+	list := make([]InflightRequest, 0, len(inflightRequests))
+	for _, t := range inflightRequests {
+		list = append(list, t.snapshot())
+	}
+	inflightRequestsMutex.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.Before(list[j].StartTime)
+	})
+	return list
+}
+
+// 取消进行中的请求，请求不存在时返回 false
+func cancelInflightRequest(id string) bool {
+	inflightRequestsMutex.Lock()
+	t, ok := inflightRequests[id]
+	inflightRequestsMutex.Unlock()
+	if !ok {
+		return false
+	}
+
+	t.mu.Lock()
+	t.info.Cancelled = true
+	t.mu.Unlock()
+	t.cancel(errRequestCancelled)
+	return true
+}
+
+// 请求是否已被管理员取消
+func requestCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRequestCancelled)
+}
+
+// 请求被中断的原因（管理员取消或服务关闭），未被中断时返回 nil
+func requestAborted(ctx context.Context) error {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errRequestCancelled) || errors.Is(cause, errServerShutdown) {
+		return cause
+	}
+	return nil
+}
+
+// 上游调用失败时返回给客户端的状态码和错误信息
+func upstreamFailure(ctx context.Context, message string) (int, string) {
+	if requestCancelled(ctx) {
+		return STATUS_REQUEST_CANCELLED, "Request cancelled by administrator"
+	}
+	if errors.Is(context.Cause(ctx), errServerShutdown) {
+		return http.StatusServiceUnavailable, "Server is shutting down"
+	}
+	return http.StatusBadGateway, message
+}
+
+// 流式响应中途被取消（管理员取消或服务关闭）时，向客户端发送错误事件
+func writeSSECancelled(ctx context.Context, w http.ResponseWriter) {
+	status, message := upstreamFailure(ctx, "")
+	errType := "request_cancelled"
+	if status == http.StatusServiceUnavailable {
+		errType = "server_shutdown"
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"code":    status,
+		},
+	})
+	fmt.Fprintf(w, "data: %s\n\n", data)
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Dashboard 进行中请求处理器；未登录管理后台时不返回客户端信息、Key 和上游 token
+func handleDashboardInflight(w http.ResponseWriter, r *http.Request) {
+	list := listInflightRequests()
+	if !checkAdminAuth(r) {
+		for i := range list {
+			info := &list[i]
+			info.RequestID, info.APIKeyID, info.APIKeyName, info.Token = "", "", "", ""
+			info.ClientIP, info.UserAgent = "", ""
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"requests": list,
+		"total":    len(list),
+	})
+}
+
+// Admin 进行中请求管理：
+//
+//	GET  /admin/api/requests              列表
+//	POST /admin/api/requests/{id}/cancel  取消
+func handleAdminAPIRequests(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/requests"), "/")
+	switch {
+	case rest == "" && r.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"requests": listInflightRequests(),
+		})
+
+	case strings.HasSuffix(rest, "/cancel") && r.Method == "POST":
+		id := strings.TrimSuffix(rest, "/cancel")
+		if !cancelInflightRequest(id) {
+			writeError(http.StatusNotFound, "请求不存在或已结束")
+			return
+		}
+		logMain.Info("管理员取消了请求", "request_id", id, "client_ip", getClientIP(r))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"id":      id,
+		})
+
+	default:
+		writeError(http.StatusNotFound, "未知的操作")
+	}
+}
@@ -0,0 +1,768 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hulisang/ZtoApi/logging"
+)
+
+// ==================== 请求日志（Journal） ====================
+//
+// JOURNAL_ENABLED=true 时，对话补全请求（API、批处理、后台任务）完整记录到 request_journal 表：
+// 请求消息、最终回答、思考内容、状态码、耗时、客户端 Key 和上游 token ID（token 的哈希前缀）。
+// 记录 ID 由服务端生成；请求 ID（可能由客户端通过 X-Request-ID 指定，不保证唯一）单独保存，可以按它查询。
+//   - JOURNAL_SAMPLE_RATE: 成功请求的采样比例（0~1），失败请求始终记录
+//   - JOURNAL_REDACT: secrets（隐藏 token、API Key 等，默认）/ content（只记录长度）/ none
+//   - JOURNAL_MAX_FIELD_BYTES: 单条消息、回答、思考内容的最大字节数，超出部分截断
+//   - JOURNAL_RETENTION_DAYS: 保留天数，0 表示永久保留
+//
+// 全文搜索使用 SQLite FTS5（trigram 分词，支持中文子串）；需要以 -tags sqlite_fts5 编译，
+// 否则退回 LIKE 查询。日志包含对话内容，查询接口需要管理员登录。
+
+const (
+	JOURNAL_REDACT_SECRETS = "secrets"
+	JOURNAL_REDACT_CONTENT = "content"
+	JOURNAL_REDACT_NONE    = "none"
+
+	JOURNAL_QUEUE_SIZE    = 256
+	JOURNAL_SNIPPET_RUNES = 40
+)
+
+var (
+	journalDB      *sql.DB
+	journalDBMutex sync.RWMutex
+	journalFTS     bool // FTS5 是否可用
+	journalQueue   chan *JournalRecord
+	journalStop    = make(chan struct{})
+	journalDone    = make(chan struct{})
+)
+
+// 初始化请求日志数据库（共用 register 数据库）并启动写入任务
+func initJournalDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	journalDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开请求日志数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	journalDB.SetMaxOpenConns(10)
+	journalDB.SetMaxIdleConns(2)
+	journalDB.SetConnMaxLifetime(5 * time.Minute)
+
+	createTableSQL := `
+	CREATE TABLE IF NOT EXISTS request_journal (
+		id TEXT PRIMARY KEY,
+		request_id TEXT,
+		created_at INTEGER NOT NULL,
+		source TEXT,
+		path TEXT,
+		model TEXT,
+		stream INTEGER DEFAULT 0,
+		status INTEGER,
+		duration_ms INTEGER,
+		api_key_id TEXT,
+		token_id TEXT,
+		client_ip TEXT,
+		user_agent TEXT,
+		conversation_id TEXT,
+		messages TEXT,
+		prompt TEXT,
+		content TEXT,
+		thinking TEXT,
+		error TEXT,
+		params TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_request_journal_created ON request_journal(created_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_request_journal_request_id ON request_journal(request_id);
+	`
+	if _, err = journalDB.Exec(createTableSQL); err != nil {
+		return fmt.Errorf("创建请求日志表失败: %v", err)
+	}
+
+	// 全文索引（外部内容表，由触发器同步）；prompt 为各条消息内容拼接的纯文本，只用于搜索
+	var hadTriggers int
+	journalDB.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'request_journal_ai'`).Scan(&hadTriggers)
+	_, err = journalDB.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS request_journal_fts USING fts5(
+		prompt, content, thinking,
+		content='request_journal', content_rowid='rowid', tokenize='trigram'
+	);
+	CREATE TRIGGER IF NOT EXISTS request_journal_ai AFTER INSERT ON request_journal BEGIN
+		INSERT INTO request_journal_fts(rowid, prompt, content, thinking)
+		VALUES (new.rowid, new.prompt, new.content, new.thinking);
+	END;
+	CREATE TRIGGER IF NOT EXISTS request_journal_ad AFTER DELETE ON request_journal BEGIN
+		INSERT INTO request_journal_fts(request_journal_fts, rowid, prompt, content, thinking)
+		VALUES ('delete', old.rowid, old.prompt, old.content, old.thinking);
+	END;
+	`)
+	if err == nil {
+		// 表已存在时 CREATE ... IF NOT EXISTS 不检查模块，实际查询一次确认 FTS5 可用
+		var rowid int64
+		if err = journalDB.QueryRow(`SELECT rowid FROM request_journal_fts LIMIT 1`).Scan(&rowid); err == sql.ErrNoRows {
+			err = nil
+		}
+	}
+	if err != nil {
+		// 去掉之前以 FTS5 编译时创建的触发器，否则写入会失败
+		logJournal.Warn("FTS5 不可用，全文搜索退回 LIKE 查询（以 -tags sqlite_fts5 编译可启用）", "error", err)
+		journalDB.Exec(`DROP TRIGGER IF EXISTS request_journal_ai; DROP TRIGGER IF EXISTS request_journal_ad;`)
+	} else {
+		journalFTS = true
+		// 触发器是新建的（首次启用或之前没有 FTS5），重建索引以包含已有记录
+		if hadTriggers == 0 {
+			if _, err := journalDB.Exec(`INSERT INTO request_journal_fts(request_journal_fts) VALUES ('rebuild')`); err != nil {
+				logJournal.Warn("重建全文索引失败", "error", err)
+			}
+		}
+	}
+
+	journalQueue = make(chan *JournalRecord, JOURNAL_QUEUE_SIZE)
+	go func() {
+		defer close(journalDone)
+		save := func(rec *JournalRecord) {
+			if err := saveJournalRecord(rec); err != nil {
+				logJournal.Warn("写入请求日志失败", "id", rec.ID, "error", err)
+			}
+		}
+		for {
+			select {
+			case rec := <-journalQueue:
+				save(rec)
+			case <-journalStop:
+				// 写完队列中剩余的记录后退出
+				for {
+					select {
+					case rec := <-journalQueue:
+						save(rec)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// 服务关闭时写完队列中的请求日志并停止写入任务
+func stopJournalWriter(ctx context.Context) {
+	if journalQueue == nil {
+		return
+	}
+	close(journalStop)
+	select {
+	case <-journalDone:
+	case <-ctx.Done():
+		logJournal.Warn("写入剩余请求日志超时", "pending", len(journalQueue))
+	}
+}
+
+// JournalRecord 一条请求日志
+type JournalRecord struct {
+	ID             string         `json:"id"`
+	RequestID      string         `json:"requestId,omitempty"`
+	CreatedAt      int64          `json:"createdAt"` // Unix 毫秒
+	Source         string         `json:"source"`
+	Path           string         `json:"path"`
+	Model          string         `json:"model"`
+	Stream         bool           `json:"stream"`
+	Status         int            `json:"status"`
+	Duration       int64          `json:"duration"` // 毫秒
+	APIKeyID       string         `json:"apiKeyId,omitempty"`
+	TokenID        string         `json:"tokenId,omitempty"`
+	ClientIP       string         `json:"clientIp,omitempty"`
+	UserAgent      string         `json:"userAgent,omitempty"`
+	ConversationID string         `json:"conversationId,omitempty"`
+	Messages       []Message      `json:"messages,omitempty"`
+	Content        string         `json:"content,omitempty"`
+	Thinking       string         `json:"thinking,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	Params         *JournalParams `json:"params,omitempty"`
+	Snippet        string         `json:"snippet,omitempty"` // 搜索结果中的匹配片段
+}
+
+// JournalParams 重放请求所需的参数（以及当时的上游前端版本，便于对比）
+type JournalParams struct {
+	EnableThinking bool                   `json:"enableThinking"`
+	Temperature    float64                `json:"temperature,omitempty"`
+	MaxTokens      int                    `json:"maxTokens,omitempty"`
+	UpstreamModel  string                 `json:"upstreamModel,omitempty"`
+	UpstreamParams map[string]interface{} `json:"upstreamParams,omitempty"`
+	FEVersion      string                 `json:"feVersion,omitempty"`
+}
+
+// 进行中请求的日志，通过 context 传递，上游内容边收边记
+type journalEntry struct {
+	mu       sync.Mutex
+	rec      JournalRecord
+	content  strings.Builder
+	thinking strings.Builder
+	finished bool
+}
+
+type journalKey struct{}
+
+// 开始记录请求日志，未启用时返回原 context 和 nil（方法对 nil 安全）
+func startJournalEntry(ctx context.Context, source, path, clientIP, userAgent string, req OpenAIRequest, apiKeyID string) (context.Context, *journalEntry) {
+	if !JOURNAL_ENABLED || journalQueue == nil {
+		return ctx, nil
+	}
+	e := &journalEntry{rec: JournalRecord{
+		ID:             newRequestID(),
+		RequestID:      requestIDFromContext(ctx),
+		CreatedAt:      time.Now().UnixMilli(),
+		Source:         source,
+		Path:           path,
+		Model:          req.Model,
+		Stream:         req.Stream,
+		APIKeyID:       apiKeyID,
+		ClientIP:       clientIP,
+		UserAgent:      userAgent,
+		ConversationID: req.ConversationID,
+		Messages:       append([]Message(nil), req.Messages...),
+		Params:         &JournalParams{Temperature: req.Temperature, MaxTokens: req.MaxTokens},
+	}}
+	return context.WithValue(ctx, journalKey{}, e), e
+}
+
+// 日志记录 ID（未启用时为空）
+func (e *journalEntry) id() string {
+	if e == nil {
+		return ""
+	}
+	return e.rec.ID
+}
+
+func journalFromContext(ctx context.Context) *journalEntry {
+	if ctx == nil {
+		return nil
+	}
+	e, _ := ctx.Value(journalKey{}).(*journalEntry)
+	return e
+}
+
+// 上游 token 的标识（哈希前缀），便于按 token 排查而不保存 token 本身
+func journalTokenID(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func (e *journalEntry) setToken(token string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.rec.TokenID = journalTokenID(token)
+	e.mu.Unlock()
+}
+
+// 记录实际发往上游的请求：完整消息（含会话历史）和参数
+func (e *journalEntry) setUpstream(upstreamReq UpstreamRequest, enableThinking bool) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rec.Messages = append([]Message(nil), upstreamReq.Messages...)
+	e.rec.Params.EnableThinking = enableThinking
+	e.rec.Params.UpstreamModel = upstreamReq.Model
+	if len(upstreamReq.Params) > 0 {
+		e.rec.Params.UpstreamParams = upstreamReq.Params
+	}
+	e.rec.Params.FEVersion = X_FE_VERSION
+}
+
+// 记录一个上游事件：按阶段分别收集思考内容和回答内容
+func (e *journalEntry) record(upstreamData *UpstreamData) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if errObj := upstreamData.upstreamError(); errObj != nil {
+		e.rec.Error = fmt.Sprintf("upstream error %d: %s", errObj.Code, errObj.Detail)
+	}
+	out := upstreamData.outputContent()
+	if out == "" {
+		return
+	}
+	b := &e.content
+	if upstreamData.Data.Phase == "thinking" {
+		b = &e.thinking
+	}
+	if b.Len() <= JOURNAL_MAX_FIELD_BYTES {
+		b.WriteString(out)
+	}
+}
+
+// 记录未到达上游的错误（例如获取 token 失败）
+func (e *journalEntry) setError(err error) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.rec.Error = err.Error()
+	e.mu.Unlock()
+}
+
+// 直接设置回答内容（缓存命中等不经过上游的情况）
+func (e *journalEntry) setContent(content string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.content.Reset()
+	e.content.WriteString(content)
+	e.mu.Unlock()
+}
+
+// 已收集的回答和思考内容（未脱敏）
+func (e *journalEntry) output() (content, thinking string) {
+	if e == nil {
+		return "", ""
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.content.String(), e.thinking.String()
+}
+
+// 请求结束：按采样和脱敏配置写入日志（由 recordRequestStatsDetailed 调用，每个请求只写一次）
+func finishJournalEntry(ctx context.Context, status int, duration time.Duration) {
+	e := journalFromContext(ctx)
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	if e.finished {
+		e.mu.Unlock()
+		return
+	}
+	e.finished = true
+	rec := e.rec
+	rec.Status = status
+	rec.Duration = duration.Milliseconds()
+	rec.Content = e.content.String()
+	rec.Thinking = e.thinking.String()
+	e.mu.Unlock()
+
+	// 失败请求始终记录，成功请求按比例采样
+	if status < 400 && rand.Float64() >= JOURNAL_SAMPLE_RATE {
+		return
+	}
+	if err := requestAborted(ctx); err != nil && rec.Error == "" {
+		rec.Error = err.Error()
+	}
+	redactJournalRecord(&rec)
+
+	select {
+	case journalQueue <- &rec:
+	default:
+		logJournal.Warn("请求日志队列已满，丢弃记录", "id", rec.ID)
+	}
+}
+
+// 按 JOURNAL_REDACT 脱敏并截断
+func redactJournalRecord(rec *JournalRecord) {
+	redact := func(s string) string {
+		switch JOURNAL_REDACT {
+		case JOURNAL_REDACT_CONTENT:
+			if s == "" {
+				return ""
+			}
+			return fmt.Sprintf("[%d chars]", utf8.RuneCountInString(s))
+		case JOURNAL_REDACT_SECRETS:
+			s = logging.Redact(s)
+		}
+		return truncateJournalField(s)
+	}
+
+	messages := make([]Message, len(rec.Messages))
+	for i, m := range rec.Messages {
+		messages[i] = Message{Role: m.Role, Content: redact(m.Content)}
+	}
+	rec.Messages = messages
+	rec.Content = redact(rec.Content)
+	rec.Thinking = redact(rec.Thinking)
+	rec.Error = logging.Redact(rec.Error)
+}
+
+// 截断到 JOURNAL_MAX_FIELD_BYTES（按字符边界）
+func truncateJournalField(s string) string {
+	if len(s) <= JOURNAL_MAX_FIELD_BYTES {
+		return s
+	}
+	cut := JOURNAL_MAX_FIELD_BYTES
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + fmt.Sprintf("…[truncated %d bytes]", len(s)-cut)
+}
+
+// 保存一条请求日志
+func saveJournalRecord(rec *JournalRecord) error {
+	messages, _ := json.Marshal(rec.Messages)
+	var params []byte
+	if rec.Params != nil {
+		params, _ = json.Marshal(rec.Params)
+	}
+	prompt := make([]string, len(rec.Messages))
+	for i, m := range rec.Messages {
+		prompt[i] = m.Content
+	}
+
+	journalDBMutex.Lock()
+	defer journalDBMutex.Unlock()
+
+	_, err := journalDB.Exec(`
+		INSERT INTO request_journal (id, request_id, created_at, source, path, model, stream, status, duration_ms,
+			api_key_id, token_id, client_ip, user_agent, conversation_id, messages, prompt, content, thinking, error, params)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, rec.ID, rec.RequestID, rec.CreatedAt, rec.Source, rec.Path, rec.Model, rec.Stream, rec.Status, rec.Duration,
+		rec.APIKeyID, rec.TokenID, rec.ClientIP, rec.UserAgent, rec.ConversationID, string(messages), strings.Join(prompt, "\n"),
+		rec.Content, rec.Thinking, rec.Error, string(params))
+	return err
+}
+
+// 清理超过保留天数的请求日志
+func cleanupJournal() {
+	if journalDB == nil || JOURNAL_RETENTION_DAYS == 0 {
+		return
+	}
+
+	journalDBMutex.Lock()
+	defer journalDBMutex.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -JOURNAL_RETENTION_DAYS).UnixMilli()
+	result, err := journalDB.Exec(`DELETE FROM request_journal WHERE created_at < ?`, cutoff)
+	if err != nil {
+		logJournal.Warn("清理请求日志失败", "error", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logJournal.Debug("清理过期的请求日志", "count", n)
+	}
+}
+
+// 请求日志查询条件
+type journalQuery struct {
+	text      string
+	requestID string
+	model     string
+	source    string
+	apiKeyID  string
+	status    string // 200 / 5xx
+	from, to  time.Time
+	page      int
+	pageSize  int
+}
+
+const journalColumns = `j.id, j.request_id, j.created_at, j.source, j.path, j.model, j.stream, j.status, j.duration_ms,
+	j.api_key_id, j.token_id, j.client_ip, j.user_agent, j.conversation_id, j.messages, j.content, j.thinking, j.error, j.params`
+
+func scanJournalRecord(scan func(dest ...interface{}) error) (*JournalRecord, error) {
+	var rec JournalRecord
+	var requestID, source, path, model, apiKeyID, tokenID, clientIP, userAgent, conversationID, messages, content, thinking, errText, params sql.NullString
+	if err := scan(&rec.ID, &requestID, &rec.CreatedAt, &source, &path, &model, &rec.Stream, &rec.Status, &rec.Duration,
+		&apiKeyID, &tokenID, &clientIP, &userAgent, &conversationID, &messages, &content, &thinking, &errText, &params); err != nil {
+		return nil, err
+	}
+	rec.RequestID = requestID.String
+	rec.Source = source.String
+	rec.Path = path.String
+	rec.Model = model.String
+	rec.APIKeyID = apiKeyID.String
+	rec.TokenID = tokenID.String
+	rec.ClientIP = clientIP.String
+	rec.UserAgent = userAgent.String
+	rec.ConversationID = conversationID.String
+	rec.Content = content.String
+	rec.Thinking = thinking.String
+	rec.Error = errText.String
+	if messages.String != "" {
+		json.Unmarshal([]byte(messages.String), &rec.Messages)
+	}
+	if params.String != "" {
+		rec.Params = &JournalParams{}
+		json.Unmarshal([]byte(params.String), rec.Params)
+	}
+	return &rec, nil
+}
+
+// 搜索请求日志，按时间倒序；返回当前页和总数
+func searchJournal(q journalQuery) ([]JournalRecord, int, error) {
+	result := []JournalRecord{}
+	if journalDB == nil {
+		return result, 0, nil
+	}
+
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	if q.text != "" {
+		// trigram 分词至少需要 3 个字符，更短的关键词使用 LIKE
+		if journalFTS && utf8.RuneCountInString(q.text) >= 3 {
+			where = append(where, "j.rowid IN (SELECT rowid FROM request_journal_fts WHERE request_journal_fts MATCH ?)")
+			args = append(args, `"`+strings.ReplaceAll(q.text, `"`, `""`)+`"`)
+		} else {
+			like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.text) + "%"
+			where = append(where, `(j.prompt LIKE ? ESCAPE '\' OR j.content LIKE ? ESCAPE '\' OR j.thinking LIKE ? ESCAPE '\')`)
+			args = append(args, like, like, like)
+		}
+	}
+	for column, value := range map[string]string{"j.request_id": q.requestID, "j.model": q.model, "j.source": q.source, "j.api_key_id": q.apiKeyID} {
+		if value != "" {
+			where = append(where, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if q.status != "" {
+		// 精确状态码（200）或状态码类别（5xx / 5）
+		class := strings.TrimSuffix(strings.ToLower(q.status), "xx")
+		n, _ := strconv.Atoi(class)
+		if len(class) == 1 {
+			where = append(where, "j.status / 100 = ?")
+		} else {
+			where = append(where, "j.status = ?")
+		}
+		args = append(args, n)
+	}
+	if !q.from.IsZero() {
+		where = append(where, "j.created_at >= ?")
+		args = append(args, q.from.UnixMilli())
+	}
+	if !q.to.IsZero() {
+		where = append(where, "j.created_at < ?")
+		args = append(args, q.to.UnixMilli())
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	journalDBMutex.RLock()
+	defer journalDBMutex.RUnlock()
+
+	var total int
+	if err := journalDB.QueryRow(`SELECT COUNT(*) FROM request_journal j WHERE `+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := journalDB.Query(`SELECT `+journalColumns+` FROM request_journal j WHERE `+whereSQL+`
+		ORDER BY j.created_at DESC LIMIT ? OFFSET ?`, append(args, q.pageSize, (q.page-1)*q.pageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rec, err := scanJournalRecord(rows.Scan)
+		if err != nil {
+			return nil, 0, err
+		}
+		// 列表只返回摘要，完整内容通过详情接口获取
+		rec.Snippet = journalSnippet(rec, q.text)
+		rec.Messages, rec.Content, rec.Thinking = nil, "", ""
+		result = append(result, *rec)
+	}
+	return result, total, nil
+}
+
+// 匹配片段：关键词前后各 JOURNAL_SNIPPET_RUNES 个字符；没有关键词时为最后一条用户消息
+func journalSnippet(rec *JournalRecord, text string) string {
+	cut := func(s string, start, end int) string {
+		r := []rune(s)
+		if start < 0 {
+			start = 0
+		}
+		if end > len(r) {
+			end = len(r)
+		}
+		snippet := string(r[start:end])
+		if start > 0 {
+			snippet = "…" + snippet
+		}
+		if end < len(r) {
+			snippet += "…"
+		}
+		return snippet
+	}
+
+	if text != "" {
+		needle := []rune(strings.ToLower(text))
+		fields := []string{rec.Content, rec.Thinking}
+		for _, m := range rec.Messages {
+			fields = append(fields, m.Content)
+		}
+		for _, field := range fields {
+			if i := strings.Index(strings.ToLower(field), string(needle)); i >= 0 {
+				pos := utf8.RuneCountInString(strings.ToLower(field)[:i])
+				return cut(field, pos-JOURNAL_SNIPPET_RUNES, pos+len(needle)+JOURNAL_SNIPPET_RUNES)
+			}
+		}
+	}
+	for i := len(rec.Messages) - 1; i >= 0; i-- {
+		if rec.Messages[i].Role == "user" {
+			return cut(rec.Messages[i].Content, 0, 2*JOURNAL_SNIPPET_RUNES)
+		}
+	}
+	return ""
+}
+
+// 获取单条请求日志
+func getJournalRecord(id string) (*JournalRecord, error) {
+	journalDBMutex.RLock()
+	defer journalDBMutex.RUnlock()
+	row := journalDB.QueryRow(`SELECT `+journalColumns+` FROM request_journal j WHERE j.id = ?`, id)
+	return scanJournalRecord(row.Scan)
+}
+
+// 删除单条请求日志
+func deleteJournalRecord(id string) (bool, error) {
+	journalDBMutex.Lock()
+	defer journalDBMutex.Unlock()
+	result, err := journalDB.Exec(`DELETE FROM request_journal WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// Dashboard 请求日志页面
+func handleDashboardJournal(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(getJournalHTML()))
+}
+
+// Admin 请求日志：
+//
+//	GET    /admin/api/journal       搜索 ?q=&request_id=&model=&source=&api_key=&status=&from=&to=&page=&pageSize=
+//	GET    /admin/api/journal/{id}  详情
+//	DELETE /admin/api/journal/{id}  删除
+//	POST   /admin/api/journal/{id}/replay  重放（见 replay.go）
+func handleAdminAPIJournal(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+	if journalDB == nil {
+		writeError(http.StatusNotFound, "请求日志未启用（JOURNAL_ENABLED=true）")
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/journal"), "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		params := r.URL.Query()
+		q := journalQuery{
+			text:      strings.TrimSpace(params.Get("q")),
+			requestID: strings.TrimSpace(params.Get("request_id")),
+			model:     params.Get("model"),
+			source:    params.Get("source"),
+			apiKeyID:  params.Get("api_key"),
+			status:    params.Get("status"),
+			page:      1,
+			pageSize:  20,
+		}
+		if p, err := strconv.Atoi(params.Get("page")); err == nil && p > 0 {
+			q.page = p
+		}
+		if ps, err := strconv.Atoi(params.Get("pageSize")); err == nil && ps > 0 && ps <= 100 {
+			q.pageSize = ps
+		}
+		for name, dest := range map[string]*time.Time{"from": &q.from, "to": &q.to} {
+			if s := params.Get(name); s != "" {
+				t, err := parseExportTime(s, statsLocation)
+				if err != nil {
+					writeError(http.StatusBadRequest, err.Error())
+					return
+				}
+				*dest = t
+			}
+		}
+
+		records, total, err := searchJournal(q)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"records":  records,
+			"total":    total,
+			"page":     q.page,
+			"pageSize": q.pageSize,
+			"fts":      journalFTS,
+		})
+
+	case id != "" && r.Method == "GET":
+		rec, err := getJournalRecord(id)
+		if err == sql.ErrNoRows {
+			writeError(http.StatusNotFound, "记录不存在")
+			return
+		}
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"record":  rec,
+		})
+
+	case strings.HasSuffix(id, "/replay") && r.Method == "POST":
+		handleJournalReplay(w, r, strings.TrimSuffix(id, "/replay"), writeError)
+
+	case id != "" && r.Method == "DELETE":
+		ok, err := deleteJournalRecord(id)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !ok {
+			writeError(http.StatusNotFound, "记录不存在")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+		})
+
+	default:
+		writeError(http.StatusNotFound, "未知的操作")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// ==================== 延迟拆分统计 ====================
+//
+// 每个成功的对话补全请求记录以下指标，按小时存入直方图分桶，查询时计算 p50/p95/p99：
+//   - ttft_ms: 收到请求到第一个内容 token
+//   - upstream_header_ms: 发出上游请求到收到上游响应头
+//   - generation_ms: 第一个内容 token 到生成结束
+//   - tokens_per_sec: 生成阶段的输出速度（token 数按内容估算）
+
+const (
+	LATENCY_TTFT            = "ttft_ms"
+	LATENCY_UPSTREAM_HEADER = "upstream_header_ms"
+	LATENCY_GENERATION      = "generation_ms"
+	LATENCY_TOKENS_PER_SEC  = "tokens_per_sec"
+)
+
+// 各指标的分桶上界（最后还有一个 +Inf 桶）
+var latencyStatBuckets = map[string][]float64{
+	LATENCY_TTFT:            {50, 100, 250, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 20000, 30000, 60000},
+	LATENCY_UPSTREAM_HEADER: {50, 100, 250, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 20000, 30000, 60000},
+	LATENCY_GENERATION:      {100, 250, 500, 1000, 2000, 5000, 10000, 20000, 30000, 60000, 120000, 300000, 600000},
+	LATENCY_TOKENS_PER_SEC:  {1, 2, 5, 10, 15, 20, 30, 40, 50, 75, 100, 150, 200, 300, 500},
+}
+
+// 展示顺序
+var latencyMetricNames = []string{LATENCY_TTFT, LATENCY_UPSTREAM_HEADER, LATENCY_GENERATION, LATENCY_TOKENS_PER_SEC}
+
+// 单个请求的各阶段时间点，通过 context 在处理链路中传递
+type requestTimings struct {
+	start          time.Time
+	upstreamHeader time.Duration
+	firstToken     time.Time
+	end            time.Time
+	outputTokens   int
+}
+
+type timingsKey struct{}
+
+// 开始记录请求的各阶段时间
+func withRequestTimings(ctx context.Context, start time.Time) (context.Context, *requestTimings) {
+	t := &requestTimings{start: start}
+	return context.WithValue(ctx, timingsKey{}, t), t
+}
+
+// 取出当前请求的时间记录，没有时返回 nil（方法可安全调用）
+func timingsFromContext(ctx context.Context) *requestTimings {
+	t, _ := ctx.Value(timingsKey{}).(*requestTimings)
+	return t
+}
+
+// 收到上游响应头
+func (t *requestTimings) upstreamResponded(latency time.Duration) {
+	if t != nil {
+		t.upstreamHeader = latency
+	}
+}
+
+// 收到第一个内容 token
+func (t *requestTimings) markFirstToken() {
+	if t != nil && t.firstToken.IsZero() {
+		t.firstToken = time.Now()
+	}
+}
+
+// 生成结束
+func (t *requestTimings) finish(content string) {
+	if t != nil {
+		t.end = time.Now()
+		t.outputTokens = estimateTokens(content)
+	}
+}
+
+// 上游不返回 usage，按字符粗略估算 token 数：CJK 字符每个算 1 个，其余每 4 个字符算 1 个
+func estimateTokens(s string) int {
+	cjk, other := 0, 0
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Han, r), unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r), unicode.Is(unicode.Hangul, r):
+			cjk++
+		case !unicode.IsSpace(r):
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// 需要记录的指标值，未知的阶段不记录
+func (t *requestTimings) observations() map[string]float64 {
+	values := make(map[string]float64)
+	if t.upstreamHeader > 0 {
+		values[LATENCY_UPSTREAM_HEADER] = float64(t.upstreamHeader.Milliseconds())
+	}
+	if !t.firstToken.IsZero() {
+		values[LATENCY_TTFT] = float64(t.firstToken.Sub(t.start).Milliseconds())
+		if !t.end.IsZero() {
+			generation := t.end.Sub(t.firstToken)
+			values[LATENCY_GENERATION] = float64(generation.Milliseconds())
+			if generation > 0 && t.outputTokens > 0 {
+				values[LATENCY_TOKENS_PER_SEC] = float64(t.outputTokens) / generation.Seconds()
+			}
+		}
+	}
+	return values
+}
+
+// 值所在的桶序号
+func latencyBucketIndex(bounds []float64, value float64) int {
+	return sort.SearchFloat64s(bounds, value)
+}
+
+// LatencyPercentiles 一个指标在一段时间内的分位数
+type LatencyPercentiles struct {
+	Count int64   `json:"count"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+}
+
+// HourlyLatency 一个小时的延迟拆分
+type HourlyLatency struct {
+	Hour    string                        `json:"hour"`
+	Metrics map[string]LatencyPercentiles `json:"metrics"`
+}
+
+// 根据分桶计数估算分位数：在目标所在桶内线性插值，落在 +Inf 桶时返回最大上界
+func bucketQuantile(q float64, bounds []float64, counts []int64) float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := q * float64(total)
+	var cumulative int64
+	for i, c := range counts {
+		if c == 0 {
+			continue
+		}
+		if float64(cumulative+c) >= rank {
+			if i >= len(bounds) {
+				return bounds[len(bounds)-1]
+			}
+			lower := 0.0
+			if i > 0 {
+				lower = bounds[i-1]
+			}
+			return lower + (bounds[i]-lower)*(rank-float64(cumulative))/float64(c)
+		}
+		cumulative += c
+	}
+	return bounds[len(bounds)-1]
+}
+
+func percentilesFromBuckets(metric string, counts []int64) LatencyPercentiles {
+	bounds := latencyStatBuckets[metric]
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	round := func(v float64) float64 { return float64(int64(v*10+0.5)) / 10 }
+	return LatencyPercentiles{
+		Count: total,
+		P50:   round(bucketQuantile(0.50, bounds, counts)),
+		P95:   round(bucketQuantile(0.95, bounds, counts)),
+		P99:   round(bucketQuantile(0.99, bounds, counts)),
+	}
+}
+
+// 查询最近若干小时的延迟拆分，同时返回整段时间的汇总
+func getHourlyLatency(hours int, loc *time.Location) ([]HourlyLatency, map[string]LatencyPercentiles, error) {
+	if statsDB == nil {
+		return []HourlyLatency{}, map[string]LatencyPercentiles{}, nil
+	}
+
+	sinceKey := hourKeyFor(time.Now().Add(-time.Duration(hours-1) * time.Hour))
+
+	rows, err := statsDB.Query(`
+		SELECT hour, metric, bucket, count FROM hourly_latency
+		WHERE hour >= ? ORDER BY hour
+	`, sinceKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	newCounts := func(metric string) []int64 { return make([]int64, len(latencyStatBuckets[metric])+1) }
+	byHour := make(map[string]map[string][]int64)
+	var hourKeys []string
+	total := make(map[string][]int64)
+	for rows.Next() {
+		var hour, metric string
+		var bucket int
+		var count int64
+		if err := rows.Scan(&hour, &metric, &bucket, &count); err != nil {
+			continue
+		}
+		bounds, ok := latencyStatBuckets[metric]
+		if !ok || bucket < 0 || bucket > len(bounds) {
+			continue
+		}
+		if byHour[hour] == nil {
+			byHour[hour] = make(map[string][]int64)
+			hourKeys = append(hourKeys, hour)
+		}
+		if byHour[hour][metric] == nil {
+			byHour[hour][metric] = newCounts(metric)
+		}
+		if total[metric] == nil {
+			total[metric] = newCounts(metric)
+		}
+		byHour[hour][metric][bucket] += count
+		total[metric][bucket] += count
+	}
+
+	result := make([]HourlyLatency, 0, len(hourKeys))
+	for _, hour := range hourKeys {
+		item := HourlyLatency{Hour: localHourKey(hour, loc), Metrics: make(map[string]LatencyPercentiles)}
+		for metric, counts := range byHour[hour] {
+			item.Metrics[metric] = percentilesFromBuckets(metric, counts)
+		}
+		result = append(result, item)
+	}
+
+	summary := make(map[string]LatencyPercentiles)
+	for metric, counts := range total {
+		summary[metric] = percentilesFromBuckets(metric, counts)
+	}
+	return result, summary, nil
+}
+
+// Dashboard 延迟拆分处理器
+func handleDashboardLatency(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	hours := 24
+	if hoursStr := r.URL.Query().Get("hours"); hoursStr != "" {
+		if h, err := strconv.Atoi(hoursStr); err == nil && h > 0 && h <= 168 {
+			hours = h
+		}
+	}
+
+	loc, ok := requestStatsLocation(w, r)
+	if !ok {
+		return
+	}
+
+	hourly, summary, err := getHourlyLatency(hours, loc)
+	if err != nil {
+		http.Error(w, "Failed to get latency stats", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"metrics": latencyMetricNames,
+		"hourly":  hourly,
+		"summary": summary,
+	})
+}
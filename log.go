@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"strings"
+
+	"github.com/hulisang/ZtoApi/logging"
+)
+
+// ==================== 日志相关 ====================
+//
+// 基于 log/slog 的结构化日志，所有输出（包括标准库 log）都经过脱敏：
+//   - LOG_LEVEL: debug / info / warn / error，未设置时 DEBUG_MODE=true 等同于 debug
+//   - LOG_FORMAT: text / json
+//   - LOG_MODULE_LEVELS: 按模块覆盖级别，例如 upstream=debug,register=warn
+//   - LOG_REDACT_CONTENT=true 时同时隐藏消息内容
+
+// 各模块的 logger
+var (
+	logMain          = logging.For("main")
+	logUpstream      = logging.For("upstream")
+	logToken         = logging.For("token")
+	logBatch         = logging.For("batch")
+	logBackground    = logging.For("background")
+	logConversations = logging.For("conversations")
+	logCache         = logging.For("cache")
+	logIdempotency   = logging.For("idempotency")
+	logJournal       = logging.For("journal")
+	logStats         = logging.For("stats")
+	logConfig        = logging.For("config")
+)
+
+// 初始化日志
+func initLogging() {
+	levelName := getEnv("LOG_LEVEL", "")
+	if levelName == "" {
+		levelName = "info"
+		if DEBUG_MODE.Load() {
+			levelName = "debug"
+		}
+	}
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		log.Printf("⚠️ LOG_LEVEL 无效，使用默认值 info")
+		level = slog.LevelInfo
+	}
+
+	format := strings.ToLower(getEnv("LOG_FORMAT", "text"))
+	if format != "text" && format != "json" {
+		log.Printf("⚠️ LOG_FORMAT 无效，使用默认值 text")
+		format = "text"
+	}
+
+	moduleLevels, err := logging.ParseModuleLevels(getEnv("LOG_MODULE_LEVELS", ""))
+	if err != nil {
+		log.Printf("⚠️ LOG_MODULE_LEVELS 无效，已忽略: %v", err)
+		moduleLevels = nil
+	}
+
+	logging.Init(logging.Config{
+		Level:         level,
+		Format:        format,
+		ModuleLevels:  moduleLevels,
+		RedactContent: getEnvBool("LOG_REDACT_CONTENT", false),
+	})
+}
+
+// 截取 token 前缀用于日志
+func tokenPrefix(token string) string {
+	if len(token) > TOKEN_DISPLAY_LENGTH {
+		return token[:TOKEN_DISPLAY_LENGTH] + "..."
+	}
+	return token
+}
@@ -0,0 +1,154 @@
+// Package logging 基于 log/slog 的结构化日志：
+// 支持日志级别、text/json 输出、按模块设置级别，并对所有输出做敏感信息脱敏。
+// For 返回的 logger 在每次输出时读取当前配置，可以在 Init 之前创建，Init 可重复调用。
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Config 日志配置
+type Config struct {
+	Level         slog.Level            // 默认级别
+	Format        string                // text / json
+	ModuleLevels  map[string]slog.Level // 按模块覆盖级别
+	RedactContent bool                  // 是否同时隐藏消息内容
+	Output        io.Writer             // 默认 os.Stderr
+}
+
+type state struct {
+	cfg  Config
+	base slog.Handler
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	Init(Config{Level: slog.LevelInfo, Format: "text"})
+}
+
+// Init 应用配置，并将 slog 与标准库 log 的默认输出接管到脱敏处理器
+func Init(cfg Config) {
+	if cfg.Output == nil {
+		cfg.Output = os.Stderr
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       slog.LevelDebug, // 级别由 moduleHandler 判断
+		ReplaceAttr: redactAttr(cfg.RedactContent),
+	}
+	var base slog.Handler
+	if strings.EqualFold(cfg.Format, "json") {
+		base = slog.NewJSONHandler(cfg.Output, opts)
+	} else {
+		base = slog.NewTextHandler(cfg.Output, opts)
+	}
+
+	current.Store(&state{cfg: cfg, base: base})
+	slog.SetDefault(slog.New(&moduleHandler{}))
+}
+
+// For 返回指定模块的 logger（输出中带 module 字段）
+func For(module string) *slog.Logger {
+	return slog.New(&moduleHandler{module: module})
+}
+
+type requestIDKey struct{}
+
+// WithRequestID 将请求 ID 放入 context，使用 *Context 方法输出的日志会带上 request_id 字段
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID 取出 context 中的请求 ID，没有时返回空字符串
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ParseLevel 解析级别名称：debug / info / warn / error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return level, err
+}
+
+// ParseModuleLevels 解析 "upstream=debug,register=warn" 形式的模块级别
+func ParseModuleLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		module, name, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的模块级别: %s", pair)
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("无效的模块级别: %s", pair)
+		}
+		levels[strings.TrimSpace(module)] = level
+	}
+	return levels, nil
+}
+
+// moduleHandler 按模块判断级别，实际输出交给当前配置的 handler
+type moduleHandler struct {
+	module string
+	ops    []handlerOp
+}
+
+// WithAttrs / WithGroup 记录下来，在输出时按顺序应用到当前 handler
+type handlerOp struct {
+	attrs []slog.Attr
+	group string
+}
+
+func (h *moduleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	st := current.Load()
+	min := st.cfg.Level
+	if moduleLevel, ok := st.cfg.ModuleLevels[h.module]; ok {
+		min = moduleLevel
+	}
+	return level >= min
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, r slog.Record) error {
+	handler := current.Load().base
+	if h.module != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	}
+	if id := RequestID(ctx); id != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String("request_id", id)})
+	}
+	for _, op := range h.ops {
+		if op.group != "" {
+			handler = handler.WithGroup(op.group)
+		} else {
+			handler = handler.WithAttrs(op.attrs)
+		}
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &moduleHandler{module: h.module, ops: append(h.ops[:len(h.ops):len(h.ops)], handlerOp{attrs: attrs})}
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &moduleHandler{module: h.module, ops: append(h.ops[:len(h.ops):len(h.ops)], handlerOp{group: name})}
+}
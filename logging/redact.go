@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const mask = "***"
+
+// 文本中的敏感信息模式，按顺序替换
+var redactRules = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Authorization: Bearer xxx
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}" + mask},
+	// Cookie / Authorization 等请求头
+	{regexp.MustCompile(`(?i)\b(cookie|set-cookie|authorization|x-zai-token)(\s*:\s*)[^\n]+`), "${1}${2}" + mask},
+	// JSON 字段 "token": "xxx"
+	{regexp.MustCompile(`(?i)("(?:[a-z_]*token|api_?key|apikey|password|passwd|secret|authorization|cookie)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + mask + `"`},
+	// URL 参数、表单、Cookie 中的 token=xxx
+	{regexp.MustCompile(`(?i)\b([a-z_]*token|api_?key|apikey|password|passwd|pwd|secret|signature)=([^&\s;,"']+)`), "${1}=" + mask},
+	// JWT
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), "eyJ" + mask},
+	// sk- 开头的 API Key
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{6,}`), "sk-" + mask},
+	// 账号导出格式 email----password----token----apikey
+	{regexp.MustCompile(`([^\s@]+@[^\s-]+)----\S+`), "${1}----" + mask},
+}
+
+// 消息内容（仅在开启 RedactContent 时）
+var contentRule = regexp.MustCompile(`("(?:content|delta_content|edit_content|reasoning_content)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// 值需要整体隐藏的字段名
+var sensitiveKeys = map[string]bool{
+	"token": true, "auth_token": true, "authorization": true, "cookie": true,
+	"password": true, "api_key": true, "apikey": true, "secret": true, "signature": true,
+}
+
+// 开启 RedactContent 时整体隐藏的字段名
+var contentKeys = map[string]bool{
+	"content": true, "message_content": true, "prompt": true, "messages": true,
+}
+
+// Redact 隐藏文本中的 token、JWT、Cookie、API Key、密码等敏感信息
+func Redact(s string) string {
+	for _, rule := range redactRules {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
+
+// RedactWithContent 在 Redact 的基础上，按当前配置隐藏 JSON 中的消息内容
+func RedactWithContent(s string) string {
+	s = Redact(s)
+	if current.Load().cfg.RedactContent {
+		s = contentRule.ReplaceAllString(s, `${1}"`+mask+`"`)
+	}
+	return s
+}
+
+// slog 输出前对每个属性脱敏（包括 msg）
+func redactAttr(redactContent bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		key := strings.ToLower(a.Key)
+		if sensitiveKeys[key] {
+			return slog.String(a.Key, mask)
+		}
+		if redactContent && contentKeys[key] && a.Value.Kind() == slog.KindString {
+			return slog.String(a.Key, fmt.Sprintf("[%d chars]", len(a.Value.String())))
+		}
+
+		switch a.Value.Kind() {
+		case slog.KindString:
+			s := Redact(a.Value.String())
+			if redactContent {
+				s = contentRule.ReplaceAllString(s, `${1}"`+mask+`"`)
+			}
+			return slog.String(a.Key, s)
+		case slog.KindAny:
+			if err, ok := a.Value.Any().(error); ok {
+				return slog.String(a.Key, Redact(err.Error()))
+			}
+		}
+		return a
+	}
+}
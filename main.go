@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
@@ -10,9 +11,11 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -22,9 +25,13 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/hulisang/ZtoApi/logging"
 	"github.com/hulisang/ZtoApi/register"
+	"github.com/hulisang/ZtoApi/sse"
+	"github.com/hulisang/ZtoApi/tracing"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -35,14 +42,60 @@ var (
 	ZAI_TOKEN         string
 	MODEL_NAME        string
 	PORT              string
-	DEBUG_MODE        bool
-	DEFAULT_STREAM    bool
 	DASHBOARD_ENABLED bool
-	ENABLE_THINKING   bool
 	REGISTER_ENABLED  bool
 	ADMIN_ENABLED     bool
 	ADMIN_USERNAME    string
 	ADMIN_PASSWORD    string
+
+	CONVERSATIONS_ENABLED bool
+	BATCH_ENABLED         bool
+	BATCH_CONCURRENCY     int
+
+	BACKGROUND_ENABLED         bool
+	BACKGROUND_CALLBACK_SECRET string
+
+	IDEMPOTENCY_TTL time.Duration
+
+	CACHE_ENABLED        bool
+	CACHE_BACKEND        string
+	CACHE_TTL            time.Duration
+	CACHE_MAX_ENTRIES    int
+	CACHE_MAX_BYTES      int
+	CACHE_DEFAULT_POLICY string
+
+	METRICS_ENABLED bool
+	METRICS_TOKEN   string
+
+	TRACING_ENABLED bool
+
+	STATS_HOURLY_RETENTION_DAYS int
+	STATS_DAILY_RETENTION_DAYS  int
+	STATS_TIMEZONE              string
+
+	DASHBOARD_ALERT_ERROR_RATE float64
+	DASHBOARD_ALERT_SLOW_MS    int
+
+	JOURNAL_ENABLED         bool
+	JOURNAL_SAMPLE_RATE     float64
+	JOURNAL_REDACT          string
+	JOURNAL_MAX_FIELD_BYTES int
+	JOURNAL_RETENTION_DAYS  int
+
+	READYZ_UPSTREAM_FAILURES int
+
+	SHUTDOWN_GRACE_PERIOD time.Duration
+)
+
+// 可热重载的配置（见 config.go）
+var (
+	DEBUG_MODE         reloadable[bool]
+	DEFAULT_STREAM     reloadable[bool]
+	ENABLE_THINKING    reloadable[bool]
+	ANON_TOKEN_ENABLED reloadable[bool]          // 没有其他 token 来源时使用匿名 token
+	THINK_TAGS_MODE    reloadable[string]        // strip: 去除<details>标签；think: 转为<think>标签；raw: 保留原样
+	UPSTREAM_TIMEOUT   reloadable[time.Duration] // 上游API调用超时时间（等待响应头）
+	MAX_LIVE_REQUESTS  reloadable[int]           // 最多保留的实时请求记录数
 )
 
 // 请求统计信息
@@ -58,7 +111,8 @@ type RequestStats struct {
 	StreamingRequests    int64
 	NonStreamingRequests int64
 	TotalTokensUsed      int64
-	StartTime            time.Time
+	StartTime            time.Time // 进程启动时间
+	StatsSince           time.Time // 累计统计的起始时间（首次启动或上次重置）
 	FastestResponse      time.Duration
 	SlowestResponse      time.Duration
 	ModelUsage           map[string]int64
@@ -74,6 +128,8 @@ type HourlyStats struct {
 	Tokens            int     `json:"tokens"`
 	StreamingCount    int     `json:"streamingCount"`
 	NonStreamingCount int     `json:"nonStreamingCount"`
+	FastestResponse   float64 `json:"fastestResponse"`
+	SlowestResponse   float64 `json:"slowestResponse"`
 }
 
 // 每日统计
@@ -93,7 +149,7 @@ type DailyStats struct {
 
 // 实时请求信息
 type LiveRequest struct {
-	ID        string    `json:"id"`
+	ID        string    `json:"id"` // 请求 ID（X-Request-ID）
 	Timestamp time.Time `json:"timestamp"`
 	Method    string    `json:"method"`
 	Path      string    `json:"path"`
@@ -113,16 +169,12 @@ var (
 	statsDBMutex  sync.RWMutex
 )
 
-// 思考内容处理策略
-const (
-	THINK_TAGS_MODE = "strip" // strip: 去除<details>标签；think: 转为<think>标签；raw: 保留原样
-)
+// 进程启动时间（即 stats.StartTime），启动后不再修改，读取时无需持有 statsMutex
+var processStartTime time.Time
 
 // 系统配置常量
 const (
-	MAX_LIVE_REQUESTS      = 100        // 最多保留的实时请求记录数
 	AUTH_TOKEN_TIMEOUT     = 10         // 获取匿名token的超时时间（秒）
-	UPSTREAM_TIMEOUT       = 60         // 上游API调用超时时间（秒）
 	TOKEN_DISPLAY_LENGTH   = 10         // token显示时的截取长度
 	NANOSECONDS_TO_SECONDS = 1000000000 // 纳秒转秒的倍数
 )
@@ -137,16 +189,32 @@ const (
 	ORIGIN_BASE    = "https://chat.z.ai"
 )
 
-// 匿名token开关
-const ANON_TOKEN_ENABLED = true
-
-// 从环境变量初始化配置
+// 从环境变量和配置文件初始化配置
 func initConfig() {
+	// 读取配置文件并校验，有错误时退出
+	if errs := loadConfigSources(); len(errs) > 0 {
+		for _, err := range errs {
+			log.Printf("❌ %v", err)
+		}
+		log.Fatalf("❌ 配置无效（%d 个错误），请修正后重新启动", len(errs))
+	}
+	applyConfig()
+}
+
+// 加载 .env 文件和配置文件并校验
+func loadConfigSources() []error {
 	// 加载 .env.local 文件（如果存在）
 	loadEnvFile(".env.local")
 	// 也尝试加载标准的 .env 文件
 	loadEnvFile(".env")
 
+	return loadConfig()
+}
+
+// 将已校验的配置写入全局变量
+func applyConfig() {
+	applyRuntimeConfig()
+
 	UPSTREAM_URL = getEnv("UPSTREAM_URL", "https://chat.z.ai/api/chat/completions")
 	DEFAULT_KEY = getEnv("DEFAULT_KEY", "sk-your-key")
 	ZAI_TOKEN = getEnv("ZAI_TOKEN", "")
@@ -158,15 +226,117 @@ func initConfig() {
 		PORT = ":" + PORT
 	}
 
-	DEBUG_MODE = getEnv("DEBUG_MODE", "true") == "true"
-	DEFAULT_STREAM = getEnv("DEFAULT_STREAM", "true") == "true"
-	DASHBOARD_ENABLED = getEnv("DASHBOARD_ENABLED", "true") == "true"
-	ENABLE_THINKING = getEnv("ENABLE_THINKING", "false") == "true"
+	DASHBOARD_ENABLED = getEnvBool("DASHBOARD_ENABLED", true)
+	DASHBOARD_ALERT_ERROR_RATE, _ = strconv.ParseFloat(getEnv("DASHBOARD_ALERT_ERROR_RATE", "0.5"), 64)
+	DASHBOARD_ALERT_SLOW_MS, _ = strconv.Atoi(getEnv("DASHBOARD_ALERT_SLOW_MS", "30000"))
 
 	// Admin 配置
-	ADMIN_ENABLED = getEnv("ADMIN_ENABLED", "true") == "true"
+	ADMIN_ENABLED = getEnvBool("ADMIN_ENABLED", true)
 	ADMIN_USERNAME = getEnv("ADMIN_USERNAME", "admin")
 	ADMIN_PASSWORD = getEnv("ADMIN_PASSWORD", "123456")
+
+	// 会话 API 配置
+	CONVERSATIONS_ENABLED = getEnvBool("CONVERSATIONS_ENABLED", true)
+
+	// 批处理 API 配置
+	BATCH_ENABLED = getEnvBool("BATCH_ENABLED", true)
+	BATCH_CONCURRENCY, _ = strconv.Atoi(getEnv("BATCH_CONCURRENCY", "2"))
+	if BATCH_CONCURRENCY < 1 {
+		BATCH_CONCURRENCY = 1
+	}
+
+	// 后台补全配置
+	BACKGROUND_ENABLED = getEnvBool("BACKGROUND_ENABLED", true)
+	BACKGROUND_CALLBACK_SECRET = getEnv("BACKGROUND_CALLBACK_SECRET", "")
+
+	// Prometheus 指标配置
+	METRICS_ENABLED = getEnvBool("METRICS_ENABLED", true)
+	METRICS_TOKEN = getEnv("METRICS_TOKEN", "")
+
+	// 链路追踪配置（OTLP 地址等见 initTracing）
+	TRACING_ENABLED = getEnvBool("TRACING_ENABLED", false)
+
+	// 幂等键保留时间
+	ttl, err := time.ParseDuration(getEnv("IDEMPOTENCY_TTL", "24h"))
+	if err != nil || ttl <= 0 {
+		log.Printf("⚠️ IDEMPOTENCY_TTL 无效，使用默认值 24h")
+		ttl = 24 * time.Hour
+	}
+	IDEMPOTENCY_TTL = ttl
+
+	// 响应缓存配置
+	CACHE_ENABLED = getEnvBool("CACHE_ENABLED", false)
+	CACHE_BACKEND = getEnv("CACHE_BACKEND", "memory")
+	CACHE_TTL, err = time.ParseDuration(getEnv("CACHE_TTL", "1h"))
+	if err != nil || CACHE_TTL <= 0 {
+		log.Printf("⚠️ CACHE_TTL 无效，使用默认值 1h")
+		CACHE_TTL = time.Hour
+	}
+	CACHE_MAX_ENTRIES, _ = strconv.Atoi(getEnv("CACHE_MAX_ENTRIES", "1000"))
+	CACHE_MAX_BYTES, _ = strconv.Atoi(getEnv("CACHE_MAX_BYTES", "67108864"))
+	CACHE_DEFAULT_POLICY = getEnv("CACHE_DEFAULT_POLICY", CACHE_POLICY_DETERMINISTIC)
+	if CACHE_DEFAULT_POLICY == "" || !isValidCachePolicy(CACHE_DEFAULT_POLICY) {
+		log.Printf("⚠️ CACHE_DEFAULT_POLICY 无效，使用默认值 %s", CACHE_POLICY_DETERMINISTIC)
+		CACHE_DEFAULT_POLICY = CACHE_POLICY_DETERMINISTIC
+	}
+
+	// 统计数据保留天数（0 表示永久保留）
+	STATS_HOURLY_RETENTION_DAYS, err = strconv.Atoi(getEnv("STATS_HOURLY_RETENTION_DAYS", "7"))
+	if err != nil || STATS_HOURLY_RETENTION_DAYS < 0 {
+		log.Printf("⚠️ STATS_HOURLY_RETENTION_DAYS 无效，使用默认值 7")
+		STATS_HOURLY_RETENTION_DAYS = 7
+	}
+	STATS_DAILY_RETENTION_DAYS, err = strconv.Atoi(getEnv("STATS_DAILY_RETENTION_DAYS", "90"))
+	if err != nil || STATS_DAILY_RETENTION_DAYS < 0 {
+		log.Printf("⚠️ STATS_DAILY_RETENTION_DAYS 无效，使用默认值 90")
+		STATS_DAILY_RETENTION_DAYS = 90
+	}
+
+	// 统计报表时区（小时数据始终按 UTC 存储）
+	STATS_TIMEZONE = getEnv("STATS_TIMEZONE", "UTC")
+	statsLocation, err = parseTimezone(STATS_TIMEZONE)
+	if err != nil {
+		log.Printf("⚠️ STATS_TIMEZONE 无效，使用默认值 UTC")
+		STATS_TIMEZONE = "UTC"
+		statsLocation = time.UTC
+	}
+
+	// 请求日志配置
+	JOURNAL_ENABLED = getEnvBool("JOURNAL_ENABLED", false)
+	JOURNAL_SAMPLE_RATE, err = strconv.ParseFloat(getEnv("JOURNAL_SAMPLE_RATE", "1"), 64)
+	if err != nil || JOURNAL_SAMPLE_RATE < 0 || JOURNAL_SAMPLE_RATE > 1 {
+		log.Printf("⚠️ JOURNAL_SAMPLE_RATE 无效，使用默认值 1")
+		JOURNAL_SAMPLE_RATE = 1
+	}
+	JOURNAL_REDACT = strings.ToLower(getEnv("JOURNAL_REDACT", JOURNAL_REDACT_SECRETS))
+	if JOURNAL_REDACT != JOURNAL_REDACT_SECRETS && JOURNAL_REDACT != JOURNAL_REDACT_CONTENT && JOURNAL_REDACT != JOURNAL_REDACT_NONE {
+		log.Printf("⚠️ JOURNAL_REDACT 无效，使用默认值 %s", JOURNAL_REDACT_SECRETS)
+		JOURNAL_REDACT = JOURNAL_REDACT_SECRETS
+	}
+	JOURNAL_MAX_FIELD_BYTES, err = strconv.Atoi(getEnv("JOURNAL_MAX_FIELD_BYTES", "65536"))
+	if err != nil || JOURNAL_MAX_FIELD_BYTES <= 0 {
+		log.Printf("⚠️ JOURNAL_MAX_FIELD_BYTES 无效，使用默认值 65536")
+		JOURNAL_MAX_FIELD_BYTES = 65536
+	}
+	JOURNAL_RETENTION_DAYS, err = strconv.Atoi(getEnv("JOURNAL_RETENTION_DAYS", "7"))
+	if err != nil || JOURNAL_RETENTION_DAYS < 0 {
+		log.Printf("⚠️ JOURNAL_RETENTION_DAYS 无效，使用默认值 7")
+		JOURNAL_RETENTION_DAYS = 7
+	}
+
+	// 就绪检查：上游连续失败多少次后视为不可用（0 表示不检查上游）
+	READYZ_UPSTREAM_FAILURES, err = strconv.Atoi(getEnv("READYZ_UPSTREAM_FAILURES", "5"))
+	if err != nil || READYZ_UPSTREAM_FAILURES < 0 {
+		log.Printf("⚠️ READYZ_UPSTREAM_FAILURES 无效，使用默认值 5")
+		READYZ_UPSTREAM_FAILURES = 5
+	}
+
+	// 优雅关闭：等待进行中的请求完成的最长时间
+	SHUTDOWN_GRACE_PERIOD, err = time.ParseDuration(getEnv("SHUTDOWN_GRACE_PERIOD", "30s"))
+	if err != nil || SHUTDOWN_GRACE_PERIOD < 0 {
+		log.Printf("⚠️ SHUTDOWN_GRACE_PERIOD 无效，使用默认值 30s")
+		SHUTDOWN_GRACE_PERIOD = 30 * time.Second
+	}
 }
 
 // 初始化统计数据库
@@ -199,9 +369,38 @@ func initStatsDB() error {
 		tokens INTEGER DEFAULT 0,
 		streaming_count INTEGER DEFAULT 0,
 		non_streaming_count INTEGER DEFAULT 0,
+		response_time_sum REAL DEFAULT 0,
+		response_time_count INTEGER DEFAULT 0,
+		min_response_time REAL DEFAULT 0,
+		max_response_time REAL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_hourly_hour ON hourly_stats(hour DESC);
+
+	CREATE TABLE IF NOT EXISTS hourly_stats_dims (
+		hour TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		status_class TEXT NOT NULL DEFAULT '',
+		api_key_id TEXT NOT NULL DEFAULT '',
+		stream INTEGER NOT NULL DEFAULT 0,
+		requests INTEGER DEFAULT 0,
+		success INTEGER DEFAULT 0,
+		failed INTEGER DEFAULT 0,
+		tokens INTEGER DEFAULT 0,
+		response_time_sum REAL DEFAULT 0,
+		response_time_count INTEGER DEFAULT 0,
+		min_response_time REAL DEFAULT 0,
+		max_response_time REAL DEFAULT 0,
+		PRIMARY KEY (hour, model, status_class, api_key_id, stream)
+	);
+
+	CREATE TABLE IF NOT EXISTS hourly_latency (
+		hour TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		bucket INTEGER NOT NULL,
+		count INTEGER DEFAULT 0,
+		PRIMARY KEY (hour, metric, bucket)
+	);
 	`
 
 	// 创建每日统计表
@@ -221,6 +420,23 @@ func initStatsDB() error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 	CREATE INDEX IF NOT EXISTS idx_daily_date ON daily_stats(date DESC);
+
+	CREATE TABLE IF NOT EXISTS daily_stats_dims (
+		date TEXT NOT NULL,
+		model TEXT NOT NULL DEFAULT '',
+		status_class TEXT NOT NULL DEFAULT '',
+		api_key_id TEXT NOT NULL DEFAULT '',
+		stream INTEGER NOT NULL DEFAULT 0,
+		requests INTEGER DEFAULT 0,
+		success INTEGER DEFAULT 0,
+		failed INTEGER DEFAULT 0,
+		tokens INTEGER DEFAULT 0,
+		response_time_sum REAL DEFAULT 0,
+		response_time_count INTEGER DEFAULT 0,
+		min_response_time REAL DEFAULT 0,
+		max_response_time REAL DEFAULT 0,
+		PRIMARY KEY (date, model, status_class, api_key_id, stream)
+	);
 	`
 
 	_, err = statsDB.Exec(createHourlyTableSQL)
@@ -233,6 +449,14 @@ func initStatsDB() error {
 		return fmt.Errorf("创建每日统计表失败: %v", err)
 	}
 
+	if err := migrateHourlyStats(); err != nil {
+		return fmt.Errorf("迁移小时统计表失败: %v", err)
+	}
+
+	if _, err := statsDB.Exec(createStatsPersistTablesSQL); err != nil {
+		return fmt.Errorf("创建累计统计表失败: %v", err)
+	}
+
 	return nil
 }
 
@@ -262,168 +486,89 @@ var (
 
 // 获取当前小时key (格式: YYYY-MM-DD-HH)
 func getHourKey() string {
-	now := time.Now().UTC()
-	return fmt.Sprintf("%d-%02d-%02d-%02d", now.Year(), now.Month(), now.Day(), now.Hour())
+	return hourKeyFor(time.Now())
+}
+
+// 获取指定时间的小时key
+func hourKeyFor(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%d-%02d-%02d-%02d", t.Year(), t.Month(), t.Day(), t.Hour())
 }
 
-// 获取当前日期key (格式: YYYY-MM-DD)
+// 获取报表时区下的当前日期key (格式: YYYY-MM-DD)
 func getDateKey() string {
-	now := time.Now().UTC()
-	return fmt.Sprintf("%d-%02d-%02d", now.Year(), now.Month(), now.Day())
+	return dateKeyIn(time.Now(), statsLocation)
+}
+
+// 保存每日统计：按报表时区从小时数据重新汇总每一天（覆盖写入，重复执行结果不变）
+func saveDailyStats() {
+	saveDailyStatsBetween("", "")
 }
 
-// 保存小时统计到数据库
-func saveHourlyStats(duration time.Duration, status int, tokens int, model string, isStreaming bool) {
+// 从小时数据重新汇总本地日期 fromDate..toDate（含，YYYY-MM-DD）的每日统计，为空表示不限
+func saveDailyStatsBetween(fromDate, toDate string) {
 	if statsDB == nil {
 		return
 	}
 
-	hourKey := getHourKey()
-	durationMs := float64(duration.Milliseconds())
-
-	statsDBMutex.Lock()
-	defer statsDBMutex.Unlock()
-
-	// 查询现有数据
-	var existing HourlyStats
-	err := statsDB.QueryRow(`
-		SELECT requests, success, failed, avg_response_time, tokens, streaming_count, non_streaming_count
-		FROM hourly_stats WHERE hour = ?
-	`, hourKey).Scan(&existing.Requests, &existing.Success, &existing.Failed,
-		&existing.AvgResponseTime, &existing.Tokens, &existing.StreamingCount, &existing.NonStreamingCount)
-
-	if err == sql.ErrNoRows {
-		// 插入新记录
-		_, err = statsDB.Exec(`
-			INSERT INTO hourly_stats (hour, requests, success, failed, avg_response_time, tokens, streaming_count, non_streaming_count)
-			VALUES (?, 1, ?, ?, ?, ?, ?, ?)
-		`, hourKey,
-			func() int {
-				if status >= 200 && status < 300 {
-					return 1
-				}
-				return 0
-			}(),
-			func() int {
-				if status >= 200 && status < 300 {
-					return 0
-				}
-				return 1
-			}(),
-			durationMs, tokens,
-			func() int {
-				if isStreaming {
-					return 1
-				}
-				return 0
-			}(),
-			func() int {
-				if !isStreaming {
-					return 1
-				}
-				return 0
-			}())
-	} else if err == nil {
-		// 更新现有记录
-		newRequests := existing.Requests + 1
-		newAvgTime := (existing.AvgResponseTime*float64(existing.Requests) + durationMs) / float64(newRequests)
-		newSuccess := existing.Success
-		newFailed := existing.Failed
-		if status >= 200 && status < 300 {
-			newSuccess++
-		} else {
-			newFailed++
+	// 只映射覆盖这些日期的 UTC 小时（前后各多取一小时，非整点时区的边界小时由日期条件过滤）
+	var fromKey, toKey string
+	where := "1 = 1"
+	var args []interface{}
+	if fromDate != "" {
+		if t, err := time.ParseInLocation("2006-01-02", fromDate, statsLocation); err == nil {
+			fromKey = hourKeyFor(t.Add(-time.Hour))
 		}
-		newStreamingCount := existing.StreamingCount
-		newNonStreamingCount := existing.NonStreamingCount
-		if isStreaming {
-			newStreamingCount++
-		} else {
-			newNonStreamingCount++
-		}
-
-		_, err = statsDB.Exec(`
-			UPDATE hourly_stats 
-			SET requests = ?, success = ?, failed = ?, avg_response_time = ?, tokens = ?, 
-			    streaming_count = ?, non_streaming_count = ?
-			WHERE hour = ?
-		`, newRequests, newSuccess, newFailed, newAvgTime, existing.Tokens+tokens,
-			newStreamingCount, newNonStreamingCount, hourKey)
+		where += " AND m.local_date >= ?"
+		args = append(args, fromDate)
 	}
-
-	if err != nil {
-		debugLog("保存小时统计失败: %v", err)
-	}
-}
-
-// 保存每日统计
-func saveDailyStats() {
-	if statsDB == nil {
-		return
+	if toDate != "" {
+		if t, err := time.ParseInLocation("2006-01-02", toDate, statsLocation); err == nil {
+			toKey = hourKeyFor(t.AddDate(0, 0, 1).Add(time.Hour))
+		}
+		where += " AND m.local_date <= ?"
+		args = append(args, toDate)
 	}
 
-	dateKey := getDateKey()
-
 	statsDBMutex.Lock()
 	defer statsDBMutex.Unlock()
 
-	// 聚合当天所有小时的数据
-	rows, err := statsDB.Query(`
-		SELECT SUM(requests), SUM(success), SUM(failed), AVG(avg_response_time), 
-		       SUM(tokens), SUM(streaming_count), SUM(non_streaming_count),
-		       MIN(avg_response_time), MAX(avg_response_time)
-		FROM hourly_stats WHERE hour LIKE ?
-	`, dateKey+"%")
-
-	if err != nil {
-		debugLog("查询每日统计失败: %v", err)
-		return
-	}
-	defer rows.Close()
-
-	if rows.Next() {
-		var totalRequests, totalSuccess, totalFailed, totalStreaming, totalNonStreaming int
-		var avgTime, fastestResponse, slowestResponse float64
-		var totalTokens int
-
-		err = rows.Scan(&totalRequests, &totalSuccess, &totalFailed, &avgTime, &totalTokens,
-			&totalStreaming, &totalNonStreaming, &fastestResponse, &slowestResponse)
+	err := withHourMap(statsLocation, fromKey, toKey, func(conn *sql.Conn) error {
+		ctx := context.Background()
+		_, err := conn.ExecContext(ctx, `
+			INSERT OR REPLACE INTO daily_stats
+			(date, requests, success, failed, avg_response_time, tokens, peak_hour,
+			 streaming_count, non_streaming_count, fastest_response, slowest_response)
+		`+dailyStatsSelectSQL+` WHERE `+where+` GROUP BY day`, args...)
 		if err != nil {
-			debugLog("扫描每日统计失败: %v", err)
-			return
+			logStats.Warn("保存每日统计失败", "error", err)
 		}
 
-		// 找出峰值小时
-		var peakHour string
-		var maxRequests int
-		rows2, err := statsDB.Query(`
-			SELECT hour, requests FROM hourly_stats 
-			WHERE hour LIKE ? ORDER BY requests DESC LIMIT 1
-		`, dateKey+"%")
-		if err == nil {
-			defer rows2.Close()
-			if rows2.Next() {
-				rows2.Scan(&peakHour, &maxRequests)
-			}
-		}
-
-		// 插入或更新每日统计
-		_, err = statsDB.Exec(`
-			INSERT OR REPLACE INTO daily_stats 
-			(date, requests, success, failed, avg_response_time, tokens, peak_hour, 
-			 streaming_count, non_streaming_count, fastest_response, slowest_response)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`, dateKey, totalRequests, totalSuccess, totalFailed, avgTime, totalTokens, peakHour,
-			totalStreaming, totalNonStreaming, fastestResponse, slowestResponse)
-
+		_, err = conn.ExecContext(ctx, `
+			INSERT OR REPLACE INTO daily_stats_dims
+			(date, model, status_class, api_key_id, stream, requests, success, failed, tokens,
+			 response_time_sum, response_time_count, min_response_time, max_response_time)
+			SELECT m.local_date AS day, model, status_class, api_key_id, stream,
+			       SUM(requests), SUM(success), SUM(failed), SUM(tokens),
+			       SUM(response_time_sum), SUM(response_time_count),
+			       COALESCE(MIN(CASE WHEN response_time_count > 0 THEN min_response_time END), 0),
+			       COALESCE(MAX(CASE WHEN response_time_count > 0 THEN max_response_time END), 0)
+			FROM hourly_stats_dims h JOIN stats_hour_map m ON m.utc_hour = h.hour
+			WHERE `+where+`
+			GROUP BY day, model, status_class, api_key_id, stream
+		`, args...)
 		if err != nil {
-			debugLog("保存每日统计失败: %v", err)
+			logStats.Warn("保存每日维度统计失败", "error", err)
 		}
+		return nil
+	})
+	if err != nil {
+		logStats.Warn("建立时区映射失败", "error", err)
 	}
 }
 
 // 获取小时统计
-func getHourlyStats(hours int) ([]HourlyStats, error) {
+func getHourlyStats(hours int, loc *time.Location) ([]HourlyStats, error) {
 	if statsDB == nil {
 		return []HourlyStats{}, nil
 	}
@@ -432,8 +577,8 @@ func getHourlyStats(hours int) ([]HourlyStats, error) {
 	defer statsDBMutex.RUnlock()
 
 	rows, err := statsDB.Query(`
-		SELECT hour, requests, success, failed, avg_response_time, tokens, 
-		       streaming_count, non_streaming_count
+		SELECT hour, requests, success, failed, avg_response_time, tokens,
+		       streaming_count, non_streaming_count, min_response_time, max_response_time
 		FROM hourly_stats ORDER BY hour DESC LIMIT ?
 	`, hours)
 	if err != nil {
@@ -445,10 +590,12 @@ func getHourlyStats(hours int) ([]HourlyStats, error) {
 	for rows.Next() {
 		var stat HourlyStats
 		err := rows.Scan(&stat.Hour, &stat.Requests, &stat.Success, &stat.Failed,
-			&stat.AvgResponseTime, &stat.Tokens, &stat.StreamingCount, &stat.NonStreamingCount)
+			&stat.AvgResponseTime, &stat.Tokens, &stat.StreamingCount, &stat.NonStreamingCount,
+			&stat.FastestResponse, &stat.SlowestResponse)
 		if err != nil {
 			continue
 		}
+		stat.Hour = localHourKey(stat.Hour, loc)
 		result = append(result, stat)
 	}
 
@@ -508,35 +655,54 @@ func cleanupOldData() {
 	statsDBMutex.Lock()
 	defer statsDBMutex.Unlock()
 
-	// 删除7天前的小时数据
-	sevenDaysAgo := time.Now().AddDate(0, 0, -7).Format("2006-01-02")
-	_, err := statsDB.Exec(`DELETE FROM hourly_stats WHERE hour < ?`, sevenDaysAgo)
-	if err != nil {
-		debugLog("清理小时数据失败: %v", err)
+	// 删除超过保留天数的小时数据（默认 7 天），按报表时区的零点截断，保证最早一天完整
+	if STATS_HOURLY_RETENTION_DAYS > 0 {
+		hourlyCutoff := hourKeyFor(startOfDayIn(time.Now(), statsLocation).AddDate(0, 0, -STATS_HOURLY_RETENTION_DAYS))
+		_, err := statsDB.Exec(`DELETE FROM hourly_stats WHERE hour < ?`, hourlyCutoff)
+		if err != nil {
+			logStats.Warn("清理小时数据失败", "error", err)
+		}
+		_, err = statsDB.Exec(`DELETE FROM hourly_stats_dims WHERE hour < ?`, hourlyCutoff)
+		if err != nil {
+			logStats.Warn("清理小时维度数据失败", "error", err)
+		}
+		_, err = statsDB.Exec(`DELETE FROM hourly_latency WHERE hour < ?`, hourlyCutoff)
+		if err != nil {
+			logStats.Warn("清理延迟统计失败", "error", err)
+		}
 	}
 
-	// 删除90天前的每日数据
-	ninetyDaysAgo := time.Now().AddDate(0, 0, -90).Format("2006-01-02")
-	_, err = statsDB.Exec(`DELETE FROM daily_stats WHERE date < ?`, ninetyDaysAgo)
-	if err != nil {
-		debugLog("清理每日数据失败: %v", err)
+	// 删除超过保留天数的每日数据（默认 90 天）
+	if STATS_DAILY_RETENTION_DAYS > 0 {
+		dailyCutoff := dateKeyIn(time.Now().AddDate(0, 0, -STATS_DAILY_RETENTION_DAYS), statsLocation)
+		_, err := statsDB.Exec(`DELETE FROM daily_stats WHERE date < ?`, dailyCutoff)
+		if err != nil {
+			logStats.Warn("清理每日数据失败", "error", err)
+		}
+		_, err = statsDB.Exec(`DELETE FROM daily_stats_dims WHERE date < ?`, dailyCutoff)
+		if err != nil {
+			logStats.Warn("清理每日维度数据失败", "error", err)
+		}
 	}
 }
 
 // 记录请求统计信息
-func recordRequestStats(startTime time.Time, path string, status int) {
-	recordRequestStatsDetailed(startTime, path, status, "", false, 0)
+func recordRequestStats(ctx context.Context, startTime time.Time, path string, status int) {
+	recordRequestStatsDetailed(ctx, startTime, path, status, "", false, 0)
 }
 
 // 记录详细的请求统计信息
-func recordRequestStatsDetailed(startTime time.Time, path string, status int, model string, isStreaming bool, tokens int) {
+func recordRequestStatsDetailed(ctx context.Context, startTime time.Time, path string, status int, model string, isStreaming bool, tokens int) {
 	duration := time.Since(startTime)
+	observeRequestMetrics(path, status, model, isStreaming, duration)
+	finishJournalEntry(ctx, status, duration)
 
 	statsMutex.Lock()
 	defer statsMutex.Unlock()
 
 	stats.TotalRequests++
 	stats.LastRequestTime = time.Now()
+	statsDirty = true
 
 	if status >= 200 && status < 300 {
 		stats.SuccessfulRequests++
@@ -585,22 +751,34 @@ func recordRequestStatsDetailed(startTime time.Time, path string, status int, mo
 	// 统计tokens
 	stats.TotalTokensUsed += int64(tokens)
 
-	// 异步保存到数据库
-	go saveHourlyStats(duration, status, tokens, model, isStreaming)
+	// 写入小时统计缓冲，由后台任务批量保存（模型、API Key 等维度从 context 读取）
+	// 维度统计优先使用客户端请求的模型名，失败请求也能归到对应模型
+	labels := statsLabelsFromContext(ctx)
+	if labels.model == "" {
+		labels.model = model
+	}
+	recordHourlyStats(time.Now(), duration, status, tokens, isStreaming || labels.stream, labels.model, labels.apiKeyID)
+
+	// 推送到 Dashboard 实时流（持有 statsMutex，保证与快照一致）
+	publishStatsDelta(path, status, duration, model, isStreaming, tokens)
+	checkDashboardAlerts(path, status, duration, labels.model)
 }
 
 // 添加实时请求信息
-func addLiveRequest(method, path string, status int, duration time.Duration, clientIP, userAgent string) {
-	addLiveRequestWithModel(method, path, status, duration, clientIP, userAgent, "")
+func addLiveRequest(requestID, method, path string, status int, duration time.Duration, clientIP, userAgent string) {
+	addLiveRequestWithModel(requestID, method, path, status, duration, clientIP, userAgent, "")
 }
 
 // 添加实时请求信息(带模型)
-func addLiveRequestWithModel(method, path string, status int, duration time.Duration, clientIP, userAgent, model string) {
+func addLiveRequestWithModel(requestID, method, path string, status int, duration time.Duration, clientIP, userAgent, model string) {
 	requestsMutex.Lock()
 	defer requestsMutex.Unlock()
 
+	if requestID == "" {
+		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
 	request := LiveRequest{
-		ID:        fmt.Sprintf("%d", time.Now().UnixNano()),
+		ID:        requestID,
 		Timestamp: time.Now(),
 		Method:    method,
 		Path:      path,
@@ -611,10 +789,11 @@ func addLiveRequestWithModel(method, path string, status int, duration time.Dura
 	}
 
 	liveRequests = append(liveRequests, request)
+	publishDashboardEvent(DASHBOARD_EVENT_REQUEST, request)
 
 	// 只保留最近的请求记录
-	if len(liveRequests) > MAX_LIVE_REQUESTS {
-		liveRequests = liveRequests[1:]
+	if max := MAX_LIVE_REQUESTS.Load(); len(liveRequests) > max {
+		liveRequests = liveRequests[len(liveRequests)-max:]
 	}
 }
 
@@ -641,7 +820,11 @@ func getLiveRequestsData() []byte {
 func getStatsData() []byte {
 	statsMutex.Lock()
 	defer statsMutex.Unlock()
+	return statsDataLocked()
+}
 
+// 构建统计数据，调用方需持有 statsMutex
+func statsDataLocked() []byte {
 	// 获取前3个最常用的模型
 	type ModelCount struct {
 		Model string `json:"model"`
@@ -683,6 +866,7 @@ func getStatsData() []byte {
 		"nonStreamingRequests": stats.NonStreamingRequests,
 		"totalTokensUsed":      stats.TotalTokensUsed,
 		"startTime":            stats.StartTime,
+		"statsSince":           stats.StatsSince,
 		"fastestResponse": func() int64 {
 			if stats.FastestResponse == 0 {
 				return -1
@@ -705,6 +889,14 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// 获取布尔型环境变量（true / false / 1 / 0）
+func getEnvBool(key string, defaultValue bool) bool {
+	if b, err := strconv.ParseBool(getEnv(key, "")); err == nil {
+		return b
+	}
+	return defaultValue
+}
+
 // 加载 .env 文件
 func loadEnvFile(filename string) {
 	file, err := os.Open(filename)
@@ -722,11 +914,12 @@ func loadEnvFile(filename string) {
 			continue
 		}
 
-		// 解析 KEY=VALUE 格式
+		// 解析 KEY=VALUE 格式（允许 export 前缀）
+		line = strings.TrimPrefix(line, "export ")
 		parts := strings.SplitN(line, "=", 2)
 		if len(parts) == 2 {
 			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
+			value := parseEnvValue(strings.TrimSpace(parts[1]))
 			// 只有当环境变量未设置时才从文件加载
 			if os.Getenv(key) == "" {
 				os.Setenv(key, value)
@@ -735,6 +928,41 @@ func loadEnvFile(filename string) {
 	}
 }
 
+// 解析 .env 中的值：双引号内支持 \n \" \\ 转义，单引号内原样保留，未加引号时去掉 " #" 之后的注释
+func parseEnvValue(value string) string {
+	if len(value) >= 2 && value[0] == '\'' {
+		if end := strings.IndexByte(value[1:], '\''); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	if len(value) >= 2 && value[0] == '"' {
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			if c == '"' {
+				return b.String()
+			}
+			if c == '\\' && i+1 < len(value) {
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value
+}
+
 // 获取客户端IP地址
 func getClientIP(r *http.Request) string {
 	// 检查X-Forwarded-For头
@@ -767,6 +995,9 @@ type OpenAIRequest struct {
 	Temperature    float64   `json:"temperature,omitempty"`
 	MaxTokens      int       `json:"max_tokens,omitempty"`
 	EnableThinking *bool     `json:"enable_thinking,omitempty"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	Background     bool      `json:"background,omitempty"`
+	CallbackURL    string    `json:"callback_url,omitempty"`
 }
 
 type Message struct {
@@ -843,6 +1074,36 @@ type UpstreamError struct {
 	Code   int    `json:"code"`
 }
 
+// upstreamError 返回上游错误（data.error 或 data.data.error 或 顶层error），没有错误时返回nil
+func (u *UpstreamData) upstreamError() *UpstreamError {
+	if u.Error != nil {
+		return u.Error
+	}
+	if u.Data.Error != nil {
+		return u.Data.Error
+	}
+	if u.Data.Inner != nil {
+		return u.Data.Inner.Error
+	}
+	return nil
+}
+
+// outputContent 返回需要输出给客户端的内容（thinking阶段内容经过转换）
+func (u *UpstreamData) outputContent() string {
+	if u.Data.DeltaContent == "" {
+		return ""
+	}
+	if u.Data.Phase == "thinking" {
+		return transformThinkingContent(u.Data.DeltaContent)
+	}
+	return u.Data.DeltaContent
+}
+
+// isDone 上游是否发送了结束信号
+func (u *UpstreamData) isDone() bool {
+	return u.Data.Done || u.Data.Phase == "done"
+}
+
 // 模型列表响应
 type ModelsResponse struct {
 	Object string  `json:"object"`
@@ -856,13 +1117,6 @@ type Model struct {
 	OwnedBy string `json:"owned_by"`
 }
 
-// debug日志函数
-func debugLog(format string, args ...interface{}) {
-	if DEBUG_MODE {
-		log.Printf("[DEBUG] "+format, args...)
-	}
-}
-
 // 转换思考内容的通用函数
 func transformThinkingContent(s string) string {
 	// 去除 <summary>…</summary>
@@ -873,7 +1127,7 @@ func transformThinkingContent(s string) string {
 	s = strings.ReplaceAll(s, "</Full>", "")
 	s = strings.TrimSpace(s)
 
-	switch THINK_TAGS_MODE {
+	switch THINK_TAGS_MODE.Load() {
 	case "think":
 		s = regexp.MustCompile(`<details[^>]*>`).ReplaceAllString(s, "<think>")
 		s = strings.ReplaceAll(s, "</details>", "</think>")
@@ -894,61 +1148,61 @@ func getUpstreamModelID(modelName string) string {
 	case "GLM-4.6":
 		return "GLM-4-6-API-V1" // 使用官方API的真实模型名称
 	default:
-		debugLog("未知模型名称: %s，使用GLM-4.6作为默认", modelName)
+		logUpstream.Debug("未知模型名称，使用GLM-4.6作为默认", "model", modelName)
 		return "GLM-4-6-API-V1" // 默认使用GLM-4.6
 	}
 }
 
 // 获取认证 token（统一入口）
 // 优先级：环境变量 ZAI_TOKEN > 数据库随机 token > 匿名 token
-func getAuthToken() (string, error) {
+func getAuthToken(ctx context.Context) (string, error) {
+	ctx, span := tracing.Start(ctx, "token.select", tracing.KindInternal)
+	defer span.End()
+
 	// 1. 优先使用环境变量配置的 ZAI_TOKEN
 	if ZAI_TOKEN != "" {
-		debugLog("使用环境变量 ZAI_TOKEN: %s...", func() string {
-			if len(ZAI_TOKEN) > TOKEN_DISPLAY_LENGTH {
-				return ZAI_TOKEN[:TOKEN_DISPLAY_LENGTH]
-			}
-			return ZAI_TOKEN
-		}())
+		span.SetAttributes(tracing.String("token.source", "env"))
+		logToken.DebugContext(ctx, "使用环境变量 ZAI_TOKEN", "token_prefix", tokenPrefix(ZAI_TOKEN))
 		return ZAI_TOKEN, nil
 	}
 
 	// 2. 尝试从数据库随机获取 token
 	if REGISTER_ENABLED {
 		if token, err := register.GetRandomToken(); err == nil && token != "" {
-			debugLog("使用数据库随机 token: %s...", func() string {
-				if len(token) > TOKEN_DISPLAY_LENGTH {
-					return token[:TOKEN_DISPLAY_LENGTH]
-				}
-				return token
-			}())
+			span.SetAttributes(tracing.String("token.source", "pool"))
+			logToken.DebugContext(ctx, "使用数据库随机 token", "token_prefix", tokenPrefix(token))
 			return token, nil
 		} else if err != nil {
-			debugLog("从数据库获取 token 失败: %v", err)
+			logToken.WarnContext(ctx, "从数据库获取 token 失败", "error", err)
 		}
 	}
 
 	// 3. fallback 到匿名 token
-	if ANON_TOKEN_ENABLED {
-		token, err := getAnonymousToken()
+	if ANON_TOKEN_ENABLED.Load() {
+		span.SetAttributes(tracing.String("token.source", "anonymous"))
+		token, err := getAnonymousToken(ctx)
 		if err == nil {
-			debugLog("使用匿名 token: %s...", func() string {
-				if len(token) > TOKEN_DISPLAY_LENGTH {
-					return token[:TOKEN_DISPLAY_LENGTH]
-				}
-				return token
-			}())
+			logToken.DebugContext(ctx, "使用匿名 token", "token_prefix", tokenPrefix(token))
 			return token, nil
 		}
-		debugLog("获取匿名 token 失败: %v", err)
+		logToken.WarnContext(ctx, "获取匿名 token 失败", "error", err)
+		span.SetError(err)
 		return "", err
 	}
 
-	return "", fmt.Errorf("无可用的认证 token")
+	err := fmt.Errorf("无可用的认证 token")
+	span.SetError(err)
+	return "", err
 }
 
 // 获取匿名token（每次对话使用不同token，避免共享记忆）
-func getAnonymousToken() (string, error) {
+func getAnonymousToken(ctx context.Context) (token string, err error) {
+	_, span := tracing.Start(ctx, "token.anonymous", tracing.KindClient)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	client := &http.Client{Timeout: AUTH_TOKEN_TIMEOUT * time.Second}
 	req, err := http.NewRequest("GET", ORIGIN_BASE+"/api/v1/auths/", nil)
 	if err != nil {
@@ -1076,11 +1330,28 @@ func extractLastUserMessage(messages []Message) string {
 }
 
 func main() {
+	// 带子命令时执行运维命令（见 cli.go），否则启动服务
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+	serve()
+}
+
+// 启动 API 服务
+func serve() {
 	// 初始化配置
 	initConfig()
+	// 运行时设置覆盖环境变量和配置文件中的同名配置
+	if err := initSettingsDB(); err != nil {
+		log.Printf("❌ 运行时设置初始化失败: %v", err)
+	}
+	initLogging()
+	initTracing()
 
 	// 初始化统计数据
-	stats.StartTime = time.Now()
+	processStartTime = time.Now()
+	stats.StartTime = processStartTime
+	stats.StatsSince = stats.StartTime
 	stats.ModelUsage = make(map[string]int64)
 	stats.FastestResponse = time.Duration(0)
 	stats.SlowestResponse = time.Duration(0)
@@ -1090,21 +1361,22 @@ func main() {
 		log.Printf("❌ 统计数据库初始化失败: %v", err)
 	} else {
 		log.Printf("✅ 统计数据库初始化成功")
+		if err := loadCumulativeStats(); err != nil {
+			log.Printf("⚠️ 恢复累计统计失败: %v", err)
+		}
+		startStatsFlusher()
 
-		// 启动每小时的定时任务（保存每日统计和清理旧数据）
-		go func() {
-			ticker := time.NewTicker(1 * time.Hour)
-			defer ticker.Stop()
-			for range ticker.C {
-				saveDailyStats()
-				cleanupOldData()
-			}
-		}()
+		// 启动每小时的定时任务（汇总每日统计和清理旧数据）
+		startPeriodicTask(time.Hour, func() {
+			flushStats()
+			saveDailyStats()
+			cleanupOldData()
+		})
 	}
 
 	// 注册路由
-	http.HandleFunc("/v1/models", handleModels)
-	http.HandleFunc("/v1/chat/completions", handleChatCompletions)
+	http.HandleFunc("/v1/models", withRequestID(handleModels))
+	http.HandleFunc("/v1/chat/completions", withRequestID(traceHandler("POST /v1/chat/completions", handleChatCompletions)))
 	http.HandleFunc("/docs", handleAPIDocs)
 	http.HandleFunc("/playground", handlePlayground)
 	http.HandleFunc("/deploy", handleDeploy)
@@ -1115,6 +1387,18 @@ func main() {
 	http.HandleFunc("/admin/api/accounts", handleAdminAPIAccounts)
 	http.HandleFunc("/admin/api/export", handleAdminAPIExport)
 	http.HandleFunc("/admin/api/import-batch", handleAdminAPIImportBatch)
+	http.HandleFunc("/admin/api/keys", handleAdminAPIKeys)
+	http.HandleFunc("/admin/api/keys/", handleAdminAPIKeys)
+	http.HandleFunc("/admin/api/stats/", handleAdminAPIStats)
+	http.HandleFunc("/admin/api/requests", handleAdminAPIRequests)
+	http.HandleFunc("/admin/api/requests/", handleAdminAPIRequests)
+	http.HandleFunc("/admin/api/journal", handleAdminAPIJournal)
+	http.HandleFunc("/admin/api/journal/", handleAdminAPIJournal)
+	http.HandleFunc("/admin/api/diagnostics", handleAdminAPIDiagnostics)
+	http.HandleFunc("/admin/api/settings", handleAdminAPISettings)
+	http.HandleFunc("/admin/api/settings/", handleAdminAPISettings)
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
 	http.HandleFunc("/", handleHome)
 
 	// Dashboard路由
@@ -1124,12 +1408,16 @@ func main() {
 		http.HandleFunc("/dashboard/requests", handleDashboardRequests)
 		http.HandleFunc("/dashboard/hourly", handleDashboardHourly)
 		http.HandleFunc("/dashboard/daily", handleDashboardDaily)
+		http.HandleFunc("/dashboard/latency", handleDashboardLatency)
+		http.HandleFunc("/dashboard/export", handleDashboardExport)
+		http.HandleFunc("/dashboard/stream", handleDashboardStream)
+		http.HandleFunc("/dashboard/inflight", handleDashboardInflight)
+		http.HandleFunc("/dashboard/journal", handleDashboardJournal)
 		log.Printf("Dashboard已启用，访问地址: http://localhost%s/dashboard", PORT)
 	}
 
 	// 初始化注册管理系统
-	registerEnabled := getEnv("REGISTER_ENABLED", "true")
-	REGISTER_ENABLED = (registerEnabled == "true" || registerEnabled == "1")
+	REGISTER_ENABLED = getEnvBool("REGISTER_ENABLED", true)
 	if REGISTER_ENABLED {
 		dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
 		if err := register.InitRegisterSystem(dbPath); err != nil {
@@ -1141,6 +1429,80 @@ func main() {
 		}
 	}
 
+	// 初始化会话系统
+	if CONVERSATIONS_ENABLED {
+		if err := initConversationDB(); err != nil {
+			log.Printf("❌ 会话系统初始化失败: %v", err)
+		} else {
+			http.HandleFunc("/v1/conversations", withRequestID(handleConversations))
+			http.HandleFunc("/v1/conversations/", withRequestID(handleConversationByID))
+			log.Printf("💬 会话 API: http://localhost%s/v1/conversations", PORT)
+		}
+	}
+
+	// 初始化批处理系统
+	if BATCH_ENABLED {
+		if err := initBatchDB(); err != nil {
+			log.Printf("❌ 批处理系统初始化失败: %v", err)
+		} else {
+			http.HandleFunc("/v1/files", withRequestID(handleFiles))
+			http.HandleFunc("/v1/files/", withRequestID(handleFileByID))
+			http.HandleFunc("/v1/batches", withRequestID(handleBatches))
+			http.HandleFunc("/v1/batches/", withRequestID(handleBatchByID))
+			startBatchWorkers(BATCH_CONCURRENCY)
+			log.Printf("📦 批处理 API: http://localhost%s/v1/batches (并发: %d)", PORT, BATCH_CONCURRENCY)
+		}
+	}
+
+	// Prometheus 指标
+	if METRICS_ENABLED {
+		http.HandleFunc("/metrics", handleMetrics)
+		log.Printf("📈 Prometheus 指标: http://localhost%s/metrics", PORT)
+	}
+
+	// 初始化客户端 API Key 存储
+	if err := initAPIKeyDB(); err != nil {
+		log.Printf("❌ API Key 存储初始化失败: %v", err)
+	}
+
+	// 初始化响应缓存
+	if CACHE_ENABLED {
+		if err := initResponseCache(); err != nil {
+			log.Printf("❌ 响应缓存初始化失败: %v", err)
+		} else {
+			log.Printf("🗃️ 响应缓存: %s (TTL: %v, 最多 %d 条, 默认策略: %s)", CACHE_BACKEND, CACHE_TTL, CACHE_MAX_ENTRIES, CACHE_DEFAULT_POLICY)
+		}
+	}
+
+	// 初始化幂等键存储
+	if err := initIdempotencyDB(); err != nil {
+		log.Printf("❌ 幂等键存储初始化失败: %v", err)
+	} else {
+		startPeriodicTask(time.Hour, cleanupIdempotencyKeys)
+	}
+
+	// 初始化后台补全系统
+	if BACKGROUND_ENABLED {
+		if err := initJobDB(); err != nil {
+			log.Printf("❌ 后台任务系统初始化失败: %v", err)
+		} else {
+			http.HandleFunc("/v1/jobs/", withRequestID(handleJobByID))
+			resumeBackgroundJobs()
+			startPeriodicTask(time.Hour, cleanupBackgroundJobs)
+			log.Printf("⏳ 后台补全: http://localhost%s/v1/jobs/{id}", PORT)
+		}
+	}
+
+	// 初始化请求日志
+	if JOURNAL_ENABLED {
+		if err := initJournalDB(); err != nil {
+			log.Printf("❌ 请求日志初始化失败: %v", err)
+		} else {
+			startPeriodicTask(time.Hour, cleanupJournal)
+			log.Printf("📜 请求日志: 采样 %.2f，脱敏 %s，全文搜索 %v", JOURNAL_SAMPLE_RATE, JOURNAL_REDACT, journalFTS)
+		}
+	}
+
 	// 初始化 Admin 系统
 	if ADMIN_ENABLED {
 		if err := initAdminDB(); err != nil {
@@ -1159,11 +1521,12 @@ func main() {
 		}
 		return DEFAULT_KEY
 	}())
-	log.Printf("Debug模式: %v", DEBUG_MODE)
-	log.Printf("默认流式响应: %v", DEFAULT_STREAM)
+	log.Printf("Debug模式: %v", DEBUG_MODE.Load())
+	log.Printf("默认流式响应: %v", DEFAULT_STREAM.Load())
 	log.Printf("Dashboard启用: %v", DASHBOARD_ENABLED)
-	log.Printf("思考功能: %v", ENABLE_THINKING)
-	log.Fatal(http.ListenAndServe(PORT, nil))
+	log.Printf("思考功能: %v", ENABLE_THINKING.Load())
+	startConfigWatcher()
+	runServer()
 }
 
 // Dashboard页面处理器
@@ -1631,7 +1994,30 @@ func handleDashboardHourly(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stats, err := getHourlyStats(hours)
+	loc, ok := requestStatsLocation(w, r)
+	if !ok {
+		return
+	}
+
+	// 带 group_by 或维度过滤时返回维度统计
+	if query, ok, err := parseStatsDimQuery(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok {
+		if query.usesAPIKey() && !checkAdminAuth(r) {
+			http.Error(w, "Admin login required for api_key statistics", http.StatusUnauthorized)
+			return
+		}
+		rows, err := getHourlyDimStats(hours, loc, query)
+		if err != nil {
+			http.Error(w, "Failed to get hourly stats", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+
+	stats, err := getHourlyStats(hours, loc)
 	if err != nil {
 		http.Error(w, "Failed to get hourly stats", http.StatusInternalServerError)
 		return
@@ -1651,7 +2037,36 @@ func handleDashboardDaily(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	stats, err := getDailyStats(days)
+	loc, ok := requestStatsLocation(w, r)
+	if !ok {
+		return
+	}
+
+	if query, ok, err := parseStatsDimQuery(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok {
+		if query.usesAPIKey() && !checkAdminAuth(r) {
+			http.Error(w, "Admin login required for api_key statistics", http.StatusUnauthorized)
+			return
+		}
+		rows, err := getDailyDimStats(days, loc, query)
+		if err != nil {
+			http.Error(w, "Failed to get daily stats", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(rows)
+		return
+	}
+
+	// 与报表时区不同时从小时数据实时汇总
+	var stats []DailyStats
+	var err error
+	if isStatsLocation(loc) {
+		stats, err = getDailyStats(days)
+	} else {
+		stats, err = getDailyStatsIn(days, loc)
+	}
 	if err != nil {
 		http.Error(w, "Failed to get daily stats", http.StatusInternalServerError)
 		return
@@ -2274,6 +2689,7 @@ func setCORSHeaders(w http.ResponseWriter) {
 }
 
 func handleModels(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	startTime := time.Now()
 	clientIP := getClientIP(r)
 	userAgent := r.UserAgent()
@@ -2292,18 +2708,13 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 	customToken := r.Header.Get("X-ZAI-Token")
 	if customToken != "" {
 		authToken = customToken
-		debugLog("使用 Playground 自定义 token: %s...", func() string {
-			if len(customToken) > TOKEN_DISPLAY_LENGTH {
-				return customToken[:TOKEN_DISPLAY_LENGTH]
-			}
-			return customToken
-		}())
+		logToken.DebugContext(ctx, "使用 Playground 自定义 token", "token_prefix", tokenPrefix(customToken))
 	} else {
 		// 2. 使用统一的 token 获取逻辑
 		var tokenErr error
-		authToken, tokenErr = getAuthToken()
+		authToken, tokenErr = getAuthToken(ctx)
 		if tokenErr != nil {
-			debugLog("获取认证 token 失败: %v", tokenErr)
+			logToken.WarnContext(ctx, "获取认证 token 失败", "error", tokenErr)
 			// 直接fallback到默认模型
 			fallbackResponse := ModelsResponse{
 				Object: "list",
@@ -2320,17 +2731,17 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(fallbackResponse)
 
 			duration := time.Since(startTime)
-			recordRequestStats(startTime, "/v1/models", http.StatusOK)
-			addLiveRequest(r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
+			recordRequestStats(ctx, startTime, "/v1/models", http.StatusOK)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
 			return
 		}
 	}
 
 	// 请求上游models API
-	client := &http.Client{Timeout: UPSTREAM_TIMEOUT * time.Second}
+	client := &http.Client{Timeout: UPSTREAM_TIMEOUT.Load()}
 	req, err := http.NewRequest("GET", "https://chat.z.ai/api/models", nil)
 	if err != nil {
-		debugLog("创建models请求失败: %v", err)
+		logUpstream.WarnContext(ctx, "创建models请求失败", "error", err)
 		sendFallbackModels(w, r, startTime, clientIP, userAgent)
 		return
 	}
@@ -2352,14 +2763,14 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		debugLog("上游models请求失败: %v", err)
+		logUpstream.WarnContext(ctx, "上游models请求失败", "error", err)
 		sendFallbackModels(w, r, startTime, clientIP, userAgent)
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		debugLog("上游models请求返回非200状态码: %d", resp.StatusCode)
+		logUpstream.WarnContext(ctx, "上游models请求返回非200状态码", "status", resp.StatusCode)
 		sendFallbackModels(w, r, startTime, clientIP, userAgent)
 		return
 	}
@@ -2373,7 +2784,7 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&upstreamData); err != nil {
-		debugLog("解析上游models响应失败: %v", err)
+		logUpstream.WarnContext(ctx, "解析上游models响应失败", "error", err)
 		sendFallbackModels(w, r, startTime, clientIP, userAgent)
 		return
 	}
@@ -2403,10 +2814,10 @@ func handleModels(w http.ResponseWriter, r *http.Request) {
 
 	// 记录成功统计
 	duration := time.Since(startTime)
-	recordRequestStats(startTime, "/v1/models", http.StatusOK)
-	addLiveRequest(r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
+	recordRequestStats(ctx, startTime, "/v1/models", http.StatusOK)
+	addLiveRequest(requestIDFromContext(ctx), r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
 
-	debugLog("成功返回 %d 个模型", len(models))
+	logMain.DebugContext(ctx, "成功返回模型列表", "count", len(models))
 }
 
 // sendFallbackModels 发送fallback单一模型响应
@@ -2428,10 +2839,10 @@ func sendFallbackModels(w http.ResponseWriter, r *http.Request, startTime time.T
 
 	// 记录统计（仍然返回200，但是fallback数据）
 	duration := time.Since(startTime)
-	recordRequestStats(startTime, "/v1/models", http.StatusOK)
-	addLiveRequest(r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
+	recordRequestStats(r.Context(), startTime, "/v1/models", http.StatusOK)
+	addLiveRequest(requestIDFromContext(r.Context()), r.Method, "/v1/models", http.StatusOK, duration, clientIP, userAgent)
 
-	debugLog("降级返回fallback模型: %s", MODEL_NAME)
+	logMain.DebugContext(r.Context(), "降级返回fallback模型", "model", MODEL_NAME)
 }
 
 func handleChatCompletions(w http.ResponseWriter, r *http.Request) {
@@ -2446,85 +2857,263 @@ func handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	debugLog("收到chat completions请求")
+	ctx := r.Context()
+	logMain.DebugContext(ctx, "收到chat completions请求")
 
 	// 验证API Key
+	_, authSpan := tracing.Start(ctx, "auth", tracing.KindInternal)
 	authHeader := r.Header.Get("Authorization")
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		debugLog("缺少或无效的Authorization头")
-		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+		logMain.DebugContext(ctx, "缺少或无效的Authorization头")
+		authSpan.SetError(errors.New("missing authorization header"))
+		authSpan.End()
+		httpError(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusUnauthorized)
-		addLiveRequest(r.Method, path, http.StatusUnauthorized, duration, "", userAgent)
+		recordRequestStats(ctx, startTime, path, http.StatusUnauthorized)
+		addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusUnauthorized, duration, "", userAgent)
 		return
 	}
 
 	apiKey := strings.TrimPrefix(authHeader, "Bearer ")
-	if apiKey != DEFAULT_KEY {
-		debugLog("无效的API key: %s", apiKey)
-		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+	clientKey, ok := authenticateAPIKey(apiKey)
+	if !ok {
+		logMain.DebugContext(ctx, "无效的API key", "api_key_prefix", tokenPrefix(apiKey))
+		authSpan.SetError(errors.New("invalid api key"))
+		authSpan.End()
+		httpError(w, "Invalid API key", http.StatusUnauthorized)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusUnauthorized)
-		addLiveRequest(r.Method, path, http.StatusUnauthorized, duration, "", userAgent)
+		recordRequestStats(ctx, startTime, path, http.StatusUnauthorized)
+		addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusUnauthorized, duration, "", userAgent)
 		return
 	}
 
-	debugLog("API key验证通过")
+	authSpan.SetAttributes(tracing.String("api_key.id", clientKey.ID))
+	authSpan.End()
+	logMain.DebugContext(ctx, "API key验证通过", "api_key_id", clientKey.ID)
+	ctx, labels := withStatsLabels(ctx, clientKey.ID)
 
 	// 读取请求体
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		debugLog("读取请求体失败: %v", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		logMain.DebugContext(ctx, "读取请求体失败", "error", err)
+		httpError(w, "Failed to read request body", http.StatusBadRequest)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadRequest)
-		addLiveRequest(r.Method, path, http.StatusBadRequest, duration, "", userAgent)
+		recordRequestStats(ctx, startTime, path, http.StatusBadRequest)
+		addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusBadRequest, duration, "", userAgent)
 		return
 	}
 
 	// 解析请求
 	var req OpenAIRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		debugLog("JSON解析失败: %v", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		logMain.DebugContext(ctx, "JSON解析失败", "error", err)
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadRequest)
-		addLiveRequest(r.Method, path, http.StatusBadRequest, duration, "", userAgent)
+		recordRequestStats(ctx, startTime, path, http.StatusBadRequest)
+		addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusBadRequest, duration, "", userAgent)
 		return
 	}
 
-	// 如果客户端没有明确指定stream参数，使用默认值
-	if !bytes.Contains(body, []byte(`"stream"`)) {
-		req.Stream = DEFAULT_STREAM
-		debugLog("客户端未指定stream参数，使用默认值: %v", DEFAULT_STREAM)
+	// 如果客户端没有明确指定stream参数，使用默认值（后台模式始终为非流式）
+	if !bytes.Contains(body, []byte(`"stream"`)) && !req.Background {
+		req.Stream = DEFAULT_STREAM.Load()
+		logMain.DebugContext(ctx, "客户端未指定stream参数，使用默认值", "stream", req.Stream)
 	}
 
-	debugLog("请求解析成功 - 模型: %s, 流式: %v, 消息数: %d", req.Model, req.Stream, len(req.Messages))
+	logMain.DebugContext(ctx, "请求解析成功", "model", req.Model, "stream", req.Stream, "message_count", len(req.Messages))
+	labels.model, labels.stream = req.Model, req.Stream
+	tracing.SpanFromContext(ctx).SetAttributes(
+		tracing.String("gen_ai.request.model", req.Model),
+		tracing.Bool("stream", req.Stream),
+		tracing.Int("messages", len(req.Messages)),
+	)
+
+	// 幂等键（仅非流式）：重放已保存的响应，或等待进行中的相同请求
+	var recorder *idempotencyRecorder
+	if idempotencyKey := r.Header.Get("Idempotency-Key"); idempotencyKey != "" && !req.Stream && idempotencyDB != nil {
+		if len(idempotencyKey) > MAX_IDEMPOTENCY_KEY_LENGTH {
+			httpError(w, "Idempotency-Key is too long", http.StatusBadRequest)
+			recordRequestStats(ctx, startTime, path, http.StatusBadRequest)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusBadRequest, time.Since(startTime), "", userAgent)
+			return
+		}
+
+		requestHash := hashRequestBody(body)
+		stored, release, err := beginIdempotentRequest(r.Context().Done(), clientKey.ID, idempotencyKey, requestHash)
+		if err != nil {
+			status := http.StatusInternalServerError
+			message := "Failed to check Idempotency-Key"
+			if err == errIdempotencyKeyMismatch {
+				status = http.StatusConflict
+				message = "Idempotency-Key was already used with a different request body"
+			}
+			logIdempotency.WarnContext(ctx, "幂等键检查失败", "error", err)
+			httpError(w, message, status)
+			recordRequestStats(ctx, startTime, path, status)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, path, status, time.Since(startTime), "", userAgent)
+			return
+		}
+		if stored != nil {
+			logIdempotency.DebugContext(ctx, "幂等键命中，重放已保存的响应", "idempotency_key", idempotencyKey)
+			writeIdempotentResponse(w, stored)
+			recordRequestStats(ctx, startTime, path, stored.StatusCode)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, path, stored.StatusCode, time.Since(startTime), "", userAgent)
+			return
+		}
+
+		// 记录本次响应，结束后保存并唤醒等待中的相同请求
+		recorder = &idempotencyRecorder{ResponseWriter: w}
+		w = recorder
+		defer func() { release(recorder.result(requestHash)) }()
+	}
+
+	// 后台模式：立即返回任务ID，由服务端完成上游调用
+	if req.Background {
+		status := handleBackgroundCompletion(w, r, req, clientKey.ID)
+		recorder.complete()
+		recordRequestStats(ctx, startTime, path, status)
+		addLiveRequest(requestIDFromContext(ctx), r.Method, path, status, time.Since(startTime), "", userAgent)
+		return
+	}
+
+	// 登记为进行中的请求，管理员可以取消
+	ctx, inflight := trackInflightRequest(ctx, "API", path, clientIP, userAgent)
+	defer inflight.done()
+	inflight.setRequest(req.Model, req.Stream, clientKey)
+	ctx, journal := startJournalEntry(ctx, "API", path, clientIP, userAgent, req, clientKey.ID)
 
 	// 生成会话相关ID
 	chatID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), time.Now().Unix())
 	msgID := fmt.Sprintf("%d", time.Now().UnixNano())
 
 	// 决定是否启用思考功能：优先使用请求参数，其次使用环境变量
-	enableThinking := ENABLE_THINKING // 默认使用环境变量值
+	enableThinking := ENABLE_THINKING.Load() // 默认使用配置值
 	if req.EnableThinking != nil {
 		enableThinking = *req.EnableThinking
-		debugLog("使用请求参数中的思考功能设置: %v", enableThinking)
+		logMain.DebugContext(ctx, "使用请求参数中的思考功能设置", "enable_thinking", enableThinking)
 	} else {
-		debugLog("使用环境变量中的思考功能设置: %v", enableThinking)
+		logMain.DebugContext(ctx, "使用环境变量中的思考功能设置", "enable_thinking", enableThinking)
+	}
+
+	// 响应缓存：按 API Key 策略查找完全相同的请求
+	var cacheKey string
+	if isCacheable(clientKey, req, body) {
+		cacheKey = buildCacheKey(req, enableThinking)
+		if cached, hit := responseCache.Get(cacheKey); hit {
+			logCache.DebugContext(ctx, "响应缓存命中", "cache_key", cacheKey[:16])
+			writeCachedResponse(w, completionID(ctx), req.Stream, cached.Content)
+			recorder.complete()
+			journal.setContent(cached.Content)
+			model := getUpstreamModelID(MODEL_NAME)
+			recordRequestStatsDetailed(ctx, startTime, path, http.StatusOK, model, req.Stream, 0)
+			addLiveRequestWithModel(requestIDFromContext(ctx), r.Method, path, http.StatusOK, time.Since(startTime), "", userAgent, model)
+			return
+		}
+		w.Header().Set("X-Cache", "MISS")
+	}
+
+	// 会话模式：加载历史消息，沿用上游 chat_id 和 token
+	messages := req.Messages
+	var conv *Conversation
+	if req.ConversationID != "" {
+		if !CONVERSATIONS_ENABLED {
+			httpError(w, "Conversations are disabled", http.StatusBadRequest)
+			recordRequestStats(ctx, startTime, path, http.StatusBadRequest)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, path, http.StatusBadRequest, time.Since(startTime), "", userAgent)
+			return
+		}
+
+		// 同一会话的请求串行执行，保证消息顺序
+		unlock := lockConversation(req.ConversationID)
+		defer unlock()
+
+		conv, err = getConversation(clientKey.ID, req.ConversationID)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == sql.ErrNoRows {
+				status = http.StatusNotFound
+			}
+			logConversations.WarnContext(ctx, "加载会话失败", "error", err)
+			httpError(w, "Conversation not found", status)
+			recordRequestStats(ctx, startTime, path, status)
+			addLiveRequest(requestIDFromContext(ctx), r.Method, path, status, time.Since(startTime), "", userAgent)
+			return
+		}
+
+		messages = append(conv.Messages, req.Messages...)
+		chatID = conv.ChatID
+		w.Header().Set("X-Conversation-ID", conv.ID)
+		logConversations.DebugContext(ctx, "会话模式", "conversation_id", conv.ID, "history", len(conv.Messages), "new_messages", len(req.Messages))
 	}
 
 	// 构造上游请求
-	upstreamReq := UpstreamRequest{
+	upstreamReq := buildUpstreamRequest(messages, chatID, msgID, enableThinking)
+	journal.setUpstream(upstreamReq, enableThinking)
+
+	// 获取认证 token（会话已绑定 token 时保持粘性路由）
+	var authToken string
+	if conv != nil && conv.AuthToken != "" {
+		authToken = conv.AuthToken
+		logToken.DebugContext(ctx, "使用会话绑定的 token", "token_prefix", tokenPrefix(authToken))
+	} else {
+		var tokenErr error
+		authToken, tokenErr = resolveAuthToken(ctx, r)
+		if tokenErr != nil {
+			logToken.WarnContext(ctx, "获取认证 token 失败", "error", tokenErr)
+			journal.setError(tokenErr)
+			httpError(w, "No available auth token", http.StatusInternalServerError)
+			recordRequestStatsDetailed(ctx, startTime, path, http.StatusInternalServerError, upstreamReq.Model, req.Stream, 0)
+			addLiveRequestWithModel(requestIDFromContext(ctx), r.Method, path, http.StatusInternalServerError, time.Since(startTime), "", userAgent, upstreamReq.Model)
+			return
+		}
+		if conv != nil {
+			if err := updateConversationToken(conv.ID, authToken); err != nil {
+				logConversations.WarnContext(ctx, "保存会话 token 失败", "error", err)
+			}
+		}
+	}
+
+	// 调用上游API
+	inflight.setToken(authToken)
+	journal.setToken(authToken)
+	var content string
+	var completed bool
+	if req.Stream {
+		content, completed = handleStreamResponseWithIDs(ctx, w, upstreamReq, chatID, authToken, startTime, path, clientIP, userAgent)
+	} else {
+		content, completed = handleNonStreamResponseWithIDs(ctx, w, upstreamReq, chatID, authToken, startTime, path, clientIP, userAgent)
+	}
+
+	if completed {
+		recorder.complete()
+	}
+
+	// 保存到响应缓存
+	if cacheKey != "" && completed && content != "" {
+		responseCache.Set(cacheKey, &cachedResponse{Content: content, CreatedAt: time.Now()})
+	}
+
+	// 会话模式：保存本轮新消息和助手回复
+	if conv != nil && completed {
+		turn := append(append([]Message{}, req.Messages...), Message{Role: "assistant", Content: content})
+		if err := appendConversationMessages(conv.ID, turn); err != nil {
+			logConversations.WarnContext(ctx, "保存会话消息失败", "error", err)
+		}
+	}
+}
+
+// 构造上游请求
+func buildUpstreamRequest(messages []Message, chatID, msgID string, enableThinking bool) UpstreamRequest {
+	return UpstreamRequest{
 		Stream:   true, // 总是使用流式从上游获取
 		ChatID:   chatID,
 		ID:       msgID,
 		Model:    getUpstreamModelID(MODEL_NAME), // 根据模型名称获取上游实际模型ID
-		Messages: req.Messages,
+		Messages: messages,
 		Params:   map[string]interface{}{},
 		Features: map[string]interface{}{
 			"enable_thinking": enableThinking,
@@ -2546,44 +3135,38 @@ func handleChatCompletions(w http.ResponseWriter, r *http.Request) {
 			"{{CURRENT_DATETIME}}": time.Now().Format("2006-01-02 15:04:05"),
 		},
 	}
+}
 
-	// 获取认证 token
-	// 优先级：请求头自定义 token > 环境变量 > 数据库随机 token > 匿名 token
-	var authToken string
-	
+// 获取请求使用的认证 token
+// 优先级：请求头自定义 token > 环境变量 > 数据库随机 token > 匿名 token
+func resolveAuthToken(ctx context.Context, r *http.Request) (string, error) {
 	// 1. 检查请求头是否有用户自定义的 ZAI Token (来自 playground)
-	customToken := r.Header.Get("X-ZAI-Token")
-	if customToken != "" {
-		authToken = customToken
-		debugLog("使用 Playground 自定义 token: %s...", func() string {
-			if len(customToken) > TOKEN_DISPLAY_LENGTH {
-				return customToken[:TOKEN_DISPLAY_LENGTH]
-			}
-			return customToken
-		}())
-	} else {
-		// 2. 使用统一的 token 获取逻辑
-		var tokenErr error
-		authToken, tokenErr = getAuthToken()
-		if tokenErr != nil {
-			debugLog("获取认证 token 失败: %v", tokenErr)
-			http.Error(w, "No available auth token", http.StatusInternalServerError)
-			return
-		}
+	if customToken := r.Header.Get("X-ZAI-Token"); customToken != "" {
+		logToken.DebugContext(ctx, "使用 Playground 自定义 token", "token_prefix", tokenPrefix(customToken))
+		return customToken, nil
 	}
 
-	// 调用上游API
-	if req.Stream {
-		handleStreamResponseWithIDs(w, upstreamReq, chatID, authToken, startTime, path, clientIP, userAgent)
-	} else {
-		handleNonStreamResponseWithIDs(w, upstreamReq, chatID, authToken, startTime, path, clientIP, userAgent)
-	}
+	// 2. 使用统一的 token 获取逻辑
+	return getAuthToken(ctx)
 }
 
-func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string, authToken string) (*http.Response, error) {
+func callUpstreamWithHeaders(ctx context.Context, upstreamReq UpstreamRequest, refererChatID string, authToken string) (resp *http.Response, err error) {
+	ctx, span := tracing.Start(ctx, "upstream.request", tracing.KindClient,
+		tracing.String("upstream.model", upstreamReq.Model),
+		tracing.String("upstream.chat_id", refererChatID),
+	)
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+		}
+		span.SetError(err)
+		span.End()
+		observeUpstreamResult(ctx, resp, err)
+	}()
+
 	reqBody, err := json.Marshal(upstreamReq)
 	if err != nil {
-		debugLog("上游请求序列化失败: %v", err)
+		logUpstream.ErrorContext(ctx, "上游请求序列化失败", "error", err)
 		return nil, err
 	}
 
@@ -2609,16 +3192,7 @@ func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string,
 	// 生成双层HMAC-SHA256签名
 	signature := generateSignature(lastUserMessage, requestID, timestampMs, userID, secret)
 
-	debugLog("签名参数 - user_id: %s, message: %s..., timestamp: %d",
-		userID,
-		func() string {
-			if len(lastUserMessage) > 20 {
-				return lastUserMessage[:20]
-			}
-			return lastUserMessage
-		}(),
-		timestampMs)
-	debugLog("生成签名: %s (双层HMAC-SHA256)", signature)
+	logUpstream.DebugContext(ctx, "生成签名 (双层HMAC-SHA256)", "user_id", userID, "timestamp", timestampMs, "message_length", len(lastUserMessage))
 
 	// 构建URL参数 - 添加所有必要的指纹参数
 	fullURL := fmt.Sprintf("%s?timestamp=%s&requestId=%s&user_id=%s&version=0.0.1&platform=web&token=%s"+
@@ -2641,12 +3215,14 @@ func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string,
 		timestamp,
 	)
 
-	debugLog("调用上游API: %s", fullURL)
-	debugLog("上游请求体: %s", string(reqBody))
+	logUpstream.DebugContext(ctx, "调用上游API", "url", baseURL, "upstream_request_id", requestID)
+	if logUpstream.Enabled(ctx, slog.LevelDebug) {
+		logUpstream.DebugContext(ctx, "上游请求体", "body", logging.RedactWithContent(string(reqBody)))
+	}
 
-	req, err := http.NewRequest("POST", fullURL, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", fullURL, bytes.NewBuffer(reqBody))
 	if err != nil {
-		debugLog("创建HTTP请求失败: %v", err)
+		logUpstream.ErrorContext(ctx, "创建HTTP请求失败", "error", err)
 		return nil, err
 	}
 
@@ -2666,6 +3242,7 @@ func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string,
 	req.Header.Set("Sec-Fetch-Dest", "empty")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-origin")
+	tracing.Inject(ctx, req.Header)
 
 	// 添加Cookie
 	req.Header.Set("Cookie", fmt.Sprintf("token=%s", authToken))
@@ -2680,8 +3257,8 @@ func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string,
 				Timeout:   30 * time.Second,
 				KeepAlive: 30 * time.Second,
 			}).DialContext,
-			// 响应头超时：60秒（等待服务器开始响应）
-			ResponseHeaderTimeout: 60 * time.Second,
+			// 响应头超时：UPSTREAM_TIMEOUT（等待服务器开始响应）
+			ResponseHeaderTimeout: UPSTREAM_TIMEOUT.Load(),
 			// TLS握手超时
 			TLSHandshakeTimeout: 10 * time.Second,
 			// 最大空闲连接
@@ -2691,44 +3268,48 @@ func callUpstreamWithHeaders(upstreamReq UpstreamRequest, refererChatID string,
 			// 不设置整体超时，让流式响应可以持续任意长时间
 		},
 	}
-	resp, err := client.Do(req)
+	sentAt := time.Now()
+	resp, err = client.Do(req)
 	if err != nil {
-		debugLog("上游请求失败: %v", err)
+		logUpstream.WarnContext(ctx, "上游请求失败", "error", err)
 		return nil, err
 	}
 
-	debugLog("上游响应状态: %d %s", resp.StatusCode, resp.Status)
+	timingsFromContext(ctx).upstreamResponded(time.Since(sentAt))
+	logUpstream.DebugContext(ctx, "上游响应", "status", resp.StatusCode)
 	return resp, nil
 }
 
-func handleStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequest, chatID string, authToken string, startTime time.Time, path string, clientIP, userAgent string) {
-	debugLog("开始处理流式响应 (chat_id=%s)", chatID)
+func handleStreamResponseWithIDs(ctx context.Context, w http.ResponseWriter, upstreamReq UpstreamRequest, chatID string, authToken string, startTime time.Time, path string, clientIP, userAgent string) (string, bool) {
+	logUpstream.DebugContext(ctx, "开始处理流式响应", "chat_id", chatID)
+	ctx, timings := withRequestTimings(ctx, startTime)
 
-	resp, err := callUpstreamWithHeaders(upstreamReq, chatID, authToken)
+	resp, err := callUpstreamWithHeaders(ctx, upstreamReq, chatID, authToken)
 	if err != nil {
-		debugLog("调用上游失败: %v", err)
-		http.Error(w, "Failed to call upstream", http.StatusBadGateway)
+		logUpstream.WarnContext(ctx, "调用上游失败", "error", err)
+		status, message := upstreamFailure(ctx, "Failed to call upstream")
+		httpError(w, message, status)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadGateway)
-		addLiveRequest("POST", path, http.StatusBadGateway, duration, "", userAgent)
-		return
+		recordRequestStats(ctx, startTime, path, status)
+		addLiveRequest(requestIDFromContext(ctx), "POST", path, status, duration, "", userAgent)
+		return "", false
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		debugLog("上游返回错误状态: %d", resp.StatusCode)
+		logUpstream.WarnContext(ctx, "上游返回错误状态", "status", resp.StatusCode)
 		// 读取错误响应体
-		if DEBUG_MODE {
+		if logUpstream.Enabled(ctx, slog.LevelDebug) {
 			body, _ := io.ReadAll(resp.Body)
-			debugLog("上游错误响应: %s", string(body))
+			logUpstream.DebugContext(ctx, "上游错误响应", "body", string(body))
 		}
-		http.Error(w, "Upstream error", http.StatusBadGateway)
+		httpError(w, "Upstream error", http.StatusBadGateway)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadGateway)
-		addLiveRequest("POST", path, http.StatusBadGateway, duration, "", userAgent)
-		return
+		recordRequestStats(ctx, startTime, path, http.StatusBadGateway)
+		addLiveRequest(requestIDFromContext(ctx), "POST", path, http.StatusBadGateway, duration, "", userAgent)
+		return "", false
 	}
 
 	// 策略2：总是展示thinking + answer
@@ -2740,13 +3321,19 @@ func handleStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequ
 
 	flusher, ok := w.(http.Flusher)
 	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-		return
+		httpError(w, "Streaming unsupported", http.StatusInternalServerError)
+		return "", false
 	}
 
+	atomic.AddInt64(&metricInflightStreams, 1)
+	defer atomic.AddInt64(&metricInflightStreams, -1)
+
+	// 同一请求的所有 chunk 使用相同的 ID
+	responseID := completionID(ctx)
+
 	// 发送第一个chunk（role）
 	firstChunk := OpenAIResponse{
-		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
+		ID:      responseID,
 		Object:  "chat.completion.chunk",
 		Created: time.Now().Unix(),
 		Model:   MODEL_NAME,
@@ -2760,45 +3347,26 @@ func handleStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequ
 	writeSSEChunk(w, firstChunk)
 	flusher.Flush()
 
-	// 读取上游SSE流
-	debugLog("开始读取上游SSE流")
-	scanner := bufio.NewScanner(resp.Body)
-	lineCount := 0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
-
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
-
-		dataStr := strings.TrimPrefix(line, "data: ")
-		if dataStr == "" {
-			continue
-		}
-
-		debugLog("收到SSE数据 (第%d行): %s", lineCount, dataStr)
-
-		var upstreamData UpstreamData
-		if err := json.Unmarshal([]byte(dataStr), &upstreamData); err != nil {
-			debugLog("SSE数据解析失败: %v", err)
-			continue
-		}
-
-		// 错误检测（data.error 或 data.data.error 或 顶层error）
-		if (upstreamData.Error != nil) || (upstreamData.Data.Error != nil) || (upstreamData.Data.Inner != nil && upstreamData.Data.Inner.Error != nil) {
-			errObj := upstreamData.Error
-			if errObj == nil {
-				errObj = upstreamData.Data.Error
-			}
-			if errObj == nil && upstreamData.Data.Inner != nil {
-				errObj = upstreamData.Data.Inner.Error
-			}
-			debugLog("上游错误: code=%d, detail=%s", errObj.Code, errObj.Detail)
+	// 读取上游SSE流，同时收集完整内容
+	logUpstream.DebugContext(ctx, "开始读取上游SSE流")
+	var fullContent strings.Builder
+	// 只有收到上游的完成信号才算完成；上游错误或流提前结束时不缓存、不写入会话历史
+	completed := false
+	upstreamFailed := false
+	phases := startUpstreamPhases(ctx)
+	inflight := inflightFromContext(ctx)
+	journal := journalFromContext(ctx)
+	eventCount, err := readUpstreamSSE(ctx, resp.Body, func(upstreamData *UpstreamData) bool {
+		inflight.setPhase(upstreamData.Data.Phase)
+		journal.record(upstreamData)
+
+		// 错误检测
+		if errObj := upstreamData.upstreamError(); errObj != nil {
+			logUpstream.WarnContext(ctx, "上游错误", "code", errObj.Code, "detail", errObj.Detail)
+			observeUpstreamError(errObj)
 			// 结束下游流
 			endChunk := OpenAIResponse{
-				ID:      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
+				ID:      responseID,
 				Object:  "chat.completion.chunk",
 				Created: time.Now().Unix(),
 				Model:   MODEL_NAME,
@@ -2807,43 +3375,42 @@ func handleStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequ
 			writeSSEChunk(w, endChunk)
 			fmt.Fprintf(w, "data: [DONE]\n\n")
 			flusher.Flush()
-			break
+			upstreamFailed = true
+			return false
 		}
 
-		debugLog("解析成功 - 类型: %s, 阶段: %s, 内容长度: %d, 完成: %v",
-			upstreamData.Type, upstreamData.Data.Phase, len(upstreamData.Data.DeltaContent), upstreamData.Data.Done)
-
 		// 策略2：总是展示thinking + answer
-		if upstreamData.Data.DeltaContent != "" {
-			var out = upstreamData.Data.DeltaContent
-			if upstreamData.Data.Phase == "thinking" {
-				out = transformThinkingContent(out)
+		if out := upstreamData.outputContent(); out != "" {
+			logUpstream.DebugContext(ctx, "发送内容", "phase", upstreamData.Data.Phase, "content", out)
+			if fullContent.Len() == 0 {
+				observeTimeToFirstToken(startTime, true)
+				timings.markFirstToken()
+				phases.firstToken()
 			}
-			if out != "" {
-				debugLog("发送内容(%s): %s", upstreamData.Data.Phase, out)
-				chunk := OpenAIResponse{
-					ID:      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
-					Object:  "chat.completion.chunk",
-					Created: time.Now().Unix(),
-					Model:   MODEL_NAME,
-					Choices: []Choice{
-						{
-							Index: 0,
-							Delta: Delta{Content: out},
-						},
+			fullContent.WriteString(out)
+			inflight.addBytes(len(out))
+			chunk := OpenAIResponse{
+				ID:      responseID,
+				Object:  "chat.completion.chunk",
+				Created: time.Now().Unix(),
+				Model:   MODEL_NAME,
+				Choices: []Choice{
+					{
+						Index: 0,
+						Delta: Delta{Content: out},
 					},
-				}
-				writeSSEChunk(w, chunk)
-				flusher.Flush()
+				},
 			}
+			writeSSEChunk(w, chunk)
+			flusher.Flush()
 		}
 
 		// 检查是否结束
-		if upstreamData.Data.Done || upstreamData.Data.Phase == "done" {
-			debugLog("检测到流结束信号")
+		if upstreamData.isDone() {
+			logUpstream.DebugContext(ctx, "检测到流结束信号")
 			// 发送结束chunk
 			endChunk := OpenAIResponse{
-				ID:      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
+				ID:      responseID,
 				Object:  "chat.completion.chunk",
 				Created: time.Now().Unix(),
 				Model:   MODEL_NAME,
@@ -2861,19 +3428,49 @@ func handleStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequ
 			// 发送[DONE]
 			fmt.Fprintf(w, "data: [DONE]\n\n")
 			flusher.Flush()
-			debugLog("流式响应完成，共处理%d行", lineCount)
-			break
+			completed = true
+			return false
 		}
+		return true
+	})
+	switch {
+	case err != nil:
+		logUpstream.WarnContext(ctx, "读取上游SSE流失败", "error", err)
+	case upstreamFailed:
+		err = errors.New("upstream error event")
+	case !completed:
+		err = errUpstreamIncomplete
+		logUpstream.WarnContext(ctx, "上游流在完成信号之前结束", "events", eventCount)
+	}
+	phases.end(eventCount, fullContent.Len(), err)
+	timings.finish(fullContent.String())
+	logUpstream.DebugContext(ctx, "流式响应结束", "events", eventCount)
+
+	// 被管理员取消或服务关闭：通知客户端后结束流
+	if requestAborted(ctx) != nil {
+		writeSSECancelled(ctx, w)
+		status, _ := upstreamFailure(ctx, "")
+		duration := time.Since(startTime)
+		recordRequestStatsDetailed(ctx, startTime, path, status, upstreamReq.Model, true, 0)
+		addLiveRequestWithModel(requestIDFromContext(ctx), "POST", path, status, duration, "", userAgent, upstreamReq.Model)
+		return fullContent.String(), false
 	}
 
-	if err := scanner.Err(); err != nil {
-		debugLog("扫描器错误: %v", err)
+	// 上游错误、读取失败或流提前结束：回答不完整，记为上游错误
+	if err != nil {
+		duration := time.Since(startTime)
+		recordRequestStatsDetailed(ctx, startTime, path, http.StatusBadGateway, upstreamReq.Model, true, 0)
+		addLiveRequestWithModel(requestIDFromContext(ctx), "POST", path, http.StatusBadGateway, duration, "", userAgent, upstreamReq.Model)
+		return fullContent.String(), false
 	}
 
 	// 记录成功请求统计
 	duration := time.Since(startTime)
-	recordRequestStatsDetailed(startTime, path, http.StatusOK, upstreamReq.Model, true, 0)
-	addLiveRequestWithModel("POST", path, http.StatusOK, duration, "", userAgent, upstreamReq.Model)
+	recordRequestStatsDetailed(ctx, startTime, path, http.StatusOK, upstreamReq.Model, true, 0)
+	recordLatencyStats(timings)
+	addLiveRequestWithModel(requestIDFromContext(ctx), "POST", path, http.StatusOK, duration, "", userAgent, upstreamReq.Model)
+
+	return fullContent.String(), true
 }
 
 func writeSSEChunk(w http.ResponseWriter, chunk OpenAIResponse) {
@@ -2881,94 +3478,172 @@ func writeSSEChunk(w http.ResponseWriter, chunk OpenAIResponse) {
 	fmt.Fprintf(w, "data: %s\n\n", data)
 }
 
-func handleNonStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamRequest, chatID string, authToken string, startTime time.Time, path string, clientIP, userAgent string) {
-	debugLog("开始处理非流式响应 (chat_id=%s)", chatID)
+// readUpstreamSSE 逐个解析上游SSE事件并回调，回调返回false时停止读取
+// 返回已读取的事件数
+func readUpstreamSSE(ctx context.Context, body io.Reader, handle func(upstreamData *UpstreamData) bool) (int, error) {
+	decoder := sse.NewDecoder(body)
+	eventCount := 0
 
-	resp, err := callUpstreamWithHeaders(upstreamReq, chatID, authToken)
-	if err != nil {
-		debugLog("调用上游失败: %v", err)
-		http.Error(w, "Failed to call upstream", http.StatusBadGateway)
-		// 记录请求统计
-		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadGateway)
-		addLiveRequest("POST", path, http.StatusBadGateway, duration, "", userAgent)
-		return
+	for {
+		event, err := decoder.Next()
+		if err == io.EOF {
+			return eventCount, nil
+		}
+		if err != nil {
+			return eventCount, err
+		}
+		eventCount++
+
+		if event.Data == "" {
+			continue
+		}
+
+		logUpstream.DebugContext(ctx, "收到SSE数据", "event", eventCount, "data", logging.RedactWithContent(event.Data))
+
+		var upstreamData UpstreamData
+		if err := json.Unmarshal([]byte(event.Data), &upstreamData); err != nil {
+			logUpstream.DebugContext(ctx, "SSE数据解析失败", "error", err)
+			continue
+		}
+
+		logUpstream.DebugContext(ctx, "解析成功", "type", upstreamData.Type, "phase", upstreamData.Data.Phase,
+			"content_length", len(upstreamData.Data.DeltaContent), "done", upstreamData.Data.Done)
+
+		if !handle(&upstreamData) {
+			return eventCount, nil
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		debugLog("上游返回错误状态: %d", resp.StatusCode)
-		// 读取错误响应体
-		if DEBUG_MODE {
-			body, _ := io.ReadAll(resp.Body)
-			debugLog("上游错误响应: %s", string(body))
+func handleNonStreamResponseWithIDs(ctx context.Context, w http.ResponseWriter, upstreamReq UpstreamRequest, chatID string, authToken string, startTime time.Time, path string, clientIP, userAgent string) (string, bool) {
+	logUpstream.DebugContext(ctx, "开始处理非流式响应", "chat_id", chatID)
+	ctx, timings := withRequestTimings(ctx, startTime)
+
+	finalContent, err := collectUpstreamCompletion(ctx, upstreamReq, chatID, authToken)
+	if err != nil {
+		message := "Failed to call upstream"
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) {
+			message = "Upstream error"
 		}
-		http.Error(w, "Upstream error", http.StatusBadGateway)
+		status, message := upstreamFailure(ctx, message)
+		httpError(w, message, status)
 		// 记录请求统计
 		duration := time.Since(startTime)
-		recordRequestStats(startTime, path, http.StatusBadGateway)
-		addLiveRequest("POST", path, http.StatusBadGateway, duration, "", userAgent)
-		return
+		recordRequestStats(ctx, startTime, path, status)
+		addLiveRequest(requestIDFromContext(ctx), "POST", path, status, duration, "", userAgent)
+		return "", false
 	}
 
-	// 收集完整响应（策略2：thinking与answer都纳入，thinking转换）
-	var fullContent strings.Builder
-	scanner := bufio.NewScanner(resp.Body)
-	debugLog("开始收集完整响应内容")
-	lineCount := 0
+	// 构造完整响应
+	response := newChatCompletionResponse(completionID(ctx), finalContent)
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		lineCount++
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	logUpstream.DebugContext(ctx, "非流式响应发送完成")
+
+	// 记录成功请求统计
+	duration := time.Since(startTime)
+	recordRequestStatsDetailed(ctx, startTime, path, http.StatusOK, upstreamReq.Model, false, 0)
+	recordLatencyStats(timings)
+	addLiveRequestWithModel(requestIDFromContext(ctx), "POST", path, http.StatusOK, duration, "", userAgent, upstreamReq.Model)
 
-		debugLog("收到原始行[%d]: %s", lineCount, line)
+	return finalContent, true
+}
 
-		if !strings.HasPrefix(line, "data: ") {
-			continue
-		}
+// 上游返回非200状态码
+type upstreamStatusError struct {
+	StatusCode int
+}
 
-		dataStr := strings.TrimPrefix(line, "data: ")
-		if dataStr == "" {
-			continue
-		}
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("上游返回错误状态: %d", e.StatusCode)
+}
 
-		debugLog("解析SSE数据: %s", dataStr)
+// 上游流在完成信号之前结束（连接断开等），已收到的回答不完整
+var errUpstreamIncomplete = errors.New("upstream stream ended before completion")
 
-		var upstreamData UpstreamData
-		if err := json.Unmarshal([]byte(dataStr), &upstreamData); err != nil {
-			debugLog("JSON解析失败: %v", err)
-			continue
+// collectUpstreamCompletion 调用上游并收集完整回复内容（策略2：thinking与answer都纳入，thinking转换）
+func collectUpstreamCompletion(ctx context.Context, upstreamReq UpstreamRequest, chatID string, authToken string) (string, error) {
+	startTime := time.Now()
+	resp, err := callUpstreamWithHeaders(ctx, upstreamReq, chatID, authToken)
+	if err != nil {
+		logUpstream.WarnContext(ctx, "调用上游失败", "error", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		logUpstream.WarnContext(ctx, "上游返回错误状态", "status", resp.StatusCode)
+		// 读取错误响应体
+		if logUpstream.Enabled(ctx, slog.LevelDebug) {
+			body, _ := io.ReadAll(resp.Body)
+			logUpstream.DebugContext(ctx, "上游错误响应", "body", string(body))
 		}
+		return "", &upstreamStatusError{StatusCode: resp.StatusCode}
+	}
 
-		debugLog("解析成功 - type:%s phase:%s content_len:%d done:%v",
-			upstreamData.Type, upstreamData.Data.Phase,
-			len(upstreamData.Data.DeltaContent), upstreamData.Data.Done)
+	var fullContent strings.Builder
+	var upstreamErr *UpstreamError
+	completed := false
+	logUpstream.DebugContext(ctx, "开始收集完整响应内容")
+
+	phases := startUpstreamPhases(ctx)
+	inflight := inflightFromContext(ctx)
+	journal := journalFromContext(ctx)
+	eventCount, err := readUpstreamSSE(ctx, resp.Body, func(upstreamData *UpstreamData) bool {
+		inflight.setPhase(upstreamData.Data.Phase)
+		journal.record(upstreamData)
+		if errObj := upstreamData.upstreamError(); errObj != nil {
+			observeUpstreamError(errObj)
+			upstreamErr = errObj
+		}
 
-		if upstreamData.Data.DeltaContent != "" {
-			out := upstreamData.Data.DeltaContent
-			if upstreamData.Data.Phase == "thinking" {
-				out = transformThinkingContent(out)
-			}
-			if out != "" {
-				debugLog("添加内容: %s", out)
-				fullContent.WriteString(out)
+		if out := upstreamData.outputContent(); out != "" {
+			logUpstream.DebugContext(ctx, "添加内容", "content", out)
+			if fullContent.Len() == 0 {
+				observeTimeToFirstToken(startTime, false)
+				timingsFromContext(ctx).markFirstToken()
+				phases.firstToken()
 			}
+			fullContent.WriteString(out)
+			inflight.addBytes(len(out))
 		}
 
-		if upstreamData.Data.Done || upstreamData.Data.Phase == "done" {
-			debugLog("检测到完成信号，停止收集")
-			break
+		if upstreamData.isDone() {
+			logUpstream.DebugContext(ctx, "检测到完成信号，停止收集")
+			completed = true
+			return false
 		}
+		return true
+	})
+	if err != nil {
+		logUpstream.WarnContext(ctx, "读取上游SSE流失败", "error", err)
+	} else if !completed && upstreamErr != nil {
+		err = fmt.Errorf("upstream error %d: %s", upstreamErr.Code, upstreamErr.Detail)
+	} else if !completed {
+		err = errUpstreamIncomplete
+		logUpstream.WarnContext(ctx, "上游流在完成信号之前结束", "events", eventCount)
+	}
+	phases.end(eventCount, fullContent.Len(), err)
+	logUpstream.DebugContext(ctx, "SSE流读取结束", "events", eventCount)
+	if err := requestAborted(ctx); err != nil {
+		return "", err
+	}
+	if err != nil {
+		return "", err
 	}
-
-	debugLog("扫描器共处理%d行", lineCount)
 
 	finalContent := fullContent.String()
-	debugLog("内容收集完成，最终长度: %d", len(finalContent))
+	timingsFromContext(ctx).finish(finalContent)
+	logUpstream.DebugContext(ctx, "内容收集完成", "length", len(finalContent))
+	return finalContent, nil
+}
 
-	// 构造完整响应
-	response := OpenAIResponse{
-		ID:      fmt.Sprintf("chatcmpl-%d", time.Now().Unix()),
+// 构造非流式对话补全响应
+func newChatCompletionResponse(id, content string) OpenAIResponse {
+	return OpenAIResponse{
+		ID:      id,
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   MODEL_NAME,
@@ -2977,7 +3652,7 @@ func handleNonStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamR
 				Index: 0,
 				Message: Message{
 					Role:    "assistant",
-					Content: finalContent,
+					Content: content,
 				},
 				FinishReason: "stop",
 			},
@@ -2988,15 +3663,66 @@ func handleNonStreamResponseWithIDs(w http.ResponseWriter, upstreamReq UpstreamR
 			TotalTokens:      0,
 		},
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-	debugLog("非流式响应发送完成")
+// runChatCompletion 在服务端完整执行一次非流式对话补全（供批处理、后台任务复用）
+// authToken 为空时使用统一的 token 获取逻辑，并记录请求统计
+func runChatCompletion(req OpenAIRequest, source string, authToken string) (response *OpenAIResponse, err error) {
+	startTime := time.Now()
+	path := "/v1/chat/completions"
+
+	ctx := logging.WithRequestID(serverContext, newRequestID())
+	ctx, timings := withRequestTimings(ctx, startTime)
+	ctx, labels := withStatsLabels(ctx, "")
+	labels.model = req.Model
+	ctx, inflight := trackInflightRequest(ctx, source, path, "", source)
+	defer inflight.done()
+	inflight.setRequest(req.Model, false, nil)
+	ctx, journal := startJournalEntry(ctx, source, path, "", source, req, "")
+	ctx, span := tracing.Start(ctx, "chat.completion "+source, tracing.KindInternal,
+		tracing.String("gen_ai.request.model", req.Model),
+	)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 
-	// 记录成功请求统计
-	duration := time.Since(startTime)
-	recordRequestStatsDetailed(startTime, path, http.StatusOK, upstreamReq.Model, false, 0)
-	addLiveRequestWithModel("POST", path, http.StatusOK, duration, "", userAgent, upstreamReq.Model)
+	enableThinking := ENABLE_THINKING.Load()
+	if req.EnableThinking != nil {
+		enableThinking = *req.EnableThinking
+	}
+
+	chatID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), time.Now().Unix())
+	msgID := fmt.Sprintf("%d", time.Now().UnixNano())
+	upstreamReq := buildUpstreamRequest(req.Messages, chatID, msgID, enableThinking)
+	journal.setUpstream(upstreamReq, enableThinking)
+
+	if authToken == "" {
+		authToken, err = getAuthToken(ctx)
+		if err != nil {
+			logToken.WarnContext(ctx, "获取认证 token 失败", "error", err)
+			journal.setError(err)
+			recordRequestStats(ctx, startTime, path, http.StatusInternalServerError)
+			addLiveRequest(requestIDFromContext(ctx), source, path, http.StatusInternalServerError, time.Since(startTime), "", source)
+			return nil, err
+		}
+	}
+
+	inflight.setToken(authToken)
+	journal.setToken(authToken)
+	content, err := collectUpstreamCompletion(ctx, upstreamReq, chatID, authToken)
+	if err != nil {
+		status, _ := upstreamFailure(ctx, "")
+		recordRequestStats(ctx, startTime, path, status)
+		addLiveRequest(requestIDFromContext(ctx), source, path, status, time.Since(startTime), "", source)
+		return nil, err
+	}
+
+	result := newChatCompletionResponse(completionID(ctx), content)
+	recordRequestStatsDetailed(ctx, startTime, path, http.StatusOK, upstreamReq.Model, false, 0)
+	recordLatencyStats(timings)
+	addLiveRequestWithModel(requestIDFromContext(ctx), source, path, http.StatusOK, time.Since(startTime), "", source, upstreamReq.Model)
+	return &result, nil
 }
 
 // ==================== Admin 相关函数 ====================
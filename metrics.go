@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hulisang/ZtoApi/register"
+)
+
+// ==================== Prometheus 指标相关 ====================
+//
+// /metrics 以 Prometheus 文本格式（0.0.4）输出指标，需要 Bearer Token 访问：
+// METRICS_TOKEN 未设置时使用 DEFAULT_KEY。
+// 计数器和直方图在请求路径上实时累加，账号池和任务状态在抓取时读取。
+
+// 延迟直方图的桶（秒）
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	metricRequests = newCounterVec("zto_requests_total",
+		"Total chat completion requests by path, model, status and stream flag.",
+		"path", "model", "status", "stream")
+	metricRequestDuration = newHistogramVec("zto_request_duration_seconds",
+		"Total request latency in seconds.", latencyBuckets,
+		"path", "stream")
+	metricTimeToFirstToken = newHistogramVec("zto_time_to_first_token_seconds",
+		"Time from request start to the first content token in seconds.", latencyBuckets,
+		"stream")
+	metricUpstreamErrors = newCounterVec("zto_upstream_errors_total",
+		"Upstream error events by UpstreamError code.",
+		"code")
+
+	metricInflightStreams int64
+)
+
+// ---------- 计数器 ----------
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *counterVec) Inc(labelValues ...string) {
+	key := formatLabels(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, key, formatFloat(c.values[key]))
+	}
+}
+
+// ---------- 直方图 ----------
+
+type histogram struct {
+	counts []uint64 // 每个桶（非累计）的观测次数，最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: make(map[string]*histogram)}
+}
+
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := formatLabels(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = hist
+	}
+	i := sort.SearchFloat64s(h.buckets, value)
+	hist.counts[i]++
+	hist.sum += value
+	hist.count++
+}
+
+func (h *histogramVec) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		hist := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += hist.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(key, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, key, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, key, hist.count)
+	}
+}
+
+// ---------- 格式化 ----------
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// 生成 {a="x",b="y"} 形式的标签串
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelValueEscaper.Replace(value))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// 在已有标签串后追加一个标签
+func withLabel(labels, name, value string) string {
+	extra := fmt.Sprintf(`%s="%s"`, name, value)
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return labels[:len(labels)-1] + "," + extra + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeGauge(w io.Writer, name, help string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, key := range sortedKeys(samples) {
+		fmt.Fprintf(w, "%s%s %s\n", name, key, formatFloat(samples[key]))
+	}
+}
+
+// ---------- 埋点 ----------
+
+// 记录一次请求的计数和总延迟
+func observeRequestMetrics(path string, status int, model string, isStreaming bool, duration time.Duration) {
+	stream := strconv.FormatBool(isStreaming)
+	metricRequests.Inc(path, model, strconv.Itoa(status), stream)
+	metricRequestDuration.Observe(duration.Seconds(), path, stream)
+}
+
+// 记录首个内容 token 的延迟
+func observeTimeToFirstToken(startTime time.Time, isStreaming bool) {
+	metricTimeToFirstToken.Observe(time.Since(startTime).Seconds(), strconv.FormatBool(isStreaming))
+}
+
+// 记录上游错误事件
+func observeUpstreamError(errObj *UpstreamError) {
+	metricUpstreamErrors.Inc(strconv.Itoa(errObj.Code))
+	recordUpstreamFailure(fmt.Sprintf("upstream error %d: %s", errObj.Code, errObj.Detail))
+}
+
+// ---------- HTTP 处理 ----------
+
+// 处理 /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	token := METRICS_TOKEN
+	if token == "" {
+		token = DEFAULT_KEY
+	}
+	if r.Header.Get("Authorization") != "Bearer "+token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metricRequests.writeTo(w)
+	metricRequestDuration.writeTo(w)
+	metricTimeToFirstToken.writeTo(w)
+	metricUpstreamErrors.writeTo(w)
+
+	writeGauge(w, "zto_inflight_streams", "Streaming responses currently in flight.",
+		map[string]float64{"": float64(atomic.LoadInt64(&metricInflightStreams))})
+
+	// 账号池（仅在启用注册系统时可用）
+	if counts, err := register.CountAccountsByStatus(); err == nil {
+		samples := make(map[string]float64)
+		for _, status := range []string{"active", "inactive", "unknown"} {
+			samples[formatLabels([]string{"status"}, []string{status})] = 0
+		}
+		for status, count := range counts {
+			samples[formatLabels([]string{"status"}, []string{status})] = float64(count)
+		}
+		writeGauge(w, "zto_token_pool_accounts", "Accounts in the token pool by status.", samples)
+	}
+
+	// 注册/检测任务
+	registerRunning := 0.0
+	if task := register.GetCurrentTask(); task != nil && task.IsRunning {
+		registerRunning = 1
+	}
+	checks, refetches := register.GetRunningTaskCounts()
+	writeGauge(w, "zto_background_tasks_running", "Running account registration, check and API key refetch tasks.",
+		map[string]float64{
+			formatLabels([]string{"task"}, []string{"register"}): registerRunning,
+			formatLabels([]string{"task"}, []string{"check"}):    float64(checks),
+			formatLabels([]string{"task"}, []string{"refetch"}):  float64(refetches),
+		})
+
+	writeGauge(w, "zto_uptime_seconds", "Seconds since the server started.",
+		map[string]float64{"": time.Since(processStartTime).Seconds()})
+	writeGauge(w, "go_goroutines", "Number of goroutines that currently exist.",
+		map[string]float64{"": float64(runtime.NumGoroutine())})
+}
@@ -101,7 +101,7 @@ func getHomeHTML() string {
 // 生成 Playground HTML (完整版，包含所有高级功能)
 func getPlaygroundHTML() string {
 	enableThinkingChecked := ""
-	if ENABLE_THINKING {
+	if ENABLE_THINKING.Load() {
 		enableThinkingChecked = "checked"
 	}
 
@@ -802,9 +802,12 @@ func getDashboardHTMLNew() string {
     <div class="container mx-auto px-4 py-8 max-w-7xl">
         <div class="text-center mb-8">
             <h1 class="text-4xl font-bold text-gray-900 mb-3">📊 Dashboard</h1>
-            <p class="text-gray-600">实时监控 API 请求和性能统计</p>
+            <p class="text-gray-600">实时监控 API 请求和性能统计 · <a href="/dashboard/journal" class="text-purple-600 hover:underline">📜 请求日志</a></p>
         </div>
 
+        <!-- Alerts -->
+        <div id="alerts" class="space-y-2 mb-6"></div>
+
         <!-- Stats Cards -->
         <div class="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-5 gap-6 mb-8">
             <div class="bg-white rounded-xl shadow-sm border p-6 hover:shadow-md transition">
@@ -988,11 +991,65 @@ func getDashboardHTMLNew() string {
             <canvas id="chart" height="80"></canvas>
         </div>
 
+        <!-- Latency Breakdown -->
+        <div class="bg-white rounded-xl shadow-sm border p-6 mb-8">
+            <div class="flex items-center justify-between mb-4">
+                <h2 class="text-xl font-bold text-gray-900">⏱️ 延迟拆分</h2>
+                <span class="text-sm text-gray-500">最近24小时，成功的对话补全请求</span>
+            </div>
+            <div id="latency-summary" class="grid grid-cols-1 md:grid-cols-4 gap-4 mb-6">
+                <p class="text-gray-500 text-sm">暂无数据</p>
+            </div>
+            <div class="overflow-x-auto">
+                <table class="w-full text-sm">
+                    <thead>
+                        <tr class="border-b">
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">小时</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">首 Token (p50 / p95 / p99)</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">上游响应头 (p50 / p95 / p99)</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">生成耗时 (p50 / p95 / p99)</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">Tokens/s (p50)</th>
+                        </tr>
+                    </thead>
+                    <tbody id="latency-hourly" class="divide-y"></tbody>
+                </table>
+            </div>
+        </div>
+
+        <!-- Inflight Requests -->
+        <div class="bg-white rounded-xl shadow-sm border p-6 mb-8">
+            <div class="flex items-center justify-between mb-4">
+                <h2 class="text-xl font-bold text-gray-900">⏳ 进行中的请求</h2>
+                <span class="text-sm text-gray-500">共 <span id="inflight-total">0</span> 个，客户端 Key、Token 和取消需要管理员登录</span>
+            </div>
+            <div class="overflow-x-auto">
+                <table class="w-full text-sm">
+                    <thead>
+                        <tr class="border-b">
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">开始时间</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">来源</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">模型</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">客户端 Key</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">Token</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">阶段</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">已发送</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">耗时</th>
+                            <th class="text-left py-2 px-3 text-gray-700 font-semibold">操作</th>
+                        </tr>
+                    </thead>
+                    <tbody id="inflight" class="divide-y"></tbody>
+                </table>
+            </div>
+            <div id="inflight-empty" class="text-center py-6 text-gray-500">
+                当前没有进行中的请求
+            </div>
+        </div>
+
         <!-- Requests Table -->
         <div class="bg-white rounded-xl shadow-sm border p-6">
             <div class="flex items-center justify-between mb-4">
                 <h2 class="text-xl font-bold text-gray-900">🔔 实时请求</h2>
-                <span class="text-sm text-gray-500">自动刷新（每5秒）</span>
+                <span class="text-sm text-gray-500">实时推送</span>
             </div>
             <div class="overflow-x-auto">
                 <table class="w-full">
@@ -1048,95 +1105,209 @@ func getDashboardHTMLNew() string {
         async function update() {
             try {
                 const statsRes = await fetch('/dashboard/stats');
-                const stats = await statsRes.json();
-
-                // Top cards
-                document.getElementById('total').textContent = stats.totalRequests;
-                document.getElementById('success').textContent = stats.successfulRequests;
-                document.getElementById('failed').textContent = stats.failedRequests;
-                document.getElementById('avgtime').textContent = Math.round(stats.averageResponseTime) + 'ms';
-                document.getElementById('homeviews').textContent = stats.homePageViews;
-
-                // API Stats
-                document.getElementById('api-calls').textContent = stats.apiCallsCount || 0;
-                document.getElementById('models-calls').textContent = stats.modelsCallsCount || 0;
-                document.getElementById('streaming').textContent = stats.streamingRequests || 0;
-                document.getElementById('non-streaming').textContent = stats.nonStreamingRequests || 0;
-
-                // Performance Stats
-                document.getElementById('avg-time-detail').textContent = Math.round(stats.averageResponseTime) + 'ms';
-                document.getElementById('fastest').textContent = stats.fastestResponse === -1 ? '-' : Math.round(stats.fastestResponse) + 'ms';
-                document.getElementById('slowest').textContent = stats.slowestResponse === 0 ? '-' : Math.round(stats.slowestResponse) + 'ms';
-                const successRate = stats.totalRequests > 0 ? ((stats.successfulRequests / stats.totalRequests) * 100).toFixed(1) : '0';
-                document.getElementById('success-rate').textContent = successRate + '%';
-
-                // System Info
-                const uptime = Date.now() - new Date(stats.startTime).getTime();
-                const hours = Math.floor(uptime / 3600000);
-                const minutes = Math.floor((uptime % 3600000) / 60000);
-                document.getElementById('uptime').textContent = hours + 'h ' + minutes + 'm';
-                document.getElementById('tokens').textContent = (stats.totalTokensUsed || 0).toLocaleString();
-                document.getElementById('last-request').textContent = stats.lastRequestTime ? new Date(stats.lastRequestTime).toLocaleTimeString() : '-';
-                document.getElementById('home-visits').textContent = stats.homePageViews;
-
-                // Top Models
-                const topModelsDiv = document.getElementById('top-models');
-                if (stats.topModels && stats.topModels.length > 0) {
-                    topModelsDiv.innerHTML = stats.topModels.map((m, i) => ` + "`" + `
-                        <div class="flex items-center justify-between">
-                            <div class="flex items-center gap-2">
-                                <span class="text-lg">${i === 0 ? '🥇' : i === 1 ? '🥈' : '🥉'}</span>
-                                <span class="font-mono text-sm text-gray-700">${m.model}</span>
-                            </div>
-                            <span class="font-bold text-purple-600">${m.count}</span>
+                renderStats(await statsRes.json());
+                await updateRequests();
+            } catch (e) {
+                console.error('Update error:', e);
+            }
+        }
+
+        function renderStats(stats) {
+            // Top cards
+            document.getElementById('total').textContent = stats.totalRequests;
+            document.getElementById('success').textContent = stats.successfulRequests;
+            document.getElementById('failed').textContent = stats.failedRequests;
+            document.getElementById('avgtime').textContent = Math.round(stats.averageResponseTime) + 'ms';
+            document.getElementById('homeviews').textContent = stats.homePageViews;
+
+            // API Stats
+            document.getElementById('api-calls').textContent = stats.apiCallsCount || 0;
+            document.getElementById('models-calls').textContent = stats.modelsCallsCount || 0;
+            document.getElementById('streaming').textContent = stats.streamingRequests || 0;
+            document.getElementById('non-streaming').textContent = stats.nonStreamingRequests || 0;
+
+            // Performance Stats
+            document.getElementById('avg-time-detail').textContent = Math.round(stats.averageResponseTime) + 'ms';
+            document.getElementById('fastest').textContent = stats.fastestResponse === -1 ? '-' : Math.round(stats.fastestResponse) + 'ms';
+            document.getElementById('slowest').textContent = stats.slowestResponse === 0 ? '-' : Math.round(stats.slowestResponse) + 'ms';
+            const successRate = stats.totalRequests > 0 ? ((stats.successfulRequests / stats.totalRequests) * 100).toFixed(1) : '0';
+            document.getElementById('success-rate').textContent = successRate + '%';
+
+            // System Info
+            const uptime = Date.now() - new Date(stats.startTime).getTime();
+            const hours = Math.floor(uptime / 3600000);
+            const minutes = Math.floor((uptime % 3600000) / 60000);
+            document.getElementById('uptime').textContent = hours + 'h ' + minutes + 'm';
+            document.getElementById('tokens').textContent = (stats.totalTokensUsed || 0).toLocaleString();
+            document.getElementById('last-request').textContent = stats.lastRequestTime ? new Date(stats.lastRequestTime).toLocaleTimeString() : '-';
+            document.getElementById('home-visits').textContent = stats.homePageViews;
+
+            // Top Models
+            const topModelsDiv = document.getElementById('top-models');
+            if (stats.topModels && stats.topModels.length > 0) {
+                topModelsDiv.innerHTML = stats.topModels.map((m, i) => ` + "`" + `
+                    <div class="flex items-center justify-between">
+                        <div class="flex items-center gap-2">
+                            <span class="text-lg">${i === 0 ? '🥇' : i === 1 ? '🥈' : '🥉'}</span>
+                            <span class="font-mono text-sm text-gray-700">${m.model}</span>
                         </div>
-                    ` + "`" + `).join('');
-                } else {
-                    topModelsDiv.innerHTML = '<p class="text-gray-500 text-sm">暂无数据</p>';
-                }
+                        <span class="font-bold text-purple-600">${m.count}</span>
+                    </div>
+                ` + "`" + `).join('');
+            } else {
+                topModelsDiv.innerHTML = '<p class="text-gray-500 text-sm">暂无数据</p>';
+            }
+        }
 
-                // Fetch paginated requests
-                const reqsRes = await fetch(` + "`" + `/dashboard/requests?page=${currentPage}&pageSize=${pageSize}` + "`" + `);
-                const data = await reqsRes.json();
-                const tbody = document.getElementById('requests');
-                const empty = document.getElementById('empty');
+        async function updateRequests() {
+            // Fetch paginated requests
+            const reqsRes = await fetch(` + "`" + `/dashboard/requests?page=${currentPage}&pageSize=${pageSize}` + "`" + `);
+            const data = await reqsRes.json();
+            const tbody = document.getElementById('requests');
+            const empty = document.getElementById('empty');
 
-                tbody.innerHTML = '';
+            tbody.innerHTML = '';
 
-                if (data.requests.length === 0) {
-                    empty.classList.remove('hidden');
-                } else {
-                    empty.classList.add('hidden');
-                    data.requests.forEach(r => {
-                        const row = tbody.insertRow();
-                        const time = new Date(r.timestamp).toLocaleTimeString();
-                        const statusClass = r.status >= 200 && r.status < 300 ? 'text-green-600 bg-green-50' : 'text-red-600 bg-red-50';
-                        const modelDisplay = r.model ? r.model : '-';
-
-                        row.innerHTML = ` + "`" + `
-                            <td class="py-3 px-4 text-gray-700">${time}</td>
-                            <td class="py-3 px-4"><span class="bg-blue-100 text-blue-700 px-2 py-1 rounded text-sm font-mono">${r.method}</span></td>
-                            <td class="py-3 px-4 font-mono text-sm text-gray-600">${r.path}</td>
-                            <td class="py-3 px-4 font-mono text-xs text-gray-600">${modelDisplay}</td>
-                            <td class="py-3 px-4"><span class="${statusClass} px-2 py-1 rounded font-semibold text-sm">${r.status}</span></td>
-                            <td class="py-3 px-4 text-gray-700">${r.duration}ms</td>
-                        ` + "`" + `;
-                    });
+            if (data.requests.length === 0) {
+                empty.classList.remove('hidden');
+            } else {
+                empty.classList.add('hidden');
+                data.requests.forEach(r => renderRequestRow(tbody.insertRow(), r));
 
-                    // Update pagination info
-                    document.getElementById('total-requests').textContent = data.total;
-                    document.getElementById('current-page').textContent = data.page;
-                    document.getElementById('total-pages').textContent = data.totalPages;
+                // Update pagination info
+                document.getElementById('total-requests').textContent = data.total;
+                document.getElementById('current-page').textContent = data.page;
+                document.getElementById('total-pages').textContent = data.totalPages;
 
-                    // Enable/disable pagination buttons
-                    document.getElementById('prev-page').disabled = data.page <= 1;
-                    document.getElementById('next-page').disabled = data.page >= data.totalPages;
-                }
+                // Enable/disable pagination buttons
+                document.getElementById('prev-page').disabled = data.page <= 1;
+                document.getElementById('next-page').disabled = data.page >= data.totalPages;
+            }
+        }
+
+        function renderRequestRow(row, r) {
+            const time = new Date(r.timestamp).toLocaleTimeString();
+            const statusClass = r.status >= 200 && r.status < 300 ? 'text-green-600 bg-green-50' : 'text-red-600 bg-red-50';
+            const modelDisplay = r.model ? r.model : '-';
+
+            row.innerHTML = ` + "`" + `
+                <td class="py-3 px-4 text-gray-700">${time}</td>
+                <td class="py-3 px-4"><span class="bg-blue-100 text-blue-700 px-2 py-1 rounded text-sm font-mono">${r.method}</span></td>
+                <td class="py-3 px-4 font-mono text-sm text-gray-600">${r.path}</td>
+                <td class="py-3 px-4 font-mono text-xs text-gray-600">${modelDisplay}</td>
+                <td class="py-3 px-4"><span class="${statusClass} px-2 py-1 rounded font-semibold text-sm">${r.status}</span></td>
+                <td class="py-3 px-4 text-gray-700">${r.duration}ms</td>
+            ` + "`" + `;
+        }
+
+        function escapeHtml(s) {
+            return String(s == null ? '' : s).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+        }
+
+        function formatBytes(n) {
+            if (n < 1024) return n + ' B';
+            if (n < 1024 * 1024) return (n / 1024).toFixed(1) + ' KB';
+            return (n / 1024 / 1024).toFixed(1) + ' MB';
+        }
+
+        async function updateInflight() {
+            try {
+                const res = await fetch('/dashboard/inflight');
+                const data = await res.json();
+                const tbody = document.getElementById('inflight');
+                document.getElementById('inflight-total').textContent = data.total;
+                document.getElementById('inflight-empty').classList.toggle('hidden', data.total > 0);
+                tbody.innerHTML = data.requests.map(r => {
+                    const phaseClass = r.phase === 'thinking' ? 'bg-yellow-100 text-yellow-700' : r.phase === 'answer' ? 'bg-green-100 text-green-700' : 'bg-gray-100 text-gray-700';
+                    const action = r.cancelled
+                        ? '<span class="text-gray-500">取消中</span>'
+                        : '<button class="text-red-600 hover:underline" onclick="cancelRequest(\'' + escapeHtml(r.id) + '\')">取消</button>';
+                    return '<tr>' +
+                        '<td class="py-2 px-3 text-gray-700">' + new Date(r.startTime).toLocaleTimeString() + '</td>' +
+                        '<td class="py-2 px-3 text-gray-600">' + escapeHtml(r.source) + (r.stream ? ' · 流式' : '') + '</td>' +
+                        '<td class="py-2 px-3 font-mono text-xs text-gray-600">' + escapeHtml(r.model || '-') + '</td>' +
+                        '<td class="py-2 px-3 text-gray-600">' + escapeHtml(r.apiKeyName || r.apiKeyId || '-') + '</td>' +
+                        '<td class="py-2 px-3 font-mono text-xs text-gray-600">' + escapeHtml(r.token || '-') + '</td>' +
+                        '<td class="py-2 px-3"><span class="' + phaseClass + ' px-2 py-1 rounded text-xs">' + escapeHtml(r.phase) + '</span></td>' +
+                        '<td class="py-2 px-3 text-gray-700">' + formatBytes(r.bytesStreamed) + '</td>' +
+                        '<td class="py-2 px-3 text-gray-700">' + (r.duration / 1000).toFixed(1) + 's</td>' +
+                        '<td class="py-2 px-3">' + action + '</td>' +
+                        '</tr>';
+                }).join('');
             } catch (e) {
-                console.error('Update error:', e);
+                console.error('Inflight update error:', e);
             }
         }
 
+        async function cancelRequest(id) {
+            if (!confirm('确定要取消这个请求吗？客户端会收到错误，上游连接也会断开。')) return;
+            const res = await fetch('/admin/api/requests/' + encodeURIComponent(id) + '/cancel', { method: 'POST' });
+            const data = await res.json().catch(() => ({}));
+            if (res.status === 401) {
+                alert('需要先登录管理后台（/admin/login）');
+            } else if (!data.success) {
+                alert('取消失败: ' + (data.error || res.status));
+            }
+            updateInflight();
+        }
+
+        // 实时推送：新请求、统计变化和告警通过 /dashboard/stream 推送，断线时退回轮询
+        let streamConnected = false;
+
+        function prependRequest(r) {
+            const total = parseInt(document.getElementById('total-requests').textContent || '0') + 1;
+            document.getElementById('total-requests').textContent = total;
+            document.getElementById('total-pages').textContent = Math.max(1, Math.ceil(total / pageSize));
+            document.getElementById('next-page').disabled = currentPage >= Math.ceil(total / pageSize);
+            if (currentPage !== 1) return;
+
+            const tbody = document.getElementById('requests');
+            document.getElementById('empty').classList.add('hidden');
+            renderRequestRow(tbody.insertRow(0), r);
+            while (tbody.rows.length > pageSize) {
+                tbody.deleteRow(tbody.rows.length - 1);
+            }
+        }
+
+        function showAlert(alert) {
+            const container = document.getElementById('alerts');
+            const item = document.createElement('div');
+            const color = alert.level === 'error' ? 'bg-red-50 border-red-300 text-red-700' : 'bg-yellow-50 border-yellow-300 text-yellow-800';
+            item.className = 'border rounded-lg px-4 py-3 shadow-sm flex items-start justify-between gap-4 ' + color;
+            const text = document.createElement('span');
+            text.textContent = (alert.level === 'error' ? '🚨 ' : '⚠️ ') + new Date(alert.timestamp).toLocaleTimeString() + ' ' + alert.message;
+            const close = document.createElement('button');
+            close.textContent = '×';
+            close.className = 'font-bold';
+            close.onclick = () => item.remove();
+            item.appendChild(text);
+            item.appendChild(close);
+            container.prepend(item);
+            while (container.children.length > 5) {
+                container.lastChild.remove();
+            }
+        }
+
+        function connectStream() {
+            if (!window.EventSource) return;
+            const source = new EventSource('/dashboard/stream');
+            const onSnapshot = (e) => {
+                const data = JSON.parse(e.data);
+                renderStats(data.stats);
+                updateRequests().catch(err => console.error('Update error:', err));
+            };
+            source.onopen = () => { streamConnected = true; };
+            source.onerror = () => { streamConnected = false; };
+            source.addEventListener('snapshot', onSnapshot);
+            source.addEventListener('resync', onSnapshot);
+            source.addEventListener('stats', (e) => renderStats(JSON.parse(e.data).stats));
+            source.addEventListener('request', (e) => {
+                prependRequest(JSON.parse(e.data));
+                updateInflight();
+            });
+            source.addEventListener('alert', (e) => showAlert(JSON.parse(e.data)));
+        }
+
         async function updateChartData() {
             try {
                 let endpoint, labelKey, subtitle;
@@ -1182,6 +1353,57 @@ func getDashboardHTMLNew() string {
             }
         }
 
+        const latencyLabels = {
+            ttft_ms: '首 Token',
+            upstream_header_ms: '上游响应头',
+            generation_ms: '生成耗时',
+            tokens_per_sec: 'Tokens/s'
+        };
+
+        function formatLatency(metric, p) {
+            if (!p) return '-';
+            if (metric === 'tokens_per_sec') return p.p50.toFixed(1);
+            return Math.round(p.p50) + ' / ' + Math.round(p.p95) + ' / ' + Math.round(p.p99) + 'ms';
+        }
+
+        async function updateLatency() {
+            try {
+                const res = await fetch('/dashboard/latency?hours=24');
+                const data = await res.json();
+
+                const summaryDiv = document.getElementById('latency-summary');
+                const summary = data.summary || {};
+                if (Object.keys(summary).length === 0) {
+                    summaryDiv.innerHTML = '<p class="text-gray-500 text-sm">暂无数据</p>';
+                } else {
+                    summaryDiv.innerHTML = data.metrics.map(m => {
+                        const p = summary[m];
+                        const unit = m === 'tokens_per_sec' ? '' : 'ms';
+                        const body = p
+                            ? '<div class="text-2xl font-bold text-purple-600">' + (m === 'tokens_per_sec' ? p.p50.toFixed(1) : Math.round(p.p50) + unit) + '</div>' +
+                              '<div class="text-xs text-gray-500">p95 ' + (m === 'tokens_per_sec' ? p.p95.toFixed(1) : Math.round(p.p95) + unit) +
+                              ' · p99 ' + (m === 'tokens_per_sec' ? p.p99.toFixed(1) : Math.round(p.p99) + unit) + ' · ' + p.count + ' 次</div>'
+                            : '<div class="text-2xl font-bold text-gray-400">-</div>';
+                        return '<div class="bg-gray-50 rounded-lg p-4"><div class="text-sm text-gray-600 mb-1">' + latencyLabels[m] + ' p50</div>' + body + '</div>';
+                    }).join('');
+                }
+
+                const tbody = document.getElementById('latency-hourly');
+                tbody.innerHTML = (data.hourly || []).slice().reverse().map(h => {
+                    const parts = h.hour.split('-');
+                    return '<tr>' +
+                        '<td class="py-2 px-3 text-gray-700">' + parts[1] + '-' + parts[2] + ' ' + parts[3] + ':00</td>' +
+                        '<td class="py-2 px-3 font-mono text-gray-700">' + formatLatency('ttft_ms', h.metrics.ttft_ms) + '</td>' +
+                        '<td class="py-2 px-3 font-mono text-gray-700">' + formatLatency('upstream_header_ms', h.metrics.upstream_header_ms) + '</td>' +
+                        '<td class="py-2 px-3 font-mono text-gray-700">' + formatLatency('generation_ms', h.metrics.generation_ms) + '</td>' +
+                        '<td class="py-2 px-3 font-mono text-gray-700">' + formatLatency('tokens_per_sec', h.metrics.tokens_per_sec) + '</td>' +
+                        '</tr>';
+                }).join('');
+            } catch (e) {
+                console.error('Latency update error:', e);
+            }
+        }
+
         function updateChart() {
             const ctx = document.getElementById('chart').getContext('2d');
 
@@ -1267,8 +1489,13 @@ func getDashboardHTMLNew() string {
 
         update();
         updateChartData();
-        setInterval(update, 5000);
+        updateLatency();
+        updateInflight();
+        connectStream();
+        setInterval(updateInflight, 2000);
+        setInterval(() => { if (!streamConnected) update(); }, 5000);
         setInterval(updateChartData, 60000); // Update chart every minute
+        setInterval(updateLatency, 60000);
     </script>
 </body>
 </html>`
@@ -1632,6 +1859,34 @@ func getAdminPanelHTML() string {
             </div>
         </div>
 
+        <!-- 运行时设置 -->
+        <div class="bg-white rounded-2xl shadow-2xl p-6 mb-6">
+            <div class="flex items-center justify-between mb-4">
+                <h2 class="text-2xl font-bold text-gray-800">运行时设置</h2>
+                <input type="text" id="settingsReason" placeholder="修改原因（可选）"
+                    class="px-4 py-2 border-2 border-gray-200 rounded-lg focus:border-indigo-500 focus:ring focus:ring-indigo-200 transition">
+            </div>
+            <p class="text-sm text-gray-500 mb-4">修改立即生效并保存到数据库，优先于环境变量和配置文件；恢复配置后使用环境变量 / 配置文件的取值。</p>
+            <div id="settingsList" class="divide-y divide-gray-200">
+                <div class="py-4 text-center text-gray-400">加载中...</div>
+            </div>
+            <h3 class="text-lg font-semibold text-gray-800 mt-6 mb-2">修改记录</h3>
+            <div class="overflow-x-auto">
+                <table class="w-full">
+                    <thead>
+                        <tr class="bg-gray-50 text-left">
+                            <th class="px-4 py-2 text-sm font-semibold text-gray-700">时间</th>
+                            <th class="px-4 py-2 text-sm font-semibold text-gray-700">设置项</th>
+                            <th class="px-4 py-2 text-sm font-semibold text-gray-700">修改</th>
+                            <th class="px-4 py-2 text-sm font-semibold text-gray-700">来源</th>
+                            <th class="px-4 py-2 text-sm font-semibold text-gray-700">原因</th>
+                        </tr>
+                    </thead>
+                    <tbody id="settingsAuditBody" class="divide-y divide-gray-200"></tbody>
+                </table>
+            </div>
+        </div>
+
         <!-- 账号列表 -->
         <div class="bg-white rounded-2xl shadow-2xl p-6 mb-6">
             <div class="flex items-center justify-between mb-4">
@@ -2031,6 +2286,91 @@ func getAdminPanelHTML() string {
             }
         });
 
+        // 运行时设置
+        function escapeHtml(s) {
+            return $('<div>').text(s == null ? '' : String(s)).html();
+        }
+
+        function formatOverride(value) {
+            return value === null ? '跟随配置' : (value ? '开启' : '关闭');
+        }
+
+        function renderSettings(settings) {
+            const $list = $('#settingsList').empty();
+            settings.forEach(s => {
+                const $row = $('<div class="flex items-center justify-between py-3"></div>');
+                $row.append(
+                    '<div>' +
+                    '<div class="font-semibold text-gray-800">' + escapeHtml(s.description) + '</div>' +
+                    '<div class="text-xs text-gray-500">' + escapeHtml(s.key) + ' · ' + escapeHtml(s.env) +
+                    ' · 配置值: ' + (s.configured ? '开启' : '关闭') +
+                    (s.overridden ? ' · <span class="text-orange-600 font-semibold">已覆盖</span>' : '') +
+                    '</div></div>'
+                );
+                const $actions = $('<div class="flex items-center gap-3"></div>');
+                if (s.overridden) {
+                    const $reset = $('<button class="px-3 py-1 border border-gray-300 rounded-lg hover:bg-gray-50 transition text-sm">恢复配置</button>');
+                    $reset.on('click', () => saveSetting(s.key, null));
+                    $actions.append($reset);
+                }
+                const $toggle = $('<input type="checkbox" class="w-5 h-5 accent-indigo-600 cursor-pointer">').prop('checked', s.value);
+                $toggle.on('change', function() { saveSetting(s.key, $(this).prop('checked')); });
+                $actions.append($toggle);
+                $row.append($actions);
+                $list.append($row);
+            });
+        }
+
+        async function loadSettings() {
+            try {
+                const response = await fetch('/admin/api/settings');
+                const result = await response.json();
+                if (!result.success) throw new Error(result.error);
+                renderSettings(result.settings);
+                await loadSettingsAudit();
+            } catch (error) {
+                $('#settingsList').html('<div class="py-4 text-center text-red-500">加载运行时设置失败: ' + escapeHtml(error.message) + '</div>');
+            }
+        }
+
+        async function loadSettingsAudit() {
+            const response = await fetch('/admin/api/settings/audit');
+            const result = await response.json();
+            const $body = $('#settingsAuditBody').empty();
+            if (!result.success || result.records.length === 0) {
+                $body.append('<tr><td colspan="5" class="px-4 py-3 text-center text-gray-400">暂无记录</td></tr>');
+                return;
+            }
+            result.records.slice(0, 20).forEach(rec => {
+                $body.append(
+                    '<tr class="text-sm">' +
+                    '<td class="px-4 py-2 text-gray-600">' + new Date(rec.created_at * 1000).toLocaleString('zh-CN') + '</td>' +
+                    '<td class="px-4 py-2 font-mono">' + escapeHtml(rec.setting) + '</td>' +
+                    '<td class="px-4 py-2">' + formatOverride(rec.old_value) + ' → ' + formatOverride(rec.new_value) + '</td>' +
+                    '<td class="px-4 py-2 text-gray-600">' + escapeHtml(rec.client_ip) + '</td>' +
+                    '<td class="px-4 py-2 text-gray-600">' + escapeHtml(rec.reason) + '</td>' +
+                    '</tr>'
+                );
+            });
+        }
+
+        async function saveSetting(key, value) {
+            const settings = {};
+            settings[key] = value;
+            try {
+                const response = await fetch('/admin/api/settings', {
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ settings, reason: $('#settingsReason').val() })
+                });
+                const result = await response.json();
+                if (!result.success) alert('保存失败: ' + result.error);
+            } catch (error) {
+                alert('保存失败: ' + error.message);
+            }
+            await loadSettings();
+        }
+
         $('#logoutBtn').on('click', async function() {
             if (confirm('确定要退出登录吗？')) {
                 await fetch('/admin/api/logout', { method: 'POST' });
@@ -2041,7 +2381,321 @@ func getAdminPanelHTML() string {
 
         $(document).ready(function() {
             loadAccounts();
+            loadSettings();
+        });
+    </script>
+</body>
+</html>`
+}
+
+// 请求日志页面（数据来自 /admin/api/journal，需要管理员登录）
+func getJournalHTML() string {
+	return `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>请求日志 - ZtoApi</title>
+    <script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-gray-50">
+    <nav class="bg-white shadow-sm border-b">
+        <div class="container mx-auto px-4 py-4">
+            <div class="flex items-center justify-between">
+                <a href="/" class="flex items-center space-x-2 text-purple-600 hover:text-purple-700 transition">
+                    <span class="text-2xl">🦕</span>
+                    <span class="text-xl font-bold">ZtoApi</span>
+                </a>
+                <div class="flex space-x-4">
+                    <a href="/" class="text-gray-600 hover:text-purple-600 transition">首页</a>
+                    <a href="/docs" class="text-gray-600 hover:text-purple-600 transition">文档</a>
+                    <a href="/playground" class="text-gray-600 hover:text-purple-600 transition">Playground</a>
+                    <a href="/deploy" class="text-gray-600 hover:text-purple-600 transition">部署</a>
+                    <a href="/dashboard" class="text-gray-600 hover:text-purple-600 transition">Dashboard</a>
+                    <a href="/dashboard/journal" class="text-purple-600 font-semibold">请求日志</a>
+                </div>
+            </div>
+        </div>
+    </nav>
+
+    <div class="container mx-auto px-4 py-8 max-w-7xl">
+        <div class="text-center mb-8">
+            <h1 class="text-4xl font-bold text-gray-900 mb-3">📜 请求日志</h1>
+            <p class="text-gray-600">搜索和查看历史对话（需要管理员登录）</p>
+        </div>
+
+        <div id="login-required" class="hidden bg-yellow-50 border border-yellow-300 text-yellow-800 rounded-lg px-4 py-3 mb-6">
+            请先 <a href="/admin/login" class="underline font-semibold">登录管理后台</a> 后刷新本页面。
+        </div>
+
+        <!-- Search -->
+        <form id="search-form" class="bg-white rounded-xl shadow-sm border p-4 mb-6 grid grid-cols-1 md:grid-cols-7 gap-3">
+            <input id="q" type="text" placeholder="搜索消息、回答和思考内容" class="md:col-span-2 px-3 py-2 border rounded">
+            <input id="request_id" type="text" placeholder="请求 ID" class="px-3 py-2 border rounded">
+            <input id="model" type="text" placeholder="模型" class="px-3 py-2 border rounded">
+            <select id="status" class="px-3 py-2 border rounded">
+                <option value="">全部状态</option>
+                <option value="2xx">2xx</option>
+                <option value="4xx">4xx</option>
+                <option value="5xx">5xx</option>
+            </select>
+            <select id="source" class="px-3 py-2 border rounded">
+                <option value="">全部来源</option>
+                <option value="API">API</option>
+                <option value="BATCH">BATCH</option>
+                <option value="BACKGROUND">BACKGROUND</option>
+                <option value="REPLAY">REPLAY</option>
+            </select>
+            <button type="submit" class="px-4 py-2 bg-purple-600 hover:bg-purple-700 text-white rounded">搜索</button>
+        </form>
+
+        <div class="grid grid-cols-1 lg:grid-cols-5 gap-6">
+            <!-- Results -->
+            <div class="lg:col-span-2 bg-white rounded-xl shadow-sm border p-4">
+                <div class="flex items-center justify-between mb-3 text-sm text-gray-600">
+                    <span>共 <span id="total">0</span> 条</span>
+                    <div class="flex items-center gap-2">
+                        <button id="prev-page" class="px-2 py-1 bg-gray-200 hover:bg-gray-300 rounded disabled:opacity-50">上一页</button>
+                        <span id="page">1</span>
+                        <button id="next-page" class="px-2 py-1 bg-gray-200 hover:bg-gray-300 rounded disabled:opacity-50">下一页</button>
+                    </div>
+                </div>
+                <div id="results" class="divide-y"></div>
+                <div id="empty" class="text-center py-8 text-gray-500 hidden">没有匹配的记录</div>
+            </div>
+
+            <!-- Detail -->
+            <div class="lg:col-span-3 bg-white rounded-xl shadow-sm border p-6">
+                <div id="detail" class="text-gray-500 text-sm">选择左侧的一条记录查看完整对话</div>
+            </div>
+        </div>
+    </div>
+
+    <script>
+        let currentPage = 1;
+        const pageSize = 20;
+
+        function escapeHtml(s) {
+            return String(s == null ? '' : s).replace(/[&<>"']/g, c => ({ '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' }[c]));
+        }
+
+        function highlight(text, q) {
+            const escaped = escapeHtml(text);
+            if (!q) return escaped;
+            const needle = escapeHtml(q).replace(/[.*+?^${}()|[\]\\]/g, '\\$&');
+            return escaped.replace(new RegExp(needle, 'gi'), m => '<mark>' + m + '</mark>');
+        }
+
+        async function fetchJournal(url, options) {
+            const res = await fetch(url, options);
+            if (res.status === 401) {
+                document.getElementById('login-required').classList.remove('hidden');
+                throw new Error('unauthorized');
+            }
+            const data = await res.json();
+            if (!data.success) throw new Error(data.error || res.status);
+            return data;
+        }
+
+        async function search() {
+            const q = document.getElementById('q').value.trim();
+            const params = new URLSearchParams({ page: currentPage, pageSize: pageSize });
+            if (q) params.set('q', q);
+            for (const name of ['request_id', 'model', 'status', 'source']) {
+                const value = document.getElementById(name).value.trim();
+                if (value) params.set(name, value);
+            }
+
+            let data;
+            try {
+                data = await fetchJournal('/admin/api/journal?' + params.toString());
+            } catch (e) {
+                if (e.message !== 'unauthorized') alert('搜索失败: ' + e.message);
+                return;
+            }
+
+            document.getElementById('total').textContent = data.total;
+            document.getElementById('page').textContent = data.page;
+            document.getElementById('prev-page').disabled = data.page <= 1;
+            document.getElementById('next-page').disabled = data.page * data.pageSize >= data.total;
+            document.getElementById('empty').classList.toggle('hidden', data.records.length > 0);
+            document.getElementById('results').innerHTML = data.records.map(r => {
+                const statusClass = r.status >= 200 && r.status < 300 ? 'text-green-600' : 'text-red-600';
+                return '<div class="py-3 cursor-pointer hover:bg-gray-50 px-2" onclick="showRecord(\'' + escapeHtml(r.id) + '\')">' +
+                    '<div class="flex items-center justify-between text-xs text-gray-500 mb-1">' +
+                    '<span>' + new Date(r.createdAt).toLocaleString() + ' · ' + escapeHtml(r.source) + ' · ' + escapeHtml(r.model || '-') + '</span>' +
+                    '<span class="' + statusClass + ' font-semibold">' + r.status + ' · ' + r.duration + 'ms</span>' +
+                    '</div>' +
+                    '<div class="text-sm text-gray-800 break-all">' + highlight(r.snippet || '', q) + '</div>' +
+                    '</div>';
+            }).join('');
+        }
+
+        async function showRecord(id) {
+            let data;
+            try {
+                data = await fetchJournal('/admin/api/journal/' + encodeURIComponent(id));
+            } catch (e) {
+                if (e.message !== 'unauthorized') alert('加载失败: ' + e.message);
+                return;
+            }
+            const r = data.record;
+            const q = document.getElementById('q').value.trim();
+            const params = r.params || {};
+            const meta = [
+                ['记录 ID', r.id], ['请求 ID', r.requestId], ['时间', new Date(r.createdAt).toLocaleString()], ['来源', r.source],
+                ['模型', r.model], ['流式', r.stream ? '是' : '否'], ['状态', r.status], ['耗时', r.duration + 'ms'],
+                ['客户端 Key', r.apiKeyId], ['Token ID', r.tokenId], ['会话', r.conversationId],
+                ['客户端 IP', r.clientIp], ['User-Agent', r.userAgent],
+                ['上游模型', params.upstreamModel], ['思考', r.params ? (params.enableThinking ? '开启' : '关闭') : undefined],
+                ['前端版本', params.feVersion]
+            ].filter(m => m[1] !== undefined && m[1] !== '');
+
+            let html = '<div class="flex items-center justify-between mb-4">' +
+                '<h2 class="text-xl font-bold text-gray-900">对话详情</h2>' +
+                '<button class="text-red-600 hover:underline text-sm" onclick="deleteRecord(\'' + escapeHtml(r.id) + '\')">删除</button>' +
+                '</div>' +
+                '<dl class="grid grid-cols-2 md:grid-cols-3 gap-2 text-xs mb-6">' +
+                meta.map(m => '<div><dt class="text-gray-500">' + m[0] + '</dt><dd class="font-mono text-gray-800 break-all">' + escapeHtml(m[1]) + '</dd></div>').join('') +
+                '</dl>';
+            if (r.error) {
+                html += '<div class="bg-red-50 border border-red-200 text-red-700 rounded px-3 py-2 text-sm mb-4">' + escapeHtml(r.error) + '</div>';
+            }
+            html += '<div class="space-y-3">';
+            for (const m of (r.messages || [])) {
+                const color = m.role === 'user' ? 'bg-purple-50 border-purple-200' : m.role === 'system' ? 'bg-gray-50 border-gray-200' : 'bg-blue-50 border-blue-200';
+                html += '<div class="border rounded-lg p-3 ' + color + '"><div class="text-xs text-gray-500 mb-1">' + escapeHtml(m.role) + '</div>' +
+                    '<div class="text-sm whitespace-pre-wrap break-words">' + highlight(m.content, q) + '</div></div>';
+            }
+            if (r.thinking) {
+                html += '<details class="border rounded-lg p-3 bg-yellow-50 border-yellow-200"><summary class="text-xs text-gray-600 cursor-pointer">思考过程</summary>' +
+                    '<div class="text-sm whitespace-pre-wrap break-words mt-2">' + highlight(r.thinking, q) + '</div></details>';
+            }
+            html += '<div class="border rounded-lg p-3 bg-green-50 border-green-200"><div class="text-xs text-gray-500 mb-1">assistant（回答）</div>' +
+                '<div class="text-sm whitespace-pre-wrap break-words">' + (r.content ? highlight(r.content, q) : '<span class="text-gray-400">（无）</span>') + '</div></div>';
+            html += '</div>';
+
+            html += '<div class="mt-6 border-t pt-4">' +
+                '<h3 class="text-lg font-semibold text-gray-900 mb-3">🔁 重放</h3>' +
+                '<div class="grid grid-cols-1 md:grid-cols-3 gap-3 text-sm mb-3">' +
+                '<input id="replay-model" type="text" placeholder="上游模型（默认 ' + escapeHtml(params.upstreamModel || '沿用') + '）" class="px-3 py-2 border rounded">' +
+                '<select id="replay-thinking" class="px-3 py-2 border rounded">' +
+                '<option value="">思考：沿用原设置</option><option value="true">思考：开启</option><option value="false">思考：关闭</option>' +
+                '</select>' +
+                '<input id="replay-token" type="password" placeholder="上游 token（默认自动获取）" class="px-3 py-2 border rounded">' +
+                '<textarea id="replay-params" rows="2" placeholder="上游 params（JSON，默认沿用）" class="md:col-span-3 px-3 py-2 border rounded font-mono"></textarea>' +
+                '</div>' +
+                '<button id="replay-button" class="px-4 py-2 bg-purple-600 hover:bg-purple-700 text-white rounded disabled:opacity-50" onclick="replayRecord(\'' + escapeHtml(r.id) + '\')">重放</button>' +
+                '<div id="replay-result" class="mt-4"></div>' +
+                '</div>';
+            document.getElementById('detail').innerHTML = html;
+        }
+
+        async function replayRecord(id) {
+            const body = {};
+            const model = document.getElementById('replay-model').value.trim();
+            if (model) body.model = model;
+            const thinking = document.getElementById('replay-thinking').value;
+            if (thinking) body.enable_thinking = thinking === 'true';
+            const token = document.getElementById('replay-token').value.trim();
+            if (token) body.token = token;
+            const params = document.getElementById('replay-params').value.trim();
+            if (params) {
+                try {
+                    body.params = JSON.parse(params);
+                } catch (e) {
+                    alert('params 不是有效的 JSON');
+                    return;
+                }
+            }
+
+            const button = document.getElementById('replay-button');
+            const result = document.getElementById('replay-result');
+            button.disabled = true;
+            result.innerHTML = '<div class="text-sm text-gray-500">重放中...</div>';
+            let data;
+            try {
+                data = await fetchJournal('/admin/api/journal/' + encodeURIComponent(id) + '/replay', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify(body)
+                });
+            } catch (e) {
+                result.innerHTML = '';
+                if (e.message !== 'unauthorized') alert('重放失败: ' + e.message);
+                return;
+            } finally {
+                button.disabled = false;
+            }
+            result.innerHTML = renderReplay(data);
+        }
+
+        // 一侧的差异：原请求显示相同和删除的部分，重放显示相同和新增的部分
+        function renderDiffSide(ops, side) {
+            return ops.map(op => {
+                if (op.op === 'equal') return escapeHtml(op.text);
+                if (side === 'old' && op.op === 'delete') return '<del class="bg-red-100 text-red-800">' + escapeHtml(op.text) + '</del>';
+                if (side === 'new' && op.op === 'insert') return '<ins class="bg-green-100 text-green-800 no-underline">' + escapeHtml(op.text) + '</ins>';
+                return '';
+            }).join('');
+        }
+
+        function renderReplay(data) {
+            let html = '<div class="text-sm mb-3 ' + (data.identical ? 'text-green-600' : 'text-orange-600') + '">' +
+                (data.identical ? '✅ 回答与原请求完全一致' : '⚠️ 回答与原请求不同（红色为原请求独有，绿色为重放独有）') + '</div>';
+            html += '<div class="grid grid-cols-1 md:grid-cols-2 gap-4">';
+            for (const [title, r, side] of [['原请求', data.original, 'old'], ['重放', data.replay, 'new']]) {
+                const statusClass = r.status >= 200 && r.status < 300 ? 'text-green-600' : 'text-red-600';
+                html += '<div class="border rounded-lg p-3">' +
+                    '<div class="flex justify-between text-xs text-gray-500 mb-2">' +
+                    '<span class="font-semibold text-gray-800">' + title + '</span>' +
+                    '<span class="' + statusClass + ' font-semibold">' + r.status + ' · ' + r.duration + 'ms</span>' +
+                    '</div>' +
+                    '<div class="text-xs text-gray-500 mb-2 font-mono break-all">' +
+                    escapeHtml([r.model || '-', '思考: ' + (r.enableThinking ? '开启' : '关闭'), r.feVersion || '前端版本未知', 'token ' + (r.tokenId || '-')].join(' · ')) +
+                    '</div>';
+                if (r.error) {
+                    html += '<div class="bg-red-50 border border-red-200 text-red-700 rounded px-2 py-1 text-xs mb-2">' + escapeHtml(r.error) + '</div>';
+                }
+                if (data.diff.thinking.length) {
+                    html += '<details class="mb-2"><summary class="text-xs text-gray-600 cursor-pointer">思考过程</summary>' +
+                        '<div class="text-sm whitespace-pre-wrap break-words mt-1">' + renderDiffSide(data.diff.thinking, side) + '</div></details>';
+                }
+                html += '<div class="text-sm whitespace-pre-wrap break-words">' +
+                    (renderDiffSide(data.diff.content, side) || '<span class="text-gray-400">（无）</span>') + '</div>' +
+                    '</div>';
+            }
+            html += '</div>';
+            html += '<div class="text-xs text-gray-500 mt-2">重放记录 ID: <span class="font-mono">' + escapeHtml(data.replay.id) + '</span></div>';
+            return html;
+        }
+
+        async function deleteRecord(id) {
+            if (!confirm('确定要删除这条记录吗？')) return;
+            try {
+                await fetchJournal('/admin/api/journal/' + encodeURIComponent(id), { method: 'DELETE' });
+            } catch (e) {
+                if (e.message !== 'unauthorized') alert('删除失败: ' + e.message);
+                return;
+            }
+            document.getElementById('detail').innerHTML = '记录已删除';
+            search();
+        }
+
+        document.getElementById('search-form').addEventListener('submit', (e) => {
+            e.preventDefault();
+            currentPage = 1;
+            search();
+        });
+        document.getElementById('prev-page').addEventListener('click', () => {
+            if (currentPage > 1) { currentPage--; search(); }
         });
+        document.getElementById('next-page').addEventListener('click', () => {
+            currentPage++;
+            search();
+        });
+
+        search();
     </script>
 </body>
 </html>`
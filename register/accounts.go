@@ -2,6 +2,7 @@ package register
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -13,9 +14,16 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 正在执行的检测/补充APIKEY任务数
+var (
+	runningCheckTasks   int32
+	runningRefetchTasks int32
+)
+
 // PushPlus通知
 func sendNotification(title, content string, config RegisterConfig) {
 	if !config.EnableNotification || config.PushPlusToken == "" {
@@ -518,6 +526,11 @@ func BatchRegisterAccounts(count int, config RegisterConfig, logChan chan<- stri
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
+			// 用户停止或服务关闭后，排队中的账号不再处理
+			if currentTask.ShouldStop {
+				return
+			}
+
 			email := generateEmail()
 			password := generatePassword()
 			emailCheckURL := fmt.Sprintf("https://mail.chatgpt.org.uk/api/get-emails?email=%s", email)
@@ -707,6 +720,21 @@ func GetRandomToken() (string, error) {
 	return token, nil
 }
 
+// 统计可用于 API 请求的 token 数（与 GetRandomToken 的条件一致）
+func CountAvailableTokens(ctx context.Context) (int, error) {
+	if db == nil {
+		return 0, fmt.Errorf("数据库未初始化")
+	}
+
+	var count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM accounts
+		WHERE token IS NOT NULL AND token != ''
+		AND status = 'active'
+	`).Scan(&count)
+	return count, err
+}
+
 // 删除账号
 func DeleteAccount(email string) error {
 	_, err := db.Exec("DELETE FROM accounts WHERE email = ?", email)
@@ -743,8 +771,43 @@ func GetCurrentTask() *RegisterTask {
 	return currentTask
 }
 
+// 获取正在执行的批量检测、批量补充APIKEY任务数
+func GetRunningTaskCounts() (checks, refetches int) {
+	return int(atomic.LoadInt32(&runningCheckTasks)), int(atomic.LoadInt32(&runningRefetchTasks))
+}
+
+// 按状态统计账号数
+func CountAccountsByStatus() (map[string]int64, error) {
+	if db == nil {
+		return nil, fmt.Errorf("数据库未初始化")
+	}
+
+	rows, err := db.Query("SELECT COALESCE(status, 'unknown'), COUNT(*) FROM accounts GROUP BY status")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		if status == "" {
+			status = "unknown"
+		}
+		counts[status] += count
+	}
+	return counts, nil
+}
+
 // 批量补充APIKEY（为无APIKEY的账号获取）
 func BatchRefetchAPIKEY(emails []string, config RegisterConfig, logChan chan<- string) (int, int) {
+	atomic.AddInt32(&runningRefetchTasks, 1)
+	defer atomic.AddInt32(&runningRefetchTasks, -1)
+
 	success := 0
 	failed := 0
 
@@ -761,6 +824,11 @@ func BatchRefetchAPIKEY(emails []string, config RegisterConfig, logChan chan<- s
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
+			// 服务关闭后，排队中的账号不再处理
+			if stopping.Load() {
+				return
+			}
+
 			// 获取账号Token
 			var token string
 			err := db.QueryRow("SELECT token FROM accounts WHERE email = ?", email).Scan(&token)
@@ -808,6 +876,9 @@ func BatchRefetchAPIKEY(emails []string, config RegisterConfig, logChan chan<- s
 
 // 批量检测账号存活性
 func BatchCheckAccounts(emails []string, logChan chan<- string) (int, int) {
+	atomic.AddInt32(&runningCheckTasks, 1)
+	defer atomic.AddInt32(&runningCheckTasks, -1)
+
 	active := 0
 	inactive := 0
 
@@ -824,6 +895,11 @@ func BatchCheckAccounts(emails []string, logChan chan<- string) (int, int) {
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
+			// 服务关闭后，排队中的账号不再处理
+			if stopping.Load() {
+				return
+			}
+
 			// 获取账号Token
 			var token string
 			err := db.QueryRow("SELECT token FROM accounts WHERE email = ?", email).Scan(&token)
@@ -916,3 +992,51 @@ func UpdateAccountAPIKEY(email, apikey string) error {
 	`, apikey, email)
 	return err
 }
+
+// ImportAccounts 导入账号（每行格式: email----password----token----apikey，apikey 可省略），
+// 返回导入成功和失败（格式错误或邮箱已存在）的数量
+func ImportAccounts(content string) (imported, failed int) {
+	lines := strings.Split(content, "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		parts := strings.Split(line, "----")
+		if len(parts) < 3 {
+			failed++
+			continue
+		}
+
+		account := &Account{
+			Email:     strings.TrimSpace(parts[0]),
+			Password:  strings.TrimSpace(parts[1]),
+			Token:     strings.TrimSpace(parts[2]),
+			Status:    "unknown",
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		}
+
+		if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
+			account.APIKEY = strings.TrimSpace(parts[3])
+		}
+
+		if err := SaveAccount(account); err != nil {
+			failed++
+		} else {
+			imported++
+		}
+	}
+	return imported, failed
+}
+
+// WriteAccounts 按导入格式写出账号，每行一个
+func WriteAccounts(w io.Writer, accounts []Account) error {
+	for _, acc := range accounts {
+		if _, err := fmt.Fprintf(w, "%s----%s----%s----%s\n", acc.Email, acc.Password, acc.Token, acc.APIKEY); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 )
 
@@ -233,9 +232,7 @@ func HandleExportAccounts(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.Header().Set("Content-Disposition", "attachment; filename=zai_accounts.txt")
 
-	for _, acc := range accounts {
-		fmt.Fprintf(w, "%s----%s----%s----%s\n", acc.Email, acc.Password, acc.Token, acc.APIKEY)
-	}
+	WriteAccounts(w, accounts)
 }
 
 // 开始注册API处理器
@@ -349,6 +346,8 @@ func HandleRegisterStream(w http.ResponseWriter, r *http.Request) {
 		select {
 		case <-r.Context().Done():
 			return
+		case <-stoppingCh:
+			return
 		case msg := <-client:
 			fmt.Fprintf(w, "data: %s\n\n", msg)
 			flusher.Flush()
@@ -386,42 +385,7 @@ func HandleImportAccounts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 解析账号数据（格式: email----password----token----apikey）
-	lines := strings.Split(string(content), "\n")
-	imported := 0
-	failed := 0
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-
-		parts := strings.Split(line, "----")
-		if len(parts) < 3 {
-			failed++
-			continue
-		}
-
-		account := &Account{
-			Email:     strings.TrimSpace(parts[0]),
-			Password:  strings.TrimSpace(parts[1]),
-			Token:     strings.TrimSpace(parts[2]),
-			Status:    "unknown",
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
-		}
-
-		if len(parts) > 3 && strings.TrimSpace(parts[3]) != "" {
-			account.APIKEY = strings.TrimSpace(parts[3])
-		}
-
-		if err := SaveAccount(account); err != nil {
-			failed++
-		} else {
-			imported++
-		}
-	}
+	imported, failed := ImportAccounts(string(content))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
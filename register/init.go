@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 
@@ -49,13 +48,11 @@ func InitRegisterSystem(dbPath string) error {
 
 	// 加载注册配置
 	if err := LoadConfig(); err != nil {
-		log.Printf("⚠️ 加载配置失败，使用默认配置: %v", err)
+		logger.Warn("⚠️ 加载配置失败，使用默认配置", "error", err)
 		currentConfig = DefaultConfig
 	}
 
-	log.Printf("✅ 注册系统初始化成功")
-	log.Printf("   - 数据库: %s", dbPath)
-	log.Printf("   - 管理账号: %s", authUsername)
+	logger.Info("✅ 注册系统初始化成功", "db", dbPath, "admin", authUsername)
 
 	return nil
 }
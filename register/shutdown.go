@@ -0,0 +1,59 @@
+package register
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// 服务关闭中：批量任务不再开始新的账号，SSE 连接断开
+	stopping     atomic.Bool
+	stoppingCh   = make(chan struct{})
+	stoppingOnce sync.Once
+)
+
+// StopTasks 服务关闭时调用：停止批量注册、检测和补充 APIKEY 任务（进行中的账号会处理完），断开实时日志连接
+func StopTasks() {
+	stoppingOnce.Do(func() {
+		stopping.Store(true)
+		close(stoppingCh)
+		if task := GetCurrentTask(); task != nil && task.IsRunning {
+			StopCurrentTask()
+			BroadcastLog("warning", "⏹️ 服务关闭，停止注册")
+		}
+	})
+}
+
+// 任务是否仍在运行
+func tasksRunning() bool {
+	task := GetCurrentTask()
+	checks, refetches := GetRunningTaskCounts()
+	return (task != nil && task.IsRunning) || checks > 0 || refetches > 0
+}
+
+// Shutdown 等待进行中的任务结束（最长到 ctx 截止）并关闭数据库
+func Shutdown(ctx context.Context) error {
+	StopTasks()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for tasksRunning() {
+		select {
+		case <-ctx.Done():
+			checks, refetches := GetRunningTaskCounts()
+			task := GetCurrentTask()
+			logger.Warn("⚠️ 等待注册任务超时，任务被中断", "register_running", task != nil && task.IsRunning,
+				"check_tasks", checks, "refetch_tasks", refetches)
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	if db != nil {
+		return db.Close()
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"sync"
 	"time"
+
+	"github.com/hulisang/ZtoApi/logging"
 )
 
 var (
@@ -12,14 +14,17 @@ var (
 	sseClientMutex  sync.RWMutex
 	logHistory      = make([]map[string]interface{}, 0, 100)
 	logHistoryMutex sync.RWMutex
+
+	logger = logging.For("register")
 )
 
-// BroadcastLog 广播日志到所有SSE客户端
+// BroadcastLog 广播日志到所有SSE客户端（消息经过脱敏）
 func BroadcastLog(level, message string) {
+	logger.Debug(message, "level", level)
 	logEntry := map[string]interface{}{
 		"type":    "log",
 		"level":   level,
-		"message": message,
+		"message": logging.Redact(message),
 		"time":    time.Now().Format("15:04:05"),
 	}
 
@@ -50,10 +55,11 @@ func BroadcastLog(level, message string) {
 
 // BroadcastLogWithLink 广播带链接的日志到所有SSE客户端
 func BroadcastLogWithLink(level, message, linkText, linkURL string) {
+	logger.Debug(message, "level", level)
 	logEntry := map[string]interface{}{
 		"type":    "log",
 		"level":   level,
-		"message": message,
+		"message": logging.Redact(message),
 		"time":    time.Now().Format("15:04:05"),
 		"link": map[string]string{
 			"text": linkText,
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hulisang/ZtoApi/logging"
+)
+
+// ==================== 请求重放 ====================
+//
+// POST /admin/api/journal/{id}/replay 用请求日志中记录的消息（含会话历史）重新调用一次上游，
+// 返回新旧回答及差异，用于排查 X_FE_VERSION 或签名逻辑变更后的上游回归。
+// 请求体中的覆盖项都可以省略，省略时沿用原请求：
+//   - model: 上游模型 ID
+//   - enable_thinking: 是否启用思考
+//   - token: 上游 token；日志只保存 token 的哈希，省略时按统一逻辑重新获取
+//   - params: 上游请求的 params 对象
+//
+// 重放以 REPLAY 来源登记为进行中的请求（可取消）并写入请求日志，不计入 API 统计。
+
+const REPLAY_DIFF_MAX_CELLS = 4000000 // 逐词对比的最大计算量，超出时改为逐行对比
+
+// JOURNAL_REDACT=content 时消息只记录了长度，无法重放
+var redactedContentPattern = regexp.MustCompile(`^\[\d+ chars\]$`)
+
+type replayOverrides struct {
+	Model          string                 `json:"model"`
+	EnableThinking *bool                  `json:"enable_thinking"`
+	Token          string                 `json:"token"`
+	Params         map[string]interface{} `json:"params"`
+}
+
+// ReplayResult 一次执行的结果（原请求或重放）
+type ReplayResult struct {
+	ID             string `json:"id"`
+	Model          string `json:"model"`
+	EnableThinking bool   `json:"enableThinking"`
+	TokenID        string `json:"tokenId,omitempty"`
+	FEVersion      string `json:"feVersion,omitempty"`
+	Status         int    `json:"status"`
+	Duration       int64  `json:"duration"` // 毫秒
+	Content        string `json:"content"`
+	Thinking       string `json:"thinking,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// DiffOp 差异片段
+type DiffOp struct {
+	Op   string `json:"op"` // equal / insert / delete
+	Text string `json:"text"`
+}
+
+// 原请求的参数，旧记录没有参数时使用当前配置
+func journalParamsOf(rec *JournalRecord) JournalParams {
+	if rec.Params != nil {
+		return *rec.Params
+	}
+	return JournalParams{EnableThinking: ENABLE_THINKING.Load()}
+}
+
+// 原请求的结果
+func originalReplayResult(rec *JournalRecord) *ReplayResult {
+	params := journalParamsOf(rec)
+	model := params.UpstreamModel
+	if model == "" {
+		model = rec.Model
+	}
+	return &ReplayResult{
+		ID:             rec.ID,
+		Model:          model,
+		EnableThinking: params.EnableThinking,
+		TokenID:        rec.TokenID,
+		FEVersion:      params.FEVersion,
+		Status:         rec.Status,
+		Duration:       rec.Duration,
+		Content:        rec.Content,
+		Thinking:       rec.Thinking,
+		Error:          rec.Error,
+	}
+}
+
+// 检查记录能否重放
+func checkReplayable(rec *JournalRecord) error {
+	if len(rec.Messages) == 0 {
+		return errors.New("记录中没有请求消息")
+	}
+	for _, m := range rec.Messages {
+		if redactedContentPattern.MatchString(m.Content) {
+			return errors.New("记录只保存了消息长度（JOURNAL_REDACT=content），无法重放")
+		}
+	}
+	return nil
+}
+
+// 重放一条请求日志（非流式），上游失败时结果中带有状态码和错误信息
+func replayJournalRecord(ctx context.Context, rec *JournalRecord, o replayOverrides, clientIP, userAgent string) (*ReplayResult, error) {
+	startTime := time.Now()
+	params := journalParamsOf(rec)
+
+	enableThinking := params.EnableThinking
+	if o.EnableThinking != nil {
+		enableThinking = *o.EnableThinking
+	}
+	chatID := fmt.Sprintf("%d-%d", time.Now().UnixNano(), time.Now().Unix())
+	msgID := fmt.Sprintf("%d", time.Now().UnixNano())
+	upstreamReq := buildUpstreamRequest(rec.Messages, chatID, msgID, enableThinking)
+	model := o.Model
+	if model == "" {
+		model = params.UpstreamModel
+	}
+	if model != "" {
+		upstreamReq.Model = model
+		upstreamReq.ModelItem.ID = model
+	}
+	if o.Params != nil {
+		upstreamReq.Params = o.Params
+	} else if params.UpstreamParams != nil {
+		upstreamReq.Params = params.UpstreamParams
+	}
+
+	ctx = logging.WithRequestID(ctx, newRequestID())
+	ctx, inflight := trackInflightRequest(ctx, "REPLAY", rec.Path, clientIP, userAgent)
+	defer inflight.done()
+	inflight.setRequest(rec.Model, false, nil)
+	req := OpenAIRequest{
+		Model:          rec.Model,
+		Messages:       rec.Messages,
+		Temperature:    params.Temperature,
+		MaxTokens:      params.MaxTokens,
+		EnableThinking: &enableThinking,
+	}
+	ctx, journal := startJournalEntry(ctx, "REPLAY", rec.Path, clientIP, userAgent, req, "")
+	journal.setUpstream(upstreamReq, enableThinking)
+
+	authToken := o.Token
+	if authToken == "" {
+		var err error
+		if authToken, err = getAuthToken(ctx); err != nil {
+			logToken.WarnContext(ctx, "获取认证 token 失败", "error", err)
+			journal.setError(err)
+			finishJournalEntry(ctx, http.StatusInternalServerError, time.Since(startTime))
+			return nil, err
+		}
+	}
+	inflight.setToken(authToken)
+	journal.setToken(authToken)
+
+	result := &ReplayResult{
+		ID:             journal.id(),
+		Model:          upstreamReq.Model,
+		EnableThinking: enableThinking,
+		TokenID:        journalTokenID(authToken),
+		FEVersion:      X_FE_VERSION,
+		Status:         http.StatusOK,
+	}
+	content, err := collectUpstreamCompletion(ctx, upstreamReq, chatID, authToken)
+	if err != nil {
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) {
+			result.Status = statusErr.StatusCode
+		} else {
+			result.Status, _ = upstreamFailure(ctx, "")
+		}
+		result.Error = err.Error()
+	}
+	result.Duration = time.Since(startTime).Milliseconds()
+	finishJournalEntry(ctx, result.Status, time.Since(startTime))
+
+	if journal != nil {
+		result.Content, result.Thinking = journal.output()
+	} else {
+		result.Content = content
+	}
+	// 与日志记录一样脱敏和截断，避免差异中出现无关的变化
+	redacted := JournalRecord{Content: result.Content, Thinking: result.Thinking, Error: result.Error}
+	redactJournalRecord(&redacted)
+	result.Content, result.Thinking, result.Error = redacted.Content, redacted.Thinking, redacted.Error
+	return result, nil
+}
+
+// ==================== 文本差异 ====================
+
+// 对比两段文本：逐词（中文逐字）对比，文本过长时逐行对比
+func diffText(a, b string) []DiffOp {
+	if a == b {
+		if a == "" {
+			return []DiffOp{}
+		}
+		return []DiffOp{{Op: "equal", Text: a}}
+	}
+	ta, tb := diffTokens(a), diffTokens(b)
+	if len(ta)*len(tb) > REPLAY_DIFF_MAX_CELLS {
+		ta, tb = strings.SplitAfter(a, "\n"), strings.SplitAfter(b, "\n")
+	}
+	if len(ta)*len(tb) > REPLAY_DIFF_MAX_CELLS {
+		return []DiffOp{{Op: "delete", Text: a}, {Op: "insert", Text: b}}
+	}
+	return diffSequences(ta, tb)
+}
+
+// 分词：连续的字母数字、连续的空白各为一个词，其余字符（中文、标点）各为一个词
+func diffTokens(s string) []string {
+	kindOf := func(r rune) int {
+		switch {
+		case unicode.IsSpace(r):
+			return 2
+		case unicode.Is(unicode.Han, r):
+			return 0
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			return 1
+		}
+		return 0
+	}
+
+	var tokens []string
+	start, kind := 0, -1
+	for i, r := range s {
+		k := kindOf(r)
+		if i > start && (k == 0 || k != kind) {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		kind = k
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// 基于最长公共子序列的差异，相邻的同类片段合并
+func diffSequences(a, b []string) []DiffOp {
+	ops := []DiffOp{}
+	add := func(op, text string) {
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Op == op {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, DiffOp{Op: op, Text: text})
+	}
+
+	// 公共前后缀不参与计算
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	add("equal", strings.Join(a[:prefix], ""))
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] 为 ma[i:] 与 mb[j:] 的最长公共子序列长度
+	n, m := len(ma), len(mb)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case ma[i] == mb[j]:
+			add("equal", ma[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("delete", ma[i])
+			i++
+		default:
+			add("insert", mb[j])
+			j++
+		}
+	}
+	add("delete", strings.Join(ma[i:], ""))
+	add("insert", strings.Join(mb[j:], ""))
+
+	add("equal", strings.Join(a[len(a)-suffix:], ""))
+	return ops
+}
+
+// 重放请求日志（由 handleAdminAPIJournal 调用，已完成鉴权）
+func handleJournalReplay(w http.ResponseWriter, r *http.Request, id string, writeError func(int, string)) {
+	rec, err := getJournalRecord(id)
+	if err == sql.ErrNoRows {
+		writeError(http.StatusNotFound, "记录不存在")
+		return
+	}
+	if err != nil {
+		writeError(http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := checkReplayable(rec); err != nil {
+		writeError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var overrides replayOverrides
+	if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil && err != io.EOF {
+		writeError(http.StatusBadRequest, "请求体格式错误: "+err.Error())
+		return
+	}
+
+	logJournal.Info("管理员重放了请求", "request_id", id, "client_ip", getClientIP(r))
+	result, err := replayJournalRecord(r.Context(), rec, overrides, getClientIP(r), r.UserAgent())
+	if err != nil {
+		writeError(http.StatusInternalServerError, "获取认证 token 失败: "+err.Error())
+		return
+	}
+
+	original := originalReplayResult(rec)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":  true,
+		"original": original,
+		"replay":   result,
+		"diff": map[string]interface{}{
+			"content":  diffText(original.Content, result.Content),
+			"thinking": diffText(original.Thinking, result.Thinking),
+		},
+		"identical": original.Content == result.Content && original.Thinking == result.Thinking,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/hulisang/ZtoApi/logging"
+)
+
+// ==================== 请求 ID 相关 ====================
+//
+// 每个 API 请求都有一个请求 ID：优先使用客户端传入的 X-Request-ID，否则自动生成。
+// 请求 ID 会通过响应头 X-Request-ID 返回，并用作 chatcmpl- 响应 ID、
+// 日志的 request_id 字段、实时请求记录的 ID 以及错误响应中的 request_id。
+
+const MAX_REQUEST_ID_LENGTH = 128
+
+// 生成新的请求 ID
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// 客户端传入的请求 ID 只允许字母、数字和 -_.:，避免污染响应头和日志
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID 为请求分配请求 ID，写入响应头和 context
+func withRequestID(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !isValidRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		handler(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	}
+}
+
+// 取出 context 中的请求 ID
+func requestIDFromContext(ctx context.Context) string {
+	return logging.RequestID(ctx)
+}
+
+// 对话补全响应 ID，同一请求的所有 chunk 保持一致
+func completionID(ctx context.Context) string {
+	id := requestIDFromContext(ctx)
+	if id == "" {
+		id = newRequestID()
+	}
+	return "chatcmpl-" + id
+}
+
+// httpError 与 http.Error 相同，但在错误信息后附加请求 ID
+func httpError(w http.ResponseWriter, message string, code int) {
+	if id := w.Header().Get("X-Request-ID"); id != "" {
+		message += " (request_id: " + id + ")"
+	}
+	http.Error(w, message, code)
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ==================== 运行时设置 ====================
+//
+// 部分开关可以在 Admin 面板中修改，无需重启：
+//   - 修改保存在 config 表（与注册系统的 RegisterConfig 共用）的 runtime 键中，重启后继续生效
+//   - 已保存的设置优先于环境变量和配置文件；设为 null 时删除覆盖，恢复为环境变量 / 配置文件的取值
+//   - 每次修改都会写入 settings_audit
+//
+//	GET /admin/api/settings
+//	PUT /admin/api/settings        {"settings": {"default_stream": false, "debug_mode": null}, "reason": "..."}
+//	GET /admin/api/settings/audit
+
+// runtimeSetting 一个可在运行时修改的开关
+type runtimeSetting struct {
+	Key         string
+	Env         string
+	Default     bool
+	Description string
+	Value       *reloadable[bool]
+}
+
+var runtimeSettings = []runtimeSetting{
+	{"default_stream", "DEFAULT_STREAM", true, "请求未指定 stream 时默认使用流式响应", &DEFAULT_STREAM},
+	{"enable_thinking", "ENABLE_THINKING", false, "启用思考功能", &ENABLE_THINKING},
+	{"debug_mode", "DEBUG_MODE", false, "调试模式（未设置 LOG_LEVEL 时输出 debug 日志）", &DEBUG_MODE},
+	{"anonymous_token", "ANON_TOKEN_ENABLED", true, "没有其他 token 来源时使用匿名 token", &ANON_TOKEN_ENABLED},
+}
+
+var (
+	settingsDB      *sql.DB
+	settingsDBMutex sync.RWMutex
+
+	// 已保存的覆盖值：key -> value（由 settingsDBMutex 保护）
+	runtimeOverrides = map[string]bool{}
+)
+
+const createSettingsTablesSQL = `
+CREATE TABLE IF NOT EXISTS config (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS settings_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	setting TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	client_ip TEXT,
+	user_agent TEXT,
+	reason TEXT,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_settings_audit_created ON settings_audit(created_at DESC);
+`
+
+// 初始化运行时设置数据库（共用 register 数据库），并应用已保存的设置
+func initSettingsDB() error {
+	dbPath := getEnv("REGISTER_DB_PATH", "./data/zai2api.db")
+
+	// 确保数据目录存在
+	os.MkdirAll("./data", 0755)
+
+	var err error
+	settingsDB, err = sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("打开运行时设置数据库失败: %v", err)
+	}
+
+	// 设置连接池
+	settingsDB.SetMaxOpenConns(5)
+	settingsDB.SetMaxIdleConns(1)
+	settingsDB.SetConnMaxLifetime(5 * time.Minute)
+
+	if _, err := settingsDB.Exec(createSettingsTablesSQL); err != nil {
+		return fmt.Errorf("创建运行时设置表失败: %v", err)
+	}
+
+	var data string
+	err = settingsDB.QueryRow(`SELECT value FROM config WHERE key = 'runtime'`).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	overrides := map[string]bool{}
+	if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+		return fmt.Errorf("解析运行时设置失败: %v", err)
+	}
+	for key := range overrides {
+		if findRuntimeSetting(key) == nil {
+			logConfig.Warn("忽略未知的运行时设置", "key", key)
+			delete(overrides, key)
+		}
+	}
+
+	settingsDBMutex.Lock()
+	runtimeOverrides = overrides
+	settingsDBMutex.Unlock()
+
+	configMu.Lock()
+	applyRuntimeSettings()
+	configMu.Unlock()
+	if len(overrides) > 0 {
+		logConfig.Info("已应用运行时设置", "count", len(overrides), "settings", formatRuntimeOverrides(overrides))
+	}
+	return nil
+}
+
+func findRuntimeSetting(key string) *runtimeSetting {
+	for i := range runtimeSettings {
+		if runtimeSettings[i].Key == key {
+			return &runtimeSettings[i]
+		}
+	}
+	return nil
+}
+
+// 应用运行时设置：有覆盖值时使用覆盖值，否则使用环境变量 / 配置文件的取值（调用方持有 configMu）
+func applyRuntimeSettings() {
+	settingsDBMutex.RLock()
+	defer settingsDBMutex.RUnlock()
+	for _, s := range runtimeSettings {
+		if value, ok := runtimeOverrides[s.Key]; ok {
+			s.Value.Store(value)
+		} else {
+			s.Value.Store(getEnvBool(s.Env, s.Default))
+		}
+	}
+}
+
+// 环境变量是否被运行时设置覆盖（重新加载配置时提示用）
+func runtimeSettingOverridden(env string) bool {
+	settingsDBMutex.RLock()
+	defer settingsDBMutex.RUnlock()
+	for _, s := range runtimeSettings {
+		if s.Env == env {
+			_, ok := runtimeOverrides[s.Key]
+			return ok
+		}
+	}
+	return false
+}
+
+// 当前的覆盖值（诊断用）
+func runtimeOverridesSnapshot() map[string]bool {
+	settingsDBMutex.RLock()
+	defer settingsDBMutex.RUnlock()
+	overrides := make(map[string]bool, len(runtimeOverrides))
+	for k, v := range runtimeOverrides {
+		overrides[k] = v
+	}
+	return overrides
+}
+
+func formatRuntimeOverrides(overrides map[string]bool) string {
+	parts := make([]string, 0, len(overrides))
+	for k, v := range overrides {
+		parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// RuntimeSettingView 运行时设置的当前状态
+type RuntimeSettingView struct {
+	Key         string `json:"key"`
+	Env         string `json:"env"`
+	Description string `json:"description"`
+	Value       bool   `json:"value"`      // 生效的取值
+	Configured  bool   `json:"configured"` // 环境变量 / 配置文件的取值
+	Overridden  bool   `json:"overridden"` // 是否被已保存的设置覆盖
+}
+
+func listRuntimeSettings() []RuntimeSettingView {
+	overrides := runtimeOverridesSnapshot()
+	configMu.Lock()
+	defer configMu.Unlock()
+	views := make([]RuntimeSettingView, 0, len(runtimeSettings))
+	for _, s := range runtimeSettings {
+		_, overridden := overrides[s.Key]
+		views = append(views, RuntimeSettingView{
+			Key:         s.Key,
+			Env:         s.Env,
+			Description: s.Description,
+			Value:       s.Value.Load(),
+			Configured:  getEnvBool(s.Env, s.Default),
+			Overridden:  overridden,
+		})
+	}
+	return views
+}
+
+// 解析 PUT 请求中的设置：值必须是布尔值或 null（删除覆盖）
+func parseRuntimeSettingsUpdate(raw map[string]json.RawMessage) (map[string]*bool, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("settings 不能为空")
+	}
+	keys := make([]string, 0, len(raw))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	updates := make(map[string]*bool, len(raw))
+	for _, key := range keys {
+		if findRuntimeSetting(key) == nil {
+			return nil, fmt.Errorf("未知的设置项: %s", key)
+		}
+		value := bytes.TrimSpace(raw[key])
+		if string(value) == "null" {
+			updates[key] = nil
+			continue
+		}
+		var b bool
+		if err := json.Unmarshal(value, &b); err != nil {
+			return nil, fmt.Errorf("设置项 %s 的值必须是 true、false 或 null", key)
+		}
+		updates[key] = &b
+	}
+	return updates, nil
+}
+
+// 覆盖值的审计表示："true" / "false"，没有覆盖时为 "null"
+func overrideAuditValue(value bool, ok bool) string {
+	if !ok {
+		return "null"
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// 保存运行时设置并写入审计记录，返回发生变化的设置项
+func updateRuntimeSettings(updates map[string]*bool, clientIP, userAgent, reason string) ([]string, error) {
+	if settingsDB == nil {
+		return nil, fmt.Errorf("运行时设置数据库未初始化")
+	}
+
+	settingsDBMutex.Lock()
+	next := make(map[string]bool, len(runtimeOverrides))
+	for k, v := range runtimeOverrides {
+		next[k] = v
+	}
+	var changed []string
+	type auditRow struct{ setting, oldValue, newValue string }
+	var rows []auditRow
+	for _, s := range runtimeSettings {
+		value, ok := updates[s.Key]
+		if !ok {
+			continue
+		}
+		oldValue, had := runtimeOverrides[s.Key]
+		if value == nil {
+			if !had {
+				continue
+			}
+			delete(next, s.Key)
+		} else {
+			if had && oldValue == *value {
+				continue
+			}
+			next[s.Key] = *value
+		}
+		newValue, has := next[s.Key]
+		changed = append(changed, s.Key)
+		rows = append(rows, auditRow{s.Key, overrideAuditValue(oldValue, had), overrideAuditValue(newValue, has)})
+	}
+	if len(changed) == 0 {
+		settingsDBMutex.Unlock()
+		return nil, nil
+	}
+
+	err := func() error {
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		tx, err := settingsDB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(`
+			INSERT OR REPLACE INTO config (key, value, updated_at)
+			VALUES ('runtime', ?, CURRENT_TIMESTAMP)
+		`, string(data))
+		if err != nil {
+			return fmt.Errorf("保存运行时设置失败: %v", err)
+		}
+		now := time.Now().Unix()
+		for _, row := range rows {
+			_, err = tx.Exec(`
+				INSERT INTO settings_audit (setting, old_value, new_value, client_ip, user_agent, reason, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+			`, row.setting, row.oldValue, row.newValue, clientIP, userAgent, reason, now)
+			if err != nil {
+				return fmt.Errorf("写入审计记录失败: %v", err)
+			}
+		}
+		return tx.Commit()
+	}()
+	if err == nil {
+		runtimeOverrides = next
+	}
+	settingsDBMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	configMu.Lock()
+	applyRuntimeSettings()
+	configMu.Unlock()
+	if _, ok := updates["debug_mode"]; ok {
+		initLogging()
+	}
+	return changed, nil
+}
+
+// SettingsAuditRecord 运行时设置审计记录
+type SettingsAuditRecord struct {
+	ID        int64           `json:"id"`
+	Setting   string          `json:"setting"`
+	OldValue  json.RawMessage `json:"old_value"`
+	NewValue  json.RawMessage `json:"new_value"`
+	ClientIP  string          `json:"client_ip"`
+	UserAgent string          `json:"user_agent"`
+	Reason    string          `json:"reason"`
+	CreatedAt int64           `json:"created_at"`
+}
+
+// 列出最近的审计记录
+func listSettingsAudit(limit int) ([]SettingsAuditRecord, error) {
+	records := []SettingsAuditRecord{}
+	if settingsDB == nil {
+		return records, nil
+	}
+
+	settingsDBMutex.RLock()
+	defer settingsDBMutex.RUnlock()
+
+	rows, err := settingsDB.Query(`
+		SELECT id, setting, old_value, new_value, client_ip, user_agent, reason, created_at
+		FROM settings_audit ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec SettingsAuditRecord
+		var oldValue, newValue string
+		var clientIP, userAgent, reason sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Setting, &oldValue, &newValue, &clientIP, &userAgent, &reason, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.OldValue = json.RawMessage(oldValue)
+		rec.NewValue = json.RawMessage(newValue)
+		rec.ClientIP = clientIP.String
+		rec.UserAgent = userAgent.String
+		rec.Reason = reason.String
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Admin 运行时设置
+func handleAdminAPISettings(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/settings"), "/")
+	switch {
+	case action == "" && r.Method == "GET":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"settings": listRuntimeSettings(),
+		})
+
+	case action == "" && r.Method == "PUT":
+		var body struct {
+			Settings map[string]json.RawMessage `json:"settings"`
+			Reason   string                     `json:"reason"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			writeError(http.StatusBadRequest, "无效的请求数据")
+			return
+		}
+		updates, err := parseRuntimeSettingsUpdate(body.Settings)
+		if err != nil {
+			writeError(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		clientIP := getClientIP(r)
+		changed, err := updateRuntimeSettings(updates, clientIP, r.UserAgent(), body.Reason)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		if len(changed) > 0 {
+			logConfig.Info("运行时设置已修改", "settings", strings.Join(changed, ","), "client_ip", clientIP, "reason", body.Reason)
+		}
+		if changed == nil {
+			changed = []string{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":  true,
+			"changed":  changed,
+			"settings": listRuntimeSettings(),
+		})
+
+	case action == "audit" && r.Method == "GET":
+		records, err := listSettingsAudit(100)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"records": records,
+		})
+
+	case action == "" || action == "audit":
+		writeError(http.StatusMethodNotAllowed, "Method not allowed")
+
+	default:
+		writeError(http.StatusNotFound, "未知的操作")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hulisang/ZtoApi/register"
+	"github.com/hulisang/ZtoApi/tracing"
+)
+
+// ==================== 优雅关闭 ====================
+//
+// 收到 SIGINT / SIGTERM 后：
+//  1. 停止接受新连接；注册系统停止批量任务，实时推送连接断开
+//  2. 等待进行中的请求（含流式响应）、批处理请求和后台任务完成，最长 SHUTDOWN_GRACE_PERIOD
+//  3. 超时仍未完成的请求被取消；被中断的批处理请求和后台任务保持执行中状态，下次启动时重新执行
+//  4. 写入统计数据和请求日志，导出剩余的链路追踪数据，关闭数据库
+//
+// 关闭过程中再次收到信号时立即退出。
+
+const SHUTDOWN_CANCEL_WAIT = 5 * time.Second // 宽限期结束取消请求后，等待其收尾的时间
+
+var errServerShutdown = errors.New("server shutting down")
+
+var (
+	// 关闭开始时关闭，定时任务、worker 和长连接据此退出
+	serverStopping = make(chan struct{})
+	// 所有请求和服务端任务的根 context，宽限期结束时取消
+	serverContext, stopServerContext = context.WithCancelCause(context.Background())
+
+	// 批处理请求、后台任务等不属于 HTTP 请求的工作
+	serverTasks   sync.WaitGroup
+	serverTasksMu sync.RWMutex
+)
+
+// 服务是否正在关闭
+func isServerStopping() bool {
+	select {
+	case <-serverStopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// 登记一个服务端任务，关闭时会等待其完成；服务正在关闭时返回 false，调用方不应再开始新的工作
+func beginServerTask() bool {
+	serverTasksMu.RLock()
+	defer serverTasksMu.RUnlock()
+	if isServerStopping() {
+		return false
+	}
+	serverTasks.Add(1)
+	return true
+}
+
+func endServerTask() {
+	serverTasks.Done()
+}
+
+// 等待服务端任务完成，ctx 截止前全部完成时返回 true
+func waitServerTasks(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		serverTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// 启动定时任务，服务关闭时停止
+func startPeriodicTask(interval time.Duration, task func()) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-serverStopping:
+				return
+			case <-ticker.C:
+			}
+			if !beginServerTask() {
+				return
+			}
+			task()
+			endServerTask()
+		}
+	}()
+}
+
+// 启动 HTTP 服务，收到退出信号后优雅关闭
+func runServer() {
+	server := &http.Server{
+		Addr: PORT,
+		BaseContext: func(net.Listener) context.Context {
+			return serverContext
+		},
+	}
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		log.Fatal(err)
+	case sig := <-sigCh:
+		log.Printf("🛑 收到信号 %v，开始优雅关闭（最长等待 %v）...", sig, SHUTDOWN_GRACE_PERIOD)
+	}
+
+	go func() {
+		sig := <-sigCh
+		log.Printf("🛑 再次收到信号 %v，立即退出", sig)
+		os.Exit(1)
+	}()
+
+	shutdownServer(server)
+	log.Printf("👋 服务已关闭")
+}
+
+// 优雅关闭
+func shutdownServer(server *http.Server) {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_GRACE_PERIOD)
+	defer cancel()
+
+	serverTasksMu.Lock()
+	close(serverStopping)
+	serverTasksMu.Unlock()
+	register.StopTasks()
+
+	if n := len(listInflightRequests()); n > 0 {
+		log.Printf("⏳ 等待 %d 个进行中的请求完成...", n)
+	}
+
+	// 停止接受新连接，等待进行中的请求和服务端任务完成
+	drained := server.Shutdown(ctx) == nil && waitServerTasks(ctx)
+	if !drained {
+		log.Printf("⚠️ 宽限期内仍有 %d 个请求未完成，取消剩余请求", len(listInflightRequests()))
+		stopServerContext(errServerShutdown)
+
+		cancelCtx, cancelWait := context.WithTimeout(context.Background(), SHUTDOWN_CANCEL_WAIT)
+		defer cancelWait()
+		if err := server.Shutdown(cancelCtx); err != nil {
+			server.Close()
+		}
+		if !waitServerTasks(cancelCtx) {
+			log.Printf("⚠️ 部分批处理请求或后台任务未能结束")
+		}
+		ctx = cancelCtx
+	} else {
+		log.Printf("✅ 进行中的请求已全部完成 (耗时 %v)", time.Since(start).Round(time.Millisecond))
+	}
+
+	if err := register.Shutdown(ctx); err != nil {
+		log.Printf("⚠️ 关闭注册系统失败: %v", err)
+	}
+
+	if statsDB != nil {
+		if err := flushStats(); err != nil {
+			log.Printf("⚠️ 写入统计数据失败: %v", err)
+		}
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), SHUTDOWN_CANCEL_WAIT)
+	defer cancelFlush()
+	stopJournalWriter(flushCtx)
+	if err := tracing.Shutdown(flushCtx); err != nil {
+		log.Printf("⚠️ 导出链路追踪数据失败: %v", err)
+	}
+
+	closeDatabases()
+}
+
+// 关闭所有数据库连接
+func closeDatabases() {
+	for name, db := range healthDatabases() {
+		if db == nil {
+			continue
+		}
+		if err := db.Close(); err != nil {
+			log.Printf("⚠️ 关闭数据库 %s 失败: %v", name, err)
+		}
+	}
+	if closer, ok := responseCache.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("⚠️ 关闭缓存数据库失败: %v", err)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"time"
+
+	"github.com/hulisang/ZtoApi/logging"
 )
 
 // SimpleClient 最简化的HTTP客户端
@@ -46,8 +48,7 @@ func (sc *SimpleClient) SendSimpleRequest(messages []Message, stream bool) (*htt
 		return nil, fmt.Errorf("序列化请求失败: %v", err)
 	}
 
-	debugLog("发送简化请求到: %s", UPSTREAM_URL)
-	debugLog("请求体: %s", string(reqBody))
+	logUpstream.Debug("发送简化请求", "url", UPSTREAM_URL, "body", logging.RedactWithContent(string(reqBody)))
 
 	// 创建HTTP请求 - 不带任何URL参数
 	req, err := http.NewRequest("POST", UPSTREAM_URL, bytes.NewBuffer(reqBody))
@@ -65,9 +66,9 @@ func (sc *SimpleClient) SendSimpleRequest(messages []Message, stream bool) (*htt
 	// 如果有token，添加Authorization
 	if sc.authToken != "" {
 		req.Header.Set("Authorization", "Bearer "+sc.authToken)
-		debugLog("使用token: %s...", sc.authToken[:min(20, len(sc.authToken))])
+		logUpstream.Debug("使用token", "token_prefix", tokenPrefix(sc.authToken))
 	} else {
-		debugLog("警告：没有token")
+		logUpstream.Warn("没有token")
 	}
 
 	resp, err := sc.httpClient.Do(req)
@@ -75,13 +76,13 @@ func (sc *SimpleClient) SendSimpleRequest(messages []Message, stream bool) (*htt
 		return nil, fmt.Errorf("请求失败: %v", err)
 	}
 
-	debugLog("响应状态: %d %s", resp.StatusCode, resp.Status)
+	logUpstream.Debug("响应状态", "status", resp.StatusCode)
 	
 	// 如果响应不是200，读取错误信息
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		debugLog("错误响应体: %s", string(body))
+		logUpstream.Debug("错误响应体", "body", string(body))
 		return nil, fmt.Errorf("服务器返回错误: %d - %s", resp.StatusCode, string(body))
 	}
 
@@ -0,0 +1,188 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrEventTooLarge 单个事件超过 MaxEventSize 时返回
+var ErrEventTooLarge = errors.New("sse: event too large")
+
+// Event 一个完整的SSE事件
+type Event struct {
+	ID    string // 最近一次 id: 字段（按规范在事件之间保持）
+	Event string // event: 字段，未设置时为空（等价于 "message"）
+	Data  string // 多个 data: 行以 "\n" 拼接
+	Retry int    // retry: 字段（毫秒），未设置为 0
+}
+
+// Decoder 增量SSE解码器
+// 与 bufio.Scanner 不同，单行长度没有上限（默认），支持 LF / CRLF / CR 三种换行
+type Decoder struct {
+	r *bufio.Reader
+
+	// MaxEventSize 单个事件 data 的最大字节数，0 表示不限制
+	MaxEventSize int
+
+	lastID  string
+	skipLF  bool // 上一行以 \r 结尾，下一个 \n 属于同一个换行符
+	line    []byte
+	data    bytes.Buffer
+	event   string
+	retry   int
+	hasData bool
+	dirty   bool // 当前事件是否已读到任何字段
+}
+
+// NewDecoder 创建解码器
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReaderSize(r, 32*1024)}
+}
+
+// Next 读取下一个事件
+// 流结束时返回 io.EOF；若结尾缺少空行，最后一个未分发的事件仍会返回
+func (d *Decoder) Next() (*Event, error) {
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			if err == io.EOF && d.dirty {
+				if ev := d.dispatch(); ev != nil {
+					return ev, nil
+				}
+			}
+			return nil, err
+		}
+
+		// 空行：分发事件
+		if len(line) == 0 {
+			if ev := d.dispatch(); ev != nil {
+				return ev, nil
+			}
+			continue
+		}
+
+		if err := d.processLine(line); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 处理一行字段
+func (d *Decoder) processLine(line []byte) error {
+	// 注释行
+	if line[0] == ':' {
+		return nil
+	}
+
+	var field, value []byte
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		field = line[:i]
+		value = line[i+1:]
+		// 冒号后的单个空格不属于值
+		if len(value) > 0 && value[0] == ' ' {
+			value = value[1:]
+		}
+	} else {
+		field = line
+	}
+
+	d.dirty = true
+	switch string(field) {
+	case "data":
+		if d.hasData {
+			d.data.WriteByte('\n')
+		}
+		d.data.Write(value)
+		d.hasData = true
+		if d.MaxEventSize > 0 && d.data.Len() > d.MaxEventSize {
+			d.reset()
+			return ErrEventTooLarge
+		}
+	case "event":
+		d.event = string(value)
+	case "id":
+		// 包含 NUL 的 id 按规范忽略
+		if bytes.IndexByte(value, 0) < 0 {
+			d.lastID = string(value)
+		}
+	case "retry":
+		if isDigits(value) {
+			if n, err := strconv.Atoi(string(value)); err == nil {
+				d.retry = n
+			}
+		}
+	}
+	return nil
+}
+
+// 分发当前事件；没有 data 的事件按规范丢弃
+func (d *Decoder) dispatch() *Event {
+	defer d.reset()
+	if !d.hasData {
+		return nil
+	}
+	return &Event{
+		ID:    d.lastID,
+		Event: d.event,
+		Data:  d.data.String(),
+		Retry: d.retry,
+	}
+}
+
+func (d *Decoder) reset() {
+	d.data.Reset()
+	d.event = ""
+	d.retry = 0
+	d.hasData = false
+	d.dirty = false
+}
+
+// readLine 读取一行（不含换行符），行长度只受 MaxEventSize 限制
+func (d *Decoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+	for {
+		if _, err := d.r.Peek(1); err != nil {
+			if err == io.EOF && len(d.line) > 0 {
+				return d.line, nil
+			}
+			return nil, err
+		}
+		buf, _ := d.r.Peek(d.r.Buffered())
+
+		if d.skipLF {
+			d.skipLF = false
+			if buf[0] == '\n' {
+				d.r.Discard(1)
+				continue
+			}
+		}
+
+		i := bytes.IndexAny(buf, "\r\n")
+		if i < 0 {
+			d.line = append(d.line, buf...)
+			d.r.Discard(len(buf))
+			if d.MaxEventSize > 0 && len(d.line) > d.MaxEventSize+len("data: ") {
+				d.reset()
+				return nil, ErrEventTooLarge
+			}
+			continue
+		}
+
+		d.line = append(d.line, buf[:i]...)
+		// \r 后面紧跟的 \n 可能还没到达，留给下一次读取时跳过，避免阻塞
+		d.skipLF = buf[i] == '\r'
+		d.r.Discard(i + 1)
+		return d.line, nil
+	}
+}
+
+func isDigits(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	return strings.Trim(string(b), "0123456789") == ""
+}
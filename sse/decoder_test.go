@@ -0,0 +1,177 @@
+package sse
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func decodeAll(t *testing.T, r io.Reader) []Event {
+	t.Helper()
+	d := NewDecoder(r)
+	var events []Event
+	for {
+		ev, err := d.Next()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Next() error: %v", err)
+		}
+		events = append(events, *ev)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	long := strings.Repeat("x", 1<<20)
+
+	tests := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{
+			name:  "single event",
+			input: "data: hello\n\n",
+			want:  []Event{{Data: "hello"}},
+		},
+		{
+			name:  "multiple events",
+			input: "data: a\n\ndata: b\n\n",
+			want:  []Event{{Data: "a"}, {Data: "b"}},
+		},
+		{
+			name:  "multi-line data",
+			input: "data: line1\ndata: line2\ndata\n\n",
+			want:  []Event{{Data: "line1\nline2\n"}},
+		},
+		{
+			name:  "event and id",
+			input: "event: update\nid: 42\ndata: {}\n\ndata: next\n\n",
+			want:  []Event{{ID: "42", Event: "update", Data: "{}"}, {ID: "42", Data: "next"}},
+		},
+		{
+			name:  "id with NUL is ignored",
+			input: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+			want:  []Event{{ID: "1", Data: "a"}, {ID: "1", Data: "b"}},
+		},
+		{
+			name:  "retry",
+			input: "retry: 3000\ndata: a\n\nretry: abc\ndata: b\n\n",
+			want:  []Event{{Data: "a", Retry: 3000}, {Data: "b"}},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "data: a\r\ndata: b\r\n\r\ndata: c\r\n\r\n",
+			want:  []Event{{Data: "a\nb"}, {Data: "c"}},
+		},
+		{
+			name:  "CR line endings",
+			input: "data: a\rdata: b\r\rdata: c\r\r",
+			want:  []Event{{Data: "a\nb"}, {Data: "c"}},
+		},
+		{
+			name:  "comments are skipped",
+			input: ": keepalive\ndata: a\n: another\n\n",
+			want:  []Event{{Data: "a"}},
+		},
+		{
+			name:  "no space after colon",
+			input: "data:a\ndata:  b\n\n",
+			want:  []Event{{Data: "a\n b"}},
+		},
+		{
+			name:  "event without data is dropped",
+			input: "event: ping\n\ndata: a\n\n",
+			want:  []Event{{Data: "a"}},
+		},
+		{
+			name:  "unknown fields are ignored",
+			input: "foo: bar\ndata: a\n\n",
+			want:  []Event{{Data: "a"}},
+		},
+		{
+			name:  "trailing event without blank line",
+			input: "data: a\n\ndata: b",
+			want:  []Event{{Data: "a"}, {Data: "b"}},
+		},
+		{
+			name:  "very long line",
+			input: "data: " + long + "\n\ndata: after\n\n",
+			want:  []Event{{Data: long}, {Data: "after"}},
+		},
+		{
+			name:  "empty input",
+			input: "",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeAll(t, strings.NewReader(tt.input))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+
+			// 每次只读一个字节，模拟网络分片到达
+			got = decodeAll(t, iotest.OneByteReader(strings.NewReader(tt.input)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("one-byte reader: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecoderMaxEventSize(t *testing.T) {
+	d := NewDecoder(strings.NewReader("data: " + strings.Repeat("x", 100) + "\n\n"))
+	d.MaxEventSize = 10
+	if _, err := d.Next(); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("got err %v, want ErrEventTooLarge", err)
+	}
+
+	d = NewDecoder(strings.NewReader("data: 12345\ndata: 67890\n\n"))
+	d.MaxEventSize = 10
+	if _, err := d.Next(); !errors.Is(err, ErrEventTooLarge) {
+		t.Fatalf("multi-line: got err %v, want ErrEventTooLarge", err)
+	}
+}
+
+func TestDecoderCRDoesNotBlock(t *testing.T) {
+	// \r 之后的数据尚未到达时，事件应当立即分发
+	pr, pw := io.Pipe()
+	d := NewDecoder(pr)
+	go pw.Write([]byte("data: a\r\r"))
+
+	ev, err := d.Next()
+	if err != nil {
+		t.Fatalf("Next() error: %v", err)
+	}
+	if ev.Data != "a" {
+		t.Fatalf("got %q, want %q", ev.Data, "a")
+	}
+	pw.Close()
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add("data: hello\n\n")
+	f.Add("event: e\nid: 1\nretry: 10\ndata: a\ndata: b\n\n")
+	f.Add("data: a\r\n\r\ndata: b\r\r: comment\n")
+	f.Add("data:\n\ndata")
+
+	f.Fuzz(func(t *testing.T, input string) {
+		whole := decodeAll(t, strings.NewReader(input))
+		chunked := decodeAll(t, iotest.OneByteReader(strings.NewReader(input)))
+		if !reflect.DeepEqual(whole, chunked) {
+			t.Fatalf("chunked decode differs: %q vs %q", whole, chunked)
+		}
+		for _, ev := range whole {
+			if len(ev.Data) > len(input) {
+				t.Fatalf("data longer than input: %d > %d", len(ev.Data), len(input))
+			}
+		}
+	})
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ==================== 统计聚合 ====================
+//
+// 请求统计先在内存中按小时聚合，再由后台任务定期批量写入数据库：
+//   - 每个小时一行，使用 UPSERT 原子累加 requests / success / ... 等计数
+//   - 同时按 小时 × 模型 × 状态码类别 × API Key × 是否流式 写入 hourly_stats_dims
+//   - 响应时间保存 sum / count / min / max，平均值和最快/最慢都是精确值
+//   - 延迟拆分直方图（hourly_latency）同样按桶累加
+//   - 每次写入后从小时数据重新汇总涉及到的日期的每日统计，重复执行结果不变
+
+const (
+	STATS_FLUSH_INTERVAL = 5 * time.Second // 定期写入间隔
+	STATS_MAX_PENDING    = 1000            // 缓冲事件数达到该值时立即写入
+)
+
+// 一个小时内尚未写入数据库的增量
+type hourlyDelta struct {
+	requests          int64
+	success           int64
+	failed            int64
+	tokens            int64
+	streaming         int64
+	nonStreaming      int64
+	responseTimeSum   float64
+	responseTimeCount int64
+	minResponseTime   float64
+	maxResponseTime   float64
+}
+
+func (d *hourlyDelta) merge(o *hourlyDelta) {
+	if o.responseTimeCount > 0 {
+		if d.responseTimeCount == 0 || o.minResponseTime < d.minResponseTime {
+			d.minResponseTime = o.minResponseTime
+		}
+		if o.maxResponseTime > d.maxResponseTime {
+			d.maxResponseTime = o.maxResponseTime
+		}
+	}
+	d.requests += o.requests
+	d.success += o.success
+	d.failed += o.failed
+	d.tokens += o.tokens
+	d.streaming += o.streaming
+	d.nonStreaming += o.nonStreaming
+	d.responseTimeSum += o.responseTimeSum
+	d.responseTimeCount += o.responseTimeCount
+}
+
+// 维度统计的聚合键
+type statsDimKey struct {
+	hour        string
+	model       string
+	statusClass string
+	apiKeyID    string
+	stream      bool
+}
+
+type latencyBucketKey struct {
+	hour   string
+	metric string
+	bucket int
+}
+
+var statsBuffer = struct {
+	mu      sync.Mutex
+	hours   map[string]*hourlyDelta
+	dims    map[statsDimKey]*hourlyDelta
+	latency map[latencyBucketKey]int64
+	pending int
+	flush   chan struct{}
+}{
+	hours:   make(map[string]*hourlyDelta),
+	dims:    make(map[statsDimKey]*hourlyDelta),
+	latency: make(map[latencyBucketKey]int64),
+	flush:   make(chan struct{}, 1),
+}
+
+// 串行化统计写入和重置：重置删除历史数据时，不能有已取出但尚未写入的增量
+var statsFlushMutex sync.Mutex
+
+// 缓冲事件过多时通知后台任务立即写入
+func notifyStatsFlushLocked() {
+	statsBuffer.pending++
+	if statsBuffer.pending >= STATS_MAX_PENDING {
+		select {
+		case statsBuffer.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 记录一次请求到小时统计缓冲（小时按请求结束时间计算）
+func recordHourlyStats(at time.Time, duration time.Duration, status int, tokens int, isStreaming bool, model, apiKeyID string) {
+	durationMs := float64(duration.Milliseconds())
+	event := &hourlyDelta{
+		requests:          1,
+		tokens:            int64(tokens),
+		responseTimeSum:   durationMs,
+		responseTimeCount: 1,
+		minResponseTime:   durationMs,
+		maxResponseTime:   durationMs,
+	}
+	if status >= 200 && status < 300 {
+		event.success = 1
+	} else {
+		event.failed = 1
+	}
+	if isStreaming {
+		event.streaming = 1
+	} else {
+		event.nonStreaming = 1
+	}
+
+	hourKey := hourKeyFor(at)
+	dimKey := statsDimKey{hour: hourKey, model: model, statusClass: statusClass(status), apiKeyID: apiKeyID, stream: isStreaming}
+	statsBuffer.mu.Lock()
+	defer statsBuffer.mu.Unlock()
+	if d := statsBuffer.hours[hourKey]; d != nil {
+		d.merge(event)
+	} else {
+		copied := *event
+		statsBuffer.hours[hourKey] = &copied
+	}
+	if d := statsBuffer.dims[dimKey]; d != nil {
+		d.merge(event)
+	} else {
+		statsBuffer.dims[dimKey] = event
+	}
+	notifyStatsFlushLocked()
+}
+
+// 记录一次请求的延迟拆分到直方图缓冲
+func recordLatencyStats(t *requestTimings) {
+	if t == nil {
+		return
+	}
+	hourKey := hourKeyFor(time.Now())
+	observations := t.observations()
+
+	statsBuffer.mu.Lock()
+	defer statsBuffer.mu.Unlock()
+	for metric, value := range observations {
+		key := latencyBucketKey{hour: hourKey, metric: metric, bucket: latencyBucketIndex(latencyStatBuckets[metric], value)}
+		statsBuffer.latency[key]++
+	}
+	if len(observations) > 0 {
+		notifyStatsFlushLocked()
+	}
+}
+
+// 把缓冲中的统计写入数据库；写入失败时放回缓冲，下次重试
+func flushStats() error {
+	if statsDB == nil {
+		return nil
+	}
+
+	statsFlushMutex.Lock()
+	defer statsFlushMutex.Unlock()
+
+	if err := saveCumulativeStats(); err != nil {
+		logStats.Warn("保存累计统计失败", "error", err)
+	}
+
+	statsBuffer.mu.Lock()
+	hours, dims, latency := statsBuffer.hours, statsBuffer.dims, statsBuffer.latency
+	statsBuffer.hours = make(map[string]*hourlyDelta)
+	statsBuffer.dims = make(map[statsDimKey]*hourlyDelta)
+	statsBuffer.latency = make(map[latencyBucketKey]int64)
+	statsBuffer.pending = 0
+	statsBuffer.mu.Unlock()
+
+	if len(hours) == 0 && len(dims) == 0 && len(latency) == 0 {
+		return nil
+	}
+
+	statsDBMutex.Lock()
+	err := writeStatsDeltas(hours, dims, latency)
+	statsDBMutex.Unlock()
+	if err != nil {
+		statsBuffer.mu.Lock()
+		for hour, d := range hours {
+			if cur := statsBuffer.hours[hour]; cur != nil {
+				cur.merge(d)
+			} else {
+				statsBuffer.hours[hour] = d
+			}
+		}
+		for key, d := range dims {
+			if cur := statsBuffer.dims[key]; cur != nil {
+				cur.merge(d)
+			} else {
+				statsBuffer.dims[key] = d
+			}
+		}
+		for key, count := range latency {
+			statsBuffer.latency[key] += count
+		}
+		statsBuffer.mu.Unlock()
+		return err
+	}
+
+	if len(hours) > 0 || len(dims) > 0 {
+		saveDailyStatsBetween(touchedStatsDates(hours, dims))
+	}
+	return nil
+}
+
+// 本次写入涉及的本地日期范围（报表时区），只需要重新汇总这些天的每日统计
+func touchedStatsDates(hours map[string]*hourlyDelta, dims map[statsDimKey]*hourlyDelta) (from, to string) {
+	touch := func(hour string) {
+		t, ok := parseHourKey(hour)
+		if !ok {
+			return
+		}
+		date := dateKeyIn(t, statsLocation)
+		if from == "" || date < from {
+			from = date
+		}
+		if to == "" || date > to {
+			to = date
+		}
+	}
+	for hour := range hours {
+		touch(hour)
+	}
+	for key := range dims {
+		touch(key.hour)
+	}
+	return from, to
+}
+
+func writeStatsDeltas(hours map[string]*hourlyDelta, dims map[statsDimKey]*hourlyDelta, latency map[latencyBucketKey]int64) error {
+	tx, err := statsDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for hour, d := range hours {
+		_, err := tx.Exec(`
+			INSERT INTO hourly_stats (hour, requests, success, failed, tokens, streaming_count, non_streaming_count,
+			                          response_time_sum, response_time_count, min_response_time, max_response_time, avg_response_time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(hour) DO UPDATE SET
+				requests = requests + excluded.requests,
+				success = success + excluded.success,
+				failed = failed + excluded.failed,
+				tokens = tokens + excluded.tokens,
+				streaming_count = streaming_count + excluded.streaming_count,
+				non_streaming_count = non_streaming_count + excluded.non_streaming_count,
+				response_time_sum = response_time_sum + excluded.response_time_sum,
+				response_time_count = response_time_count + excluded.response_time_count,
+				min_response_time = CASE WHEN response_time_count = 0 THEN excluded.min_response_time
+				                         ELSE MIN(min_response_time, excluded.min_response_time) END,
+				max_response_time = MAX(max_response_time, excluded.max_response_time),
+				avg_response_time = (response_time_sum + excluded.response_time_sum) / (response_time_count + excluded.response_time_count)
+		`, hour, d.requests, d.success, d.failed, d.tokens, d.streaming, d.nonStreaming,
+			d.responseTimeSum, d.responseTimeCount, d.minResponseTime, d.maxResponseTime,
+			d.responseTimeSum/float64(d.responseTimeCount))
+		if err != nil {
+			return fmt.Errorf("写入小时统计失败: %v", err)
+		}
+	}
+
+	for key, d := range dims {
+		_, err := tx.Exec(`
+			INSERT INTO hourly_stats_dims (hour, model, status_class, api_key_id, stream, requests, success, failed, tokens,
+			                               response_time_sum, response_time_count, min_response_time, max_response_time)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(hour, model, status_class, api_key_id, stream) DO UPDATE SET
+				requests = requests + excluded.requests,
+				success = success + excluded.success,
+				failed = failed + excluded.failed,
+				tokens = tokens + excluded.tokens,
+				response_time_sum = response_time_sum + excluded.response_time_sum,
+				response_time_count = response_time_count + excluded.response_time_count,
+				min_response_time = CASE WHEN response_time_count = 0 THEN excluded.min_response_time
+				                         ELSE MIN(min_response_time, excluded.min_response_time) END,
+				max_response_time = MAX(max_response_time, excluded.max_response_time)
+		`, key.hour, key.model, key.statusClass, key.apiKeyID, key.stream, d.requests, d.success, d.failed, d.tokens,
+			d.responseTimeSum, d.responseTimeCount, d.minResponseTime, d.maxResponseTime)
+		if err != nil {
+			return fmt.Errorf("写入维度统计失败: %v", err)
+		}
+	}
+
+	for key, count := range latency {
+		_, err := tx.Exec(`
+			INSERT INTO hourly_latency (hour, metric, bucket, count) VALUES (?, ?, ?, ?)
+			ON CONFLICT(hour, metric, bucket) DO UPDATE SET count = count + excluded.count
+		`, key.hour, key.metric, key.bucket, count)
+		if err != nil {
+			return fmt.Errorf("写入延迟统计失败: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 启动后台写入任务（服务关闭时停止，最后一次写入由关闭流程完成）
+func startStatsFlusher() {
+	go func() {
+		ticker := time.NewTicker(STATS_FLUSH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-statsBuffer.flush:
+			case <-serverStopping:
+				return
+			}
+			if err := flushStats(); err != nil {
+				logStats.Warn("写入统计数据失败", "error", err)
+				publishDashboardAlert("stats_flush", "error", fmt.Sprintf("写入统计数据失败: %v", err))
+			}
+		}
+	}()
+}
+
+// 为旧版本的 hourly_stats 补充 sum / count / min / max 列
+// 旧数据只有平均值，迁移时按 avg * requests 还原总和，min / max 取平均值
+func migrateHourlyStats() error {
+	columns := map[string]bool{}
+	rows, err := statsDB.Query(`PRAGMA table_info(hourly_stats)`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err == nil {
+			columns[name] = true
+		}
+	}
+	rows.Close()
+
+	if columns["response_time_sum"] {
+		return nil
+	}
+
+	tx, err := statsDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`ALTER TABLE hourly_stats ADD COLUMN response_time_sum REAL DEFAULT 0`,
+		`ALTER TABLE hourly_stats ADD COLUMN response_time_count INTEGER DEFAULT 0`,
+		`ALTER TABLE hourly_stats ADD COLUMN min_response_time REAL DEFAULT 0`,
+		`ALTER TABLE hourly_stats ADD COLUMN max_response_time REAL DEFAULT 0`,
+		`UPDATE hourly_stats SET response_time_sum = avg_response_time * requests, response_time_count = requests,
+		        min_response_time = avg_response_time, max_response_time = avg_response_time
+		 WHERE requests > 0`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	logStats.Info("小时统计表已迁移", "added_columns", "sum,count,min,max")
+	return nil
+}
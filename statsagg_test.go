@@ -0,0 +1,237 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 使用临时目录中的统计数据库和空的统计缓冲，测试结束后恢复全局状态
+func useStatsDB(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	t.Setenv("REGISTER_DB_PATH", "stats.db")
+
+	oldDB, oldLocation := statsDB, statsLocation
+	if err := initStatsDB(); err != nil {
+		t.Fatal(err)
+	}
+	resetStatsBuffer := func() {
+		statsBuffer.mu.Lock()
+		statsBuffer.hours = make(map[string]*hourlyDelta)
+		statsBuffer.dims = make(map[statsDimKey]*hourlyDelta)
+		statsBuffer.latency = make(map[latencyBucketKey]int64)
+		statsBuffer.pending = 0
+		statsBuffer.mu.Unlock()
+	}
+	resetStatsBuffer()
+	t.Cleanup(func() {
+		statsDB.Close()
+		statsDB, statsLocation = oldDB, oldLocation
+		resetStatsBuffer()
+	})
+}
+
+// 测试用的统计事件
+type statsEvent struct {
+	at       string // UTC 时间，格式 2006-01-02 15:04
+	duration time.Duration
+	status   int
+	tokens   int
+	stream   bool
+	model    string
+}
+
+func (e statsEvent) record(t *testing.T) {
+	t.Helper()
+	at, err := time.ParseInLocation("2006-01-02 15:04", e.at, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordHourlyStats(at, e.duration, e.status, e.tokens, e.stream, e.model, "key-1")
+}
+
+type hourlyRow struct {
+	requests, success, failed, tokens, streaming, nonStreaming int64
+	sum                                                        float64
+	count                                                      int64
+	min, max, avg                                              float64
+}
+
+func readHourlyRow(t *testing.T, hour string) hourlyRow {
+	t.Helper()
+	var r hourlyRow
+	err := statsDB.QueryRow(`
+		SELECT requests, success, failed, tokens, streaming_count, non_streaming_count,
+		       response_time_sum, response_time_count, min_response_time, max_response_time, avg_response_time
+		FROM hourly_stats WHERE hour = ?
+	`, hour).Scan(&r.requests, &r.success, &r.failed, &r.tokens, &r.streaming, &r.nonStreaming,
+		&r.sum, &r.count, &r.min, &r.max, &r.avg)
+	if err != nil {
+		t.Fatalf("read hourly_stats %s: %v", hour, err)
+	}
+	return r
+}
+
+func TestFlushStatsUpsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]statsEvent // 每批事件之后执行一次 flushStats
+		hour    string
+		want    hourlyRow
+	}{
+		{
+			name: "single flush",
+			batches: [][]statsEvent{{
+				{at: "2026-03-01 10:05", duration: 100 * time.Millisecond, status: 200, tokens: 10, stream: true, model: "glm"},
+				{at: "2026-03-01 10:40", duration: 300 * time.Millisecond, status: 502, model: "glm"},
+			}},
+			hour: "2026-03-01-10",
+			want: hourlyRow{requests: 2, success: 1, failed: 1, tokens: 10, streaming: 1, nonStreaming: 1, sum: 400, count: 2, min: 100, max: 300, avg: 200},
+		},
+		{
+			name: "increments across flushes",
+			batches: [][]statsEvent{
+				{{at: "2026-03-01 10:05", duration: 200 * time.Millisecond, status: 200, tokens: 5, model: "glm"}},
+				{{at: "2026-03-01 10:06", duration: 50 * time.Millisecond, status: 200, tokens: 7, model: "glm"}},
+				{{at: "2026-03-01 10:07", duration: 500 * time.Millisecond, status: 429, model: "glm"}},
+			},
+			hour: "2026-03-01-10",
+			want: hourlyRow{requests: 3, success: 2, failed: 1, tokens: 12, nonStreaming: 3, sum: 750, count: 3, min: 50, max: 500, avg: 250},
+		},
+		{
+			name: "other hours untouched",
+			batches: [][]statsEvent{{
+				{at: "2026-03-01 10:05", duration: 100 * time.Millisecond, status: 200, model: "glm"},
+				{at: "2026-03-01 11:05", duration: 900 * time.Millisecond, status: 200, model: "glm"},
+			}},
+			hour: "2026-03-01-11",
+			want: hourlyRow{requests: 1, success: 1, nonStreaming: 1, sum: 900, count: 1, min: 900, max: 900, avg: 900},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStatsDB(t)
+			for _, batch := range tt.batches {
+				for _, e := range batch {
+					e.record(t)
+				}
+				if err := flushStats(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := readHourlyRow(t, tt.hour); got != tt.want {
+				t.Errorf("hourly_stats %s:\n got %+v\nwant %+v", tt.hour, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlushStatsDims(t *testing.T) {
+	useStatsDB(t)
+	for _, e := range []statsEvent{
+		{at: "2026-03-01 10:05", duration: 100 * time.Millisecond, status: 200, model: "glm", stream: true},
+		{at: "2026-03-01 10:06", duration: 300 * time.Millisecond, status: 201, model: "glm", stream: true},
+		{at: "2026-03-01 10:07", duration: 200 * time.Millisecond, status: 500, model: "glm", stream: true},
+		{at: "2026-03-01 10:08", duration: 200 * time.Millisecond, status: 200, model: "other"},
+	} {
+		e.record(t)
+	}
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		model, statusClass string
+		stream             bool
+		requests           int64
+		min, max           float64
+	}{
+		{model: "glm", statusClass: "2xx", stream: true, requests: 2, min: 100, max: 300},
+		{model: "glm", statusClass: "5xx", stream: true, requests: 1, min: 200, max: 200},
+		{model: "other", statusClass: "2xx", stream: false, requests: 1, min: 200, max: 200},
+	}
+	for _, tt := range tests {
+		var requests int64
+		var min, max float64
+		err := statsDB.QueryRow(`
+			SELECT requests, min_response_time, max_response_time FROM hourly_stats_dims
+			WHERE hour = '2026-03-01-10' AND model = ? AND status_class = ? AND stream = ?
+		`, tt.model, tt.statusClass, tt.stream).Scan(&requests, &min, &max)
+		if err != nil {
+			t.Errorf("%s/%s/%v: %v", tt.model, tt.statusClass, tt.stream, err)
+			continue
+		}
+		if requests != tt.requests || min != tt.min || max != tt.max {
+			t.Errorf("%s/%s/%v: got (%d, %v, %v), want (%d, %v, %v)",
+				tt.model, tt.statusClass, tt.stream, requests, min, max, tt.requests, tt.min, tt.max)
+		}
+	}
+}
+
+func TestFlushStatsRebuildsTouchedDays(t *testing.T) {
+	useStatsDB(t)
+	statsEvent{at: "2026-02-27 10:00", duration: 100 * time.Millisecond, status: 200, model: "glm"}.record(t)
+	statsEvent{at: "2026-03-01 10:00", duration: 100 * time.Millisecond, status: 200, model: "glm"}.record(t)
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 之前的日期已经汇总过，再次写入时不应重新汇总
+	statsDB.Exec(`UPDATE daily_stats SET requests = 99 WHERE date = '2026-02-27'`)
+	statsEvent{at: "2026-03-01 11:00", duration: 100 * time.Millisecond, status: 200, model: "glm"}.record(t)
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		date     string
+		requests int
+		peakHour string
+	}{
+		{date: "2026-02-27", requests: 99, peakHour: "2026-02-27-10"},
+		{date: "2026-03-01", requests: 2, peakHour: "2026-03-01-10"},
+	}
+	for _, tt := range tests {
+		var requests int
+		var peakHour string
+		if err := statsDB.QueryRow(`SELECT requests, peak_hour FROM daily_stats WHERE date = ?`, tt.date).Scan(&requests, &peakHour); err != nil {
+			t.Fatalf("daily_stats %s: %v", tt.date, err)
+		}
+		if requests != tt.requests || peakHour != tt.peakHour {
+			t.Errorf("daily_stats %s: got (%d, %s), want (%d, %s)", tt.date, requests, peakHour, tt.requests, tt.peakHour)
+		}
+	}
+}
+
+func TestTouchedStatsDates(t *testing.T) {
+	india := time.FixedZone("UTC+05:30", 5*3600+30*60)
+	tests := []struct {
+		name     string
+		loc      *time.Location
+		hours    []string
+		from, to string
+	}{
+		{name: "single day", loc: time.UTC, hours: []string{"2026-03-01-10", "2026-03-01-23"}, from: "2026-03-01", to: "2026-03-01"},
+		{name: "across midnight", loc: time.UTC, hours: []string{"2026-03-01-23", "2026-03-02-00"}, from: "2026-03-01", to: "2026-03-02"},
+		{name: "local date", loc: india, hours: []string{"2026-03-01-20"}, from: "2026-03-02", to: "2026-03-02"},
+		{name: "half hour boundary", loc: india, hours: []string{"2026-03-01-18"}, from: "2026-03-01", to: "2026-03-01"},
+		{name: "invalid hour", loc: time.UTC, hours: []string{"bad"}},
+	}
+
+	oldLocation := statsLocation
+	t.Cleanup(func() { statsLocation = oldLocation })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statsLocation = tt.loc
+			hours := map[string]*hourlyDelta{}
+			for _, hour := range tt.hours {
+				hours[hour] = &hourlyDelta{}
+			}
+			from, to := touchedStatsDates(hours, nil)
+			if from != tt.from || to != tt.to {
+				t.Errorf("got (%q, %q), want (%q, %q)", from, to, tt.from, tt.to)
+			}
+		})
+	}
+}
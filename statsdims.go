@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== 维度统计 ====================
+//
+// 请求统计同时按 小时 × 模型 × 状态码类别 × API Key × 是否流式 聚合：
+//   - hourly_stats_dims 保存小时数据，daily_stats_dims 由小时数据汇总
+//   - /dashboard/hourly、/dashboard/daily 带 group_by 或过滤参数时返回维度统计
+//   - 批处理、后台任务等非 API Key 请求的 api_key 为空字符串
+//   - 按 api_key 分组或过滤会暴露各 Key 的用量和名称，需要管理员登录
+
+// 维度名称与数据库列的对应关系（同时决定输出顺序）
+var statsDimensions = []struct {
+	name   string
+	column string
+}{
+	{"model", "model"},
+	{"status_class", "status_class"},
+	{"api_key", "api_key_id"},
+	{"stream", "stream"},
+}
+
+// 请求的统计维度，通过 context 在处理链路中传递，解析请求后补全
+type statsLabels struct {
+	apiKeyID string
+	model    string
+	stream   bool
+}
+
+type statsLabelsKey struct{}
+
+// 开始记录请求的统计维度
+func withStatsLabels(ctx context.Context, apiKeyID string) (context.Context, *statsLabels) {
+	labels := &statsLabels{apiKeyID: apiKeyID}
+	return context.WithValue(ctx, statsLabelsKey{}, labels), labels
+}
+
+// 取出当前请求的统计维度，没有时返回空值
+func statsLabelsFromContext(ctx context.Context) statsLabels {
+	if ctx == nil {
+		return statsLabels{}
+	}
+	if labels, ok := ctx.Value(statsLabelsKey{}).(*statsLabels); ok {
+		return *labels
+	}
+	return statsLabels{}
+}
+
+// 状态码类别：2xx / 4xx / 5xx ...
+func statusClass(status int) string {
+	return fmt.Sprintf("%dxx", status/100)
+}
+
+// 维度统计查询参数
+type statsDimQuery struct {
+	groupBy []string          // 维度名称
+	filters map[string]string // 维度名称 -> 过滤值
+}
+
+// 解析 group_by 与过滤参数；两者都没有时 ok 为 false
+func parseStatsDimQuery(r *http.Request) (query statsDimQuery, ok bool, err error) {
+	q := r.URL.Query()
+	query.filters = make(map[string]string)
+
+	if groupBy := q.Get("group_by"); groupBy != "" {
+		seen := make(map[string]bool)
+		for _, name := range strings.Split(groupBy, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" || seen[name] {
+				continue
+			}
+			if statsDimColumn(name) == "" {
+				return query, false, fmt.Errorf("Invalid group_by: %s", name)
+			}
+			seen[name] = true
+			query.groupBy = append(query.groupBy, name)
+		}
+	}
+
+	for _, dim := range statsDimensions {
+		if !q.Has(dim.name) {
+			continue
+		}
+		value := q.Get(dim.name)
+		switch dim.name {
+		case "stream":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return query, false, fmt.Errorf("Invalid stream: %s", value)
+			}
+			value = "0"
+			if b {
+				value = "1"
+			}
+		case "status_class":
+			// 允许 5 / 5xx / 5XX
+			value = strings.ToLower(value)
+			if len(value) == 1 {
+				value += "xx"
+			}
+		}
+		query.filters[dim.name] = value
+	}
+
+	return query, len(query.groupBy) > 0 || len(query.filters) > 0, nil
+}
+
+// 是否按 API Key 分组或过滤
+func (q statsDimQuery) usesAPIKey() bool {
+	_, filtered := q.filters["api_key"]
+	return filtered || containsString(q.groupBy, "api_key")
+}
+
+func statsDimColumn(name string) string {
+	for _, dim := range statsDimensions {
+		if dim.name == name {
+			return dim.column
+		}
+	}
+	return ""
+}
+
+// StatsDimRow 一个时间段内某组维度的统计，未参与分组的维度不输出
+type StatsDimRow struct {
+	Hour            string  `json:"hour,omitempty"`
+	Date            string  `json:"date,omitempty"`
+	Period          string  `json:"period,omitempty"`
+	Model           *string `json:"model,omitempty"`
+	StatusClass     *string `json:"statusClass,omitempty"`
+	APIKeyID        *string `json:"apiKey,omitempty"`
+	APIKeyName      string  `json:"apiKeyName,omitempty"`
+	Stream          *bool   `json:"stream,omitempty"`
+	Requests        int     `json:"requests"`
+	Success         int     `json:"success"`
+	Failed          int     `json:"failed"`
+	Tokens          int     `json:"tokens"`
+	AvgResponseTime float64 `json:"avgResponseTime"`
+	FastestResponse float64 `json:"fastestResponse"`
+	SlowestResponse float64 `json:"slowestResponse"`
+}
+
+// 维度统计的数据来源与时间范围
+type dimStatsSource struct {
+	table  string         // hourly_stats_dims / daily_stats_dims
+	column string         // hour / date
+	period string         // 时间段的 SQL 表达式，为空时直接使用 column
+	from   string         // column 的下界（含），为空表示不限
+	to     string         // column 的上界（含），为空表示不限
+	loc    *time.Location // 非空时关联 stats_hour_map（m.local_hour / m.local_date），仅用于小时数据
+	where  string         // 额外的过滤条件
+	args   []interface{}
+}
+
+// 获取最近若干小时的维度统计（小时按 loc 展示）
+func getHourlyDimStats(hours int, loc *time.Location, query statsDimQuery) ([]StatsDimRow, error) {
+	since := hourKeyFor(time.Now().Add(-time.Duration(hours-1) * time.Hour))
+	rows, err := queryDimStats(dimStatsSource{
+		table: "hourly_stats_dims", column: "hour", period: "m.local_hour", from: since, loc: loc,
+	}, query)
+	for i := range rows {
+		rows[i].Hour, rows[i].Period = rows[i].Period, ""
+	}
+	return rows, err
+}
+
+// 获取最近若干天的维度统计；loc 与报表时区不同时从小时数据实时汇总
+func getDailyDimStats(days int, loc *time.Location, query statsDimQuery) ([]StatsDimRow, error) {
+	since := startOfDayIn(time.Now(), loc).AddDate(0, 0, -(days - 1))
+	src := dimStatsSource{table: "daily_stats_dims", column: "date", from: dateKeyIn(since, loc)}
+	if !isStatsLocation(loc) {
+		src = dimStatsSource{
+			table: "hourly_stats_dims", column: "hour", period: "m.local_date", from: hourKeyFor(since), loc: loc,
+			where: "m.local_date >= ?", args: []interface{}{dateKeyIn(since, loc)},
+		}
+	}
+	rows, err := queryDimStats(src, query)
+	for i := range rows {
+		rows[i].Date, rows[i].Period = rows[i].Period, ""
+	}
+	return rows, err
+}
+
+// 按时间段与 group_by 维度汇总，过滤条件作用于所有维度；结果的时间段在 Period 中
+func queryDimStats(src dimStatsSource, query statsDimQuery) ([]StatsDimRow, error) {
+	result := []StatsDimRow{}
+	if statsDB == nil {
+		return result, nil
+	}
+
+	period := src.period
+	if period == "" {
+		period = src.column
+	}
+	columns := []string{period}
+	for _, name := range query.groupBy {
+		columns = append(columns, statsDimColumn(name))
+	}
+	where := []string{"1 = 1"}
+	args := []interface{}{}
+	if src.from != "" {
+		where = append(where, src.column+" >= ?")
+		args = append(args, src.from)
+	}
+	if src.to != "" {
+		where = append(where, src.column+" <= ?")
+		args = append(args, src.to)
+	}
+	if src.where != "" {
+		where = append(where, src.where)
+		args = append(args, src.args...)
+	}
+	for _, dim := range statsDimensions {
+		if value, ok := query.filters[dim.name]; ok {
+			where = append(where, dim.column+" = ?")
+			args = append(args, value)
+		}
+	}
+
+	from := src.table
+	if src.loc != nil {
+		from += " JOIN stats_hour_map m ON m.utc_hour = " + src.table + ".hour"
+	}
+
+	groupColumns := strings.Join(columns, ", ")
+	sqlQuery := fmt.Sprintf(`
+		SELECT %s,
+		       SUM(requests), SUM(success), SUM(failed), SUM(tokens),
+		       CASE WHEN SUM(response_time_count) > 0
+		            THEN SUM(response_time_sum) / SUM(response_time_count) ELSE 0 END,
+		       COALESCE(MIN(CASE WHEN response_time_count > 0 THEN min_response_time END), 0),
+		       COALESCE(MAX(CASE WHEN response_time_count > 0 THEN max_response_time END), 0)
+		FROM %s WHERE %s
+		GROUP BY %s ORDER BY %s
+	`, groupColumns, from, strings.Join(where, " AND "), groupColumns, groupColumns)
+
+	statsDBMutex.RLock()
+	var err error
+	if src.loc != nil {
+		err = withHourMap(src.loc, src.from, src.to, func(conn *sql.Conn) error {
+			result, err = scanDimStats(conn, sqlQuery, args, query)
+			return err
+		})
+	} else {
+		result, err = scanDimStats(statsDB, sqlQuery, args, query)
+	}
+	statsDBMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// 按 API Key 分组时附带名称，便于按团队展示
+	if containsString(query.groupBy, "api_key") && apiKeyDB != nil {
+		if keys, err := listAPIKeys(); err == nil {
+			names := make(map[string]string, len(keys))
+			for _, k := range keys {
+				names[k.ID] = k.Name
+			}
+			for i := range result {
+				result[i].APIKeyName = names[*result[i].APIKeyID]
+			}
+		}
+	}
+	return result, nil
+}
+
+func scanDimStats(q statsQuerier, sqlQuery string, args []interface{}, query statsDimQuery) ([]StatsDimRow, error) {
+	result := []StatsDimRow{}
+	rows, err := q.QueryContext(context.Background(), sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row StatsDimRow
+		dims := make([]interface{}, len(query.groupBy))
+		for i, name := range query.groupBy {
+			switch name {
+			case "model":
+				row.Model = new(string)
+				dims[i] = row.Model
+			case "status_class":
+				row.StatusClass = new(string)
+				dims[i] = row.StatusClass
+			case "api_key":
+				row.APIKeyID = new(string)
+				dims[i] = row.APIKeyID
+			case "stream":
+				row.Stream = new(bool)
+				dims[i] = row.Stream
+			}
+		}
+		dest := append([]interface{}{&row.Period}, dims...)
+		dest = append(dest, &row.Requests, &row.Success, &row.Failed, &row.Tokens,
+			&row.AvgResponseTime, &row.FastestResponse, &row.SlowestResponse)
+		if err := rows.Scan(dest...); err != nil {
+			continue
+		}
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
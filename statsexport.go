@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ==================== 统计导出 ====================
+//
+// GET /dashboard/export 按任意时间范围导出维度统计，供报表和容量规划使用（需要管理员登录）：
+//   - from / to: RFC3339、YYYY-MM-DD、YYYY-MM-DDTHH:MM 或 Unix 秒，范围为 [from, to)
+//   - tz: 报表时区，没有时区的时间参数和时间段都按该时区解释（默认 STATS_TIMEZONE）
+//   - granularity: hour / day / week / month（hour 来自小时数据；其余来自每日数据，
+//     tz 与 STATS_TIMEZONE 不同时从小时数据实时汇总）
+//   - group_by 以及 model / status_class / api_key / stream 过滤参数与 /dashboard/hourly 相同
+//   - format: json / csv
+
+const STATS_EXPORT_DEFAULT_DAYS = 30
+
+// 各粒度由日期列得到时间段的 SQL 表达式；week 以周一为一周的开始
+var statsExportGranularities = map[string]func(dateColumn string) string{
+	"hour":  nil,
+	"day":   func(d string) string { return d },
+	"week":  func(d string) string { return "date(" + d + ", 'weekday 0', '-6 days')" },
+	"month": func(d string) string { return "substr(" + d + ", 1, 7)" },
+}
+
+// 解析导出时间参数，没有时区的时间按 loc 解释
+func parseExportTime(s string, loc *time.Location) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid time: %s", s)
+}
+
+// Dashboard 统计导出处理器
+func handleDashboardExport(w http.ResponseWriter, r *http.Request) {
+	if !checkAdminAuth(r) {
+		http.Error(w, "Admin login required", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query()
+
+	granularity := q.Get("granularity")
+	if granularity == "" {
+		granularity = "day"
+	}
+	periodOf, ok := statsExportGranularities[granularity]
+	if !ok {
+		http.Error(w, "Invalid granularity: must be hour, day, week or month", http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Invalid format: must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	loc, ok := requestStatsLocation(w, r)
+	if !ok {
+		return
+	}
+
+	to := time.Now()
+	if s := q.Get("to"); s != "" {
+		t, err := parseExportTime(s, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.AddDate(0, 0, -STATS_EXPORT_DEFAULT_DAYS)
+	if s := q.Get("from"); s != "" {
+		t, err := parseExportTime(s, loc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be earlier than to", http.StatusBadRequest)
+		return
+	}
+
+	query, _, err := parseStatsDimQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// to 不含在范围内，按 to 之前的最后一个时间段计算上界
+	last := to.Add(-time.Nanosecond)
+	var src dimStatsSource
+	switch {
+	case periodOf == nil:
+		src = dimStatsSource{table: "hourly_stats_dims", column: "hour", period: "m.local_hour", loc: loc,
+			from: hourKeyFor(from), to: hourKeyFor(last)}
+	case isStatsLocation(loc):
+		src = dimStatsSource{table: "daily_stats_dims", column: "date", period: periodOf("date"),
+			from: dateKeyIn(from, loc), to: dateKeyIn(last, loc)}
+	default:
+		fromDate, toDate := dateKeyIn(from, loc), dateKeyIn(last, loc)
+		src = dimStatsSource{table: "hourly_stats_dims", column: "hour", period: periodOf("m.local_date"), loc: loc,
+			from:  hourKeyFor(startOfDayIn(from, loc)),
+			to:    hourKeyFor(startOfDayIn(last, loc).AddDate(0, 0, 1).Add(-time.Hour)),
+			where: "m.local_date BETWEEN ? AND ?", args: []interface{}{fromDate, toDate}}
+	}
+
+	rows, err := queryDimStats(src, query)
+	if err != nil {
+		http.Error(w, "Failed to export stats", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "csv" {
+		filename := fmt.Sprintf("stats-%s-%s-%s.csv", granularity, from.In(loc).Format("20060102"), last.In(loc).Format("20060102"))
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+		writeStatsCSV(w, query.groupBy, rows)
+		return
+	}
+
+	groupBy := query.groupBy
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from.In(loc),
+		"to":          to.In(loc),
+		"timezone":    loc.String(),
+		"granularity": granularity,
+		"group_by":    groupBy,
+		"rows":        rows,
+	})
+}
+
+// 以 CSV 输出导出结果，列顺序：时间段、分组维度、统计值
+func writeStatsCSV(w http.ResponseWriter, groupBy []string, rows []StatsDimRow) {
+	formatMs := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+
+	header := []string{"period"}
+	for _, name := range groupBy {
+		header = append(header, name)
+		if name == "api_key" {
+			header = append(header, "api_key_name")
+		}
+	}
+	header = append(header, "requests", "success", "failed", "tokens",
+		"avg_response_time_ms", "fastest_response_ms", "slowest_response_ms")
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, row := range rows {
+		record := []string{row.Period}
+		for _, name := range groupBy {
+			switch name {
+			case "model":
+				record = append(record, *row.Model)
+			case "status_class":
+				record = append(record, *row.StatusClass)
+			case "api_key":
+				record = append(record, *row.APIKeyID, row.APIKeyName)
+			case "stream":
+				record = append(record, strconv.FormatBool(*row.Stream))
+			}
+		}
+		record = append(record,
+			strconv.Itoa(row.Requests), strconv.Itoa(row.Success), strconv.Itoa(row.Failed), strconv.Itoa(row.Tokens),
+			formatMs(row.AvgResponseTime), formatMs(row.FastestResponse), formatMs(row.SlowestResponse))
+		cw.Write(record)
+	}
+	cw.Flush()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ==================== 累计统计持久化 ====================
+//
+// Dashboard 上的累计统计（stats）保存在 stats_snapshot 表中：
+//   - 启动时恢复，之后随统计写入任务每 5 秒保存一次（有变化时），收到退出信号时再保存一次
+//   - StartTime 始终是本次进程的启动时间，StatsSince 是累计统计的起始时间
+//   - 管理员可以通过 POST /admin/api/stats/reset 重置统计，每次重置都会写入 stats_audit
+
+// 累计统计是否有尚未保存的变化（由 statsMutex 保护）
+var statsDirty bool
+
+const createStatsPersistTablesSQL = `
+CREATE TABLE IF NOT EXISTS stats_snapshot (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	data TEXT NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS stats_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	action TEXT NOT NULL,
+	client_ip TEXT,
+	user_agent TEXT,
+	reason TEXT,
+	include_history INTEGER DEFAULT 0,
+	snapshot TEXT,
+	created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_stats_audit_created ON stats_audit(created_at DESC);
+`
+
+// 从数据库恢复累计统计
+func loadCumulativeStats() error {
+	if statsDB == nil {
+		return nil
+	}
+
+	var data string
+	statsDBMutex.RLock()
+	err := statsDB.QueryRow(`SELECT data FROM stats_snapshot WHERE id = 1`).Scan(&data)
+	statsDBMutex.RUnlock()
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved RequestStats
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return fmt.Errorf("解析累计统计失败: %v", err)
+	}
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	saved.StartTime = stats.StartTime
+	if saved.StatsSince.IsZero() {
+		saved.StatsSince = stats.StartTime
+	}
+	if saved.ModelUsage == nil {
+		saved.ModelUsage = make(map[string]int64)
+	}
+	stats = saved
+	logStats.Info("已恢复累计统计", "total_requests", stats.TotalRequests, "stats_since", stats.StatsSince.Format(time.RFC3339))
+	return nil
+}
+
+// 保存累计统计（没有变化时跳过）
+func saveCumulativeStats() error {
+	if statsDB == nil {
+		return nil
+	}
+
+	statsMutex.Lock()
+	if !statsDirty {
+		statsMutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(stats)
+	statsDirty = false
+	statsMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	statsDBMutex.Lock()
+	_, err = statsDB.Exec(`
+		INSERT INTO stats_snapshot (id, data, updated_at) VALUES (1, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at
+	`, string(data), time.Now().Unix())
+	statsDBMutex.Unlock()
+	if err != nil {
+		statsMutex.Lock()
+		statsDirty = true
+		statsMutex.Unlock()
+		return fmt.Errorf("保存累计统计失败: %v", err)
+	}
+	return nil
+}
+
+// 重置统计：清空累计统计，可选同时删除小时/每日历史数据，并写入审计记录
+// 审计记录和历史数据删除提交成功后才替换内存中的统计，失败时统计保持不变。
+// 重置期间持有 statsFlushMutex 和 statsMutex：后台写入不会把重置前的增量写回已清空的表，
+// 请求统计的记录也会等待重置完成，快照之后结束的请求计入新的统计而不会丢失。
+func resetStats(clientIP, userAgent, reason string, includeHistory bool) (*RequestStats, error) {
+	statsFlushMutex.Lock()
+	defer statsFlushMutex.Unlock()
+
+	now := time.Now()
+	statsMutex.Lock()
+	snapshot, _ := json.Marshal(stats)
+	fresh := RequestStats{
+		StartTime:  stats.StartTime,
+		StatsSince: now,
+		ModelUsage: make(map[string]int64),
+	}
+
+	if statsDB != nil {
+		statsDBMutex.Lock()
+		err := func() error {
+			tx, err := statsDB.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if includeHistory {
+				for _, table := range []string{"hourly_stats", "hourly_stats_dims", "hourly_latency", "daily_stats", "daily_stats_dims"} {
+					if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+						return fmt.Errorf("清空 %s 失败: %v", table, err)
+					}
+				}
+			}
+			_, err = tx.Exec(`
+				INSERT INTO stats_audit (action, client_ip, user_agent, reason, include_history, snapshot, created_at)
+				VALUES ('reset', ?, ?, ?, ?, ?, ?)
+			`, clientIP, userAgent, reason, includeHistory, string(snapshot), now.Unix())
+			if err != nil {
+				return fmt.Errorf("写入审计记录失败: %v", err)
+			}
+			return tx.Commit()
+		}()
+		statsDBMutex.Unlock()
+		if err != nil {
+			statsMutex.Unlock()
+			return nil, err
+		}
+	}
+
+	if includeHistory {
+		// 丢弃尚未写入的增量，避免重置后又被写回
+		statsBuffer.mu.Lock()
+		statsBuffer.hours = make(map[string]*hourlyDelta)
+		statsBuffer.dims = make(map[statsDimKey]*hourlyDelta)
+		statsBuffer.latency = make(map[latencyBucketKey]int64)
+		statsBuffer.pending = 0
+		statsBuffer.mu.Unlock()
+	}
+
+	previous := stats
+	stats = fresh
+	statsDirty = true
+	statsMutex.Unlock()
+
+	if err := saveCumulativeStats(); err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// StatsAuditRecord 统计审计记录
+type StatsAuditRecord struct {
+	ID             int64           `json:"id"`
+	Action         string          `json:"action"`
+	ClientIP       string          `json:"client_ip"`
+	UserAgent      string          `json:"user_agent"`
+	Reason         string          `json:"reason"`
+	IncludeHistory bool            `json:"include_history"`
+	Snapshot       json.RawMessage `json:"snapshot,omitempty"`
+	CreatedAt      int64           `json:"created_at"`
+}
+
+// 列出最近的审计记录
+func listStatsAudit(limit int) ([]StatsAuditRecord, error) {
+	records := []StatsAuditRecord{}
+	if statsDB == nil {
+		return records, nil
+	}
+
+	statsDBMutex.RLock()
+	defer statsDBMutex.RUnlock()
+
+	rows, err := statsDB.Query(`
+		SELECT id, action, client_ip, user_agent, reason, include_history, snapshot, created_at
+		FROM stats_audit ORDER BY id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec StatsAuditRecord
+		var clientIP, userAgent, reason, snapshot sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Action, &clientIP, &userAgent, &reason, &rec.IncludeHistory, &snapshot, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.ClientIP = clientIP.String
+		rec.UserAgent = userAgent.String
+		rec.Reason = reason.String
+		if snapshot.String != "" {
+			rec.Snapshot = json.RawMessage(snapshot.String)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Admin 统计管理：
+//
+//	POST /admin/api/stats/reset  {"reason": "...", "include_history": false}
+//	GET  /admin/api/stats/audit
+func handleAdminAPIStats(w http.ResponseWriter, r *http.Request) {
+	setCORSHeaders(w)
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeError := func(status int, message string) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": false,
+			"error":   message,
+		})
+	}
+
+	if !checkAdminAuth(r) {
+		writeError(http.StatusUnauthorized, "未授权")
+		return
+	}
+
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api/stats"), "/")
+	switch {
+	case action == "reset" && r.Method == "POST":
+		var body struct {
+			Reason         string `json:"reason"`
+			IncludeHistory bool   `json:"include_history"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeError(http.StatusBadRequest, "无效的请求数据")
+				return
+			}
+		}
+
+		clientIP := getClientIP(r)
+		previous, err := resetStats(clientIP, r.UserAgent(), body.Reason, body.IncludeHistory)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		logStats.Info("统计数据已重置", "client_ip", clientIP, "include_history", body.IncludeHistory, "reason", body.Reason)
+		// 通知已连接的 Dashboard 重新加载完整状态
+		_, snapshot := dashboardSnapshot()
+		publishDashboardEvent(DASHBOARD_EVENT_RESYNC, json.RawMessage(snapshot))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":         true,
+			"previous_total":  previous.TotalRequests,
+			"previous_since":  previous.StatsSince,
+			"include_history": body.IncludeHistory,
+		})
+
+	case action == "audit" && r.Method == "GET":
+		records, err := listStatsAudit(100)
+		if err != nil {
+			writeError(http.StatusInternalServerError, err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success": true,
+			"records": records,
+		})
+
+	default:
+		writeError(http.StatusNotFound, "未知的操作")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 使用空的累计统计，测试结束后恢复
+func useFreshStats(t *testing.T) {
+	t.Helper()
+	statsMutex.Lock()
+	old, oldDirty := stats, statsDirty
+	stats = RequestStats{StartTime: time.Now(), StatsSince: time.Now(), ModelUsage: make(map[string]int64)}
+	statsMutex.Unlock()
+	t.Cleanup(func() {
+		statsMutex.Lock()
+		stats, statsDirty = old, oldDirty
+		statsMutex.Unlock()
+	})
+}
+
+func recordTestRequest(status int) {
+	recordRequestStatsDetailed(context.Background(), time.Now().Add(-10*time.Millisecond), "/v1/chat/completions", status, "glm", false, 3)
+}
+
+func countRows(t *testing.T, table string) int {
+	t.Helper()
+	var n int
+	if err := statsDB.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestResetStats(t *testing.T) {
+	tests := []struct {
+		name           string
+		includeHistory bool
+		wantHourly     int
+	}{
+		{name: "keep history", includeHistory: false, wantHourly: 1},
+		{name: "include history", includeHistory: true, wantHourly: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStatsDB(t)
+			useFreshStats(t)
+			recordTestRequest(200)
+			recordTestRequest(502)
+			if err := flushStats(); err != nil {
+				t.Fatal(err)
+			}
+
+			previous, err := resetStats("10.0.0.1", "test-agent", "monthly report", tt.includeHistory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if previous.TotalRequests != 2 || previous.FailedRequests != 1 {
+				t.Errorf("previous: got total %d failed %d, want 2 and 1", previous.TotalRequests, previous.FailedRequests)
+			}
+
+			statsMutex.Lock()
+			current := stats
+			statsMutex.Unlock()
+			if current.TotalRequests != 0 || len(current.ModelUsage) != 0 {
+				t.Errorf("stats after reset: %+v", current)
+			}
+			if !current.StartTime.Equal(previous.StartTime) || !current.StatsSince.After(previous.StatsSince) {
+				t.Errorf("StartTime must be kept and StatsSince moved: got %v / %v", current.StartTime, current.StatsSince)
+			}
+
+			if got := countRows(t, "hourly_stats"); got != tt.wantHourly {
+				t.Errorf("hourly_stats rows: got %d, want %d", got, tt.wantHourly)
+			}
+			if got := countRows(t, "daily_stats"); got != tt.wantHourly {
+				t.Errorf("daily_stats rows: got %d, want %d", got, tt.wantHourly)
+			}
+
+			// 重置后的累计统计已保存，重启后恢复的是清空后的统计
+			var data string
+			if err := statsDB.QueryRow(`SELECT data FROM stats_snapshot WHERE id = 1`).Scan(&data); err != nil {
+				t.Fatal(err)
+			}
+			var saved RequestStats
+			json.Unmarshal([]byte(data), &saved)
+			if saved.TotalRequests != 0 {
+				t.Errorf("saved snapshot: got %d requests, want 0", saved.TotalRequests)
+			}
+
+			records, err := listStatsAudit(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 {
+				t.Fatalf("audit records: got %d, want 1", len(records))
+			}
+			rec := records[0]
+			if rec.Action != "reset" || rec.ClientIP != "10.0.0.1" || rec.UserAgent != "test-agent" ||
+				rec.Reason != "monthly report" || rec.IncludeHistory != tt.includeHistory {
+				t.Errorf("audit record: %+v", rec)
+			}
+			var audited RequestStats
+			if err := json.Unmarshal(rec.Snapshot, &audited); err != nil || audited.TotalRequests != 2 {
+				t.Errorf("audit snapshot: got %d requests (%v), want 2", audited.TotalRequests, err)
+			}
+		})
+	}
+}
+
+func TestResetStatsFailureKeepsStats(t *testing.T) {
+	useStatsDB(t)
+	useFreshStats(t)
+	recordTestRequest(200)
+
+	statsDB.Exec(`DROP TABLE stats_audit`)
+	if _, err := resetStats("10.0.0.1", "", "", true); err == nil {
+		t.Fatal("resetStats() succeeded without the audit table")
+	}
+
+	statsMutex.Lock()
+	total := stats.TotalRequests
+	statsMutex.Unlock()
+	if total != 1 {
+		t.Errorf("stats after failed reset: got %d requests, want 1", total)
+	}
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+	if got := countRows(t, "hourly_stats"); got != 1 {
+		t.Errorf("hourly_stats rows after failed reset: got %d, want 1", got)
+	}
+}
+
+// 重置与请求记录、后台写入并发时，不丢失也不重复计算请求
+func TestResetStatsConcurrentRecording(t *testing.T) {
+	useStatsDB(t)
+	useFreshStats(t)
+
+	// 记录请求和后台写入持续进行，直到重置完成
+	done := make(chan struct{})
+	var recorded atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				recordTestRequest(200)
+				recorded.Add(1)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			flushStats()
+		}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	previous, err := resetStats("10.0.0.1", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(done)
+	wg.Wait()
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	statsMutex.Lock()
+	current := stats.TotalRequests
+	statsMutex.Unlock()
+	if previous.TotalRequests+current != recorded.Load() {
+		t.Errorf("requests: %d before reset + %d after, want %d in total", previous.TotalRequests, current, recorded.Load())
+	}
+
+	// 历史数据只包含重置之后的请求
+	var hourly int64
+	statsDB.QueryRow(`SELECT COALESCE(SUM(requests), 0) FROM hourly_stats`).Scan(&hourly)
+	if hourly != current {
+		t.Errorf("hourly_stats requests: got %d, want %d (requests after reset)", hourly, current)
+	}
+}
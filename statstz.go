@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // 容器镜像中可能没有时区数据库
+)
+
+// ==================== 统计时区 ====================
+//
+// 小时统计始终按 UTC 小时存储（YYYY-MM-DD-HH），报表按配置的时区展示：
+//   - STATS_TIMEZONE 决定每日汇总的日期边界、高峰小时以及清理旧数据的边界
+//   - 统计接口可以用 tz 参数临时指定时区；与配置不同时，按天的数据从小时数据实时汇总
+//   - 按时区换算时把 UTC 小时映射到本地小时/日期（临时表 stats_hour_map），
+//     非整点偏移的时区按小时开始时刻所在的本地小时计算
+
+// 报表时区（由 STATS_TIMEZONE 配置）
+var statsLocation = time.UTC
+
+// 解析时区：IANA 名称（Asia/Shanghai）、Local、UTC，或固定偏移（+08:00、UTC+8、-5）
+func parseTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "UTC") || name == "Z" {
+		return time.UTC, nil
+	}
+	if loc, err := time.LoadLocation(name); err == nil {
+		return loc, nil
+	}
+
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(name), "UTC"), "GMT")
+	if len(offset) < 2 || (offset[0] != '+' && offset[0] != '-') {
+		return nil, fmt.Errorf("无效的时区: %s", name)
+	}
+	sign := 1
+	if offset[0] == '-' {
+		sign = -1
+	}
+	hoursPart, minutesPart, _ := strings.Cut(offset[1:], ":")
+	if minutesPart == "" && len(hoursPart) == 4 {
+		hoursPart, minutesPart = hoursPart[:2], hoursPart[2:]
+	}
+	hours, err := strconv.Atoi(hoursPart)
+	if err != nil || hours > 14 {
+		return nil, fmt.Errorf("无效的时区: %s", name)
+	}
+	minutes := 0
+	if minutesPart != "" {
+		if minutes, err = strconv.Atoi(minutesPart); err != nil || minutes >= 60 {
+			return nil, fmt.Errorf("无效的时区: %s", name)
+		}
+	}
+	label := fmt.Sprintf("UTC%c%02d:%02d", offset[0], hours, minutes)
+	return time.FixedZone(label, sign*(hours*3600+minutes*60)), nil
+}
+
+// 取请求的报表时区：tz 参数优先，否则使用配置的时区
+func statsLocationFromRequest(r *http.Request) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return statsLocation, nil
+	}
+	loc, err := parseTimezone(tz)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid tz: %s", tz)
+	}
+	return loc, nil
+}
+
+// 解析请求的报表时区并通过 X-Stats-Timezone 响应头返回；参数无效时直接返回 400
+func requestStatsLocation(w http.ResponseWriter, r *http.Request) (*time.Location, bool) {
+	loc, err := statsLocationFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	w.Header().Set("X-Stats-Timezone", loc.String())
+	return loc, true
+}
+
+// 是否与配置的报表时区相同（相同则可以直接使用每日汇总表）
+func isStatsLocation(loc *time.Location) bool {
+	return loc == statsLocation || loc.String() == statsLocation.String()
+}
+
+// 解析 UTC 小时 key
+func parseHourKey(key string) (time.Time, bool) {
+	t, err := time.ParseInLocation("2006-01-02-15", key, time.UTC)
+	return t, err == nil
+}
+
+// 指定时区下的小时 key
+func hourKeyIn(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02-15")
+}
+
+// 指定时区下的日期 key
+func dateKeyIn(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format("2006-01-02")
+}
+
+// 把 UTC 小时 key 换算为指定时区的小时 key
+func localHourKey(key string, loc *time.Location) string {
+	if t, ok := parseHourKey(key); ok {
+		return hourKeyIn(t, loc)
+	}
+	return key
+}
+
+// 指定时区下某天零点对应的时刻
+func startOfDayIn(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// statsQuerier 可以是 *sql.DB 或固定的 *sql.Conn
+type statsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// 在固定连接上建立 UTC 小时到本地小时/日期的映射（临时表 stats_hour_map），再执行 fn。
+// fromKey / toKey 为 UTC 小时 key 的范围（含），为空表示不限。调用方负责加 statsDBMutex。
+func withHourMap(loc *time.Location, fromKey, toKey string, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := statsDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TEMP TABLE IF NOT EXISTS stats_hour_map (
+			utc_hour TEXT PRIMARY KEY,
+			local_hour TEXT NOT NULL,
+			local_date TEXT NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM stats_hour_map`); err != nil {
+		return err
+	}
+
+	where := "1 = 1"
+	args := []interface{}{}
+	if fromKey != "" {
+		where += " AND hour >= ?"
+		args = append(args, fromKey)
+	}
+	if toKey != "" {
+		where += " AND hour <= ?"
+		args = append(args, toKey)
+	}
+	rows, err := conn.QueryContext(ctx, `
+		SELECT hour FROM hourly_stats WHERE `+where+`
+		UNION SELECT hour FROM hourly_stats_dims WHERE `+where+`
+		UNION SELECT hour FROM hourly_latency WHERE `+where,
+		append(append(append([]interface{}{}, args...), args...), args...)...)
+	if err != nil {
+		return err
+	}
+	var hours []string
+	for rows.Next() {
+		var hour string
+		if err := rows.Scan(&hour); err == nil {
+			hours = append(hours, hour)
+		}
+	}
+	rows.Close()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO stats_hour_map (utc_hour, local_hour, local_date) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hour := range hours {
+		t, ok := parseHourKey(hour)
+		if !ok {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, hour, hourKeyIn(t, loc), dateKeyIn(t, loc)); err != nil {
+			stmt.Close()
+			tx.Rollback()
+			return err
+		}
+	}
+	stmt.Close()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// 按本地日期从小时数据汇总每日统计（每日汇总和临时时区查询共用），需要 stats_hour_map
+const dailyStatsSelectSQL = `
+	SELECT m.local_date AS day,
+	       SUM(h.requests), SUM(h.success), SUM(h.failed),
+	       CASE WHEN SUM(h.response_time_count) > 0
+	            THEN SUM(h.response_time_sum) / SUM(h.response_time_count) ELSE 0 END,
+	       SUM(h.tokens),
+	       (SELECT pm.local_hour FROM hourly_stats p JOIN stats_hour_map pm ON pm.utc_hour = p.hour
+	        WHERE pm.local_date = m.local_date ORDER BY p.requests DESC, p.hour LIMIT 1),
+	       SUM(h.streaming_count), SUM(h.non_streaming_count),
+	       COALESCE(MIN(CASE WHEN h.response_time_count > 0 THEN h.min_response_time END), 0),
+	       COALESCE(MAX(CASE WHEN h.response_time_count > 0 THEN h.max_response_time END), 0)
+	FROM hourly_stats h JOIN stats_hour_map m ON m.utc_hour = h.hour
+`
+
+// 在指定时区下从小时数据汇总最近若干天的每日统计（受小时数据保留天数限制）
+func getDailyStatsIn(days int, loc *time.Location) ([]DailyStats, error) {
+	result := []DailyStats{}
+	if statsDB == nil {
+		return result, nil
+	}
+
+	since := startOfDayIn(time.Now(), loc).AddDate(0, 0, -(days - 1))
+
+	statsDBMutex.RLock()
+	defer statsDBMutex.RUnlock()
+
+	err := withHourMap(loc, hourKeyFor(since), "", func(conn *sql.Conn) error {
+		rows, err := conn.QueryContext(context.Background(),
+			dailyStatsSelectSQL+` WHERE m.local_date >= ? GROUP BY day ORDER BY day`, dateKeyIn(since, loc))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var stat DailyStats
+			var peakHour sql.NullString
+			if err := rows.Scan(&stat.Date, &stat.Requests, &stat.Success, &stat.Failed,
+				&stat.AvgResponseTime, &stat.Tokens, &peakHour,
+				&stat.StreamingCount, &stat.NonStreamingCount, &stat.FastestResponse, &stat.SlowestResponse); err != nil {
+				continue
+			}
+			stat.PeakHour = peakHour.String
+			result = append(result, stat)
+		}
+		return nil
+	})
+	return result, err
+}
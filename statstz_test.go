@@ -0,0 +1,154 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimezone(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string // 该时区下 2026-03-01 00:00 UTC 的本地时间
+		wantErr bool
+	}{
+		{name: "", want: "2026-03-01 00:00"},
+		{name: "UTC", want: "2026-03-01 00:00"},
+		{name: "Asia/Shanghai", want: "2026-03-01 08:00"},
+		{name: "America/New_York", want: "2026-02-28 19:00"},
+		{name: "+08:00", want: "2026-03-01 08:00"},
+		{name: "UTC+8", want: "2026-03-01 08:00"},
+		{name: "GMT-5", want: "2026-02-28 19:00"},
+		{name: "+0530", want: "2026-03-01 05:30"},
+		{name: "-5", want: "2026-02-28 19:00"},
+		{name: "Mars/Olympus", wantErr: true},
+		{name: "+15", wantErr: true},
+		{name: "+08:60", wantErr: true},
+		{name: "8", wantErr: true},
+	}
+
+	at := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := parseTimezone(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseTimezone(%q): got %v, want error", tt.name, loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := at.In(loc).Format("2006-01-02 15:04"); got != tt.want {
+				t.Errorf("parseTimezone(%q): got %s, want %s", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalHourKey(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	india := time.FixedZone("UTC+05:30", 5*3600+30*60)
+	tests := []struct {
+		key  string
+		loc  *time.Location
+		want string
+	}{
+		{key: "2026-03-01-10", loc: time.UTC, want: "2026-03-01-10"},
+		{key: "2026-03-01-20", loc: shanghai, want: "2026-03-02-04"},
+		{key: "2026-03-01-02", loc: time.FixedZone("UTC-05:00", -5*3600), want: "2026-02-28-21"},
+		// 非整点偏移按小时开始时刻所在的本地小时计算
+		{key: "2026-03-01-18", loc: india, want: "2026-03-01-23"},
+		{key: "invalid", loc: shanghai, want: "invalid"},
+	}
+	for _, tt := range tests {
+		if got := localHourKey(tt.key, tt.loc); got != tt.want {
+			t.Errorf("localHourKey(%q, %s): got %q, want %q", tt.key, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestDailyStatsRebucketedByTimezone(t *testing.T) {
+	useStatsDB(t)
+
+	// 三个请求分布在 UTC 的两天，换算到其他时区后日期边界不同
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour) // 昨天 00:00 UTC
+	events := []time.Time{base.Add(2 * time.Hour), base.Add(20 * time.Hour), base.Add(23 * time.Hour)}
+	for _, at := range events {
+		recordHourlyStats(at, 100*time.Millisecond, 200, 1, false, "glm", "key-1")
+	}
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	tests := []struct {
+		name string
+		loc  *time.Location
+	}{
+		{name: "UTC", loc: time.UTC},
+		{name: "Asia/Shanghai", loc: shanghai},
+		{name: "UTC-05:00", loc: time.FixedZone("UTC-05:00", -5*3600)},
+		{name: "UTC+05:30", loc: time.FixedZone("UTC+05:30", 5*3600+30*60)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := map[string]int{}
+			for _, at := range events {
+				want[at.In(tt.loc).Format("2006-01-02")]++
+			}
+
+			daily, err := getDailyStatsIn(7, tt.loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]int{}
+			for _, d := range daily {
+				got[d.Date] = d.Requests
+				if d.PeakHour == "" || d.PeakHour[:10] != d.Date {
+					t.Errorf("%s: peak hour %q is not a local hour of the day", d.Date, d.PeakHour)
+				}
+			}
+			if len(got) != len(want) {
+				t.Fatalf("days: got %v, want %v", got, want)
+			}
+			for date, n := range want {
+				if got[date] != n {
+					t.Errorf("%s: got %d requests, want %d", date, got[date], n)
+				}
+			}
+		})
+	}
+}
+
+func TestSavedDailyStatsUseStatsLocation(t *testing.T) {
+	useStatsDB(t)
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	statsLocation = shanghai
+
+	base := time.Now().UTC().Truncate(24 * time.Hour).Add(-24 * time.Hour)
+	for _, at := range []time.Time{base.Add(10 * time.Hour), base.Add(17 * time.Hour)} {
+		recordHourlyStats(at, 100*time.Millisecond, 200, 1, false, "glm", "key-1")
+	}
+	if err := flushStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每日汇总表与按同一时区实时汇总的结果一致
+	saved, err := getDailyStats(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := getDailyStatsIn(7, shanghai)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != 2 || len(live) != 2 {
+		t.Fatalf("days: saved %d, live %d, want 2", len(saved), len(live))
+	}
+	for i := range saved {
+		if saved[i].Date != live[i].Date || saved[i].Requests != live[i].Requests || saved[i].PeakHour != live[i].PeakHour {
+			t.Errorf("day %d: saved %+v, live %+v", i, saved[i], live[i])
+		}
+	}
+}
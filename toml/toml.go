@@ -0,0 +1,425 @@
+// Package toml 解析配置文件使用的 TOML 子集：
+// 表（[a] / [a.b]）、键值对（裸键、引号键、点分键）、基本字符串与字面量字符串、
+// 整数、浮点数、布尔值以及由这些值组成的数组（可跨行）。
+// 不支持多行字符串、日期时间、内联表和表数组。
+package toml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Value 一个键的值及其所在行号
+type Value struct {
+	Data interface{} // string / int64 / float64 / bool / []interface{}
+	Line int
+}
+
+// Error 带行号的解析错误
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("第 %d 行: %s", e.Line, e.Msg)
+}
+
+// Parse 解析文档，返回以点分完整路径（如 server.port）为键的扁平结果
+func Parse(data []byte) (map[string]Value, error) {
+	if !utf8.Valid(data) {
+		return nil, &Error{Line: 1, Msg: "文件不是有效的 UTF-8"}
+	}
+	p := &parser{
+		src:    strings.TrimPrefix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\ufeff"),
+		line:   1,
+		values: map[string]Value{},
+		tables: map[string]bool{},
+		dotted: map[string]bool{},
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.values, nil
+}
+
+type parser struct {
+	src    string
+	pos    int
+	line   int
+	prefix []string
+	values map[string]Value
+	tables map[string]bool // 由 [a.b] 定义的表
+	dotted map[string]bool // 由点分键 a.b = 1 隐式定义的表，不能再用 [a] 定义
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+// 跳过空格和制表符
+func (p *parser) skipSpaces() {
+	for !p.eof() && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// 跳过空白、换行和注释（数组内部使用）
+func (p *parser) skipBlank() {
+	for !p.eof() {
+		switch p.src[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			p.skipComment()
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) skipComment() {
+	for !p.eof() && p.src[p.pos] != '\n' {
+		p.pos++
+	}
+}
+
+// 当前行剩余部分只能是空白和注释
+func (p *parser) endOfLine() error {
+	p.skipSpaces()
+	if p.peek() == '#' {
+		p.skipComment()
+	}
+	if p.eof() {
+		return nil
+	}
+	if p.src[p.pos] != '\n' {
+		return p.errorf("行尾有多余的内容")
+	}
+	p.pos++
+	p.line++
+	return nil
+}
+
+func (p *parser) parse() error {
+	for {
+		p.skipBlank()
+		if p.eof() {
+			return nil
+		}
+		if p.peek() == '[' {
+			if err := p.parseTable(); err != nil {
+				return err
+			}
+		} else if err := p.parseKeyValue(); err != nil {
+			return err
+		}
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// [a.b]
+func (p *parser) parseTable() error {
+	p.pos++
+	if p.peek() == '[' {
+		return p.errorf("不支持表数组")
+	}
+	p.skipSpaces()
+	key, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpaces()
+	if p.peek() != ']' {
+		return p.errorf("表名缺少 ]")
+	}
+	p.pos++
+
+	for i := 1; i <= len(key); i++ {
+		if _, ok := p.values[strings.Join(key[:i], ".")]; ok {
+			return p.errorf("表 [%s] 与已定义的键冲突", strings.Join(key, "."))
+		}
+	}
+	name := strings.Join(key, ".")
+	if p.tables[name] || p.dotted[name] {
+		return p.errorf("表 [%s] 重复定义", name)
+	}
+	p.tables[name] = true
+	p.prefix = key
+	return nil
+}
+
+// key = value
+func (p *parser) parseKeyValue() error {
+	line := p.line
+	key, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpaces()
+	if p.peek() != '=' {
+		return p.errorf("键 %s 后缺少 =", strings.Join(key, "."))
+	}
+	p.pos++
+	p.skipSpaces()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	path := append(append([]string{}, p.prefix...), key...)
+	full := strings.Join(path, ".")
+	// 点分键的每一级前缀都是隐式表，不能是已定义的键或 [表]
+	for i := len(p.prefix) + 1; i < len(path); i++ {
+		name := strings.Join(path[:i], ".")
+		if _, ok := p.values[name]; ok {
+			return &Error{Line: line, Msg: fmt.Sprintf("键 %s 与已定义的键 %s 冲突", full, name)}
+		}
+		if p.tables[name] {
+			return &Error{Line: line, Msg: fmt.Sprintf("键 %s 不能扩展已定义的表 [%s]", full, name)}
+		}
+	}
+	if _, ok := p.values[full]; ok {
+		return &Error{Line: line, Msg: fmt.Sprintf("键 %s 重复定义", full)}
+	}
+	if p.tables[full] || p.dotted[full] {
+		return &Error{Line: line, Msg: fmt.Sprintf("键 %s 与表冲突", full)}
+	}
+	for i := len(p.prefix) + 1; i < len(path); i++ {
+		p.dotted[strings.Join(path[:i], ".")] = true
+	}
+	p.values[full] = Value{Data: value, Line: line}
+	return nil
+}
+
+// 点分键，每段为裸键或引号键
+func (p *parser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipSpaces()
+		var part string
+		switch c := p.peek(); {
+		case c == '"':
+			s, err := p.parseBasicString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		case c == '\'':
+			s, err := p.parseLiteralString()
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		default:
+			start := p.pos
+			for !p.eof() && isBareKeyChar(p.src[p.pos]) {
+				p.pos++
+			}
+			if start == p.pos {
+				return nil, p.errorf("无效的键")
+			}
+			part = p.src[start:p.pos]
+		}
+		parts = append(parts, part)
+
+		p.skipSpaces()
+		if p.peek() != '.' {
+			return parts, nil
+		}
+		p.pos++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *parser) parseValue() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		if strings.HasPrefix(p.src[p.pos:], `"""`) {
+			return nil, p.errorf("不支持多行字符串")
+		}
+		return p.parseBasicString()
+	case c == '\'':
+		if strings.HasPrefix(p.src[p.pos:], `'''`) {
+			return nil, p.errorf("不支持多行字符串")
+		}
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return nil, p.errorf("不支持内联表")
+	case c == 0 || c == '\n' || c == '#':
+		return nil, p.errorf("缺少值")
+	}
+
+	start := p.pos
+	for !p.eof() && !strings.ContainsRune(" \t\n#,]", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	return p.parseScalar(p.src[start:p.pos])
+}
+
+// 布尔值、整数和浮点数
+func (p *parser) parseScalar(s string) (interface{}, error) {
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+
+	if strings.Contains(s, "__") || strings.HasPrefix(s, "_") || strings.HasSuffix(s, "_") {
+		return nil, p.errorf("无效的值 %q", s)
+	}
+	clean := strings.ReplaceAll(s, "_", "")
+	if strings.HasPrefix(clean, "0x") || strings.HasPrefix(clean, "0o") || strings.HasPrefix(clean, "0b") {
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[clean[1]]
+		if n, err := strconv.ParseInt(clean[2:], base, 64); err == nil {
+			return n, nil
+		}
+		return nil, p.errorf("无效的整数 %q", s)
+	}
+	if n, err := strconv.ParseInt(clean, 10, 64); err == nil {
+		digits := strings.TrimLeft(clean, "+-")
+		if len(digits) > 1 && digits[0] == '0' {
+			return nil, p.errorf("整数 %q 不能有前导零", s)
+		}
+		return n, nil
+	}
+	if strings.ContainsAny(clean, ".eE") {
+		if f, err := strconv.ParseFloat(clean, 64); err == nil {
+			return f, nil
+		}
+	}
+	if strings.ContainsAny(s, ":-") && len(s) >= 8 {
+		return nil, p.errorf("不支持日期时间，请使用字符串")
+	}
+	return nil, p.errorf("无效的值 %q（字符串需要加引号）", s)
+}
+
+// "..."，支持 \b \t \n \f \r \" \\ \uXXXX \UXXXXXXXX
+func (p *parser) parseBasicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.src[p.pos] == '\n' {
+			return "", p.errorf("字符串缺少结束的引号")
+		}
+		c := p.src[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", p.errorf("字符串缺少结束的引号")
+			}
+			e := p.src[p.pos]
+			p.pos++
+			switch e {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"':
+				b.WriteByte('"')
+			case '\\':
+				b.WriteByte('\\')
+			case 'u', 'U':
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.src) {
+					return "", p.errorf("无效的 Unicode 转义")
+				}
+				code, err := strconv.ParseUint(p.src[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(code)) {
+					return "", p.errorf("无效的 Unicode 转义")
+				}
+				b.WriteRune(rune(code))
+				p.pos += n
+			default:
+				return "", p.errorf("无效的转义字符 \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// '...'，内容原样保留
+func (p *parser) parseLiteralString() (string, error) {
+	p.pos++
+	end := strings.IndexAny(p.src[p.pos:], "'\n")
+	if end < 0 || p.src[p.pos+end] == '\n' {
+		return "", p.errorf("字符串缺少结束的引号")
+	}
+	s := p.src[p.pos : p.pos+end]
+	p.pos += end + 1
+	return s, nil
+}
+
+// [v1, v2, ...]，可跨行，允许尾随逗号
+func (p *parser) parseArray() ([]interface{}, error) {
+	p.pos++
+	items := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.peek() == ']' {
+			p.pos++
+			return items, nil
+		}
+		if p.eof() {
+			return nil, p.errorf("数组缺少 ]")
+		}
+		item, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		p.skipBlank()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+		default:
+			return nil, p.errorf("数组元素之间缺少逗号")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package toml
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// 只比较取值，行号由 TestParseLines 检查
+func parseData(t *testing.T, input string) map[string]interface{} {
+	t.Helper()
+	doc, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	got := make(map[string]interface{}, len(doc))
+	for key, v := range doc {
+		got[key] = v.Data
+	}
+	return got
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "empty input",
+			input: "",
+			want:  map[string]interface{}{},
+		},
+		{
+			name:  "comments and blank lines",
+			input: "# comment\n\n  a = 1 # trailing\n\t\n",
+			want:  map[string]interface{}{"a": int64(1)},
+		},
+		{
+			name:  "tables",
+			input: "top = true\n[server]\nport = 8080\n[server.tls]\nenabled = false\n[log]\nlevel = \"info\"\n",
+			want: map[string]interface{}{
+				"top":                true,
+				"server.port":        int64(8080),
+				"server.tls.enabled": false,
+				"log.level":          "info",
+			},
+		},
+		{
+			name:  "dotted keys",
+			input: "a.b = 1\na . c = 2\n[x]\ny.z = \"v\"\n",
+			want:  map[string]interface{}{"a.b": int64(1), "a.c": int64(2), "x.y.z": "v"},
+		},
+		{
+			name:  "sub-table after dotted keys",
+			input: "[fruit]\napple.color = \"red\"\n[fruit.apple.texture]\nsmooth = true\n",
+			want:  map[string]interface{}{"fruit.apple.color": "red", "fruit.apple.texture.smooth": true},
+		},
+		{
+			name:  "parent header after sub-table",
+			input: "[a.b]\nc = 1\n[a]\nd = 2\n",
+			want:  map[string]interface{}{"a.b.c": int64(1), "a.d": int64(2)},
+		},
+		{
+			name:  "quoted keys",
+			input: "\"a.b\" = 1\n'c d' = 2\n[\"x y\".z]\nk = 3\n",
+			want:  map[string]interface{}{"a.b": int64(1), "c d": int64(2), "x y.z.k": int64(3)},
+		},
+		{
+			name:  "basic string escapes",
+			input: `s = "tab\tnl\nquote\"back\\cr\rbs\bff\f"` + "\n" + `u = "\u00e9\U0001F600"`,
+			want:  map[string]interface{}{"s": "tab\tnl\nquote\"back\\cr\rbs\bff\f", "u": "é😀"},
+		},
+		{
+			name:  "literal string is raw",
+			input: `path = 'C:\temp\n'`,
+			want:  map[string]interface{}{"path": `C:\temp\n`},
+		},
+		{
+			name:  "integers",
+			input: "a = 42\nb = -17\nc = +3\nd = 0\ne = 1_000_000\n",
+			want: map[string]interface{}{
+				"a": int64(42), "b": int64(-17), "c": int64(3), "d": int64(0), "e": int64(1000000),
+			},
+		},
+		{
+			name:  "hex octal binary",
+			input: "h = 0xdead_BEEF\no = 0o755\nb = 0b1010_1010\n",
+			want:  map[string]interface{}{"h": int64(0xdeadbeef), "o": int64(0o755), "b": int64(0xaa)},
+		},
+		{
+			name:  "floats",
+			input: "a = 0.5\nb = -1e3\nc = 6.626e-34\nd = 1_000.5\n",
+			want:  map[string]interface{}{"a": 0.5, "b": -1000.0, "c": 6.626e-34, "d": 1000.5},
+		},
+		{
+			name:  "arrays",
+			input: "a = [1, 2, 3]\nb = []\nc = [\"x\", 'y', ]\nd = [[1], [true, 1.5]]\n",
+			want: map[string]interface{}{
+				"a": []interface{}{int64(1), int64(2), int64(3)},
+				"b": []interface{}{},
+				"c": []interface{}{"x", "y"},
+				"d": []interface{}{[]interface{}{int64(1)}, []interface{}{true, 1.5}},
+			},
+		},
+		{
+			name:  "multi-line array with comments",
+			input: "a = [\n  1, # one\n  # skipped\n  2,\n]\nb = 3\n",
+			want:  map[string]interface{}{"a": []interface{}{int64(1), int64(2)}, "b": int64(3)},
+		},
+		{
+			name:  "CRLF and BOM",
+			input: "\ufeff[t]\r\na = 1\r\nb = \"x\"\r\n",
+			want:  map[string]interface{}{"t.a": int64(1), "t.b": "x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseData(t, tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSpecialFloats(t *testing.T) {
+	got := parseData(t, "a = inf\nb = -inf\nc = +inf\nd = nan\n")
+	if got["a"] != math.Inf(1) || got["c"] != math.Inf(1) {
+		t.Errorf("inf: got %v, %v", got["a"], got["c"])
+	}
+	if got["b"] != math.Inf(-1) {
+		t.Errorf("-inf: got %v", got["b"])
+	}
+	if f, ok := got["d"].(float64); !ok || !math.IsNaN(f) {
+		t.Errorf("nan: got %v", got["d"])
+	}
+}
+
+func TestParseLines(t *testing.T) {
+	input := "# header\n[a]\nx = 1\n\ny = [\n  1,\n  2,\n]\nz = \"after\"\n"
+	doc, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	want := map[string]int{"a.x": 3, "a.y": 5, "a.z": 9}
+	for key, line := range want {
+		if doc[key].Line != line {
+			t.Errorf("%s: got line %d, want %d", key, doc[key].Line, line)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		line  int
+		msg   string
+	}{
+		{"duplicate key", "a = 1\nb = 2\na = 3\n", 3, "键 a 重复定义"},
+		{"duplicate dotted key", "[t]\nx.y = 1\nx.y = 2\n", 3, "键 t.x.y 重复定义"},
+		{"duplicate table", "[a]\n[b]\n[a]\n", 3, "表 [a] 重复定义"},
+		{"header after dotted key", "a.b = 1\n[a]\n", 2, "表 [a] 重复定义"},
+		{"nested header after dotted key", "[fruit]\napple.color = \"red\"\n[fruit.apple]\n", 3, "表 [fruit.apple] 重复定义"},
+		{"key over dotted table", "a.b = 1\na = 2\n", 2, "键 a 与表冲突"},
+		{"dotted key over value", "a = 1\na.b = 2\n", 2, "键 a.b 与已定义的键 a 冲突"},
+		{"dotted key extends header table", "[a.b]\nc = 1\n[a]\nb.d = 2\n", 4, "不能扩展已定义的表 [a.b]"},
+		{"key over header table", "[a.b]\n[a]\nb = 1\n", 3, "键 a.b 与表冲突"},
+		{"header over value", "[a]\nb = 1\n[a.b]\n", 3, "表 [a.b] 与已定义的键冲突"},
+		{"header under value", "[a]\nb = 1\n[a.b.c]\n", 3, "表 [a.b.c] 与已定义的键冲突"},
+		{"invalid escape", "a = 1\ns = \"\\x\"\n", 2, `无效的转义字符 \x`},
+		{"invalid unicode escape", "s = \"\\uZZZZ\"\n", 1, "无效的 Unicode 转义"},
+		{"unterminated string", "\ns = \"abc\n", 2, "字符串缺少结束的引号"},
+		{"unterminated literal string", "s = 'abc\n", 1, "字符串缺少结束的引号"},
+		{"bare string", "a = 1\nb = hello\n", 2, "字符串需要加引号"},
+		{"leading zero", "a = 007\n", 1, "不能有前导零"},
+		{"double underscore", "a = 1__0\n", 1, "无效的值"},
+		{"trailing underscore", "a = 10_\n", 1, "无效的值"},
+		{"invalid hex", "a = 0xZZ\n", 1, "无效的整数"},
+		{"invalid binary", "a = 0b102\n", 1, "无效的整数"},
+		{"missing value", "a =\n", 1, "缺少值"},
+		{"missing equals", "a 1\n", 1, "键 a 后缺少 ="},
+		{"trailing content", "a = 1 2\n", 1, "行尾有多余的内容"},
+		{"array missing comma", "a = [\n  1\n  2\n]\n", 3, "数组元素之间缺少逗号"},
+		{"unterminated array", "a = [\n  1,\n", 3, "数组缺少 ]"},
+		{"table missing bracket", "[a\n", 1, "表名缺少 ]"},
+		{"array of tables", "[[a]]\n", 1, "不支持表数组"},
+		{"inline table", "a = {}\n", 1, "不支持内联表"},
+		{"multi-line string", "a = \"\"\"x\"\"\"\n", 1, "不支持多行字符串"},
+		{"datetime", "a = 2024-01-01\n", 1, "不支持日期时间"},
+		{"invalid UTF-8", "a = \"\xff\"\n", 1, "不是有效的 UTF-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.input))
+			var perr *Error
+			if !errors.As(err, &perr) {
+				t.Fatalf("got err %v, want *Error", err)
+			}
+			if perr.Line != tt.line {
+				t.Errorf("got line %d, want %d (%v)", perr.Line, tt.line, err)
+			}
+			if !strings.Contains(perr.Msg, tt.msg) {
+				t.Errorf("got message %q, want it to contain %q", perr.Msg, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hulisang/ZtoApi/tracing"
+)
+
+// ==================== 链路追踪相关 ====================
+//
+// TRACING_ENABLED=true 时启用 OpenTelemetry 兼容的链路追踪（默认关闭）：
+//   - 入站请求解析 W3C traceparent，上游请求注入 traceparent
+//   - span：HTTP 请求、API Key 校验、token 选择（含匿名 token）、上游请求、
+//     首个 token、流式生成
+//   - 通过 OTLP/HTTP（JSON）导出，地址等使用标准 OTEL_* 环境变量配置
+
+// 初始化链路追踪
+func initTracing() {
+	if !TRACING_ENABLED {
+		return
+	}
+
+	endpoint := getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	if endpoint == "" {
+		base := strings.TrimRight(getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"), "/")
+		endpoint = base + "/v1/traces"
+	}
+
+	// 格式: key1=value1,key2=value2
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""), ",") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+
+	ratio, err := strconv.ParseFloat(getEnv("TRACING_SAMPLE_RATIO", "1"), 64)
+	if err != nil {
+		ratio = 1
+	}
+
+	tracing.Init(tracing.Config{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		ServiceName: getEnv("OTEL_SERVICE_NAME", "ztoapi"),
+		SampleRatio: ratio,
+	})
+	log.Printf("🔭 链路追踪: OTLP/HTTP -> %s (采样率: %g)", endpoint, ratio)
+}
+
+// 记录状态码的 ResponseWriter，保留 Flush 能力供流式响应使用
+type statusWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sw *statusWriter) WriteHeader(statusCode int) {
+	if sw.statusCode == 0 {
+		sw.statusCode = statusCode
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.statusCode == 0 {
+		sw.statusCode = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *statusWriter) Flush() {
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// traceHandler 为处理函数创建服务端 span；未启用追踪时原样返回
+func traceHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	if !tracing.Enabled() {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Extract(r.Context(), r.Header)
+		ctx, span := tracing.Start(ctx, name, tracing.KindServer,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", r.URL.Path),
+			tracing.String("client.address", getClientIP(r)),
+			tracing.String("request.id", requestIDFromContext(r.Context())),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		handler(sw, r.WithContext(ctx))
+
+		span.SetAttributes(tracing.Int("http.response.status_code", sw.statusCode))
+		if sw.statusCode >= 500 {
+			span.SetError(errStatus(sw.statusCode))
+		}
+	}
+}
+
+type errStatus int
+
+func (e errStatus) Error() string {
+	return "HTTP " + strconv.Itoa(int(e)) + " " + http.StatusText(int(e))
+}
+
+// 上游响应阶段追踪：首个 token 之前为 upstream.first_token，之后为 stream.completion
+type upstreamPhaseTracer struct {
+	ctx     context.Context
+	current *tracing.Span
+	first   bool
+}
+
+func startUpstreamPhases(ctx context.Context) *upstreamPhaseTracer {
+	_, span := tracing.Start(ctx, "upstream.first_token", tracing.KindInternal)
+	return &upstreamPhaseTracer{ctx: ctx, current: span}
+}
+
+// 收到首个内容 token
+func (t *upstreamPhaseTracer) firstToken() {
+	if t.first {
+		return
+	}
+	t.first = true
+	t.current.End()
+	_, t.current = tracing.Start(t.ctx, "stream.completion", tracing.KindInternal)
+}
+
+// 上游流结束
+func (t *upstreamPhaseTracer) end(eventCount, contentLength int, err error) {
+	t.current.SetAttributes(
+		tracing.Int("upstream.events", eventCount),
+		tracing.Int("response.content_length", contentLength),
+	)
+	t.current.SetError(err)
+	t.current.End()
+}
@@ -0,0 +1,256 @@
+// Package tracing 是一个精简的 OpenTelemetry 兼容追踪实现：
+// W3C traceparent 传播，span 通过 OTLP/HTTP（JSON 编码）批量导出到 collector。
+// 未调用 Init 时所有操作都是空操作，Start 返回的 *Span 为 nil 且方法可安全调用。
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind 对应 OTLP 的 span kind
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext 跨进程传播的追踪上下文
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid trace id 和 span id 都不能全为 0
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent 生成 W3C traceparent 头
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent 解析 W3C traceparent 头
+func ParseTraceparent(header string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// 版本 00 必须恰好 4 段，更高版本允许追加字段
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	traceID, err := hex.DecodeString(parts[1])
+	if err != nil || len(traceID) != 16 {
+		return sc, false
+	}
+	spanID, err := hex.DecodeString(parts[2])
+	if err != nil || len(spanID) != 8 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || len(flags) != 1 {
+		return sc, false
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&0x01 == 1
+	return sc, sc.IsValid()
+}
+
+// Attribute span 属性
+type Attribute struct {
+	Key   string
+	Value interface{} // string / int64 / float64 / bool
+}
+
+func String(key, value string) Attribute          { return Attribute{key, value} }
+func Int(key string, value int) Attribute         { return Attribute{key, int64(value)} }
+func Int64(key string, value int64) Attribute     { return Attribute{key, value} }
+func Float64(key string, value float64) Attribute { return Attribute{key, value} }
+func Bool(key string, value bool) Attribute       { return Attribute{key, value} }
+
+type event struct {
+	name  string
+	time  time.Time
+	attrs []Attribute
+}
+
+// Span 一个追踪区间
+type Span struct {
+	name      string
+	kind      SpanKind
+	sc        SpanContext
+	parentID  [8]byte
+	start     time.Time
+	recording bool
+
+	mu         sync.Mutex
+	end        time.Time
+	attrs      []Attribute
+	events     []event
+	statusCode int
+	statusMsg  string
+	ended      bool
+}
+
+// SpanContext 返回 span 的传播上下文
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes 设置属性
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// AddEvent 记录一个时间点事件
+func (s *Span) AddEvent(name string, attrs ...Attribute) {
+	if s == nil || !s.recording {
+		return
+	}
+	s.mu.Lock()
+	s.events = append(s.events, event{name: name, time: time.Now(), attrs: attrs})
+	s.mu.Unlock()
+}
+
+// SetError 将 span 标记为错误
+func (s *Span) SetError(err error) {
+	if s == nil || !s.recording || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.statusCode = statusError
+	s.statusMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束 span 并交给导出器，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.recording && exp != nil {
+		exp.enqueue(s)
+	}
+}
+
+// OTLP status code: ERROR
+const statusError = 2
+
+// ---------- context ----------
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// ContextWithSpan 将 span 放入 context
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext 取出当前 span，没有时返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Extract 从请求头中提取远端 traceparent，作为后续 span 的父级
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if !Enabled() {
+		return ctx
+	}
+	if sc, ok := ParseTraceparent(header.Get("traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject 将当前 span 写入请求头 traceparent
+func Inject(ctx context.Context, header http.Header) {
+	if span := SpanFromContext(ctx); span != nil {
+		header.Set("traceparent", span.sc.Traceparent())
+	}
+}
+
+// Enabled 追踪是否已启用
+func Enabled() bool {
+	return exp != nil
+}
+
+// Start 创建子 span；追踪未启用时返回原 context 和 nil
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attribute) (context.Context, *Span) {
+	if !Enabled() {
+		return ctx, nil
+	}
+
+	span := &Span{name: name, kind: kind, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.sc.TraceID = parent.sc.TraceID
+		span.sc.Sampled = parent.sc.Sampled
+		span.parentID = parent.sc.SpanID
+	} else if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		span.sc.TraceID = remote.TraceID
+		span.sc.Sampled = remote.Sampled
+		span.parentID = remote.SpanID
+	} else {
+		rand.Read(span.sc.TraceID[:])
+		span.sc.Sampled = shouldSample(span.sc.TraceID)
+	}
+	rand.Read(span.sc.SpanID[:])
+	span.recording = span.sc.Sampled
+	span.SetAttributes(attrs...)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+// 按 trace id 的低 8 字节做比例采样，同一个 trace 的判断结果稳定
+func shouldSample(traceID [16]byte) bool {
+	ratio := exp.cfg.SampleRatio
+	if ratio >= 1 {
+		return true
+	}
+	if ratio <= 0 {
+		return false
+	}
+	var v uint64
+	for _, b := range traceID[8:] {
+		v = v<<8 | uint64(b)
+	}
+	return float64(v>>1) < ratio*float64(math.MaxUint64>>1)
+}